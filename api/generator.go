@@ -1,6 +1,6 @@
 package api
 
-//go:generate go run ./tmake/ account.tcd user.tcd search.tcd clusterId.tcd
+//go:generate go run ./tmake/ account.tcd user.tcd search.tcd clusterId.tcd message.tcd
 //go:generate go run ./tmake/ service linker.tcd
 //go:generate go fmt .
 //go:generate go fmt ./client
@@ -0,0 +1,11 @@
+package api
+
+import (
+	"github.com/ihaiker/tenured-go-server/registry"
+	"github.com/ihaiker/tenured-go-server/registry/load_balance"
+)
+
+// 搜索数据按照搜索key hash，和存储服务副本、迁移使用的路由key一致，在search.tcd中注册为search
+func SearchLoadBalance(serverName, serverTag string, reg registry.ServiceRegistry) load_balance.LoadBalance {
+	return load_balance.NewHashLoadBalance(serverName, serverTag, reg, 100)
+}
@@ -3,6 +3,7 @@ package tests
 import (
 	"github.com/ihaiker/tenured-go-server/api"
 	"github.com/ihaiker/tenured-go-server/api/client"
+	"github.com/ihaiker/tenured-go-server/commons/mixins"
 	"github.com/ihaiker/tenured-go-server/commons/snowflake"
 	"github.com/ihaiker/tenured-go-server/registry"
 	"github.com/ihaiker/tenured-go-server/registry/load_balance"
@@ -13,7 +14,7 @@ import (
 
 func GetAccountService() (server *client.AccountServiceClient, reg registry.ServiceRegistry, err error) {
 	var plugin registry.Plugins
-	if plugin, err = plugins.GetRegistryPlugins("consul://127.0.0.1:8500"); err != nil {
+	if plugin, err = plugins.GetRegistryPlugins(mixins.Get(mixins.KeyRegistry, mixins.Registry)); err != nil {
 		return
 	} else {
 		if reg, err = plugin.Registry(); err != nil {
@@ -74,6 +75,21 @@ func TestAccountServiceClient_Search(t *testing.T) {
 	}
 }
 
+func TestAccountServiceClient_ScanAccounts(t *testing.T) {
+	server, _, err := GetAccountService()
+	assert.Nil(t, err)
+
+	gl := &load_balance.GlobalLoading{}
+	search := new(api.Search)
+	for gl.NextNode() {
+		err := server.ScanAccounts(gl, search, func(account *api.Account) error {
+			t.Log(account)
+			return nil
+		})
+		assert.Nil(t, err)
+	}
+}
+
 func TestAccountService_GetEmail(t *testing.T) {
 	server, _, err := GetAccountService()
 	assert.Nil(t, err)
@@ -4,6 +4,7 @@ import (
 	"github.com/ihaiker/tenured-go-server/api"
 	"github.com/ihaiker/tenured-go-server/api/client"
 	"github.com/ihaiker/tenured-go-server/commons"
+	"github.com/ihaiker/tenured-go-server/commons/mixins"
 	"github.com/ihaiker/tenured-go-server/registry"
 	"github.com/ihaiker/tenured-go-server/registry/load_balance"
 	"github.com/ihaiker/tenured-go-server/registry/plugins"
@@ -14,7 +15,7 @@ import (
 func GetClusterService() (server *client.ClusterIdServiceClient, err error) {
 	var plugin registry.Plugins
 	var reg registry.ServiceRegistry
-	if plugin, err = plugins.GetRegistryPlugins("consul://127.0.0.1:8500"); err != nil {
+	if plugin, err = plugins.GetRegistryPlugins(mixins.Get(mixins.KeyRegistry, mixins.Registry)); err != nil {
 		return
 	} else {
 		if reg, err = plugin.Registry(); err != nil {
@@ -3,6 +3,7 @@ package tests
 import (
 	"github.com/ihaiker/tenured-go-server/api"
 	"github.com/ihaiker/tenured-go-server/api/client"
+	"github.com/ihaiker/tenured-go-server/commons/mixins"
 	"github.com/ihaiker/tenured-go-server/registry"
 	"github.com/ihaiker/tenured-go-server/registry/load_balance"
 	"github.com/ihaiker/tenured-go-server/registry/plugins"
@@ -12,7 +13,7 @@ func GetLinkerService() (server *client.UserServiceClient) {
 	var reg registry.ServiceRegistry
 	var err error
 	var plugin registry.Plugins
-	if plugin, err = plugins.GetRegistryPlugins("consul://127.0.0.1:8500"); err != nil {
+	if plugin, err = plugins.GetRegistryPlugins(mixins.Get(mixins.KeyRegistry, mixins.Registry)); err != nil {
 		return
 	} else {
 		if reg, err = plugin.Registry(); err != nil {
@@ -0,0 +1,52 @@
+package tests
+
+import (
+	"github.com/ihaiker/tenured-go-server/api"
+	"github.com/ihaiker/tenured-go-server/api/client"
+	"github.com/ihaiker/tenured-go-server/commons/mixins"
+	"github.com/ihaiker/tenured-go-server/registry"
+	"github.com/ihaiker/tenured-go-server/registry/load_balance"
+	"github.com/ihaiker/tenured-go-server/registry/plugins"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func GetMessageService() (server *client.MessageServiceClient) {
+	var reg registry.ServiceRegistry
+	var err error
+	var plugin registry.Plugins
+	if plugin, err = plugins.GetRegistryPlugins(mixins.Get(mixins.KeyRegistry, mixins.Registry)); err != nil {
+		return
+	} else {
+		if reg, err = plugin.Registry(); err != nil {
+			return
+		}
+	}
+	server = client.NewMessageServiceClient(load_balance.NewHashLoadBalance("tenured_store", api.StoreMessage, reg, 100))
+	if err = server.Start(); err != nil {
+		return
+	}
+	return
+}
+
+func TestMessageService(t *testing.T) {
+	server := GetMessageService()
+
+	first, err := server.Save(1, []byte("first"))
+	assert.Nil(t, err)
+	second, err := server.Save(1, []byte("second"))
+	assert.Nil(t, err)
+	assert.True(t, second.Id > first.Id)
+
+	messages, err := server.Fetch(1, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(messages.Messages))
+	assert.Equal(t, "first", string(messages.Messages[0].Body))
+
+	assert.Nil(t, server.Remove(1, first.Id))
+	assert.Nil(t, server.Remove(1, second.Id))
+
+	messages, err = server.Fetch(1, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(messages.Messages))
+}
@@ -94,6 +94,7 @@ func NewDef(tcd *TCDInfo) *Def {
 	return &Def{
 		modules: []Module{
 			imports, NewErrors(),
+			NewLoadBalanceDef(imports),
 			enums, typeDefs,
 			NewServicesDef(imports, typeDefs),
 		},
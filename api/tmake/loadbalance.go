@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"text/template"
+)
+
+var loadBalancePattern = regexp.MustCompile(`^(\w+) ([\w.]+)$`)
+
+// loadBalance { 名称 初始化方法 }，初始化方法为 load_balance.LoadBalanceFunc，按照名称注册后在方法的loadBalance(名称)中使用
+type LoadBalanceDef struct {
+	Imports *Imports
+
+	LoadBalances map[string]string
+}
+
+func (this *LoadBalanceDef) Add(addLines []string, info *TCDInfo) error {
+	_, lines := comment(addLines)
+	if "loadBalance {" != lines[0] {
+		return NotMatch
+	}
+	lines = body(lines)
+	for _, line := range lines {
+		if !loadBalancePattern.MatchString(line) {
+			return errors.New("error at: " + line)
+		}
+		loadBalance := loadBalancePattern.FindStringSubmatch(line)
+		this.LoadBalances[loadBalance[1]] = loadBalance[2]
+	}
+	if len(this.LoadBalances) > 0 {
+		this.Imports.AddInterface(TenuredHome+"/registry/load_balance", "")
+	}
+	return nil
+}
+
+func (this *LoadBalanceDef) InterOuter(info *TCDInfo) []byte {
+	b := new(bytes.Buffer)
+	if len(this.LoadBalances) > 0 {
+		t := template.Must(template.New("letter").Parse(`
+func init() { {{range $k,$v := .LoadBalances}}
+	load_balance.RegisterLoadBalance("{{$k}}", {{$v}}){{end}}
+}`))
+		_ = t.Execute(b, this)
+	}
+	return b.Bytes()
+}
+
+func NewLoadBalanceDef(imports *Imports) *LoadBalanceDef {
+	return &LoadBalanceDef{
+		Imports:      imports,
+		LoadBalances: map[string]string{},
+	}
+}
@@ -13,7 +13,7 @@ import (
 )
 
 var servicePattern = regexp.MustCompile(`^service (\w+)\(([0-9]{4,5})\)[ ]?\{$`)
-var funcPattern = regexp.MustCompile(`^(\w+)\(([ ,\[\]\w]*)\) \(([ ,\[\]\w]*)\)( error\(([,\w]+)\))?( loadBalance\((\w+)\))?( timeout\((\w+)\))?$`)
+var funcPattern = regexp.MustCompile(`^(\w+)\(([ ,\[\]\w]*)\) \(([ ,\[\]\w]*)\)( error\(([,\w]+)\))?( loadBalance\((\w+)\))?( timeout\((\w+)\))?( retry\((\d+)\))?( idempotent)?( stream)?$`)
 
 type FunParam struct {
 	Name string
@@ -92,6 +92,15 @@ type FuncDef struct {
 	tcd        *TCDInfo
 
 	Timeout string
+
+	//失败重试次数
+	Retry int
+
+	//是否幂等，幂等方法在超时的情况下也会重试
+	Idempotent bool
+
+	//流式返回，返回值的每一条数据作为一个数据帧发送，客户端通过回调函数接收
+	Stream bool
 }
 
 func (this *FuncDef) TimeoutDuration() string {
@@ -103,6 +112,28 @@ func (this *FuncDef) TimeoutDuration() string {
 	return fmt.Sprintf("time.Millisecond*%d", timeoutMillisecond)
 }
 
+func (this *FuncDef) RetryPolicy() string {
+	if this.Retry == 0 {
+		return "protocol.NoRetry"
+	}
+	return fmt.Sprintf("protocol.NewRetryPolicy(%d, %v)", this.Retry, this.Idempotent)
+}
+
+// 流式方法的回调参数，apiPackage 是否在类型前面加上api包名
+func (this *FuncDef) StreamArg(apiPackage bool) string {
+	if !this.Stream {
+		return ""
+	}
+	itemType := this.Outs[0].ShowType()
+	if apiPackage {
+		itemType = this.Outs[0].UseShowType()
+	}
+	if len(this.Ins) > 0 {
+		return ", stream func(" + itemType + ") error"
+	}
+	return "stream func(" + itemType + ") error"
+}
+
 func (this *FuncDef) ClientBody() string {
 	b := new(bytes.Buffer)
 
@@ -123,7 +154,7 @@ func (this *FuncDef) ClientBody() string {
 				return %s protocol.ErrorRouter()
 			}
 			defer this.loadBalance.Return(%s,regKey)
-		`, loadBalanceParam, strings.Repeat("nil,", len(this.Outs)), requestCode,
+		`, loadBalanceParam, strings.Repeat("nil,", len(this.ReturnOuts())), requestCode,
 	))
 
 	//header
@@ -162,46 +193,65 @@ func (this *FuncDef) ClientBody() string {
 
 	//invoke
 	timeoutMillisecond := this.TimeoutDuration()
+	retryPolicy := this.RetryPolicy()
 	outLength := len(this.Outs)
-	if outLength == 0 {
+	if this.Stream {
+		if "[]byte" == this.Outs[0].Type {
+			b.WriteString(fmt.Sprintf(`
+				return this.InvokeStream(serverInstance, %s, requestHeader, requestBody, %s, func(item *protocol.TenuredCommand) error {
+					return stream(item.Body)
+				})
+			`, requestCode, timeoutMillisecond))
+		} else {
+			b.WriteString(fmt.Sprintf(`
+				return this.InvokeStream(serverInstance, %s, requestHeader, requestBody, %s, func(item *protocol.TenuredCommand) error {
+					respHeader := &%s{}
+					if err := item.GetHeader(respHeader); err != nil {
+						return err
+					}
+					return stream(respHeader)
+				})
+			`, requestCode, timeoutMillisecond, (this.tcd.ApiPackageName + "." + this.Outs[0].Type)))
+		}
+	} else if outLength == 0 {
 		b.WriteString(fmt.Sprintf(`
-			if _, err = this.Invoke(serverInstance[0], %s, requestHeader,requestBody, %s, nil); !commons.IsNil(err) {
+			if _, err = this.InvokeRetry(serverInstance, %s, %s, requestHeader,requestBody, %s, nil); !commons.IsNil(err) {
 				return protocol.ConvertError(err)
 			}
 			return nil
-		`, requestCode, timeoutMillisecond))
+		`, retryPolicy, requestCode, timeoutMillisecond))
 	} else if outLength == 1 {
 		if "[]byte" == this.Outs[0].Type { //body
 			b.WriteString(fmt.Sprintf(`
 					var respBody []byte
-					if respBody, err = this.Invoke(serverInstance[0], %s, requestHeader,requestBody, %s, nil); !commons.IsNil(err) {
+					if respBody, err = this.InvokeRetry(serverInstance, %s, %s, requestHeader,requestBody, %s, nil); !commons.IsNil(err) {
 						return nil,protocol.ConvertError(err)
 					}else{
 						return respBody,nil
 					}
-				`, requestCode, timeoutMillisecond))
+				`, retryPolicy, requestCode, timeoutMillisecond))
 		} else if isBase(this.Outs[0].Type) {
 			log.Panic("方法" + this.serviceDef.Name + "." + this.Name + "返回值定义错误，只能为 struct,[]byte两种类型。")
 		} else { //from header
 			b.WriteString(fmt.Sprintf(`
 				respHeader := &%s{}
-				if _, err = this.Invoke(serverInstance[0], %s, requestHeader,requestBody, %s, respHeader); !commons.IsNil(err) {
+				if _, err = this.InvokeRetry(serverInstance, %s, %s, requestHeader,requestBody, %s, respHeader); !commons.IsNil(err) {
 					return nil,protocol.ConvertError(err)
 				}else{
 					return respHeader,nil
 				}
-			`, (this.tcd.ApiPackageName + "." + this.Outs[0].Type), requestCode, timeoutMillisecond))
+			`, (this.tcd.ApiPackageName + "." + this.Outs[0].Type), retryPolicy, requestCode, timeoutMillisecond))
 		}
 	} else {
 		b.WriteString(fmt.Sprintf(`
 			respHeader := &%s{}
 			var respBody []byte
-			if respBody, err = this.Invoke(serverInstance[0], %s, requestHeader,requestBody, %s, respHeader); !commons.IsNil(err) {
+			if respBody, err = this.InvokeRetry(serverInstance, %s, %s, requestHeader,requestBody, %s, respHeader); !commons.IsNil(err) {
 				return nil, nil, protocol.ConvertError(err)
 			}else{
 				return respHeader, respBody, nil
 			}
-		`, (this.tcd.ApiPackageName + "." + this.Outs[0].Type), requestCode, timeoutMillisecond))
+		`, (this.tcd.ApiPackageName + "." + this.Outs[0].Type), retryPolicy, requestCode, timeoutMillisecond))
 	}
 	return string(b.Bytes())
 }
@@ -278,6 +328,32 @@ func (this *FuncDef) InvokeBody() string {
 		st.Bodyer = true
 	}
 
+	if this.Stream {
+		st := struct {
+			Method   string
+			Request  string
+			ItemType string
+			Header   string
+			Body     string
+			Timeout  string
+		}{Method: this.Name, Request: st.Request, ItemType: this.Outs[0].UseShowType(), Header: "item", Body: "nil",
+			Timeout: this.TimeoutDuration()}
+		if st.Request != "" && !strings.HasSuffix(st.Request, ",") {
+			st.Request += ","
+		}
+		if "[]byte" == this.Outs[0].Type {
+			st.Header, st.Body = "nil", "item"
+		}
+		ftl(`
+			if err := service.{{.Method}}({{.Request}} func(item {{.ItemType}}) error {
+				return protocol.WriteStreamItem(channel, request, {{.Header}}, {{.Body}}, {{.Timeout}})
+			}); err != nil {
+				response.RemotingError(err)
+			}
+		`, st, b)
+		return string(b.Bytes())
+	}
+
 	ftl(`
 		if {{if .Header}}respHeader,{{end}}{{if .Bodyer}}respBody,{{end}} err := service.{{.Method}}({{.Request}}); err != nil {
 			response.RemotingError(err)
@@ -290,6 +366,14 @@ func (this *FuncDef) InvokeBody() string {
 	return string(b.Bytes())
 }
 
+// 接口定义中的返回值，流式方法的返回值通过回调函数传递
+func (this *FuncDef) ReturnOuts() []FunParam {
+	if this.Stream {
+		return nil
+	}
+	return this.Outs
+}
+
 func NameAndType(p string) (string, string) {
 	nt := strings.SplitN(p, " ", 2)
 	if len(nt) == 2 {
@@ -353,6 +437,11 @@ func (this *ServicesDef) Add(addLines []string, info *TCDInfo) error {
 		if gs[9] != "" {
 			funDef.Timeout = gs[9]
 		}
+		if gs[11] != "" {
+			funDef.Retry, _ = strconv.Atoi(gs[11])
+		}
+		funDef.Idempotent = gs[12] != ""
+		funDef.Stream = gs[13] != ""
 
 		if funDef.LoadBalance == "none" {
 			this.Imports.AddInterface(TenuredHome+"/registry/load_balance", "")
@@ -376,6 +465,11 @@ func (this *ServicesDef) Add(addLines []string, info *TCDInfo) error {
 		}
 		//errorss := gs[5]
 
+		if funDef.Stream && (len(funDef.Outs) != 1 ||
+			!(funDef.Outs[0].IsBody() || !isBase(funDef.Outs[0].Type) && !isArray(funDef.Outs[0].Type))) {
+			log.Panic("方法" + serviceDef.Name + "." + funDef.Name + " stream返回值定义错误，只能为 struct,[]byte 其中一个")
+		}
+
 		serviceDef.Funcs = append(serviceDef.Funcs, funDef)
 	}
 	this.Services = append(this.Services, serviceDef)
@@ -392,6 +486,11 @@ var (
 	{{range .Funcs}}{{$s.Name}}{{.Name}} = uint16({{.RequestCode}})
 	{{end}}
 		{{$s.Name}}Range = protocol.RequestCode{Min: {{.StartCode}}, Max: {{.EndCode}}}
+
+	//{{$s.Name}} 指定了负载均衡的请求码，值为负载均衡名称
+	{{$s.Name}}LoadBalances = map[uint16]string{ {{range .Funcs}}{{if .LoadBalance}}
+		{{$s.Name}}{{.Name}}: "{{.LoadBalance}}",{{end}}{{end}}
+	}
 {{end}}
 )
 
@@ -400,7 +499,7 @@ var (
 type {{.Name}} interface {
 	{{range .Funcs}}
 	{{.Desc}}
-	{{.Name}}({{if eq .LoadBalance "none" }} gl *load_balance.GlobalLoading,{{end}} {{range $i,$in := .Ins}}{{if gt $i 0}},{{end}} {{.Name}} {{.ShowType}}{{end}} ) ( {{range .Outs}}{{.ShowType}}, {{end}}*protocol.TenuredError )
+	{{.Name}}({{if eq .LoadBalance "none" }} gl *load_balance.GlobalLoading,{{end}} {{range $i,$in := .Ins}}{{if gt $i 0}},{{end}} {{.Name}} {{.ShowType}}{{end}}{{.StreamArg false}} ) ( {{range .ReturnOuts}}{{.ShowType}}, {{end}}*protocol.TenuredError )
 	{{end}}
 }{{end}}`, this, b)
 	return b.Bytes()
@@ -427,7 +526,7 @@ func (this *{{.Name}}Client) Shutdown(interrupt bool) {
 
 {{range .Funcs}}
 	{{.Desc}}
-func (this *{{$s.Name}}Client) {{.Name}}({{if eq .LoadBalance "none" }} gl *load_balance.GlobalLoading,{{end}}{{range $i,$in := .Ins}}{{if gt $i 0}},{{end}} {{.Name}} {{.UseShowType}}{{end}} ) ( {{range .Outs}}{{.UseShowType}}, {{end}}*protocol.TenuredError ) {
+func (this *{{$s.Name}}Client) {{.Name}}({{if eq .LoadBalance "none" }} gl *load_balance.GlobalLoading,{{end}}{{range $i,$in := .Ins}}{{if gt $i 0}},{{end}} {{.Name}} {{.UseShowType}}{{end}}{{.StreamArg true}} ) ( {{range .ReturnOuts}}{{.UseShowType}}, {{end}}*protocol.TenuredError ) {
 	{{.ClientBody}}
 }
 {{end}}
@@ -457,7 +556,19 @@ func New{{.Name}}Invoke(tenuredServer *protocol.TenuredServer, service {{$.TCD.A
 	{{.Desc}}
 	{
 		executor := manager.Get("{{$s.Name}}.{{.Name}}")
+		{{if .Stream}}
+		//不支持流式回复的旧版本客户端
+		tenuredServer.RegisterCommandProcesser({{$.TCD.ApiPackageName}}.{{$s.Name}}{{.Name}}, func(channel remoting.RemotingChannel, request *protocol.TenuredCommand) {
+			response := protocol.NewACK(request.ID()).RemotingError(protocol.ErrorVersion())
+			if err := channel.Write(response, {{.TimeoutDuration}}); err != nil {
+				logger.Error("{{$s.Name}}.{{.Name}} write error: ", err)
+			}
+		}, executor)
+		tenuredServer.RegisterVersionCommandProcesser({{$.TCD.ApiPackageName}}.{{$s.Name}}{{.Name}}, protocol.VERSION_STREAM, func(channel remoting.RemotingChannel, request *protocol.TenuredCommand) {
+			defer protocol.CloseStream(channel, request)
+		{{else}}
 		tenuredServer.RegisterCommandProcesser({{$.TCD.ApiPackageName}}.{{$s.Name}}{{.Name}}, func(channel remoting.RemotingChannel, request *protocol.TenuredCommand) {
+		{{end}}
 			response := protocol.NewACK(request.ID())
 			{{.InvokeBody}}
 			if err := channel.Write(response, {{.TimeoutDuration}}); err != nil {
@@ -0,0 +1,183 @@
+package breaker
+
+import (
+	"sync"
+	"time"
+)
+
+type State int32
+
+const (
+	StateClosed   State = iota //正常调用
+	StateOpen                  //熔断中，拒绝所有调用
+	StateHalfOpen              //熔断超时，允许少量探测调用
+)
+
+func (this State) String() string {
+	switch this {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknow"
+}
+
+type Config struct {
+	//连续失败多少次后熔断
+	FailureThreshold int `json:"failureThreshold" yaml:"failureThreshold"`
+
+	//熔断后多长时间进入半开状态，SECONDS
+	OpenTimeout int `json:"openTimeout" yaml:"openTimeout"`
+
+	//半开状态下允许同时探测的调用数
+	HalfOpenMaxCalls int `json:"halfOpenMaxCalls" yaml:"halfOpenMaxCalls"`
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		FailureThreshold: 5,
+		OpenTimeout:      10,
+		HalfOpenMaxCalls: 1,
+	}
+}
+
+//熔断器统计信息
+type Stat struct {
+	Name     string    `json:"name"`
+	State    string    `json:"state"`
+	Failures int       `json:"failures"` //当前连续失败次数
+	Success  uint64    `json:"success"`
+	Failure  uint64    `json:"failure"`
+	Rejected uint64    `json:"rejected"`
+	Changed  time.Time `json:"changed"` //最后状态变更时间
+}
+
+type Breaker struct {
+	name   string
+	config *Config
+	lock   *sync.Mutex
+
+	state         State
+	failures      int
+	halfOpenCalls int
+	changed       time.Time
+
+	success  uint64
+	failure  uint64
+	rejected uint64
+
+	onChange func(name string, from, to State)
+}
+
+func (this *Breaker) openTimeout() time.Duration {
+	return time.Duration(this.config.OpenTimeout) * time.Second
+}
+
+func (this *Breaker) setState(state State) {
+	if this.state == state {
+		return
+	}
+	from := this.state
+	this.state = state
+	this.changed = time.Now()
+	this.failures = 0
+	this.halfOpenCalls = 0
+	if this.onChange != nil {
+		this.onChange(this.name, from, state)
+	}
+}
+
+//熔断器打开，并且已经过了熔断时间的进入半开状态
+func (this *Breaker) currentState() State {
+	if this.state == StateOpen && time.Since(this.changed) >= this.openTimeout() {
+		this.setState(StateHalfOpen)
+	}
+	return this.state
+}
+
+//当前是否可以调用，不会占用半开状态的探测名额，用于负载均衡器过滤节点
+func (this *Breaker) Available() bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	switch this.currentState() {
+	case StateOpen:
+		return false
+	case StateHalfOpen:
+		return this.halfOpenCalls < this.config.HalfOpenMaxCalls
+	}
+	return true
+}
+
+//申请一次调用，返回false表示熔断中。申请成功后必须调用 Success 或者 Failure 反馈调用结果
+func (this *Breaker) Allow() bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	switch this.currentState() {
+	case StateOpen:
+		this.rejected++
+		return false
+	case StateHalfOpen:
+		if this.halfOpenCalls >= this.config.HalfOpenMaxCalls {
+			this.rejected++
+			return false
+		}
+		this.halfOpenCalls++
+	}
+	return true
+}
+
+func (this *Breaker) Success() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.success++
+	switch this.state {
+	case StateHalfOpen:
+		this.setState(StateClosed)
+	case StateClosed:
+		this.failures = 0
+	}
+}
+
+func (this *Breaker) Failure() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.failure++
+	switch this.state {
+	case StateHalfOpen:
+		this.setState(StateOpen)
+	case StateClosed:
+		this.failures++
+		if this.failures >= this.config.FailureThreshold {
+			this.setState(StateOpen)
+		}
+	}
+}
+
+func (this *Breaker) State() State {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.currentState()
+}
+
+func (this *Breaker) Stat() Stat {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return Stat{
+		Name: this.name, State: this.currentState().String(), Failures: this.failures,
+		Success: this.success, Failure: this.failure, Rejected: this.rejected,
+		Changed: this.changed,
+	}
+}
+
+func NewBreaker(name string, config *Config) *Breaker {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	return &Breaker{
+		name: name, config: config, lock: new(sync.Mutex),
+		state: StateClosed, changed: time.Now(),
+	}
+}
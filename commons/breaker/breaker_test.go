@@ -0,0 +1,49 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	b := NewBreaker("127.0.0.1:6071", &Config{FailureThreshold: 2, OpenTimeout: 1, HalfOpenMaxCalls: 1})
+	assert.Equal(t, StateClosed, b.State())
+
+	b.Failure()
+	b.Success()
+	b.Failure()
+	assert.Equal(t, StateClosed, b.State())
+	b.Failure()
+	assert.Equal(t, StateOpen, b.State())
+	assert.False(t, b.Allow())
+	assert.False(t, b.Available())
+
+	time.Sleep(time.Second)
+	assert.Equal(t, StateHalfOpen, b.State())
+	assert.True(t, b.Available())
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+	b.Failure()
+	assert.Equal(t, StateOpen, b.State())
+
+	time.Sleep(time.Second)
+	assert.True(t, b.Allow())
+	b.Success()
+	assert.Equal(t, StateClosed, b.State())
+
+	stat := b.Stat()
+	assert.Equal(t, uint64(2), stat.Success)
+	assert.Equal(t, uint64(4), stat.Failure)
+	assert.Equal(t, uint64(2), stat.Rejected)
+}
+
+func TestBreakers(t *testing.T) {
+	bs := NewBreakers(&Config{FailureThreshold: 1, OpenTimeout: 10, HalfOpenMaxCalls: 1})
+	assert.True(t, bs.Available("127.0.0.1:1"))
+	bs.Get("127.0.0.1:1").Failure()
+	assert.False(t, bs.Available("127.0.0.1:1"))
+	assert.True(t, bs.Available("127.0.0.1:2"))
+	assert.Equal(t, 1, len(bs.Stats()))
+}
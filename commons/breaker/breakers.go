@@ -0,0 +1,90 @@
+package breaker
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/ihaiker/tenured-go-server/commons/logs"
+)
+
+var logger = logs.GetLogger("breaker")
+
+//按照远程地址管理熔断器
+type Breakers struct {
+	config   *Config
+	lock     *sync.RWMutex
+	breakers map[string]*Breaker
+}
+
+//修改所有熔断器的阈值，config为nil时不修改
+func (this *Breakers) SetConfig(config *Config) {
+	if config == nil {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.config = config
+	for _, b := range this.breakers {
+		b.lock.Lock()
+		b.config = config
+		b.lock.Unlock()
+	}
+}
+
+func (this *Breakers) Get(address string) *Breaker {
+	this.lock.RLock()
+	b, has := this.breakers[address]
+	this.lock.RUnlock()
+	if has {
+		return b
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if b, has = this.breakers[address]; !has {
+		b = NewBreaker(address, this.config)
+		b.onChange = func(name string, from, to State) {
+			logger.Warnf("circuit breaker %s: %s -> %s", name, from, to)
+		}
+		this.breakers[address] = b
+	}
+	return b
+}
+
+//地址是否可用，没有调用过的地址总是可用的
+func (this *Breakers) Available(address string) bool {
+	this.lock.RLock()
+	b, has := this.breakers[address]
+	this.lock.RUnlock()
+	return !has || b.Available()
+}
+
+func (this *Breakers) Stats() []Stat {
+	this.lock.RLock()
+	stats := make([]Stat, 0, len(this.breakers))
+	for _, b := range this.breakers {
+		stats = append(stats, b.Stat())
+	}
+	this.lock.RUnlock()
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
+
+func NewBreakers(config *Config) *Breakers {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	return &Breakers{
+		config: config, lock: new(sync.RWMutex),
+		breakers: map[string]*Breaker{},
+	}
+}
+
+//进程内所有远程调用共用的熔断器，负载均衡器和调用方通过地址共享状态
+var defBreakers = NewBreakers(nil)
+
+func Default() *Breakers {
+	return defBreakers
+}
@@ -18,3 +18,12 @@ type ExecutorService interface {
 
 	Shutdown(interrupt bool)
 }
+
+//执行队列使用情况，用于检查执行器是否饱和
+type ExecutorQueue interface {
+	//等待执行的任务数
+	Pending() int
+
+	//队列容量
+	Capacity() int
+}
@@ -47,6 +47,14 @@ func (this *fixedExecutorService) InvokeAll(fn ...func() interface{}) []future.F
 	return all
 }
 
+func (this *fixedExecutorService) Pending() int {
+	return len(this.queue)
+}
+
+func (this *fixedExecutorService) Capacity() int {
+	return cap(this.queue)
+}
+
 func (this *fixedExecutorService) Shutdown(interrupt bool) {
 	this.status.Shutdown(func() {
 		this.interrupt = interrupt
@@ -15,6 +15,9 @@ type ExecutorManager interface {
 	Fix(name string, size, buffer int) ExecutorService
 	Single(name string, buffer int) ExecutorService
 
+	//所有的执行器，默认执行器的名称为default
+	All() map[string]ExecutorService
+
 	Config(config map[string]string) error
 }
 
@@ -51,6 +54,14 @@ func (this *defExecutorManager) Single(module string, buffer int) ExecutorServic
 	}
 }
 
+func (this *defExecutorManager) All() map[string]ExecutorService {
+	all := map[string]ExecutorService{"default": this.def}
+	for name, executor := range this.executorMap {
+		all[name] = executor
+	}
+	return all
+}
+
 func executorParam(value string) (exeType string, param []int, err error) {
 	m := regexp.MustCompile(`(fix|single|scheduled)\((\d+),?(\d+)?\)`)
 	if m.MatchString(value) {
@@ -13,6 +13,12 @@ const ServerPrefix = "tenured"
 const KeyRegistry = "tenured.registry"
 const Registry = "consul://127.0.0.1:8500"
 
+//模块间认证的共享密钥
+const KeyAuthSecret = "tenured.secret"
+
+//服务所在的可用区，注册时写入服务实例，调用时优先选择相同可用区的节点
+const KeyZone = "tenured.zone"
+
 const KeyDataPath = "tenured.dataPath"
 const DataPath = "/data/tenured"
 
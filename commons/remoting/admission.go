@@ -0,0 +1,192 @@
+package remoting
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	errMaxConnections      = errors.New("too many connections")
+	errMaxConnectionsPerIP = errors.New("too many connections from the same ip")
+	errBanned              = errors.New("the ip is banned")
+)
+
+//认证失败和封禁记录的最大数量，超过后淘汰最早的记录，防止大量IP认证失败时占用内存
+const admissionMaxEntries = 65536
+
+type authFailure struct {
+	count int
+	first time.Time
+}
+
+//连接准入控制：总连接数、单IP连接数和认证失败封禁
+type admission struct {
+	maxConnections      int
+	maxConnectionsPerIP int
+	authFailureLimit    int
+	authBanTime         time.Duration
+
+	mutex    sync.Mutex
+	total    int
+	perIP    map[string]int
+	bans     map[string]time.Time
+	failures map[string]*authFailure
+
+	maxEntries int
+	//上次清理过期记录的时间
+	expired time.Time
+}
+
+func newAdmission(config *RemotingConfig) *admission {
+	return &admission{
+		maxConnections:      config.MaxConnections,
+		maxConnectionsPerIP: config.MaxConnectionsPerIP,
+		authFailureLimit:    config.AuthFailureLimit,
+		authBanTime:         time.Duration(config.AuthBanTime) * time.Second,
+		perIP:               map[string]int{},
+		bans:                map[string]time.Time{},
+		failures:            map[string]*authFailure{},
+		maxEntries:          admissionMaxEntries,
+	}
+}
+
+//获取地址中的IP。unix socket 连接只能来自本机，没有对端IP，返回空字符串，不做单IP连接数限制和认证失败封禁
+func hostOf(address string) string {
+	if IsUnixAddress(address) {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
+
+func (this *admission) acquire(ip string) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if until, has := this.bans[ip]; has && ip != "" {
+		if time.Now().Before(until) {
+			return errBanned
+		}
+		delete(this.bans, ip)
+	}
+	if this.maxConnections > 0 && this.total >= this.maxConnections {
+		return errMaxConnections
+	}
+	if this.maxConnectionsPerIP > 0 && ip != "" && this.perIP[ip] >= this.maxConnectionsPerIP {
+		return errMaxConnectionsPerIP
+	}
+	this.total++
+	if ip != "" {
+		this.perIP[ip]++
+	}
+	return nil
+}
+
+func (this *admission) release(ip string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.total--
+	if ip == "" {
+		return
+	}
+	if this.perIP[ip]--; this.perIP[ip] <= 0 {
+		delete(this.perIP, ip)
+	}
+}
+
+//记录认证失败，封禁时间内失败次数达到上限时封禁该IP，返回是否被封禁
+func (this *admission) authFailure(ip string) bool {
+	if ip == "" || this.authFailureLimit <= 0 || this.authBanTime <= 0 {
+		return false
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	now := time.Now()
+	this.expire(now)
+	failure, has := this.failures[ip]
+	if !has || now.Sub(failure.first) > this.authBanTime {
+		if !has && len(this.failures) >= this.maxEntries {
+			this.evictFailure()
+		}
+		failure = &authFailure{first: now}
+		this.failures[ip] = failure
+	}
+	if failure.count++; failure.count >= this.authFailureLimit {
+		delete(this.failures, ip)
+		if _, has := this.bans[ip]; !has && len(this.bans) >= this.maxEntries {
+			this.evictBan()
+		}
+		this.bans[ip] = now.Add(this.authBanTime)
+		return true
+	}
+	return false
+}
+
+//定期清理过期的认证失败和封禁记录，最多每 authBanTime 清理一次
+func (this *admission) expire(now time.Time) {
+	if now.Sub(this.expired) < this.authBanTime {
+		return
+	}
+	this.expired = now
+	for ip, failure := range this.failures {
+		if now.Sub(failure.first) > this.authBanTime {
+			delete(this.failures, ip)
+		}
+	}
+	for ip, until := range this.bans {
+		if !now.Before(until) {
+			delete(this.bans, ip)
+		}
+	}
+}
+
+//淘汰最早开始记录的认证失败
+func (this *admission) evictFailure() {
+	oldest, first := "", time.Time{}
+	for ip, failure := range this.failures {
+		if oldest == "" || failure.first.Before(first) {
+			oldest, first = ip, failure.first
+		}
+	}
+	delete(this.failures, oldest)
+}
+
+//淘汰最早解封的IP
+func (this *admission) evictBan() {
+	oldest, first := "", time.Time{}
+	for ip, until := range this.bans {
+		if oldest == "" || until.Before(first) {
+			oldest, first = ip, until
+		}
+	}
+	delete(this.bans, oldest)
+}
+
+func (this *admission) authSuccess(ip string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	delete(this.failures, ip)
+}
+
+//当前被封禁的IP和解封时间
+func (this *admission) banned() map[string]time.Time {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	now := time.Now()
+	out := map[string]time.Time{}
+	for ip, until := range this.bans {
+		if now.Before(until) {
+			out[ip] = until
+		} else {
+			delete(this.bans, ip)
+		}
+	}
+	return out
+}
@@ -0,0 +1,148 @@
+package remoting
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdmission(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxConnections = 3
+	config.MaxConnectionsPerIP = 2
+	config.AuthFailureLimit = 2
+	config.AuthBanTime = 1
+	admission := newAdmission(config)
+
+	assert.Nil(t, admission.acquire("10.0.0.1"))
+	assert.Nil(t, admission.acquire("10.0.0.1"))
+	assert.Equal(t, errMaxConnectionsPerIP, admission.acquire("10.0.0.1"))
+	assert.Nil(t, admission.acquire("10.0.0.2"))
+	assert.Equal(t, errMaxConnections, admission.acquire("10.0.0.3"))
+
+	admission.release("10.0.0.1")
+	assert.Nil(t, admission.acquire("10.0.0.3"))
+
+	assert.False(t, admission.authFailure("10.0.0.4"))
+	admission.authSuccess("10.0.0.4")
+	assert.False(t, admission.authFailure("10.0.0.4"))
+	assert.True(t, admission.authFailure("10.0.0.4"))
+	assert.Contains(t, admission.banned(), "10.0.0.4")
+
+	admission.release("10.0.0.3")
+	assert.Equal(t, errBanned, admission.acquire("10.0.0.4"))
+	time.Sleep(time.Millisecond * 1100)
+	assert.Nil(t, admission.acquire("10.0.0.4"))
+	assert.Empty(t, admission.banned())
+}
+
+func TestRemotingServer_MaxConnectionsPerIP(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxConnectionsPerIP = 1
+	server, _ := NewRemotingServer("127.0.0.1:6084", config)
+	server.SetCoder(DefaultCoder())
+	server.SetHandler(&HandlerWrapper{})
+	assert.Nil(t, server.Start())
+	defer server.Shutdown(true)
+
+	first, err := net.Dial("tcp", "127.0.0.1:6084")
+	assert.Nil(t, err)
+	defer first.Close()
+
+	second, err := net.Dial("tcp", "127.0.0.1:6084")
+	assert.Nil(t, err)
+	defer second.Close()
+
+	//第二个连接被服务端直接关闭
+	_ = second.SetReadDeadline(time.Now().Add(time.Second * 3))
+	_, err = second.Read(make([]byte, 1))
+	assert.NotNil(t, err)
+	if netErr, ok := err.(net.Error); ok {
+		assert.False(t, netErr.Timeout())
+	}
+}
+
+//本机 unix socket 连接不共享单IP连接数，也不会被封禁
+func TestAdmission_UnixSocket(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxConnections = 3
+	config.MaxConnectionsPerIP = 1
+	config.AuthFailureLimit = 1
+	config.AuthBanTime = 10
+	admission := newAdmission(config)
+
+	assert.Equal(t, "", hostOf("unix:///tmp/tenured.sock#1"))
+	assert.Equal(t, "10.0.0.1", hostOf("10.0.0.1:6071"))
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, admission.acquire(""))
+		assert.False(t, admission.authFailure(""))
+	}
+	assert.Equal(t, errMaxConnections, admission.acquire(""))
+	assert.Empty(t, admission.perIP)
+	assert.Empty(t, admission.banned())
+	admission.release("")
+	assert.Nil(t, admission.acquire(""))
+}
+
+func TestRemotingServer_UnixSocketPerIP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenured")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config := DefaultConfig()
+	config.MaxConnectionsPerIP = 1
+	config.UnixSocket = filepath.Join(dir, "admission.sock")
+	server, err := NewRemotingServer("127.0.0.1:6096", config)
+	assert.Nil(t, err)
+	server.SetCoder(DefaultCoder())
+	server.SetHandler(&HandlerWrapper{})
+	assert.Nil(t, server.Start())
+	defer server.Shutdown(true)
+	time.Sleep(time.Millisecond * 100)
+
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("unix", config.UnixSocket)
+		assert.Nil(t, err)
+		defer conn.Close()
+
+		//两个连接都没有被服务端关闭，读取超时
+		_ = conn.SetReadDeadline(time.Now().Add(time.Millisecond * 300))
+		_, err = conn.Read(make([]byte, 1))
+		if netErr, ok := err.(net.Error); assert.True(t, ok) {
+			assert.True(t, netErr.Timeout())
+		}
+	}
+}
+
+func TestAdmission_Expire(t *testing.T) {
+	config := DefaultConfig()
+	config.AuthFailureLimit = 2
+	config.AuthBanTime = 1
+	admission := newAdmission(config)
+	admission.maxEntries = 2
+
+	//超过上限淘汰最早的记录
+	for _, ip := range []string{"10.0.1.1", "10.0.1.2", "10.0.1.3"} {
+		assert.False(t, admission.authFailure(ip))
+	}
+	assert.Equal(t, 2, len(admission.failures))
+	assert.NotContains(t, admission.failures, "10.0.1.1")
+
+	for _, ip := range []string{"10.0.2.1", "10.0.2.2", "10.0.2.3"} {
+		admission.authFailure(ip)
+		assert.True(t, admission.authFailure(ip))
+	}
+	assert.Equal(t, 2, len(admission.bans))
+	assert.NotContains(t, admission.bans, "10.0.2.1")
+
+	//过期后再次记录时清理
+	time.Sleep(time.Millisecond * 1100)
+	assert.False(t, admission.authFailure("10.0.3.1"))
+	assert.Equal(t, 1, len(admission.failures))
+	assert.Empty(t, admission.bans)
+}
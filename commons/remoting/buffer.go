@@ -0,0 +1,68 @@
+package remoting
+
+import (
+	"sync"
+)
+
+const (
+	minBufferShift = 6  //64B
+	maxBufferShift = 20 //1M
+)
+
+//按照2的幂次分级的字节缓存池，减少编解码时的内存分配
+type BufferPool struct {
+	pools []*sync.Pool
+}
+
+//缓存级别，返回-1表示超出缓存范围
+func (this *BufferPool) level(size int) int {
+	for shift := minBufferShift; shift <= maxBufferShift; shift++ {
+		if size <= 1<<uint(shift) {
+			return shift - minBufferShift
+		}
+	}
+	return -1
+}
+
+//获取一个长度为size的缓存，使用完成后需要调用 Release 归还
+func (this *BufferPool) Acquire(size int) []byte {
+	idx := this.level(size)
+	if idx < 0 {
+		return make([]byte, size)
+	}
+	bs := this.pools[idx].Get().(*[]byte)
+	return (*bs)[:size]
+}
+
+//归还缓存，只接受 Acquire 分配的缓存，归还后不能再次使用
+func (this *BufferPool) Release(bs []byte) {
+	size := cap(bs)
+	idx := this.level(size)
+	if idx < 0 || size != 1<<uint(idx+minBufferShift) {
+		return
+	}
+	bs = bs[:size]
+	this.pools[idx].Put(&bs)
+}
+
+func NewBufferPool() *BufferPool {
+	pool := &BufferPool{pools: make([]*sync.Pool, maxBufferShift-minBufferShift+1)}
+	for i := range pool.pools {
+		size := 1 << uint(i+minBufferShift)
+		pool.pools[i] = &sync.Pool{New: func() interface{} {
+			bs := make([]byte, size)
+			return &bs
+		}}
+	}
+	return pool
+}
+
+var defBufferPool = NewBufferPool()
+
+func AcquireBuffer(size int) []byte {
+	return defBufferPool.Acquire(size)
+}
+
+func ReleaseBuffer(bs []byte) {
+	defBufferPool.Release(bs)
+}
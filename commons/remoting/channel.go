@@ -1,25 +1,39 @@
 package remoting
 
 import (
+	"bufio"
 	"errors"
 	"github.com/ihaiker/tenured-go-server/commons"
 	"io"
 	"net"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type RemotingChannel interface {
 	RemoteAddr() string
 
-	Attributes() map[string]interface{}
+	//真实的客户端地址，经过受信任代理时为 PROXY 头信息中的地址，否则和 RemoteAddr 相同
+	ClientAddr() string
+
+	//连接的附加属性，读写协程和处理器协程都会访问，使用锁保护
+	GetAttribute(key string) (interface{}, bool)
+	SetAttribute(key string, value interface{})
 
 	Write(msg interface{}, timeout time.Duration) error
 
 	AsyncWrite(msg interface{}, timeout time.Duration, callback func(error))
 
+	//发送队列中等待发送的消息数
+	Pending() int
+
+	//修改当前连接的心跳间隔。passive为true时由对端发送心跳，本端不再调用 OnIdle 只检测超时
+	SetHeartbeat(interval time.Duration, passive bool)
+
 	Close()
 }
 
@@ -32,11 +46,14 @@ type sendMessage struct {
 type defChannel struct {
 	config *RemotingConfig
 
-	addr    string
-	conn    *net.TCPConn
-	coder   RemotingCoder
-	handler RemotingHandler
+	addr       string
+	clientAddr string
+	conn       net.Conn
+	reader     *bufio.Reader
+	coder      RemotingCoder
+	handler    RemotingHandler
 
+	attrLock   *sync.RWMutex
 	attributes map[string]interface{}
 
 	onCloseFn func(channel RemotingChannel)
@@ -45,17 +62,38 @@ type defChannel struct {
 	closeOnce *sync.Once
 
 	sendChan chan sendMessage
-
-	waitGroup   *sync.WaitGroup
-	idleTimer   *time.Timer
-	idleTimeout int
+	//发送队列是否处于高水位，1:是
+	highWatermark int32
+
+	waitGroup *sync.WaitGroup
+	idleTimer *time.Timer
+	//连续没有读取到数据的心跳周期数，读取协程清零，心跳协程累加
+	idleTimeout int32
+	//当前连接的心跳间隔，纳秒
+	idleTime int64
+	//是否由对端发送心跳，1:是
+	idlePassive int32
 }
 
 func (this *defChannel) RemoteAddr() string {
 	return this.addr
 }
-func (this *defChannel) Attributes() map[string]interface{} {
-	return this.attributes
+func (this *defChannel) ClientAddr() string {
+	if this.clientAddr != "" {
+		return this.clientAddr
+	}
+	return this.addr
+}
+func (this *defChannel) GetAttribute(key string) (interface{}, bool) {
+	this.attrLock.RLock()
+	defer this.attrLock.RUnlock()
+	value, has := this.attributes[key]
+	return value, has
+}
+func (this *defChannel) SetAttribute(key string, value interface{}) {
+	this.attrLock.Lock()
+	defer this.attrLock.Unlock()
+	this.attributes[key] = value
 }
 
 func (this *defChannel) encodeMessage(msg interface{}) (bs []byte, err error) {
@@ -92,6 +130,7 @@ func (this *defChannel) write(msg interface{}, timeout time.Duration, callback f
 		return err
 	} else {
 		if timeoutTime.Before(time.Now()) { //encode timeout
+			this.release(bs)
 			err := &RemotingError{Op: ErrSendTimeout, Err: errors.New("send timeout: " + timeout.String())}
 			if callback != nil {
 				callback(err)
@@ -100,8 +139,10 @@ func (this *defChannel) write(msg interface{}, timeout time.Duration, callback f
 		}
 		fn := func() error {
 			result := make(chan error, 1)
-			this.sendChan <- sendMessage{msg: bs, timeout: time.Now().Add(timeout), result: result}
-			err := <-result
+			err := this.enqueue(sendMessage{msg: bs, timeout: timeoutTime, result: result})
+			if err == nil {
+				err = <-result
+			}
 			close(result)
 			if callback != nil {
 				callback(err)
@@ -117,6 +158,90 @@ func (this *defChannel) write(msg interface{}, timeout time.Duration, callback f
 	}
 }
 
+//放入发送队列，队列满时按照 OverflowPolicy 处理
+func (this *defChannel) enqueue(msg sendMessage) (err error) {
+	defer func() {
+		if err != nil {
+			this.release(msg.msg)
+		} else {
+			this.checkHighWatermark()
+		}
+	}()
+
+	select {
+	case <-this.closeChan:
+		return &RemotingError{Op: ErrClosed, Err: errors.New("the channel is closed")}
+	default:
+	}
+	select {
+	case this.sendChan <- msg:
+		return nil
+	default:
+	}
+
+	switch this.config.OverflowPolicy {
+	case OverflowDropNewest:
+		return &RemotingError{Op: ErrOverflow, Err: errors.New("send queue is full, drop message")}
+	case OverflowDisconnect:
+		logger.Warnf("channel %s send queue is full, disconnect", this.RemoteAddr())
+		this.Close()
+		return &RemotingError{Op: ErrOverflow, Err: errors.New("send queue is full, disconnect")}
+	case OverflowDropOldest:
+		for {
+			select {
+			case <-this.closeChan:
+				return &RemotingError{Op: ErrClosed, Err: errors.New("the channel is closed")}
+			case oldest := <-this.sendChan:
+				this.release(oldest.msg)
+				oldest.result <- &RemotingError{Op: ErrOverflow, Err: errors.New("send queue is full, drop oldest message")}
+			default:
+			}
+			select {
+			case this.sendChan <- msg:
+				return nil
+			default:
+			}
+		}
+	default: //OverflowBlock
+		timer := time.NewTimer(time.Until(msg.timeout))
+		defer timer.Stop()
+		select {
+		case <-this.closeChan:
+			return &RemotingError{Op: ErrClosed, Err: errors.New("the channel is closed")}
+		case this.sendChan <- msg:
+			return nil
+		case <-timer.C:
+			return &RemotingError{Op: ErrSendTimeout, Err: errors.New("send queue is full, timeout")}
+		}
+	}
+}
+
+func (this *defChannel) checkHighWatermark() {
+	if this.config.HighWatermark <= 0 {
+		return
+	}
+	if pending := len(this.sendChan); pending >= this.config.HighWatermark &&
+		atomic.CompareAndSwapInt32(&this.highWatermark, 0, 1) {
+		logger.Debugf("channel %s high watermark: %d", this.RemoteAddr(), pending)
+		this.handler.OnHighWatermark(this, pending)
+	}
+}
+
+func (this *defChannel) checkLowWatermark() {
+	if atomic.LoadInt32(&this.highWatermark) == 0 {
+		return
+	}
+	if pending := len(this.sendChan); pending <= this.config.LowWatermark &&
+		atomic.CompareAndSwapInt32(&this.highWatermark, 1, 0) {
+		logger.Debugf("channel %s low watermark: %d", this.RemoteAddr(), pending)
+		this.handler.OnLowWatermark(this, pending)
+	}
+}
+
+func (this *defChannel) Pending() int {
+	return len(this.sendChan)
+}
+
 func (this *defChannel) Write(msg interface{}, timeout time.Duration) error {
 	return this.write(msg, timeout, nil)
 }
@@ -124,8 +249,11 @@ func (this *defChannel) AsyncWrite(msg interface{}, timeout time.Duration, callb
 	_ = this.write(msg, timeout, callback)
 }
 
+//onClose为nil时使用创建时设置的关闭回调
 func (this *defChannel) Do(onClose func(channel RemotingChannel)) error {
-	this.onCloseFn = onClose
+	if onClose != nil {
+		this.onCloseFn = onClose
+	}
 	go this.syncDo(this.readLoop)
 	if this.config.IdleTime > 0 {
 		go this.syncDo(this.heartbeatLoop)
@@ -157,11 +285,19 @@ func (this *defChannel) closeUnWriteMessageChan() {
 		case <-time.After(time.Second):
 			return
 		case msg := <-this.sendChan:
+			this.release(msg.msg)
 			msg.result <- &RemotingError{Op: ErrClosed, Err: errors.New("the channel is closed")}
 		}
 	}
 }
 
+//回收编码器从缓存池中分配的数据
+func (this *defChannel) release(bs []byte) {
+	if isPooledCoder(this.coder) {
+		ReleaseBuffer(bs)
+	}
+}
+
 func (this *defChannel) writeLoop() {
 	defer func() {
 		defer logger.Debug("channel close write loop: ", this.RemoteAddr())
@@ -170,20 +306,72 @@ func (this *defChannel) writeLoop() {
 	}()
 	logger.Debug("channel start write loop:", this.RemoteAddr())
 
+	batchSize := this.config.WriteBatch
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	batch := make([]sendMessage, 0, batchSize)
+	buffers := make(net.Buffers, 0, batchSize)
 	for {
 		select {
 		case <-this.closeChan:
 			return
 		case msg := <-this.sendChan:
-			if msg.timeout.Before(time.Now()) {
-				msg.result <- &RemotingError{Op: ErrSendTimeout, Err: errors.New("send timeout")}
-			} else if _, err := this.conn.Write(msg.msg); err != nil {
-				msg.result <- err
-			} else {
-				msg.result <- nil
+			batch = append(batch[:0], msg)
+			//合并队列中已经在等待的消息，队列为空时让出一次调度给其他发送者，仍然为空则立即写出
+			for yield := batchSize > 1; ; yield = false {
+				batch = this.drain(batch, batchSize)
+				if !yield || len(batch) > 1 {
+					break
+				}
+				runtime.Gosched()
 			}
+			buffers = this.flush(batch, buffers[:0])
+			this.checkLowWatermark()
+		}
+	}
+}
+
+//非阻塞的取出队列中等待的消息
+func (this *defChannel) drain(batch []sendMessage, batchSize int) []sendMessage {
+	for len(batch) < batchSize {
+		select {
+		case msg := <-this.sendChan:
+			batch = append(batch, msg)
+		default:
+			return batch
 		}
 	}
+	return batch
+}
+
+//使用一次（writev）系统调用写出一批消息，并通知每个消息的发送结果
+func (this *defChannel) flush(batch []sendMessage, buffers net.Buffers) net.Buffers {
+	now := time.Now()
+	for _, msg := range batch {
+		if !msg.timeout.Before(now) {
+			buffers = append(buffers, msg.msg)
+		}
+	}
+	var err error
+	if len(buffers) > 0 {
+		//WriteTo会修改切片本身，这里使用副本，保留底层数组供下次使用
+		writes := buffers
+		_, err = writes.WriteTo(this.conn)
+	}
+	for i, msg := range batch {
+		if msg.timeout.Before(now) {
+			msg.result <- &RemotingError{Op: ErrSendTimeout, Err: errors.New("send timeout")}
+		} else {
+			msg.result <- err
+		}
+		this.release(msg.msg)
+		batch[i].msg = nil
+	}
+	for i := range buffers {
+		buffers[i] = nil
+	}
+	return buffers
 }
 
 func (this *defChannel) readLoop() {
@@ -197,7 +385,7 @@ func (this *defChannel) readLoop() {
 		case <-this.closeChan:
 			return
 		default:
-			if msg, err := this.decoderMessage(this.conn); err != nil {
+			if msg, err := this.decoderMessage(this.reader); err != nil {
 				if err != io.EOF && err != io.ErrUnexpectedEOF && err.Error() == "use of closed network connection" {
 					logger.Errorf("channel %s decoder error: %s ", this.RemoteAddr(), err)
 				}
@@ -224,7 +412,7 @@ func (this *defChannel) handlerMessage(msg interface{}) {
 	this.handler.OnMessage(this, msg)
 }
 
-func (this *defChannel) decoderMessage(conn *net.TCPConn) (msg interface{}, err error) {
+func (this *defChannel) decoderMessage(reader io.Reader) (msg interface{}, err error) {
 	defer func() {
 		if e := recover(); e != nil {
 			err = commons.Catch(e)
@@ -235,7 +423,7 @@ func (this *defChannel) decoderMessage(conn *net.TCPConn) (msg interface{}, err
 	}()
 
 	_ = this.conn.SetReadDeadline(time.Now().Add(time.Second))
-	msg, err = this.coder.Decode(this, conn)
+	msg, err = this.coder.Decode(this, reader)
 	if err != nil {
 		if opErr, ok := err.(*net.OpError); ok && opErr.Timeout() {
 			err = nil
@@ -254,11 +442,24 @@ func (c *defChannel) isClosed(err error) bool {
 }
 
 func (this *defChannel) heartbeatTimeout() time.Duration {
-	return time.Second * time.Duration(this.config.IdleTime)
+	return time.Duration(atomic.LoadInt64(&this.idleTime))
+}
+
+func (this *defChannel) SetHeartbeat(interval time.Duration, passive bool) {
+	if interval <= 0 {
+		return
+	}
+	atomic.StoreInt64(&this.idleTime, int64(interval))
+	if passive {
+		atomic.StoreInt32(&this.idlePassive, 1)
+	} else {
+		atomic.StoreInt32(&this.idlePassive, 0)
+	}
+	this.idleTimer.Reset(interval)
 }
 func (this *defChannel) resetReadIdle() {
 	this.idleTimer.Reset(this.heartbeatTimeout())
-	this.idleTimeout = 0
+	atomic.StoreInt32(&this.idleTimeout, 0)
 }
 
 func (this *defChannel) heartbeatLoop() {
@@ -266,18 +467,18 @@ func (this *defChannel) heartbeatLoop() {
 	logger.Debug("start heartbeat loop: ", this.RemoteAddr())
 	defer this.Close()
 
-	idleCheckTime := this.heartbeatTimeout()
 	for {
 		select {
 		case <-this.closeChan:
 			return
 		case t := <-this.idleTimer.C:
 			timestr := t.Format("2006-01-02 15:04:05")
-			if this.idleTimeout+1 <= this.config.IdleTimeout {
-				logger.Debugf("SendIdle to: %s, time: %s", this.RemoteAddr(), timestr)
-				this.idleTimeout = this.idleTimeout + 1
-				this.idleTimer.Reset(idleCheckTime)
-				this.handler.OnIdle(this)
+			if int(atomic.AddInt32(&this.idleTimeout, 1)) <= this.config.IdleTimeout {
+				this.idleTimer.Reset(this.heartbeatTimeout())
+				if atomic.LoadInt32(&this.idlePassive) == 0 {
+					logger.Debugf("SendIdle to: %s, time: %s", this.RemoteAddr(), timestr)
+					this.handler.OnIdle(this)
+				}
 			} else {
 				logger.Debugf("IdleTimerOut: %s, %s", this.RemoteAddr(), timestr)
 				return
@@ -292,21 +493,25 @@ func (this *defChannel) syncDo(fn func()) {
 	fn()
 }
 
-func NewChannel(conn *net.TCPConn, config *RemotingConfig) *defChannel {
-	_ = conn.SetNoDelay(true)
-	_ = conn.SetKeepAlive(true)
-	_ = conn.SetKeepAlivePeriod(time.Duration(config.IdleTime) * time.Second) //这个地方依赖系统
+func NewChannel(conn net.Conn, config *RemotingConfig) *defChannel {
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		_ = tcpConn.SetNoDelay(true)
+		_ = tcpConn.SetKeepAlive(true)
+		_ = tcpConn.SetKeepAlivePeriod(time.Duration(config.IdleTime) * time.Second) //这个地方依赖系统
+	}
 
 	channel := &defChannel{
 		config:     config,
 		conn:       conn,
+		reader:     bufio.NewReader(conn),
 		addr:       conn.RemoteAddr().String(),
+		attrLock:   new(sync.RWMutex),
 		attributes: map[string]interface{}{},
 		closeChan:  make(chan struct{}),
 		closeOnce:  &sync.Once{},
 		sendChan:   make(chan sendMessage, config.SendLimit),
+		idleTime:   int64(time.Duration(config.IdleTime) * time.Second),
 	}
 	channel.idleTimer = time.NewTimer(channel.heartbeatTimeout())
-	channel.idleTimeout = 0
 	return channel
 }
@@ -0,0 +1,101 @@
+package remoting
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+//使用缓存池编码的测试编码器，解码时直接丢弃数据
+type benchCoder struct{}
+
+func (this *benchCoder) Decode(channel RemotingChannel, reader io.Reader) (interface{}, error) {
+	bs := AcquireBuffer(4096)
+	defer ReleaseBuffer(bs)
+	if _, err := reader.Read(bs); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (this *benchCoder) Encode(channel RemotingChannel, msg interface{}) ([]byte, error) {
+	if bs, ok := msg.([]byte); ok {
+		out := AcquireBuffer(len(bs))
+		copy(out, bs)
+		return out, nil
+	}
+	return nil, os.ErrInvalid
+}
+
+func (this *benchCoder) Pooled() bool {
+	return true
+}
+
+func benchmarkWrite(b *testing.B, port int, writeBatch int) {
+	config := DefaultConfig()
+	config.WriteBatch = writeBatch
+	address := "127.0.0.1:" + strconv.Itoa(port)
+
+	server, _ := NewRemotingServer(address, config)
+	server.SetCoder(&benchCoder{})
+	server.SetHandler(&HandlerWrapper{})
+	if err := server.Start(); err != nil {
+		b.Fatal(err)
+	}
+	defer server.Shutdown(true)
+
+	client := NewRemotingClient(config)
+	client.SetCoder(&benchCoder{})
+	client.SetHandler(&HandlerWrapper{})
+	_ = client.Start()
+	defer client.Shutdown(true)
+
+	msg := make([]byte, 128)
+	if err := client.SendTo(address, msg, time.Second); err != nil {
+		b.Fatal(err)
+	}
+
+	b.SetBytes(int64(len(msg)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.SetParallelism(16)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := client.SendTo(address, msg, time.Second*3); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.StopTimer()
+}
+
+//WriteBatch=1 为合并写出之前每条消息一次系统调用的行为
+func BenchmarkChannel_Write(b *testing.B) {
+	b.Run("single", func(b *testing.B) {
+		benchmarkWrite(b, 6081, 1)
+	})
+	b.Run("batch", func(b *testing.B) {
+		benchmarkWrite(b, 6082, DefaultConfig().WriteBatch)
+	})
+}
+
+var benchSink []byte
+
+func BenchmarkBufferPool(b *testing.B) {
+	b.Run("make", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			benchSink = make([]byte, 1024)
+		}
+	})
+	b.Run("pool", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			benchSink = AcquireBuffer(1024)
+			ReleaseBuffer(benchSink)
+		}
+	})
+}
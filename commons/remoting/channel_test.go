@@ -0,0 +1,119 @@
+package remoting
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type watermarkHandler struct {
+	HandlerWrapper
+	high, low chan int
+}
+
+func (h *watermarkHandler) OnHighWatermark(c RemotingChannel, pending int) {
+	h.high <- pending
+}
+
+func (h *watermarkHandler) OnLowWatermark(c RemotingChannel, pending int) {
+	h.low <- pending
+}
+
+//创建一个没有启动读写循环的channel，发送的消息会一直积压在队列中
+func newPendingChannel(t *testing.T, policy OverflowPolicy) (*defChannel, *watermarkHandler) {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	conn, err := net.DialTCP("tcp", nil, listener.Addr().(*net.TCPAddr))
+	assert.Nil(t, err)
+
+	config := DefaultConfig()
+	config.SendLimit = 2
+	config.HighWatermark = 2
+	config.LowWatermark = 0
+	config.OverflowPolicy = policy
+
+	handler := &watermarkHandler{high: make(chan int, 1), low: make(chan int, 1)}
+	channel := NewChannel(conn, config)
+	channel.coder = DefaultCoder()
+	channel.handler = handler
+	return channel, handler
+}
+
+func asyncWrite(channel *defChannel, msg string) chan error {
+	result := make(chan error, 1)
+	channel.AsyncWrite([]byte(msg), time.Millisecond*200, func(err error) {
+		result <- err
+	})
+	return result
+}
+
+func TestChannel_OverflowBlock(t *testing.T) {
+	channel, handler := newPendingChannel(t, OverflowBlock)
+	defer channel.Close()
+
+	asyncWrite(channel, "1")
+	asyncWrite(channel, "2")
+	assert.Equal(t, 2, <-handler.high)
+	assert.Equal(t, 2, channel.Pending())
+
+	err := channel.Write([]byte("3"), time.Millisecond*100)
+	assert.True(t, IsRemotingError(err, ErrSendTimeout))
+}
+
+func TestChannel_OverflowDropNewest(t *testing.T) {
+	channel, _ := newPendingChannel(t, OverflowDropNewest)
+	defer channel.Close()
+
+	asyncWrite(channel, "1")
+	asyncWrite(channel, "2")
+	time.Sleep(time.Millisecond * 50)
+	err := channel.Write([]byte("3"), time.Second)
+	assert.True(t, IsRemotingError(err, ErrOverflow))
+	assert.Equal(t, 2, channel.Pending())
+}
+
+func TestChannel_OverflowDropOldest(t *testing.T) {
+	channel, _ := newPendingChannel(t, OverflowDropOldest)
+	defer channel.Close()
+
+	first := asyncWrite(channel, "1")
+	time.Sleep(time.Millisecond * 20)
+	asyncWrite(channel, "2")
+	time.Sleep(time.Millisecond * 20)
+	asyncWrite(channel, "3")
+	assert.True(t, IsRemotingError(<-first, ErrOverflow))
+
+	msg := <-channel.sendChan
+	assert.Equal(t, "2", string(msg.msg))
+}
+
+func TestChannel_OverflowDisconnect(t *testing.T) {
+	channel, _ := newPendingChannel(t, OverflowDisconnect)
+
+	asyncWrite(channel, "1")
+	asyncWrite(channel, "2")
+	time.Sleep(time.Millisecond * 50)
+	err := channel.Write([]byte("3"), time.Second)
+	assert.True(t, IsRemotingError(err, ErrOverflow))
+
+	err = channel.Write([]byte("4"), time.Second)
+	assert.True(t, IsRemotingError(err, ErrClosed))
+}
+
+func TestChannel_LowWatermark(t *testing.T) {
+	channel, handler := newPendingChannel(t, OverflowBlock)
+	defer channel.Close()
+
+	asyncWrite(channel, "1")
+	asyncWrite(channel, "2")
+	assert.Equal(t, 2, <-handler.high)
+
+	<-channel.sendChan
+	<-channel.sendChan
+	channel.checkLowWatermark()
+	assert.Equal(t, 0, <-handler.low)
+}
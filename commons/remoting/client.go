@@ -2,7 +2,6 @@ package remoting
 
 import (
 	"github.com/ihaiker/tenured-go-server/commons"
-	"net"
 	"sync"
 	"time"
 )
@@ -34,14 +33,14 @@ func (this *RemotingClient) createNewChannel(address string, timeout time.Durati
 	this.lock.Lock()
 	defer this.lock.Unlock()
 
-	if channel, ok := this.channels[address]; ok { //并发的双重检查
+	if channel, err := this.remotingImpl.getChannel(address, timeout); err == nil { //并发的双重检查
 		return channel, nil
 	}
 
-	if conn, err := net.DialTimeout("tcp", address, timeout); err != nil {
+	if conn, err := dial(address, timeout); err != nil {
 		return nil, err
 	} else {
-		channel, err := this.newChannel(address, conn.(*net.TCPConn))
+		channel, err := this.newChannel(address, conn)
 		return channel, err
 	}
 }
@@ -53,12 +52,13 @@ func NewRemotingClient(config *RemotingConfig) *RemotingClient {
 	client := &RemotingClient{
 		lock: &sync.Mutex{},
 		remotingImpl: remotingImpl{
-			config:    config,
-			channels:  make(map[string]RemotingChannel),
-			exitChan:  make(chan struct{}),
-			status:    commons.S_STATUS_INIT,
-			waitGroup: &sync.WaitGroup{},
-			hocks:     map[Hock]func(){},
+			config:       config,
+			channelsLock: new(sync.RWMutex),
+			channels:     make(map[string]RemotingChannel),
+			exitChan:     make(chan struct{}),
+			status:       commons.S_STATUS_INIT,
+			waitGroup:    &sync.WaitGroup{},
+			hocks:        map[Hock]func(){},
 		},
 	}
 	return client
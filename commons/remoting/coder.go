@@ -10,6 +10,19 @@ type RemotingCoder interface {
 	Encode(RemotingChannel, interface{}) ([]byte, error)
 }
 
+//编码结果由 AcquireBuffer 分配的编码器，写出之后channel负责调用 ReleaseBuffer 回收
+type PooledCoder interface {
+	RemotingCoder
+	Pooled() bool
+}
+
+func isPooledCoder(coder RemotingCoder) bool {
+	if pc, ok := coder.(PooledCoder); ok {
+		return pc.Pooled()
+	}
+	return false
+}
+
 type RemotingCoderFactory func(RemotingChannel, RemotingConfig) RemotingCoder
 
 type Bytes1024Coder struct{}
@@ -4,20 +4,64 @@ import (
 	"encoding/json"
 )
 
+//发送队列满时的处理策略
+type OverflowPolicy string
+
+const (
+	OverflowBlock      = OverflowPolicy("block")      //阻塞等待，直到发送超时
+	OverflowDropOldest = OverflowPolicy("dropOldest") //丢弃队列中最早的消息
+	OverflowDropNewest = OverflowPolicy("dropNewest") //丢弃当前发送的消息
+	OverflowDisconnect = OverflowPolicy("disconnect") //关闭连接
+)
+
 type RemotingConfig struct {
 	//Asynchronously send message size
 	SendLimit int `json:"sendLimit" yaml:"sendLimit"`
 
+	//发送队列满时的处理策略，默认：block
+	OverflowPolicy OverflowPolicy `json:"overflowPolicy" yaml:"overflowPolicy"`
+
+	//发送队列积压超过高水位时调用 RemotingHandler.OnHighWatermark，
+	//之后降到低水位以下时调用 RemotingHandler.OnLowWatermark。小于等于0不检查
+	HighWatermark int `json:"highWatermark" yaml:"highWatermark"`
+	LowWatermark  int `json:"lowWatermark" yaml:"lowWatermark"`
+
+	//一次系统调用最多合并写出的消息数，小于等于1不合并
+	WriteBatch int `json:"writeBatch" yaml:"writeBatch"`
+
 	// the limit of packet send channel
 	PacketBytesLimit int `json:"packetBytesLimit" yaml:"packetBytesLimit"`
 
 	AcceptTimeout int `json:"acceptTimeout" yaml:"acceptTimeout"`
 
+	//服务端额外监听的unix domain socket文件，同一主机上的服务可以通过 unix:// 地址调用。为空不监听
+	UnixSocket string `json:"unixSocket,omitempty" yaml:"unixSocket,omitempty"`
+
+	//受信任的代理地址（CIDR、IP或者unix，unix表示信任所有unix socket连接），来自这些地址的连接先读取 HAProxy PROXY protocol v1/v2 头信息，
+	//通过 RemotingChannel.ClientAddr 获取真实的客户端地址。为空时不解析
+	TrustedProxies []string `json:"trustedProxies,omitempty" yaml:"trustedProxies,omitempty"`
+
+	//服务端最大连接数和单个IP的最大连接数，小于等于0不限制。经过受信任代理时按照真实的客户端IP计算
+	MaxConnections      int `json:"maxConnections" yaml:"maxConnections"`
+	MaxConnectionsPerIP int `json:"maxConnectionsPerIP" yaml:"maxConnectionsPerIP"`
+
+	//连接建立后必须在此时间内完成认证，否则关闭连接，SECONDS。小于等于0不检查
+	AuthTimeout int `json:"authTimeout" yaml:"authTimeout"`
+
+	//AuthBanTime 时间内认证失败 AuthFailureLimit 次后，封禁该IP AuthBanTime，SECONDS。小于等于0不封禁
+	AuthFailureLimit int `json:"authFailureLimit" yaml:"authFailureLimit"`
+	AuthBanTime      int `json:"authBanTime" yaml:"authBanTime"`
+
 	//heartbeat time,and timeout SECONDS
 	IdleTime int `json:"idleTime" yaml:"idleTime"`
 
 	//连续几次heartbeat不传递就就认为掉线
 	IdleTimeout int `json:"idleTimeout" yaml:"idleTimeout"`
+
+	//客户端在认证时可以提议自己的心跳间隔并负责发送心跳，服务端限制在此范围内，SECONDS。
+	//MaxIdleTime小于等于0时不接受客户端提议
+	MinIdleTime int `json:"minIdleTime" yaml:"minIdleTime"`
+	MaxIdleTime int `json:"maxIdleTime" yaml:"maxIdleTime"`
 }
 
 func (cfg *RemotingConfig) String() string {
@@ -28,9 +72,18 @@ func (cfg *RemotingConfig) String() string {
 func DefaultConfig() *RemotingConfig {
 	return &RemotingConfig{
 		SendLimit:        10000,
+		OverflowPolicy:   OverflowBlock,
+		HighWatermark:    8000,
+		LowWatermark:     2000,
+		WriteBatch:       64,
 		PacketBytesLimit: 1024,
 		AcceptTimeout:    3,
+		AuthTimeout:      10,
+		AuthFailureLimit: 5,
+		AuthBanTime:      300,
 		IdleTime:         15,
 		IdleTimeout:      3,
+		MinIdleTime:      5,
+		MaxIdleTime:      300,
 	}
 }
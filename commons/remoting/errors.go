@@ -20,6 +20,7 @@ const (
 	ErrPacketBytesLimit = ErrorType("PacketBytesLimit")
 	ErrClosed           = ErrorType("Closed")
 	ErrSendTimeout      = ErrorType("Timeout")
+	ErrOverflow         = ErrorType("Overflow")
 
 	ErrNoChannel = ErrorType("NoChannel")
 )
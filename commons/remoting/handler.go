@@ -15,6 +15,12 @@ type RemotingHandler interface {
 
 	//关闭事件，当当前客户端关闭连接
 	OnClose(c RemotingChannel)
+
+	//发送队列积压到高水位，pending为当前队列中等待发送的消息数
+	OnHighWatermark(c RemotingChannel, pending int)
+
+	//发送队列从高水位恢复到低水位以下
+	OnLowWatermark(c RemotingChannel, pending int)
 }
 
 type RemotingHandlerFactory func(RemotingChannel, RemotingConfig) RemotingHandler
@@ -41,3 +47,11 @@ func (h *HandlerWrapper) OnError(c RemotingChannel, err error, msg interface{})
 func (h *HandlerWrapper) OnIdle(c RemotingChannel) {
 	//logger.Debugf("RemotingHandler OnIdle : %s", c.RemoteAddr())
 }
+
+func (h *HandlerWrapper) OnHighWatermark(c RemotingChannel, pending int) {
+	//logger.Debugf("RemotingHandler OnHighWatermark : %s, %d", c.RemoteAddr(), pending)
+}
+
+func (h *HandlerWrapper) OnLowWatermark(c RemotingChannel, pending int) {
+	//logger.Debugf("RemotingHandler OnLowWatermark : %s, %d", c.RemoteAddr(), pending)
+}
@@ -0,0 +1,48 @@
+package remoting
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type passiveHandler struct {
+	HandlerWrapper
+	idles int32
+}
+
+func (this *passiveHandler) OnChannel(channel RemotingChannel) error {
+	channel.SetHeartbeat(time.Millisecond*300, true)
+	return nil
+}
+
+func (this *passiveHandler) OnIdle(channel RemotingChannel) {
+	atomic.AddInt32(&this.idles, 1)
+}
+
+func TestChannel_PassiveHeartbeat(t *testing.T) {
+	config := DefaultConfig()
+	config.IdleTime = 10
+	config.IdleTimeout = 2
+	server, _ := NewRemotingServer("127.0.0.1:6085", config)
+	handler := &passiveHandler{}
+	server.SetCoder(DefaultCoder())
+	server.SetHandler(handler)
+	assert.Nil(t, server.Start())
+	defer server.Shutdown(true)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:6085")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	//对端不发送心跳，按照协商的间隔超时关闭，服务端不发送心跳
+	start := time.Now()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	_, err = conn.Read(make([]byte, 1))
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < time.Second*3)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&handler.idles))
+}
@@ -0,0 +1,69 @@
+package remoting
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//unix domain socket 地址前缀，例如：unix:///var/run/tenured/store.sock
+const UnixPrefix = "unix://"
+
+//解析地址对应的网络类型，unix:// 开头的地址使用unix domain socket，其他使用tcp
+func ParseAddress(address string) (network string, addr string) {
+	if strings.HasPrefix(address, UnixPrefix) {
+		return "unix", strings.TrimPrefix(address, UnixPrefix)
+	}
+	return "tcp", address
+}
+
+func IsUnixAddress(address string) bool {
+	return strings.HasPrefix(address, UnixPrefix)
+}
+
+type deadlineListener interface {
+	net.Listener
+	SetDeadline(t time.Time) error
+}
+
+func listen(address string) (deadlineListener, error) {
+	network, addr := ParseAddress(address)
+	if network == "unix" {
+		//上次异常退出时遗留的socket文件
+		if _, err := os.Stat(addr); err == nil {
+			if conn, err := net.Dial("unix", addr); err == nil {
+				_ = conn.Close()
+				return nil, fmt.Errorf("the socket %s is in use", addr)
+			}
+			_ = os.Remove(addr)
+		}
+		unixAddr, err := net.ResolveUnixAddr("unix", addr)
+		if err != nil {
+			return nil, err
+		}
+		return net.ListenUnix("unix", unixAddr)
+	}
+	tcpAddr, err := net.ResolveTCPAddr("tcp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.ListenTCP("tcp", tcpAddr)
+}
+
+func dial(address string, timeout time.Duration) (net.Conn, error) {
+	network, addr := ParseAddress(address)
+	return net.DialTimeout(network, addr, timeout)
+}
+
+var unixConnIndex uint64
+
+//unix socket 连接没有对端地址，使用监听地址和序号区分不同的连接
+func remoteAddress(listenAddress string, conn net.Conn) string {
+	if _, ok := conn.(*net.UnixConn); ok {
+		return fmt.Sprintf("%s#%d", listenAddress, atomic.AddUint64(&unixConnIndex, 1))
+	}
+	return conn.RemoteAddr().String()
+}
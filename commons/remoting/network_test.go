@@ -0,0 +1,66 @@
+package remoting
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type receiverHandler struct {
+	HandlerWrapper
+	received chan string
+}
+
+func (this *receiverHandler) OnMessage(channel RemotingChannel, msg interface{}) {
+	this.received <- string(msg.([]byte))
+}
+
+func TestParseAddress(t *testing.T) {
+	network, addr := ParseAddress("unix:///var/run/tenured/store.sock")
+	assert.Equal(t, "unix", network)
+	assert.Equal(t, "/var/run/tenured/store.sock", addr)
+
+	network, addr = ParseAddress("127.0.0.1:6072")
+	assert.Equal(t, "tcp", network)
+	assert.Equal(t, "127.0.0.1:6072", addr)
+}
+
+func TestRemotingServer_UnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenured")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config := DefaultConfig()
+	config.UnixSocket = filepath.Join(dir, "remoting.sock")
+	server, _ := NewRemotingServer("127.0.0.1:6087", config)
+	received := make(chan string, 2)
+	server.SetCoder(DefaultCoder())
+	server.SetHandler(&receiverHandler{received: received})
+	assert.Nil(t, server.Start())
+	defer server.Shutdown(true)
+	time.Sleep(time.Millisecond * 100)
+
+	client := NewRemotingClient(nil)
+	client.SetCoder(DefaultCoder())
+	client.SetHandler(&HandlerWrapper{})
+	assert.Nil(t, client.Start())
+	defer client.Shutdown(true)
+
+	assert.Nil(t, client.SendTo(UnixPrefix+config.UnixSocket, []byte("unix"), time.Second))
+	assert.Nil(t, client.SendTo("127.0.0.1:6087", []byte("tcp"), time.Second))
+	//两个连接的消息到达顺序不确定
+	messages := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-received:
+			messages[msg] = true
+		case <-time.After(time.Second * 3):
+			t.Fatal("wait message timeout")
+		}
+	}
+	assert.Equal(t, map[string]bool{"unix": true, "tcp": true}, messages)
+}
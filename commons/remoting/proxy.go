@@ -0,0 +1,162 @@
+package remoting
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/ihaiker/tenured-go-server/commons"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+//HAProxy PROXY protocol v2 签名
+var proxyV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+var proxyV1Prefix = []byte("PROXY ")
+
+const ErrProxyHeader = commons.Error("invalid proxy protocol header")
+
+//v1头信息最大长度，包含结尾的 \r\n
+const proxyV1MaxLength = 107
+
+//读取 PROXY protocol v1/v2 头信息，返回真实的客户端地址。
+//没有头信息，或者头信息中没有客户端地址（v1 UNKNOWN，v2 LOCAL）时返回空字符串
+func ReadProxyHeader(reader *bufio.Reader) (string, error) {
+	//v1头和v2头的最短长度都不小于v2签名长度，连接上没有任何数据时直接返回
+	peek, err := reader.Peek(len(proxyV2Signature))
+	if err != nil {
+		if len(peek) > 0 && bytes.HasPrefix(proxyV1Prefix, peek) {
+			return "", ErrProxyHeader
+		}
+		return "", nil
+	}
+	if bytes.Equal(peek, proxyV2Signature) {
+		return readProxyV2(reader)
+	} else if bytes.HasPrefix(peek, proxyV1Prefix) {
+		return readProxyV1(reader)
+	}
+	return "", nil
+}
+
+//PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func readProxyV1(reader *bufio.Reader) (string, error) {
+	line := make([]byte, 0, proxyV1MaxLength)
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		} else if len(line) >= proxyV1MaxLength {
+			return "", ErrProxyHeader
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return "", ErrProxyHeader
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return "", nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return "", ErrProxyHeader
+	}
+	ip := net.ParseIP(fields[2])
+	if ip == nil {
+		return "", ErrProxyHeader
+	}
+	if port, err := strconv.ParseUint(fields[4], 10, 16); err != nil {
+		return "", ErrProxyHeader
+	} else {
+		return net.JoinHostPort(ip.String(), strconv.Itoa(int(port))), nil
+	}
+}
+
+func readProxyV2(reader *bufio.Reader) (string, error) {
+	head := make([]byte, 16)
+	if _, err := io.ReadFull(reader, head); err != nil {
+		return "", err
+	}
+	if head[12]>>4 != 0x2 {
+		return "", ErrProxyHeader
+	}
+	command, family := head[12]&0x0F, head[13]
+	body := make([]byte, binary.BigEndian.Uint16(head[14:16]))
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return "", err
+	}
+	//LOCAL 命令是代理自己发起的连接（例如健康检查）
+	if command == 0x0 {
+		return "", nil
+	} else if command != 0x1 {
+		return "", ErrProxyHeader
+	}
+	switch family {
+	case 0x11: //TCP over IPv4
+		if len(body) < 12 {
+			return "", ErrProxyHeader
+		}
+		port := binary.BigEndian.Uint16(body[8:10])
+		return net.JoinHostPort(net.IP(body[0:4]).String(), strconv.Itoa(int(port))), nil
+	case 0x21: //TCP over IPv6
+		if len(body) < 36 {
+			return "", ErrProxyHeader
+		}
+		port := binary.BigEndian.Uint16(body[32:34])
+		return net.JoinHostPort(net.IP(body[0:16]).String(), strconv.Itoa(int(port))), nil
+	default:
+		return "", nil
+	}
+}
+
+//受信任的代理地址，只解析来自这些地址的 PROXY 头信息
+type trustedProxies struct {
+	nets []*net.IPNet
+	//信任所有unix domain socket连接，只有本机的进程可以连接
+	unix bool
+}
+
+//支持CIDR、单个IP和 unix（信任所有 unix socket 连接）
+func parseTrustedProxies(values []string) (trustedProxies, error) {
+	proxies := trustedProxies{}
+	for _, value := range values {
+		if value == "unix" || value == UnixPrefix {
+			proxies.unix = true
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			if ip := net.ParseIP(value); ip == nil {
+				return proxies, errors.New("invalid trusted proxy: " + value)
+			} else if ip.To4() != nil {
+				value += "/32"
+			} else {
+				value += "/128"
+			}
+		}
+		if _, ipNet, err := net.ParseCIDR(value); err != nil {
+			return proxies, err
+		} else {
+			proxies.nets = append(proxies.nets, ipNet)
+		}
+	}
+	return proxies, nil
+}
+
+func (this trustedProxies) Contains(addr net.Addr) bool {
+	switch addr := addr.(type) {
+	case *net.UnixAddr:
+		return this.unix
+	case *net.TCPAddr:
+		for _, ipNet := range this.nets {
+			if ipNet.Contains(addr.IP) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,198 @@
+package remoting
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type proxyHandler struct {
+	HandlerWrapper
+	clientAddr chan string
+}
+
+func (this *proxyHandler) OnChannel(channel RemotingChannel) error {
+	this.clientAddr <- channel.ClientAddr()
+	return nil
+}
+
+func proxyV2Header(command byte, ip net.IP, port uint16) []byte {
+	header := bytes.NewBuffer(nil)
+	header.Write(proxyV2Signature)
+	header.WriteByte(0x20 | command)
+	body := bytes.NewBuffer(nil)
+	if ip4 := ip.To4(); ip4 != nil {
+		header.WriteByte(0x11)
+		body.Write(ip4)
+		body.Write(net.IPv4(10, 0, 0, 1).To4())
+	} else {
+		header.WriteByte(0x21)
+		body.Write(ip.To16())
+		body.Write(net.IPv6loopback)
+	}
+	_ = binary.Write(body, binary.BigEndian, port)
+	_ = binary.Write(body, binary.BigEndian, uint16(6073))
+	_ = binary.Write(header, binary.BigEndian, uint16(body.Len()))
+	header.Write(body.Bytes())
+	return header.Bytes()
+}
+
+func readProxy(data []byte) (string, string, error) {
+	reader := bufio.NewReader(bytes.NewReader(data))
+	addr, err := ReadProxyHeader(reader)
+	rest := new(bytes.Buffer)
+	_, _ = rest.ReadFrom(reader)
+	return addr, rest.String(), err
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	addr, rest, err := readProxy([]byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 6073\r\nhello"))
+	assert.Nil(t, err)
+	assert.Equal(t, "192.168.0.1:56324", addr)
+	assert.Equal(t, "hello", rest)
+
+	addr, _, err = readProxy([]byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 6073\r\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, "[2001:db8::1]:56324", addr)
+
+	addr, rest, err = readProxy([]byte("PROXY UNKNOWN\r\nhello"))
+	assert.Nil(t, err)
+	assert.Equal(t, "", addr)
+	assert.Equal(t, "hello", rest)
+
+	_, _, err = readProxy([]byte("PROXY TCP4 192.168.0.1\r\n"))
+	assert.Equal(t, ErrProxyHeader, err)
+
+	addr, rest, err = readProxy(append(proxyV2Header(0x1, net.IPv4(172, 16, 0, 3), 40000), []byte("hello")...))
+	assert.Nil(t, err)
+	assert.Equal(t, "172.16.0.3:40000", addr)
+	assert.Equal(t, "hello", rest)
+
+	addr, _, err = readProxy(proxyV2Header(0x1, net.ParseIP("2001:db8::3"), 40000))
+	assert.Nil(t, err)
+	assert.Equal(t, "[2001:db8::3]:40000", addr)
+
+	addr, rest, err = readProxy(append(proxyV2Header(0x0, net.IPv4(172, 16, 0, 3), 40000), []byte("hello")...))
+	assert.Nil(t, err)
+	assert.Equal(t, "", addr)
+	assert.Equal(t, "hello", rest)
+
+	//没有头信息，数据保持不变
+	addr, rest, err = readProxy([]byte("hello world, tenured"))
+	assert.Nil(t, err)
+	assert.Equal(t, "", addr)
+	assert.Equal(t, "hello world, tenured", rest)
+}
+
+func TestTrustedProxies(t *testing.T) {
+	proxies, err := parseTrustedProxies([]string{"10.0.0.0/8", "127.0.0.1", "::1"})
+	assert.Nil(t, err)
+	assert.True(t, proxies.Contains(&net.TCPAddr{IP: net.IPv4(10, 1, 2, 3)}))
+	assert.True(t, proxies.Contains(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}))
+	assert.True(t, proxies.Contains(&net.TCPAddr{IP: net.IPv6loopback}))
+	assert.False(t, proxies.Contains(&net.TCPAddr{IP: net.IPv4(192, 168, 0, 1)}))
+	assert.False(t, proxies.Contains(&net.UnixAddr{Net: "unix"}))
+
+	proxies, err = parseTrustedProxies([]string{"unix"})
+	assert.Nil(t, err)
+	assert.True(t, proxies.Contains(&net.UnixAddr{Net: "unix"}))
+	assert.False(t, proxies.Contains(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}))
+
+	_, err = parseTrustedProxies([]string{"10.0.0.300"})
+	assert.NotNil(t, err)
+}
+
+func TestRemotingServer_ProxyProtocol(t *testing.T) {
+	config := DefaultConfig()
+	config.TrustedProxies = []string{"127.0.0.1"}
+	server, err := NewRemotingServer("127.0.0.1:6083", config)
+	assert.Nil(t, err)
+
+	clientAddr := make(chan string, 1)
+	server.SetCoder(DefaultCoder())
+	server.SetHandler(&proxyHandler{clientAddr: clientAddr})
+	assert.Nil(t, server.Start())
+	defer server.Shutdown(true)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:6083")
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, _ = conn.Write([]byte("PROXY TCP4 192.168.0.1 127.0.0.1 56324 6083\r\n"))
+
+	select {
+	case addr := <-clientAddr:
+		assert.Equal(t, "192.168.0.1:56324", addr)
+	case <-time.After(time.Second * 3):
+		t.Fatal("wait channel timeout")
+	}
+}
+
+//代理没有发送头信息时不影响其他连接的接入
+func TestRemotingServer_ProxySlowHeader(t *testing.T) {
+	config := DefaultConfig()
+	config.AcceptTimeout = 5
+	config.TrustedProxies = []string{"127.0.0.1"}
+	server, err := NewRemotingServer("127.0.0.1:6094", config)
+	assert.Nil(t, err)
+
+	clientAddr := make(chan string, 1)
+	server.SetCoder(DefaultCoder())
+	server.SetHandler(&proxyHandler{clientAddr: clientAddr})
+	assert.Nil(t, server.Start())
+	defer server.Shutdown(true)
+
+	slow, err := net.Dial("tcp", "127.0.0.1:6094")
+	assert.Nil(t, err)
+	defer slow.Close()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:6094")
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, _ = conn.Write([]byte("PROXY TCP4 192.168.0.2 127.0.0.1 56325 6094\r\n"))
+
+	select {
+	case addr := <-clientAddr:
+		assert.Equal(t, "192.168.0.2:56325", addr)
+	case <-time.After(time.Second):
+		t.Fatal("wait channel timeout")
+	}
+}
+
+func TestRemotingServer_ProxyUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenured")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config := DefaultConfig()
+	config.TrustedProxies = []string{"unix"}
+	config.UnixSocket = filepath.Join(dir, "proxy.sock")
+	server, err := NewRemotingServer("127.0.0.1:6095", config)
+	assert.Nil(t, err)
+
+	clientAddr := make(chan string, 1)
+	server.SetCoder(DefaultCoder())
+	server.SetHandler(&proxyHandler{clientAddr: clientAddr})
+	assert.Nil(t, server.Start())
+	defer server.Shutdown(true)
+	time.Sleep(time.Millisecond * 100)
+
+	conn, err := net.Dial("unix", config.UnixSocket)
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, _ = conn.Write([]byte("PROXY TCP4 192.168.0.3 127.0.0.1 56326 6095\r\n"))
+
+	select {
+	case addr := <-clientAddr:
+		assert.Equal(t, "192.168.0.3:56326", addr)
+	case <-time.After(time.Second * 3):
+		t.Fatal("wait channel timeout")
+	}
+}
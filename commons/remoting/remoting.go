@@ -35,8 +35,10 @@ type Remoting interface {
 }
 
 type remotingImpl struct {
-	config   *RemotingConfig
-	channels map[string]RemotingChannel
+	config *RemotingConfig
+	//连接在读取协程中关闭，服务端在各自的协程中创建，需要加锁访问
+	channelsLock *sync.RWMutex
+	channels     map[string]RemotingChannel
 
 	status   commons.ServerStatus
 	exitChan chan struct{} // notify all goroutines to shutdown
@@ -47,6 +49,9 @@ type remotingImpl struct {
 
 	hocks           map[Hock]func()
 	channelSelector func(address string, timeout time.Duration) (RemotingChannel, error)
+
+	//连接关闭后的回调，在 RemotingHandler.OnClose 之后调用
+	onChannelClose func(channel RemotingChannel)
 }
 
 func (this *remotingImpl) SetCoderFactory(coderFactory RemotingCoderFactory) {
@@ -121,27 +126,44 @@ func (this *remotingImpl) SyncSendTo(address string, msg interface{}, timeout ti
 }
 
 func (this *remotingImpl) getChannel(address string, timeout time.Duration) (RemotingChannel, error) {
-	if channel, ok := this.channels[address]; ok {
+	this.channelsLock.RLock()
+	channel, ok := this.channels[address]
+	this.channelsLock.RUnlock()
+	if ok {
 		return channel, nil
 	} else {
 		return nil, &RemotingError{Op: ErrNoChannel, Err: errors.New("not found channel " + address)}
 	}
 }
 
-func (this *remotingImpl) newChannel(address string, conn *net.TCPConn) (RemotingChannel, error) {
+func (this *remotingImpl) newChannel(address string, conn net.Conn, options ...func(channel *defChannel)) (RemotingChannel, error) {
 	this.waitGroup.Add(1)
 	logger.Debugf("new channel：%s", address)
 
 	channel := NewChannel(conn, this.config)
 	channel.addr = address
+	for _, option := range options {
+		option(channel)
+	}
 	channel.waitGroup = this.waitGroup
 	channel.coder = this.coderFactory(channel, *this.config)
 	channel.handler = this.handlerFactory(channel, *this.config)
-	this.channels[address] = channel
-	err := channel.Do(func(ch RemotingChannel) {
-		delete(this.channels, ch.RemoteAddr())
+	//加入连接表之后就可能被 closeChannels 关闭，关闭回调需要在此之前设置
+	channel.onCloseFn = func(ch RemotingChannel) {
+		this.channelsLock.Lock()
+		if this.channels[ch.RemoteAddr()] == ch {
+			delete(this.channels, ch.RemoteAddr())
+		}
+		this.channelsLock.Unlock()
+		if this.onChannelClose != nil {
+			this.onChannelClose(ch)
+		}
 		this.waitGroup.Done()
-	})
+	}
+	this.channelsLock.Lock()
+	this.channels[address] = channel
+	this.channelsLock.Unlock()
+	err := channel.Do(nil)
 	return channel, err
 }
 
@@ -154,11 +176,18 @@ func (this *remotingImpl) IsStatus(status commons.ServerStatus) bool {
 }
 
 func (this *remotingImpl) closeChannels() {
-	for _, v := range this.channels {
+	this.channelsLock.Lock()
+	channels := make([]RemotingChannel, 0, len(this.channels))
+	for address, v := range this.channels {
 		if v != nil {
-			delete(this.channels, v.RemoteAddr())
-			v.Close()
+			channels = append(channels, v)
 		}
+		delete(this.channels, address)
+	}
+	this.channelsLock.Unlock()
+	//关闭回调中会再次加锁
+	for _, v := range channels {
+		v.Close()
 	}
 }
 
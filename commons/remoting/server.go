@@ -1,6 +1,7 @@
 package remoting
 
 import (
+	"bufio"
 	"github.com/ihaiker/tenured-go-server/commons"
 	"net"
 	"sync"
@@ -10,6 +11,9 @@ import (
 type RemotingServer struct {
 	address string
 	remotingImpl
+
+	proxies   trustedProxies
+	admission *admission
 }
 
 func (this *RemotingServer) Start() error {
@@ -17,16 +21,22 @@ func (this *RemotingServer) Start() error {
 		return nil
 	}
 
-	if tcpAddr, err := net.ResolveTCPAddr("tcp4", this.address); err != nil {
-		return err
-	} else if listener, err := net.ListenTCP("tcp", tcpAddr); err != nil {
+	listener, err := listen(this.address)
+	if err != nil {
 		return err
-	} else {
-		go this.startListener(listener)
-		return nil
 	}
+	if this.config.UnixSocket != "" && !IsUnixAddress(this.address) {
+		unixListener, err := listen(UnixPrefix + this.config.UnixSocket)
+		if err != nil {
+			_ = listener.Close()
+			return err
+		}
+		go this.startListener(UnixPrefix+this.config.UnixSocket, unixListener)
+	}
+	go this.startListener(this.address, listener)
+	return nil
 }
-func (this *RemotingServer) startListener(listener *net.TCPListener) {
+func (this *RemotingServer) startListener(listenAddress string, listener deadlineListener) {
 	this.waitGroup.Add(1)
 	defer func() {
 		_ = listener.Close()
@@ -42,7 +52,7 @@ func (this *RemotingServer) startListener(listener *net.TCPListener) {
 		case <-this.exitChan:
 			return
 		default:
-			conn, err := listener.AcceptTCP()
+			conn, err := listener.Accept()
 			if err != nil {
 				if netErr, ok := err.(*net.OpError); ok && netErr.Timeout() {
 					continue
@@ -51,27 +61,109 @@ func (this *RemotingServer) startListener(listener *net.TCPListener) {
 					return
 				}
 			}
-			address := conn.RemoteAddr().String()
-			if _, err = this.newChannel(address, conn); err != nil {
-				logger.Infof("the server reject connection. %s", err.Error())
-			}
+			//读取代理头信息可能等待 acceptTimeout，不能阻塞其他连接的接入
+			this.waitGroup.Add(1)
+			go this.accept(listenAddress, conn, acceptTimeout)
 		}
 	}
 }
+
+func (this *RemotingServer) accept(listenAddress string, conn net.Conn, timeout time.Duration) {
+	defer this.waitGroup.Done()
+	address := remoteAddress(listenAddress, conn)
+	reader := bufio.NewReader(conn)
+	clientAddr := ""
+	if this.proxies.Contains(conn.RemoteAddr()) {
+		var err error
+		if clientAddr, err = this.readProxyHeader(conn, reader, timeout); err != nil {
+			logger.Warnf("read proxy header from %s error: %s", address, err)
+			_ = conn.Close()
+			return
+		}
+	}
+	if !this.IsActive() {
+		_ = conn.Close()
+		return
+	}
+	if err := this.admission.acquire(hostOf(clientAddrOr(clientAddr, address))); err != nil {
+		logger.Infof("the server reject connection %s: %s", clientAddrOr(clientAddr, address), err)
+		_ = conn.Close()
+		return
+	}
+	if _, err := this.newChannel(address, conn, func(channel *defChannel) {
+		channel.reader = reader
+		channel.clientAddr = clientAddr
+	}); err != nil {
+		logger.Infof("the server reject connection. %s", err.Error())
+	}
+}
+func clientAddrOr(clientAddr, address string) string {
+	if clientAddr != "" {
+		return clientAddr
+	}
+	return address
+}
+
+//记录连接认证失败，失败次数过多时封禁客户端IP并关闭连接
+func (this *RemotingServer) AuthFailure(channel RemotingChannel) {
+	ip := hostOf(channel.ClientAddr())
+	if this.admission.authFailure(ip) {
+		logger.Warnf("too many auth failures, ban %s for %s", ip, this.admission.authBanTime)
+		channel.Close()
+	}
+}
+
+func (this *RemotingServer) AuthSuccess(channel RemotingChannel) {
+	this.admission.authSuccess(hostOf(channel.ClientAddr()))
+}
+
+//当前被封禁的IP和解封时间
+func (this *RemotingServer) Banned() map[string]time.Time {
+	return this.admission.banned()
+}
+
+//代理在建立连接后立即发送头信息，最多等待 acceptTimeout，服务关闭时立即放弃
+func (this *RemotingServer) readProxyHeader(conn net.Conn, reader *bufio.Reader, timeout time.Duration) (string, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-this.exitChan:
+			_ = conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	defer func() {
+		close(done)
+		_ = conn.SetReadDeadline(time.Time{})
+	}()
+	return ReadProxyHeader(reader)
+}
+
 func NewRemotingServer(address string, config *RemotingConfig) (*RemotingServer, error) {
 	if config == nil {
 		config = DefaultConfig()
 	}
+	proxies, err := parseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
 	server := &RemotingServer{
-		address: address,
+		address:   address,
+		proxies:   proxies,
+		admission: newAdmission(config),
 		remotingImpl: remotingImpl{
-			config:    config,
-			channels:  make(map[string]RemotingChannel),
-			exitChan:  make(chan struct{}),
-			status:    commons.S_STATUS_INIT,
-			waitGroup: &sync.WaitGroup{},
-			hocks:     map[Hock]func(){},
+			config:       config,
+			channelsLock: new(sync.RWMutex),
+			channels:     make(map[string]RemotingChannel),
+			exitChan:     make(chan struct{}),
+			status:       commons.S_STATUS_INIT,
+			waitGroup:    &sync.WaitGroup{},
+			hocks:        map[Hock]func(){},
 		},
 	}
+	server.onChannelClose = func(channel RemotingChannel) {
+		server.admission.release(hostOf(channel.ClientAddr()))
+	}
 	return server, nil
 }
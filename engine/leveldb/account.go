@@ -46,10 +46,24 @@ func emailKey(email string) string {
 	return fmt.Sprintf("Email:%s", email)
 }
 
+//账户数据按照账户ID路由，APP数据跟随账户
+func accountRingKey(key []byte) string {
+	k := string(key)
+	if len(k) < 2 {
+		return k
+	}
+	id, _ := strconv.ParseUint(strings.SplitN(k[2:], ",", 2)[0], 10, 64)
+	switch k[:2] {
+	case "S:", "T:":
+		id = MAX_ID - id
+	}
+	return strconv.FormatUint(id, 10)
+}
+
 type AccountServer struct {
 	storeName string
 	dataPath  string
-	data      *leveldb.DB
+	replicaDB
 
 	reg            registry.ServiceRegistry
 	loadBalance    load_balance.LoadBalance
@@ -62,6 +76,7 @@ func NewAccountServer(storeName, dataPath string) (*AccountServer, error) {
 		storeName: storeName,
 		dataPath:  dataPath + "/store/account",
 	}
+	accountServer.ringKey = accountRingKey
 	return accountServer, nil
 }
 
@@ -98,15 +113,15 @@ func (this *AccountServer) Apply(account *api.Account) *protocol.TenuredError {
 	batch := &leveldb.Batch{}
 	batch.Put(accountKey(account.Id), bs)
 	batch.Put(statusKey(account.Id), []byte(api.AccountStatusApply))
-	if err := this.data.Write(batch, writeOptions); err != nil {
-		return protocol.ErrorDB(err)
+	if err := this.write(batch); err != nil {
+		return err
 	}
 	return nil
 }
 
 func (this *AccountServer) Get(id uint64) (*api.Account, *protocol.TenuredError) {
 	logger.Debug("获取用户: ", id)
-	if val, err := this.data.Get(accountKey(id), readOptions); err != nil {
+	if val, err := this.get(accountKey(id)); err != nil {
 		return nil, notFound(err, api.ErrAccountNotExists)
 	} else {
 		account := &api.Account{}
@@ -148,46 +163,74 @@ func (this *AccountServer) GetByEmail(email string) (*api.Account, *protocol.Ten
 	return this.accountService.Get(accountId)
 }
 
-func (this *AccountServer) Search(gl *load_balance.GlobalLoading, search *api.Search) (*api.SearchResult, *protocol.TenuredError) {
-	logger.Debug("搜索：", search)
+//按照状态倒序遍历账户，fn返回false时停止
+func (this *AccountServer) scanAccounts(search *api.Search, fn func(account *api.Account) (bool, *protocol.TenuredError)) *protocol.TenuredError {
+	sn, err := this.data.GetSnapshot()
+	if err != nil {
+		return protocol.ConvertError(err)
+	}
+	defer sn.Release()
 
-	if sn, err := this.data.GetSnapshot(); err != nil {
-		return nil, protocol.ConvertError(err)
+	var startKey []byte
+	if search.StartId == 0 {
+		startKey = statusKey(MAX_ID - MIN_ID)
 	} else {
-		sr := &api.SearchResult{Accounts: make([]*api.Account, 0)}
+		startKey = statusKey(search.StartId)
+	}
 
-		var startKey []byte
-		if search.StartId == 0 {
-			startKey = statusKey(MAX_ID - MIN_ID)
-		} else {
-			startKey = statusKey(search.StartId)
+	it := sn.NewIterator(&util.Range{Start: startKey}, readOptions)
+	defer it.Release()
+	for it.Next() {
+		key := string(it.Key())
+		if !strings.HasPrefix(key, "S:") {
+			break
 		}
-
-		resultSize := 0
-		it := sn.NewIterator(&util.Range{Start: startKey}, readOptions)
-		defer it.Release()
-		for it.Next() && resultSize < search.Limit {
-			key := string(it.Key())
-			if !strings.HasPrefix(key, "S:") {
-				break
-			}
-			if "" != string(search.Status) &&
-				search.Status != api.AccountStatus(string(it.Value())) {
-				continue
-			}
-			id, _ := strconv.ParseUint(key[2:], 10, 64)
-			if search.StartId != 0 && search.StartId == MAX_ID-id {
-				continue
-			}
-			if account, err := this.Get(MAX_ID - id); err != nil {
-				return nil, err
-			} else {
-				resultSize++
-				sr.Accounts = append(sr.Accounts, account)
-			}
+		if "" != string(search.Status) &&
+			search.Status != api.AccountStatus(string(it.Value())) {
+			continue
 		}
+		id, _ := strconv.ParseUint(key[2:], 10, 64)
+		if search.StartId != 0 && search.StartId == MAX_ID-id {
+			continue
+		}
+		if account, err := this.Get(MAX_ID - id); err != nil {
+			return err
+		} else if next, err := fn(account); err != nil {
+			return err
+		} else if !next {
+			break
+		}
+	}
+	return nil
+}
+
+func (this *AccountServer) Search(gl *load_balance.GlobalLoading, search *api.Search) (*api.SearchResult, *protocol.TenuredError) {
+	logger.Debug("搜索：", search)
+
+	sr := &api.SearchResult{Accounts: make([]*api.Account, 0)}
+	if search.Limit <= 0 {
 		return sr, nil
 	}
+	if err := this.scanAccounts(search, func(account *api.Account) (bool, *protocol.TenuredError) {
+		sr.Accounts = append(sr.Accounts, account)
+		return len(sr.Accounts) < search.Limit, nil
+	}); err != nil {
+		return nil, err
+	}
+	return sr, nil
+}
+
+func (this *AccountServer) ScanAccounts(gl *load_balance.GlobalLoading, search *api.Search, stream func(*api.Account) error) *protocol.TenuredError {
+	logger.Debug("扫描：", search)
+
+	size := 0
+	return this.scanAccounts(search, func(account *api.Account) (bool, *protocol.TenuredError) {
+		if err := stream(account); err != nil {
+			return false, protocol.ConvertError(err)
+		}
+		size++
+		return search.Limit <= 0 || size < search.Limit, nil
+	})
 }
 
 func (this *AccountServer) Check(checkAccount *api.CheckAccount) *protocol.TenuredError {
@@ -214,8 +257,8 @@ func (this *AccountServer) Check(checkAccount *api.CheckAccount) *protocol.Tenur
 
 		bs, _ := json.Marshal(ac)
 		batch.Put(accountKey(ac.Id), bs)
-		if err := this.data.Write(batch, writeOptions); err != nil {
-			return protocol.ErrorDB(err)
+		if err := this.write(batch); err != nil {
+			return err
 		}
 		return nil
 	}
@@ -237,8 +280,49 @@ func (this *AccountServer) ApplyApp(app *api.App) *protocol.TenuredError {
 	batch.Put(appKey(app.AccountId, app.Id), bs)
 	batch.Put(appStatusKey(app.AccountId, app.Id), []byte(api.AccountStatusApply))
 
-	if err := this.data.Write(batch, writeOptions); err != nil {
-		return protocol.ErrorDB(err)
+	if err := this.write(batch); err != nil {
+		return err
+	}
+	return nil
+}
+
+//按照状态倒序遍历账户APP，fn返回false时停止
+func (this *AccountServer) scanApps(searchApp *api.SearchApp, fn func(app *api.App) (bool, *protocol.TenuredError)) *protocol.TenuredError {
+	sn, err := this.data.GetSnapshot()
+	if err != nil {
+		return protocol.ConvertError(err)
+	}
+	defer sn.Release()
+
+	var startKey []byte
+	if searchApp.StartId == 0 {
+		startKey = appStatusKey(searchApp.AccountId, MAX_ID-MIN_ID)
+	} else {
+		startKey = appStatusKey(searchApp.AccountId, searchApp.StartId)
+	}
+
+	it := sn.NewIterator(&util.Range{Start: startKey}, readOptions)
+	defer it.Release()
+	for it.Next() {
+		key := string(it.Key())
+		if !strings.HasPrefix(key, "T:") {
+			break
+		}
+		if "" != string(searchApp.Status) &&
+			searchApp.Status != api.AccountStatus(string(it.Value())) {
+			continue
+		}
+		accountId, appId, _ := commons.SplitToUint2(key[2:], 10, 64)
+		if searchApp.StartId != 0 && searchApp.StartId == MAX_ID-appId {
+			continue
+		}
+		if app, err := this.GetApp(MAX_ID-accountId, MAX_ID-appId); err != nil {
+			return err
+		} else if next, err := fn(app); err != nil {
+			return err
+		} else if !next {
+			break
+		}
 	}
 	return nil
 }
@@ -247,48 +331,36 @@ func (this *AccountServer) ApplyApp(app *api.App) *protocol.TenuredError {
 func (this *AccountServer) SearchApp(searchApp *api.SearchApp) (*api.SearchAppResult, *protocol.TenuredError) {
 	logger.Debug("搜索：", searchApp)
 
-	if sn, err := this.data.GetSnapshot(); err != nil {
-		return nil, protocol.ConvertError(err)
-	} else {
-		sr := &api.SearchAppResult{SearchApps: make([]*api.App, 0)}
+	sr := &api.SearchAppResult{SearchApps: make([]*api.App, 0)}
+	if searchApp.Limit <= 0 {
+		return sr, nil
+	}
+	if err := this.scanApps(searchApp, func(app *api.App) (bool, *protocol.TenuredError) {
+		sr.SearchApps = append(sr.SearchApps, app)
+		return len(sr.SearchApps) < searchApp.Limit, nil
+	}); err != nil {
+		return nil, err
+	}
+	return sr, nil
+}
 
-		var startKey []byte
-		if searchApp.StartId == 0 {
-			startKey = appStatusKey(searchApp.AccountId, MAX_ID-MIN_ID)
-		} else {
-			startKey = appStatusKey(searchApp.AccountId, searchApp.StartId)
-		}
+//扫描账户下的全部APP
+func (this *AccountServer) ScanApps(searchApp *api.SearchApp, stream func(*api.App) error) *protocol.TenuredError {
+	logger.Debug("扫描：", searchApp)
 
-		resultSize := 0
-		it := sn.NewIterator(&util.Range{Start: startKey}, readOptions)
-		defer it.Release()
-		for it.Next() && resultSize < searchApp.Limit {
-			key := string(it.Key())
-			if !strings.HasPrefix(key, "T:") {
-				break
-			}
-			if "" != string(searchApp.Status) &&
-				searchApp.Status != api.AccountStatus(string(it.Value())) {
-				continue
-			}
-			accountId, appId, _ := commons.SplitToUint2(key[2:], 10, 64)
-			if searchApp.StartId != 0 && searchApp.StartId == MAX_ID-appId {
-				continue
-			}
-			if app, err := this.GetApp(MAX_ID-accountId, MAX_ID-appId); err != nil {
-				return nil, err
-			} else {
-				resultSize++
-				sr.SearchApps = append(sr.SearchApps, app)
-			}
+	size := 0
+	return this.scanApps(searchApp, func(app *api.App) (bool, *protocol.TenuredError) {
+		if err := stream(app); err != nil {
+			return false, protocol.ConvertError(err)
 		}
-		return sr, nil
-	}
+		size++
+		return searchApp.Limit <= 0 || size < searchApp.Limit, nil
+	})
 }
 
 func (this *AccountServer) GetApp(accountId uint64, appId uint64) (*api.App, *protocol.TenuredError) {
 	key := appKey(accountId, appId)
-	if val, err := this.data.Get(key, readOptions); err != nil {
+	if val, err := this.get(key); err != nil {
 		if err.Error() == levelDBNotFound {
 			return nil, api.ErrAccountAppNotExists
 		} else {
@@ -328,8 +400,8 @@ func (this *AccountServer) CheckApp(checkAccountApp *api.CheckAccountApp) *proto
 
 		bs, _ := json.Marshal(ac)
 		batch.Put(appKey(ac.AccountId, ac.Id), bs)
-		if err := this.data.Write(batch, writeOptions); err != nil {
-			return protocol.ErrorDB(err)
+		if err := this.write(batch); err != nil {
+			return err
 		}
 		return nil
 	}
@@ -339,6 +411,11 @@ func (this *AccountServer) SetRegistry(serviceRegistry registry.ServiceRegistry)
 	this.reg = serviceRegistry
 }
 
+//账户按照时间hash路由，副本节点和客户端读取转移使用相同的环
+func (this *AccountServer) ReplicaRing(serverName, serverTag string, reg registry.ServiceRegistry) load_balance.RingLoadBalance {
+	return AccountLoadBalance(serverName, serverTag, reg).(load_balance.RingLoadBalance)
+}
+
 func (this *AccountServer) Start() (err error) {
 	this.loadBalance = NewLoadBalance(this.storeName, this.reg)
 
@@ -363,6 +440,10 @@ func (this *AccountServer) Start() (err error) {
 	return commons.StartIfService(this.loadBalance)
 }
 
+func (this *AccountServer) Health() error {
+	return health(this.data)
+}
+
 func (this *AccountServer) Shutdown(interrupt bool) {
 	if err := this.data.Close(); err != nil {
 		logger.Error("close account error: ", err)
@@ -1,7 +1,10 @@
 package leveldb
 
 import (
+	"errors"
+
 	"github.com/ihaiker/tenured-go-server/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 )
 
@@ -10,6 +13,15 @@ var writeOptions = &opt.WriteOptions{Sync: true}
 
 const levelDBNotFound = "leveldb: not found"
 
+//检查数据库是否可用，数据库没有打开或者已经关闭时返回错误
+func health(db *leveldb.DB) error {
+	if db == nil {
+		return errors.New("leveldb not open")
+	}
+	_, err := db.GetProperty("leveldb.num-files-at-level0")
+	return err
+}
+
 func notFound(err error, perr *protocol.TenuredError) *protocol.TenuredError {
 	if err.Error() == levelDBNotFound {
 		return perr
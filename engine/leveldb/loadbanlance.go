@@ -1,6 +1,8 @@
 package leveldb
 
 import (
+	"fmt"
+
 	"github.com/ihaiker/tenured-go-server/api"
 	"github.com/ihaiker/tenured-go-server/registry"
 	"github.com/ihaiker/tenured-go-server/registry/load_balance"
@@ -19,11 +21,36 @@ func AccountLoadBalance(serverName, serverTag string, reg registry.ServiceRegist
 }
 
 func SearchLoadBalance(serverName, serverTag string, reg registry.ServiceRegistry) load_balance.LoadBalance {
-	return load_balance.NewHashLoadBalance(serverName, serverTag, reg, 100)
+	return api.SearchLoadBalance(serverName, serverTag, reg)
 }
 
+//用户数据按照cloudId hash，和副本、迁移使用的路由key一致
 func UserLoadBalance(serverName, serverTag string, reg registry.ServiceRegistry) load_balance.LoadBalance {
-	return load_balance.NewRoundLoadBalance(serverName, serverTag, reg)
+	return load_balance.NewHashKeyLoadBalance(serverName, serverTag, reg, 100, func(requestCode uint16, obj ...interface{}) string {
+		switch requestCode {
+		case api.UserServiceAddUser:
+			return fmt.Sprintf("%d", obj[0].(*api.User).CloudId)
+		case api.UserServiceRequestLoginToken:
+			return fmt.Sprintf("%d", obj[0].(*api.TokenRequest).CloudId)
+		case api.UserServiceGetByCloudId, api.UserServiceModifyUser, api.UserServiceGetToken:
+			return fmt.Sprintf("%d", obj[2].(uint64))
+		}
+		return fmt.Sprintf("%v", obj[0])
+	})
+}
+
+//离线消息按照cloudId hash，同一个用户的消息保存在同一个节点
+func MessageLoadBalance(serverName, serverTag string, reg registry.ServiceRegistry) load_balance.LoadBalance {
+	return load_balance.NewHashLoadBalance(serverName, serverTag, reg, 100)
+}
+
+//使用.tcd文件中方法指定的负载均衡，覆盖默认设置
+func useLoadBalances(lbm *load_balance.LoadBalanceManager, loadBalances map[uint16]string, serverName, serverTag string, reg registry.ServiceRegistry) {
+	for requestCode, name := range loadBalances {
+		if err := lbm.UseLoadBalance(requestCode, name, serverName, serverTag, reg); err != nil {
+			logger.Errorf("request %d use load balance error: %s", requestCode, err)
+		}
+	}
 }
 
 func NewLoadBalance(serverName string, reg registry.ServiceRegistry) load_balance.LoadBalance {
@@ -35,10 +62,8 @@ func NewLoadBalance(serverName string, reg registry.ServiceRegistry) load_balanc
 		for requestCode := api.AccountServiceRange.Min; requestCode < api.AccountServiceRange.Max; requestCode++ {
 			lbm.AddLoadBalance(requestCode, timedHashLoadBalance)
 		}
-		round := load_balance.NewRoundLoadBalance(serverName, api.StoreAccount, reg)
-		lbm.AddLoadBalance(api.AccountServiceGetByMobile, round)
-		lbm.AddLoadBalance(api.AccountServiceGetByEmail, round)
-		lbm.AddLoadBalance(api.AccountServiceSearch, load_balance.NewNoneLoadBalance(serverName, api.StoreAccount, reg))
+		//GetByMobile、GetByEmail使用leastActive，Search使用none，在account.tcd中指定
+		useLoadBalances(lbm, api.AccountServiceLoadBalances, serverName, api.StoreAccount, reg)
 	}
 
 	//search
@@ -47,6 +72,7 @@ func NewLoadBalance(serverName string, reg registry.ServiceRegistry) load_balanc
 		for requestCode := api.SearchServiceRange.Min; requestCode < api.SearchServiceRange.Max; requestCode++ {
 			lbm.AddLoadBalance(requestCode, searchLoadBalance)
 		}
+		useLoadBalances(lbm, api.SearchServiceLoadBalances, serverName, api.StoreSearch, reg)
 	}
 
 	//user
@@ -55,13 +81,23 @@ func NewLoadBalance(serverName string, reg registry.ServiceRegistry) load_balanc
 		for requestCode := api.UserServiceRange.Min; requestCode < api.UserServiceRange.Max; requestCode++ {
 			lbm.AddLoadBalance(requestCode, userLoadBalance)
 		}
-		round := load_balance.NewRoundLoadBalance(serverName, api.StoreUser, reg)
-		lbm.AddLoadBalance(api.UserServiceGetByTenantUserId, round)
+		//GetByTenantUserId使用leastActive，在user.tcd中指定
+		useLoadBalances(lbm, api.UserServiceLoadBalances, serverName, api.StoreUser, reg)
+	}
+
+	//message
+	{
+		messageLoadBalance := MessageLoadBalance(serverName, api.StoreMessage, reg)
+		for requestCode := api.MessageServiceRange.Min; requestCode < api.MessageServiceRange.Max; requestCode++ {
+			lbm.AddLoadBalance(requestCode, messageLoadBalance)
+		}
+		useLoadBalances(lbm, api.MessageServiceLoadBalances, serverName, api.StoreMessage, reg)
 	}
 
 	//snowflake
 	{
 		lbm.AddLoadBalance(api.ClusterIdServiceGet, load_balance.NewRoundLoadBalance(serverName, api.StoreClusterId, reg))
+		useLoadBalances(lbm, api.ClusterIdServiceLoadBalances, serverName, api.StoreClusterId, reg)
 	}
 	return lbm
 }
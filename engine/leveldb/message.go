@@ -0,0 +1,154 @@
+package leveldb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/api"
+	"github.com/ihaiker/tenured-go-server/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/comparer"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func messagePrefix(cloudId uint64) []byte {
+	return []byte(fmt.Sprintf("M:%d:", cloudId))
+}
+
+//消息ID定长，保证按照保存顺序遍历
+func messageKey(cloudId, id uint64) []byte {
+	return []byte(fmt.Sprintf("M:%d:%020d", cloudId, id))
+}
+
+//每个cloudId最后分配的消息ID
+func messageSequenceKey(cloudId uint64) []byte {
+	return []byte(fmt.Sprintf("N:%d", cloudId))
+}
+
+//离线消息按照cloudId路由，和客户端的hash key一致
+func messageRingKey(key []byte) string {
+	k := string(key)
+	if strings.HasPrefix(k, "M:") {
+		k = k[2:strings.LastIndex(k, ":")]
+	} else if strings.HasPrefix(k, "N:") {
+		k = k[2:]
+	}
+	return k
+}
+
+//离线消息服务，消息和最后分配的ID一起复制到副本节点，节点变化时迁移
+type MessageServer struct {
+	storeName string
+	dataPath  string
+	replicaDB
+
+	//按照cloudId分段加锁，同一个cloudId的消息ID分配和保存串行执行
+	locks [64]sync.Mutex
+}
+
+func NewMessageServer(storeName, dataPath string) (*MessageServer, error) {
+	messageServer := &MessageServer{
+		storeName: storeName,
+		dataPath:  dataPath + "/store/message",
+	}
+	messageServer.ringKey = messageRingKey
+	return messageServer, nil
+}
+
+//同一个cloudId的消息ID递增，最后分配的ID和消息一起保存，重启后不会重复
+func (this *MessageServer) Save(cloudId uint64, body []byte) (*api.MessageId, *protocol.TenuredError) {
+	lock := &this.locks[cloudId%uint64(len(this.locks))]
+	lock.Lock()
+	defer lock.Unlock()
+
+	id, err := this.nextId(cloudId)
+	if err != nil {
+		return nil, protocol.ErrorDB(err)
+	}
+	message := &api.OfflineMessage{
+		Id:         id,
+		CloudId:    cloudId,
+		Body:       body,
+		CreateTime: time.Now().Format("2006-01-02 15:04:05"),
+	}
+	value, _ := json.Marshal(message)
+	sequence := make([]byte, 8)
+	binary.BigEndian.PutUint64(sequence, id)
+	batch := &leveldb.Batch{}
+	batch.Put(messageKey(cloudId, id), value)
+	batch.Put(messageSequenceKey(cloudId), sequence)
+	if err := this.write(batch); err != nil {
+		return nil, err
+	}
+	return &api.MessageId{Id: id}, nil
+}
+
+//下一个消息ID：大于最后分配的ID，并且不小于当前纳秒时间，迁移到其他节点后依然大致按照保存时间递增
+func (this *MessageServer) nextId(cloudId uint64) (uint64, error) {
+	last := uint64(0)
+	if value, err := this.get(messageSequenceKey(cloudId)); err == nil && len(value) == 8 {
+		last = binary.BigEndian.Uint64(value)
+	} else if err != nil && err != leveldb.ErrNotFound {
+		return 0, err
+	}
+	if now := uint64(time.Now().UnixNano()); now > last {
+		return now, nil
+	}
+	return last + 1, nil
+}
+
+//只读取本地的消息，节点变化后迁移完成前可能缺少还没有迁入的消息
+func (this *MessageServer) Fetch(cloudId uint64, limit int) (*api.OfflineMessages, *protocol.TenuredError) {
+	messages := &api.OfflineMessages{Messages: make([]*api.OfflineMessage, 0)}
+
+	it := this.data.NewIterator(util.BytesPrefix(messagePrefix(cloudId)), readOptions)
+	defer it.Release()
+	for it.Next() {
+		message := &api.OfflineMessage{}
+		if err := json.Unmarshal(it.Value(), message); err != nil {
+			logger.Warnf("unmarshal offline message %s error: %s", string(it.Key()), err)
+			continue
+		}
+		messages.Messages = append(messages.Messages, message)
+		if limit > 0 && len(messages.Messages) >= limit {
+			break
+		}
+	}
+	if err := it.Error(); err != nil {
+		return nil, protocol.ErrorDB(err)
+	}
+	return messages, nil
+}
+
+func (this *MessageServer) Remove(cloudId uint64, id uint64) *protocol.TenuredError {
+	return this.delete(messageKey(cloudId, id))
+}
+
+func (this *MessageServer) Start() (err error) {
+	logger.Debug("start message store")
+	if err = os.MkdirAll(this.dataPath, 0755); err != nil {
+		logger.Error("start message store error: ", err)
+		return
+	}
+	if this.data, err = leveldb.OpenFile(this.dataPath, &opt.Options{Comparer: comparer.DefaultComparer}); err != nil {
+		logger.Error("start message store error: ", err)
+		return err
+	}
+	return nil
+}
+
+func (this *MessageServer) Health() error {
+	return health(this.data)
+}
+
+func (this *MessageServer) Shutdown(interrupt bool) {
+	if err := this.data.Close(); err != nil {
+		logger.Error("close message error: ", err)
+	}
+}
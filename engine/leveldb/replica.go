@@ -0,0 +1,145 @@
+package leveldb
+
+import (
+	"github.com/ihaiker/tenured-go-server/engine/replica"
+	"github.com/ihaiker/tenured-go-server/protocol"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+//收集batch中写入的数据
+type batchEntries []*replica.Entry
+
+func (this *batchEntries) Put(key, value []byte) {
+	*this = append(*this, &replica.Entry{Key: copyBytes(key), Value: copyBytes(value)})
+}
+
+func (this *batchEntries) Delete(key []byte) {
+	*this = append(*this, &replica.Entry{Key: copyBytes(key)})
+}
+
+func copyBytes(bs []byte) []byte {
+	out := make([]byte, len(bs))
+	copy(out, bs)
+	return out
+}
+
+//数据库写入后复制到副本节点，各存储服务共用
+type replicaDB struct {
+	data    *leveldb.DB
+	ringKey func(key []byte) string
+	writer  replica.Writer
+	reader  replica.Reader
+	marker  replica.Marker
+}
+
+//本地不存在时从迁移前的节点读取，读取到的数据不保存到本地，由迁移写入，避免覆盖读取之后本地的写入
+func (this *replicaDB) get(key []byte) ([]byte, error) {
+	value, err := this.data.Get(key, readOptions)
+	if err != leveldb.ErrNotFound || this.reader == nil {
+		return value, err
+	}
+	if value, err := this.reader(key); err != nil {
+		return nil, err
+	} else if value != nil {
+		return value, nil
+	}
+	return nil, err
+}
+
+func (this *replicaDB) has(key []byte) (bool, error) {
+	if _, err := this.get(key); err == leveldb.ErrNotFound {
+		return false, nil
+	} else {
+		return err == nil, err
+	}
+}
+
+func (this *replicaDB) write(batch *leveldb.Batch) *protocol.TenuredError {
+	if this.writer == nil && this.marker == nil {
+		if err := this.data.Write(batch, writeOptions); err != nil {
+			return protocol.ErrorDB(err)
+		}
+		return nil
+	}
+	entries := batchEntries{}
+	if err := batch.Replay(&entries); err != nil {
+		return protocol.ErrorDB(err)
+	}
+	if this.marker != nil {
+		this.marker(entries)
+	}
+	if err := this.data.Write(batch, writeOptions); err != nil {
+		return protocol.ErrorDB(err)
+	}
+	if this.writer == nil {
+		return nil
+	}
+	if err := this.writer(entries); err != nil {
+		return protocol.ErrorPartialWrite(err)
+	}
+	return nil
+}
+
+func (this *replicaDB) put(key, value []byte) *protocol.TenuredError {
+	batch := &leveldb.Batch{}
+	batch.Put(key, value)
+	return this.write(batch)
+}
+
+func (this *replicaDB) delete(key []byte) *protocol.TenuredError {
+	batch := &leveldb.Batch{}
+	batch.Delete(key)
+	return this.write(batch)
+}
+
+func (this *replicaDB) RingKey(key []byte) string {
+	return this.ringKey(key)
+}
+
+func (this *replicaDB) SetReplicaWriter(writer replica.Writer) {
+	this.writer = writer
+}
+
+func (this *replicaDB) SetReplicaReader(reader replica.Reader) {
+	this.reader = reader
+}
+
+func (this *replicaDB) SetReplicaMarker(marker replica.Marker) {
+	this.marker = marker
+}
+
+func (this *replicaDB) GetReplica(key []byte) ([]byte, error) {
+	if value, err := this.data.Get(key, readOptions); err == leveldb.ErrNotFound {
+		return nil, nil
+	} else {
+		return value, err
+	}
+}
+
+func (this *replicaDB) ApplyReplica(entries []*replica.Entry) error {
+	batch := &leveldb.Batch{}
+	for _, entry := range entries {
+		if entry.Value == nil {
+			batch.Delete(entry.Key)
+		} else {
+			batch.Put(entry.Key, entry.Value)
+		}
+	}
+	return this.data.Write(batch, writeOptions)
+}
+
+func (this *replicaDB) ScanReplica(fn func(entry *replica.Entry) bool) error {
+	snapshot, err := this.data.GetSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snapshot.Release()
+	it := snapshot.NewIterator(nil, readOptions)
+	defer it.Release()
+	for it.Next() {
+		if !fn(&replica.Entry{Key: copyBytes(it.Key()), Value: copyBytes(it.Value())}) {
+			break
+		}
+	}
+	return it.Error()
+}
@@ -13,31 +13,25 @@ import (
 type SearchServer struct {
 	storeName string
 	dataPath  string
-	data      *leveldb.DB
+	replicaDB
 }
 
 func (this *SearchServer) Put(key string, value []byte) *protocol.TenuredError {
-	if has, err := this.data.Has([]byte(key), readOptions); err != nil {
+	if has, err := this.has([]byte(key)); err != nil {
 		return protocol.ErrorDB(err)
 	} else if has {
 		return api.ErrSearchExists
 	}
 
-	if err := this.data.Put([]byte(key), value, writeOptions); err != nil {
-		return protocol.ErrorDB(err)
-	}
-	return nil
+	return this.put([]byte(key), value)
 }
 
 func (this *SearchServer) Set(key string, body []byte) *protocol.TenuredError {
-	if err := this.data.Put([]byte(key), body, writeOptions); err != nil {
-		return protocol.ErrorDB(err)
-	}
-	return nil
+	return this.put([]byte(key), body)
 }
 
 func (this *SearchServer) Get(key string) ([]byte, *protocol.TenuredError) {
-	if value, err := this.data.Get([]byte(key), readOptions); err != nil {
+	if value, err := this.get([]byte(key)); err != nil {
 		return nil, notFound(err, api.ErrSearchNotExists)
 	} else {
 		return value, nil
@@ -45,13 +39,7 @@ func (this *SearchServer) Get(key string) ([]byte, *protocol.TenuredError) {
 }
 
 func (this *SearchServer) Remove(key string) *protocol.TenuredError {
-	if err := this.data.Delete([]byte(key), writeOptions); err != nil {
-		if err.Error() == levelDBNotFound {
-			return nil
-		}
-		return protocol.ErrorDB(err)
-	}
-	return nil
+	return this.delete([]byte(key))
 }
 
 func (this *SearchServer) Start() (err error) {
@@ -67,6 +55,10 @@ func (this *SearchServer) Start() (err error) {
 	return nil
 }
 
+func (this *SearchServer) Health() error {
+	return health(this.data)
+}
+
 func (this *SearchServer) Shutdown(interrupt bool) {
 	if err := this.data.Close(); err != nil {
 		logger.Error("close search error: ", err)
@@ -74,8 +66,13 @@ func (this *SearchServer) Shutdown(interrupt bool) {
 }
 
 func NewSearchServer(storeName, dataPath string) (*SearchServer, error) {
-	return &SearchServer{
+	searchServer := &SearchServer{
 		storeName: storeName,
 		dataPath:  dataPath + "/store/search",
-	}, nil
+	}
+	//搜索数据按照搜索key路由
+	searchServer.ringKey = func(key []byte) string {
+		return string(key)
+	}
+	return searchServer, nil
 }
@@ -28,10 +28,16 @@ func tokenKey(accountId, appId uint64, cloudId uint64) []byte {
 	return []byte(fmt.Sprintf("T:%d:%d:%d", accountId, appId, cloudId))
 }
 
+//用户数据按照cloudId路由
+func userRingKey(key []byte) string {
+	k := string(key)
+	return k[strings.LastIndex(k, ":")+1:]
+}
+
 type UserServer struct {
 	storeName string
 	dataPath  string
-	data      *leveldb.DB
+	replicaDB
 
 	reg         registry.ServiceRegistry
 	loadBalance load_balance.LoadBalance
@@ -47,6 +53,7 @@ func NewUserServer(serverName, dataPath string) (*UserServer, error) {
 		dataPath:       dataPath + "/store/user",
 		serviceManager: commons.NewServiceManager(),
 	}
+	userServer.ringKey = userRingKey
 	return userServer, nil
 }
 
@@ -69,8 +76,8 @@ func (this *UserServer) AddUser(user *api.User) *protocol.TenuredError {
 	//写入数据
 	key := cloudKey(user.AccountId, user.AppId, user.CloudId)
 	value, _ := json.Marshal(user)
-	if err := this.data.Put(key, value, writeOptions); err != nil {
-		return protocol.ErrorDB(err)
+	if err := this.put(key, value); err != nil {
+		return err
 	}
 	return nil
 }
@@ -92,7 +99,7 @@ func (this *UserServer) GetByTenantUserId(accountId uint64, appId uint64, userId
 //根据租户给定的用户ID获取用户
 func (this *UserServer) GetByCloudId(accountId uint64, appId uint64, cloudId uint64) (*api.User, *protocol.TenuredError) {
 	key := cloudKey(accountId, appId, cloudId)
-	if val, err := this.data.Get(key, readOptions); err != nil {
+	if val, err := this.get(key); err != nil {
 		return nil, notFound(err, api.ErrAccountNotExists)
 	} else {
 		user := &api.User{}
@@ -119,8 +126,8 @@ func (this *UserServer) ModifyUser(accountId uint64, appId uint64, cloudId uint6
 	//写入数据
 	key := cloudKey(user.AccountId, user.AppId, user.CloudId)
 	value, _ := json.Marshal(user)
-	if err := this.data.Put(key, value, writeOptions); err != nil {
-		return protocol.ErrorDB(err)
+	if err := this.put(key, value); err != nil {
+		return err
 	}
 	return nil
 }
@@ -138,15 +145,15 @@ func (this *UserServer) RequestLoginToken(req *api.TokenRequest) (*api.TokenResp
 	}
 	key := tokenKey(req.AccountId, req.AppId, req.CloudId)
 	val, _ := json.Marshal(token)
-	if err := this.data.Put(key, val, writeOptions); err != nil {
-		return nil, protocol.ErrorDB(err)
+	if err := this.put(key, val); err != nil {
+		return nil, err
 	}
 	return token, nil
 }
 
 func (this *UserServer) GetToken(accountId, appId, cloudId uint64) (*api.TokenResponse, *protocol.TenuredError) {
 	key := tokenKey(accountId, appId, cloudId)
-	if val, err := this.data.Get(key, readOptions); err != nil {
+	if val, err := this.get(key); err != nil {
 		return nil, protocol.ErrorDB(err)
 	} else {
 		token := &api.TokenResponse{}
@@ -179,6 +186,10 @@ func (this *UserServer) Start() (err error) {
 	return this.serviceManager.Start()
 }
 
+func (this *UserServer) Health() error {
+	return health(this.data)
+}
+
 func (this *UserServer) Shutdown(interrupt bool) {
 	if err := this.data.Close(); err != nil {
 		logger.Error("close user error: ", err)
@@ -3,6 +3,7 @@ package engine
 import (
 	"github.com/ihaiker/tenured-go-server/api"
 	"github.com/ihaiker/tenured-go-server/commons/executors"
+	"github.com/ihaiker/tenured-go-server/engine/replica"
 	"github.com/ihaiker/tenured-go-server/protocol"
 	"github.com/ihaiker/tenured-go-server/registry"
 	"github.com/ihaiker/tenured-go-server/registry/load_balance"
@@ -18,6 +19,7 @@ type StorePlugin interface {
 	Account() (api.AccountService, error)
 	User() (api.UserService, error)
 	Search() (api.SearchService, error)
+	Message() (api.MessageService, error)
 }
 type StorePluginFunc func(storeServiceName string, config *StoreEngineConfig) (StorePlugin, error)
 
@@ -39,6 +41,41 @@ type TenuredServerAware interface {
 	SetTenuredServer(server *protocol.TenuredServer)
 }
 
+//存储服务健康检查，服务不可用时返回错误
+type HealthIndicator interface {
+	Health() error
+}
+
+//支持副本复制的存储服务，本地写入的数据以key/value的方式复制到hash环上的后续节点
+type ReplicaStore interface {
+	//数据的路由key，和客户端负载均衡使用的key一致，决定数据的副本节点
+	RingKey(key []byte) string
+
+	//设置本地写入后的复制方法
+	SetReplicaWriter(writer replica.Writer)
+
+	//写入其他节点复制过来的数据，不会再次复制
+	ApplyReplica(entries []*replica.Entry) error
+
+	//遍历本地的全部数据，用于副本修复，fn返回false时停止
+	ScanReplica(fn func(entry *replica.Entry) bool) error
+
+	//设置数据迁移时本地不存在数据的读取方法
+	SetReplicaReader(reader replica.Reader)
+
+	//设置本地写入之前的标记方法
+	SetReplicaMarker(marker replica.Marker)
+
+	//读取本地数据，不存在时返回nil
+	GetReplica(key []byte) ([]byte, error)
+}
+
+//可选接口，副本节点使用的hash环，需要和客户端路由使用的负载均衡一致。
+//没有实现时使用按照 RingKey 的一致性hash
+type ReplicaRing interface {
+	ReplicaRing(serverName, serverTag string, reg registry.ServiceRegistry) load_balance.RingLoadBalance
+}
+
 //执行组件Aware
 type ExecutorManagerAware interface {
 	SetManager(manager executors.ExecutorManager)
@@ -27,6 +27,10 @@ func (this *levelDBStorePlugins) Search() (api.SearchService, error) {
 	return leveldb.NewSearchServer(this.storeServiceName, this.dataPath)
 }
 
+func (this *levelDBStorePlugins) Message() (api.MessageService, error) {
+	return leveldb.NewMessageServer(this.storeServiceName, this.dataPath)
+}
+
 func newLevelDBStore(storeServiceName string, config *StoreEngineConfig) (StorePlugin, error) {
 	dataPath := commons.NewFile(config.Attributes["dataPath"])
 	if !dataPath.Exist() || !dataPath.IsDir() {
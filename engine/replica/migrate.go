@@ -0,0 +1,32 @@
+package replica
+
+//查询存储节点的数据迁移进度，控制台使用
+const REQUEST_CODE_MIGRATE_STATUS = uint16(4203)
+
+const MigrateRunning = "running" //正在迁移
+const MigrateDone = "done"       //迁移完成
+const MigrateFailed = "failed"   //部分来源节点迁移失败
+
+//迁移的来源节点
+type MigrateSource struct {
+	Id      string `json:"id"`
+	Address string `json:"address"`
+	//从此节点迁移的hash区间数
+	Ranges int `json:"ranges"`
+	//已经接收的数据条数
+	Received int    `json:"received"`
+	Done     bool   `json:"done"`
+	Error    string `json:"error,omitempty"`
+}
+
+//节点变化后当前节点迁入数据的进度
+type MigrateStatus struct {
+	Store string `json:"store"`
+	//引起迁移的节点变化，例如：join:1234
+	Event   string           `json:"event"`
+	State   string           `json:"state"`
+	Sources []*MigrateSource `json:"sources"`
+	//开始和结束时间，单位毫秒
+	StartTime int64 `json:"startTime"`
+	EndTime   int64 `json:"endTime,omitempty"`
+}
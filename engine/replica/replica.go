@@ -0,0 +1,27 @@
+package replica
+
+//复制到副本节点的数据，Value为nil时表示删除
+type Entry struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value,omitempty"`
+}
+
+//本地写入完成后复制到副本节点，返回错误时表示没有达到写入副本数。
+//先写本地再复制，返回错误时本地的写入不会回滚，存储服务返回 protocol.ErrorPartialWrite
+type Writer func(entries []*Entry) error
+
+//本地写入之前调用，数据迁移时记录迁移期间写入（包括删除）的key，迁入的旧数据不会覆盖
+type Marker func(entries []*Entry)
+
+//本地不存在的数据从迁移前的节点读取，都不存在时返回nil
+type Reader func(key []byte) ([]byte, error)
+
+//按照数据的路由key分组，同一组的数据属于相同的副本节点
+func Group(entries []*Entry, ringKey func(key []byte) string) map[string][]*Entry {
+	groups := map[string][]*Entry{}
+	for _, entry := range entries {
+		key := ringKey(entry.Key)
+		groups[key] = append(groups[key], entry)
+	}
+	return groups
+}
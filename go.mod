@@ -27,5 +27,8 @@ require (
 	github.com/stretchr/testify v1.3.0
 	github.com/syndtr/goleveldb v1.0.0
 	github.com/yudai/pp v2.0.1+incompatible // indirect
+	go.etcd.io/etcd/api/v3 v3.5.17
+	go.etcd.io/etcd/client/v3 v3.5.17
+	go.etcd.io/etcd/server/v3 v3.5.17
 	gopkg.in/mgo.v2 v2.0.0-20180705113604-9856a29383ce // indirect
 )
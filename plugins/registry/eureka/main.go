@@ -0,0 +1,13 @@
+package main
+
+import (
+	"github.com/ihaiker/tenured-go-server/registry"
+	"github.com/ihaiker/tenured-go-server/registry/eureka"
+)
+
+//使用 -buildmode=plugin 编译，由 registry/plugins 通过 plugin.Open 加载
+func NewRegistryPlugins(config *registry.PluginConfig) (registry.Plugins, error) {
+	return eureka.NewRegistryPlugins(config)
+}
+
+func main() {}
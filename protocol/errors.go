@@ -37,6 +37,12 @@ func ErrorNoAuth() *TenuredError {
 	}
 }
 
+func ErrorNoPermission() *TenuredError {
+	return &TenuredError{
+		code: "1001", message: "Not allowed access, no permission",
+	}
+}
+
 func ErrorNoModule() *TenuredError {
 	return &TenuredError{
 		code: "0000", message: "Can't found module",
@@ -51,6 +57,15 @@ func ErrorRouter() *TenuredError {
 	return &TenuredError{code: "0002", message: "No valid route"}
 }
 
+func ErrorVersion() *TenuredError {
+	return &TenuredError{code: "0003", message: "No common protocol version"}
+}
+
+//数据已经写入本地但是没有达到要求的副本数，不会回滚。数据在副本修复时复制到其他节点，幂等的写操作可以重试
+func ErrorPartialWrite(err error) *TenuredError {
+	return &TenuredError{code: "0004", message: "partially written: " + err.Error()}
+}
+
 func NewError(code, message string) *TenuredError {
 	return &TenuredError{code: code, message: message}
 }
@@ -6,6 +6,16 @@ type AuthHeader struct {
 	Module     string            `json:"module"`
 	Address    string            `json:"address,omitempty"`
 	Attributes map[string]string `json:"attributes,omitempty"`
+	//支持的协议版本，认证时协商
+	Versions []int `json:"versions,omitempty"`
+	//客户端提议的心跳间隔，SECONDS。认证回复中为协商后的间隔
+	Heartbeat int `json:"heartbeat,omitempty"`
+	//签名时间戳，UNIX秒
+	Timestamp int64 `json:"timestamp,omitempty"`
+	//每次签名生成的随机数，防止重放
+	Nonce string `json:"nonce,omitempty"`
+	//模块签名，见 AuthSignature
+	Signature string `json:"signature,omitempty"`
 }
 
 func (this *AuthHeader) AddAttributes(key, value string) {
@@ -1,6 +1,17 @@
 package protocol
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/ihaiker/tenured-go-server/commons/remoting"
 )
 
@@ -11,6 +22,22 @@ type TenuredAuthChecker interface {
 	IsAuthed(channel remoting.RemotingChannel) bool
 }
 
+//可选接口，认证通过后检查连接是否可以调用指定的请求码
+type TenuredPermissionChecker interface {
+	Permit(channel remoting.RemotingChannel, code uint16) bool
+}
+
+//可选接口，认证成功后回复给客户端的头信息，返回nil时使用 TenuredServer.AuthHeader
+type TenuredAuthResponder interface {
+	AuthResponse(channel remoting.RemotingChannel) interface{}
+}
+
+//可选接口，认证成功的回复写出之后调用，此后写出的消息都在认证回复之后到达客户端
+type TenuredAuthListener interface {
+	OnAuthed(channel remoting.RemotingChannel)
+}
+
+//只检查认证头格式，不做任何校验。仅用于测试和开发环境
 type ModuleAuthChecker struct {
 }
 
@@ -19,17 +46,190 @@ func (this *ModuleAuthChecker) Auth(channel remoting.RemotingChannel, command *T
 	if err := command.GetHeader(header); err != nil {
 		return ConvertError(err)
 	} else {
-		channel.Attributes()[auth_attributes_name] = true
+		channel.SetAttribute(auth_attributes_name, true)
 	}
 
 	return nil
 }
 
 func (this *ModuleAuthChecker) IsAuthed(channel remoting.RemotingChannel) bool {
-	attrs := channel.Attributes()
-	if attrs == nil {
+	_, has := channel.GetAttribute(auth_attributes_name)
+	return has
+}
+
+//计算模块认证签名：HMAC-SHA256(secret, module \n address \n timestamp \n nonce)
+func AuthSignature(secret, module, address string, timestamp int64, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(module + "\n" + address + "\n" + strconv.FormatInt(timestamp, 10) + "\n" + nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//使用共享密钥对认证头签名，每次建立连接时使用新的随机数重新签名，服务端拒绝重复的随机数
+func (this *AuthHeader) Sign(secret string) {
+	nonce := make([]byte, 16)
+	_, _ = rand.Read(nonce)
+	this.Nonce = hex.EncodeToString(nonce)
+	this.Timestamp = time.Now().Unix()
+	this.Signature = AuthSignature(secret, this.Module, this.Address, this.Timestamp, this.Nonce)
+}
+
+//协议层的请求码（心跳、健康检查、流控），认证通过后总是允许调用，不受请求码范围限制
+func isProtocolCode(code uint16) bool {
+	return code == REQUEST_CODE_IDLE || code == REQUEST_CODE_HEALTH || code == REQUEST_CODE_STREAM
+}
+
+//请求码范围，包含From和To
+type CodeRange struct {
+	From uint16
+	To   uint16
+}
+
+func (this CodeRange) Contains(code uint16) bool {
+	return code >= this.From && code <= this.To
+}
+
+//解析请求码范围，支持：* 、 2000 、 2000-2999
+func ParseCodeRange(value string) (CodeRange, error) {
+	value = strings.TrimSpace(value)
+	if value == "*" {
+		return CodeRange{From: 0, To: 0xFFFF}, nil
+	}
+	from, to := value, value
+	if idx := strings.Index(value, "-"); idx != -1 {
+		from, to = strings.TrimSpace(value[:idx]), strings.TrimSpace(value[idx+1:])
+	}
+	f, err := strconv.ParseUint(from, 10, 16)
+	if err != nil {
+		return CodeRange{}, fmt.Errorf("invalid code range %s: %v", value, err)
+	}
+	t, err := strconv.ParseUint(to, 10, 16)
+	if err != nil {
+		return CodeRange{}, fmt.Errorf("invalid code range %s: %v", value, err)
+	}
+	if f > t {
+		return CodeRange{}, fmt.Errorf("invalid code range %s", value)
+	}
+	return CodeRange{From: uint16(f), To: uint16(t)}, nil
+}
+
+//共享密钥认证，客户端使用 AuthHeader.Sign 签名，时间戳超出允许偏差的认证头会被拒绝，
+//允许偏差内已经使用过的随机数也会被拒绝，防止截获的认证头被重放。
+//allowList 不为nil时，只有列表中的模块可以连接，并且只能调用对应范围内的请求码
+type HmacAuthChecker struct {
+	secret    string
+	skew      time.Duration
+	allowList map[string][]CodeRange
+
+	nonceLock *sync.Mutex
+	//已经使用的随机数和过期时间，时间戳超出允许偏差后认证头本身就会被拒绝，不需要继续保存
+	nonces     map[string]time.Time
+	nonceClean time.Time
+}
+
+//allows: 模块名 -> 请求码范围，为nil时不限制模块和请求码
+func NewHmacAuthChecker(secret string, skew time.Duration, allows map[string][]string) (*HmacAuthChecker, error) {
+	if secret == "" {
+		return nil, errors.New("auth secret is empty")
+	}
+	if skew <= 0 {
+		skew = time.Minute * 5
+	}
+	checker := &HmacAuthChecker{secret: secret, skew: skew, nonceLock: new(sync.Mutex), nonces: map[string]time.Time{}}
+	if allows != nil {
+		checker.allowList = map[string][]CodeRange{}
+		for module, ranges := range allows {
+			for _, value := range ranges {
+				if codeRange, err := ParseCodeRange(value); err != nil {
+					return nil, err
+				} else {
+					checker.allowList[module] = append(checker.allowList[module], codeRange)
+				}
+			}
+		}
+	}
+	return checker, nil
+}
+
+func (this *HmacAuthChecker) Auth(channel remoting.RemotingChannel, command *TenuredCommand) *TenuredError {
+	header := &AuthHeader{}
+	if err := command.GetHeader(header); err != nil {
+		return ConvertError(err)
+	}
+	if header.Module == "" || header.Signature == "" || header.Nonce == "" {
+		return ErrorNoAuth()
+	}
+	if offset := time.Since(time.Unix(header.Timestamp, 0)); offset > this.skew || offset < -this.skew {
+		logger.Infof("module %s auth timestamp expired: %d", header.Module, header.Timestamp)
+		return ErrorNoAuth()
+	}
+	expect := AuthSignature(this.secret, header.Module, header.Address, header.Timestamp, header.Nonce)
+	if !hmac.Equal([]byte(expect), []byte(header.Signature)) {
+		logger.Infof("module %s auth signature error", header.Module)
+		return ErrorNoAuth()
+	}
+	if !this.useNonce(header.Nonce) {
+		logger.Infof("module %s auth nonce replayed", header.Module)
+		return ErrorNoAuth()
+	}
+	if this.allowList != nil {
+		if _, has := this.allowList[header.Module]; !has {
+			return ErrorNoPermission()
+		}
+	}
+	channel.SetAttribute(auth_attributes_name, header.Module)
+	return nil
+}
+
+//记录随机数，已经使用过返回false
+func (this *HmacAuthChecker) useNonce(nonce string) bool {
+	this.nonceLock.Lock()
+	defer this.nonceLock.Unlock()
+	now := time.Now()
+	if now.Sub(this.nonceClean) > time.Second {
+		this.nonceClean = now
+		for n, expire := range this.nonces {
+			if now.After(expire) {
+				delete(this.nonces, n)
+			}
+		}
+	}
+	if expire, has := this.nonces[nonce]; has && now.Before(expire) {
 		return false
 	}
-	_, has := attrs[auth_attributes_name]
+	//时间戳允许前后偏差，随机数保存两倍偏差
+	this.nonces[nonce] = now.Add(this.skew * 2)
+	return true
+}
+
+func (this *HmacAuthChecker) IsAuthed(channel remoting.RemotingChannel) bool {
+	_, has := channel.GetAttribute(auth_attributes_name)
 	return has
 }
+
+func (this *HmacAuthChecker) Permit(channel remoting.RemotingChannel, code uint16) bool {
+	if this.allowList == nil || isProtocolCode(code) {
+		return true
+	}
+	value, _ := channel.GetAttribute(auth_attributes_name)
+	module, ok := value.(string)
+	if !ok {
+		return false
+	}
+	for _, codeRange := range this.allowList[module] {
+		if codeRange.Contains(code) {
+			return true
+		}
+	}
+	return false
+}
+
+var moduleCredential = struct {
+	module string
+	secret string
+}{}
+
+//设置当前进程调用其他模块时使用的模块名和共享密钥，api客户端启动时使用
+func SetModuleCredential(module, secret string) {
+	moduleCredential.module = module
+	moduleCredential.secret = secret
+}
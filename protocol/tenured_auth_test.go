@@ -0,0 +1,218 @@
+package protocol
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCodeRange(t *testing.T) {
+	r, err := ParseCodeRange("*")
+	assert.Nil(t, err)
+	assert.True(t, r.Contains(0) && r.Contains(65535))
+
+	r, err = ParseCodeRange("2000-2999")
+	assert.Nil(t, err)
+	assert.True(t, r.Contains(2000) && r.Contains(2999))
+	assert.False(t, r.Contains(3000))
+
+	r, err = ParseCodeRange("3001")
+	assert.Nil(t, err)
+	assert.Equal(t, CodeRange{From: 3001, To: 3001}, r)
+
+	_, err = ParseCodeRange("3000-2000")
+	assert.NotNil(t, err)
+	_, err = ParseCodeRange("abc")
+	assert.NotNil(t, err)
+}
+
+func TestHmacAuthChecker(t *testing.T) {
+	address := "127.0.0.1:6078"
+	authServer, _ := NewTenuredServer(address, nil)
+	authServer.AuthHeader = &AuthHeader{Module: "test", Address: address}
+	authServer.AuthChecker, _ = NewHmacAuthChecker("secret", time.Minute, map[string][]string{
+		"linker": {"2"},
+		"tenant": {"3-10"},
+	})
+	for _, code := range []uint16{HELLO, HEADER} {
+		authServer.RegisterCommandProcesser(code, func(channel remoting.RemotingChannel, request *TenuredCommand) {
+			_ = channel.Write(NewACK(request.ID()), time.Second)
+		}, nil)
+	}
+	assert.Nil(t, authServer.Start())
+	defer authServer.Shutdown(true)
+
+	invoke := func(module, secret string, code uint16) error {
+		client, _ := NewTenuredClient(nil)
+		client.AuthHeader = &AuthHeader{Module: module, Address: "127.0.0.1:8080"}
+		client.AuthSecret = secret
+		_ = client.Start()
+		defer client.Shutdown(true)
+
+		if resp, err := client.Invoke(address, NewRequest(code), time.Second); err != nil {
+			return err
+		} else {
+			return resp.GetError()
+		}
+	}
+
+	assert.Nil(t, invoke("linker", "secret", HELLO))
+	assert.Nil(t, invoke("tenant", "secret", HEADER))
+	//心跳和健康检查不受请求码范围限制
+	assert.Nil(t, invoke("linker", "secret", REQUEST_CODE_IDLE))
+	assert.Nil(t, invoke("linker", "secret", REQUEST_CODE_HEALTH))
+
+	//密钥错误或者没有签名
+	assert.NotNil(t, invoke("linker", "wrong", HELLO))
+	assert.NotNil(t, invoke("linker", "", HELLO))
+
+	//不在允许列表中的模块和请求码
+	assert.NotNil(t, invoke("console", "secret", HELLO))
+	err := invoke("linker", "secret", HEADER)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, ErrorNoPermission().Code(), ConvertError(err).Code())
+	}
+}
+
+func TestHmacAuthChecker_Expired(t *testing.T) {
+	checker, _ := NewHmacAuthChecker("secret", time.Minute, nil)
+	header := &AuthHeader{Module: "linker"}
+	header.Timestamp = time.Now().Add(-time.Hour).Unix()
+	header.Nonce = "expired"
+	header.Signature = AuthSignature("secret", header.Module, header.Address, header.Timestamp, header.Nonce)
+
+	request := NewRequest(REQUEST_CODE_ATUH)
+	_ = request.SetHeader(header)
+	assert.NotNil(t, checker.Auth(nil, request))
+}
+
+//只保存属性的连接
+type attributeChannel struct {
+	remoting.RemotingChannel
+	attributes map[string]interface{}
+}
+
+func (this *attributeChannel) GetAttribute(key string) (interface{}, bool) {
+	value, has := this.attributes[key]
+	return value, has
+}
+
+func (this *attributeChannel) SetAttribute(key string, value interface{}) {
+	this.attributes[key] = value
+}
+
+func TestHmacAuthChecker_Replay(t *testing.T) {
+	checker, _ := NewHmacAuthChecker("secret", time.Minute, nil)
+	header := &AuthHeader{Module: "linker", Address: "127.0.0.1:8080"}
+	header.Sign("secret")
+	request := NewRequest(REQUEST_CODE_ATUH)
+	_ = request.SetHeader(header)
+
+	assert.Nil(t, checker.Auth(&attributeChannel{attributes: map[string]interface{}{}}, request))
+	//同一个认证头再次使用
+	assert.NotNil(t, checker.Auth(&attributeChannel{attributes: map[string]interface{}{}}, request))
+
+	//没有随机数
+	header.Nonce = ""
+	header.Signature = AuthSignature("secret", header.Module, header.Address, header.Timestamp, header.Nonce)
+	_ = request.SetHeader(header)
+	assert.NotNil(t, checker.Auth(&attributeChannel{attributes: map[string]interface{}{}}, request))
+}
+
+func TestTenuredServer_AuthTimeout(t *testing.T) {
+	address := "127.0.0.1:6079"
+	config := remoting.DefaultConfig()
+	config.AuthTimeout = 1
+	config.AuthFailureLimit = 2
+	config.AuthBanTime = 60
+	timeoutServer, _ := NewTenuredServer(address, config)
+	timeoutServer.AuthHeader = &AuthHeader{Module: "test", Address: address}
+	timeoutServer.AuthChecker, _ = NewHmacAuthChecker("secret", time.Minute, nil)
+	assert.Nil(t, timeoutServer.Start())
+	defer timeoutServer.Shutdown(true)
+
+	//已认证的连接不受影响
+	client, _ := NewTenuredClient(nil)
+	client.AuthHeader = &AuthHeader{Module: "linker"}
+	client.AuthSecret = "secret"
+	assert.Nil(t, client.Start())
+	defer client.Shutdown(true)
+	_, err := client.Invoke(address, NewIdle(), time.Second)
+	assert.Nil(t, err)
+
+	//未认证的连接超时关闭
+	conn, err := net.Dial("tcp", address)
+	assert.Nil(t, err)
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+	_, err = conn.Read(make([]byte, 1))
+	if netErr, ok := err.(net.Error); assert.NotNil(t, err) && ok {
+		assert.False(t, netErr.Timeout())
+	}
+
+	_, err = client.Invoke(address, NewIdle(), time.Second)
+	assert.Nil(t, err)
+
+	//多次认证失败后封禁
+	for i := 0; i < 2; i++ {
+		bad, _ := NewTenuredClient(nil)
+		bad.AuthHeader = &AuthHeader{Module: "linker"}
+		bad.AuthSecret = "wrong"
+		_ = bad.Start()
+		_, err = bad.Invoke(address, NewIdle(), time.Second)
+		assert.NotNil(t, err)
+		bad.Shutdown(true)
+	}
+	assert.Contains(t, timeoutServer.server.Banned(), "127.0.0.1")
+}
+
+type ticketAuthChecker struct {
+	ModuleAuthChecker
+}
+
+func (this *ticketAuthChecker) AuthResponse(channel remoting.RemotingChannel) interface{} {
+	return map[string]string{"ticket": "abc"}
+}
+
+func (this *ticketAuthChecker) OnAuthed(channel remoting.RemotingChannel) {
+	_ = channel.Write(NewRequest(HELLO).MakeOneway(), time.Second)
+}
+
+func TestTenuredServer_AuthResponder(t *testing.T) {
+	address := "127.0.0.1:6080"
+	ticketServer, _ := NewTenuredServer(address, nil)
+	ticketServer.AuthHeader = &AuthHeader{Module: "test", Address: address}
+	ticketServer.AuthChecker = &ticketAuthChecker{}
+	assert.Nil(t, ticketServer.Start())
+	defer ticketServer.Shutdown(true)
+
+	events := make(chan string, 2)
+	client, _ := NewTenuredClient(nil)
+	client.AuthHeader = &AuthHeader{Module: "test"}
+	client.AuthResponseHandler = func(client *TenuredClient, cmd *TenuredCommand) {
+		header := map[string]string{}
+		cmd.GetSafeHeader(&header)
+		events <- header["ticket"]
+	}
+	client.RegisterCommandProcesser(HELLO, func(channel remoting.RemotingChannel, request *TenuredCommand) {
+		events <- "hello"
+	}, nil)
+	assert.Nil(t, client.Start())
+	defer client.Shutdown(true)
+
+	_, err := client.Invoke(address, NewIdle(), time.Second)
+	assert.Nil(t, err)
+	received := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case event := <-events:
+			received[event] = true
+		case <-time.After(time.Second * 3):
+			t.Fatal("wait event timeout")
+		}
+	}
+	assert.Equal(t, map[string]bool{"abc": true, "hello": true}, received)
+}
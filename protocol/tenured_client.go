@@ -1,6 +1,7 @@
 package protocol
 
 import (
+	"github.com/ihaiker/tenured-go-server/commons/breaker"
 	"github.com/ihaiker/tenured-go-server/commons/c8tmap"
 	"github.com/ihaiker/tenured-go-server/commons/remoting"
 	"time"
@@ -10,12 +11,18 @@ type TenuredClient struct {
 	tenuredService
 	AuthHeader          interface{}
 	AuthResponseHandler func(client *TenuredClient, cmd *TenuredCommand)
+
+	//共享密钥，不为空时每次连接对 AuthHeader 签名
+	AuthSecret string
+
+	//提议的心跳间隔，SECONDS。服务端接受后由客户端发送心跳，服务端不再发送
+	Heartbeat int
 }
 
 func (this *TenuredClient) OnChannel(channel remoting.RemotingChannel) error {
 	logger.Debug("send auth code:", channel.RemoteAddr())
 	request := NewRequest(REQUEST_CODE_ATUH)
-	if err := request.SetHeader(this.AuthHeader); err != nil {
+	if err := request.SetHeader(this.authHeader()); err != nil {
 		return err
 	}
 	resp, err := this.Invoke(channel.RemoteAddr(), request, time.Second*3)
@@ -28,6 +35,17 @@ func (this *TenuredClient) OnChannel(channel remoting.RemotingChannel) error {
 		return err
 	}
 
+	//服务端回复协商后的版本，旧版本的服务端总是回复 VERSION_DEFAULT
+	setVersion(channel, resp.Version)
+
+	//服务端接受心跳提议时回复协商后的间隔
+	heartbeat := &HeartbeatHeader{}
+	resp.GetSafeHeader(heartbeat)
+	if heartbeat.Heartbeat > 0 {
+		setHeartbeat(channel, heartbeat.Heartbeat)
+		channel.SetHeartbeat(time.Duration(heartbeat.Heartbeat)*time.Second, false)
+	}
+
 	if this.AuthResponseHandler != nil {
 		this.AuthResponseHandler(this, resp)
 		/*header := &AuthHeader{}
@@ -41,6 +59,34 @@ func (this *TenuredClient) OnChannel(channel remoting.RemotingChannel) error {
 	return nil
 }
 
+//重定向通知只由客户端处理，服务端收到时和未注册的请求码一样忽略
+func (this *TenuredClient) OnMessage(channel remoting.RemotingChannel, msg interface{}) {
+	if command := msg.(*TenuredCommand); !command.IsACK() && command.code == REQUEST_CODE_REDIRECT {
+		this.onRedirect(channel, command)
+		return
+	}
+	this.tenuredService.OnMessage(channel, msg)
+}
+
+//认证头信息中附加支持的协议版本和模块签名
+func (this *TenuredClient) authHeader() interface{} {
+	header, ok := this.AuthHeader.(*AuthHeader)
+	if !ok {
+		return this.AuthHeader
+	}
+	out := *header
+	if len(this.versions) > 0 && len(out.Versions) == 0 {
+		out.Versions = toVersionsHeader(this.versions)
+	}
+	if this.Heartbeat > 0 && out.Heartbeat == 0 {
+		out.Heartbeat = this.Heartbeat
+	}
+	if this.AuthSecret != "" {
+		out.Sign(this.AuthSecret)
+	}
+	return &out
+}
+
 func (this *TenuredClient) Start() error {
 	if this.AuthHeader == nil {
 		return ErrorNoModule()
@@ -59,6 +105,9 @@ func NewTenuredClient(config *remoting.RemotingConfig) (*TenuredClient, error) {
 			remoting:         remotingClient,
 			responseTables:   c8tmap.New(), //map[uint32]*responseTableBlock{},
 			commandProcesser: map[uint16]*tenuredCommandRunner{},
+			versionProcesser: map[uint32]*tenuredCommandRunner{},
+			versions:         Versions(),
+			breakers:         breaker.Default(),
 		},
 	}
 	remotingClient.SetHandler(client)
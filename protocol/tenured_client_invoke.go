@@ -3,6 +3,7 @@ package protocol
 import (
 	"github.com/ihaiker/tenured-go-server/commons/remoting"
 	"github.com/ihaiker/tenured-go-server/registry"
+	"io"
 	"time"
 )
 
@@ -14,10 +15,56 @@ func (this *TenuredClientInvoke) Invoke(
 	serverInstance *registry.ServerInstance,
 	code uint16, header interface{}, body []byte, timeout time.Duration, respHeader interface{},
 ) ([]byte, *TenuredError) {
+	respBody, err := this.invoke(serverInstance, code, header, body, timeout, respHeader)
+	if err != nil {
+		return nil, ConvertError(err)
+	}
+	return respBody, nil
+}
+
+//按照负载均衡返回的节点顺序调用，失败时根据重试策略转移到下一个可用节点
+func (this *TenuredClientInvoke) InvokeRetry(
+	serverInstances []*registry.ServerInstance, policy *RetryPolicy,
+	code uint16, header interface{}, body []byte, timeout time.Duration, respHeader interface{},
+) ([]byte, *TenuredError) {
+	if policy == nil {
+		policy = NoRetry
+	}
+	instances := make([]*registry.ServerInstance, 0, len(serverInstances))
+	for _, serverInstance := range serverInstances {
+		if registry.IsOK(serverInstance) {
+			instances = append(instances, serverInstance)
+		}
+	}
+	if len(instances) == 0 {
+		return nil, ErrorRouter()
+	}
+
+	var err error
+	for attempt := 0; attempt <= policy.Retry; attempt++ {
+		if attempt > 0 {
+			time.Sleep(policy.backoff(attempt))
+		}
+		serverInstance := instances[attempt%len(instances)]
+		var respBody []byte
+		if respBody, err = this.invoke(serverInstance, code, header, body, timeout, respHeader); err == nil {
+			return respBody, nil
+		} else if !policy.retryable(err) {
+			break
+		}
+		logger.Debugf("invoke %d at %s error: %v, attempt %d", code, serverInstance.Address, err, attempt+1)
+	}
+	return nil, ConvertError(err)
+}
+
+func (this *TenuredClientInvoke) invoke(
+	serverInstance *registry.ServerInstance,
+	code uint16, header interface{}, body []byte, timeout time.Duration, respHeader interface{},
+) ([]byte, error) {
 	request := NewRequest(code)
 	if header != nil {
 		if err := request.SetHeader(header); err != nil {
-			return nil, ConvertError(err)
+			return nil, err
 		}
 	}
 	if body != nil {
@@ -25,7 +72,7 @@ func (this *TenuredClientInvoke) Invoke(
 	}
 	response, invokeErr := this.client.Invoke(serverInstance.Address, request, timeout)
 	if invokeErr != nil {
-		return nil, ConvertError(invokeErr)
+		return nil, invokeErr
 	}
 	if !response.IsSuccess() {
 		return nil, response.GetError()
@@ -35,18 +82,62 @@ func (this *TenuredClientInvoke) Invoke(
 			if err == ErrNoHeader {
 				respHeader = nil
 			} else {
-				return nil, ConvertError(err)
+				return nil, err
 			}
 		}
 	}
 	return response.Body, nil
 }
 
+//流式调用，每收到一个数据帧调用一次fn，fn返回错误时取消接收。
+//流式调用中途失败无法安全的转移到其他节点，所以不会重试，只使用第一个可用节点
+func (this *TenuredClientInvoke) InvokeStream(
+	serverInstances []*registry.ServerInstance,
+	code uint16, header interface{}, body []byte, timeout time.Duration, fn func(item *TenuredCommand) error,
+) *TenuredError {
+	var serverInstance *registry.ServerInstance
+	for _, si := range serverInstances {
+		if registry.IsOK(si) {
+			serverInstance = si
+			break
+		}
+	}
+	if serverInstance == nil {
+		return ErrorRouter()
+	}
+
+	request := NewRequest(code)
+	if header != nil {
+		if err := request.SetHeader(header); err != nil {
+			return ConvertError(err)
+		}
+	}
+	request.Body = body
+
+	stream, err := this.client.InvokeStream(serverInstance.Address, request, timeout)
+	if err != nil {
+		return ConvertError(err)
+	}
+	for {
+		item, err := stream.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return ConvertError(err)
+		}
+		if err = fn(item); err != nil {
+			stream.Cancel()
+			return ConvertError(err)
+		}
+	}
+}
+
 func (this *TenuredClientInvoke) initTenuredClient() (err error) {
 	if this.client, err = NewTenuredClient(remoting.DefaultConfig()); err != nil {
 		return
 	}
-	this.client.AuthHeader = &AuthHeader{}
+	this.client.AuthHeader = &AuthHeader{Module: moduleCredential.module}
+	this.client.AuthSecret = moduleCredential.secret
 	return this.client.Start()
 }
 
@@ -20,46 +20,41 @@ type tenuredCoder struct {
 
 func (this *tenuredCoder) Decode(channel remoting.RemotingChannel, reader io.Reader) (interface{}, error) {
 	command := &TenuredCommand{}
-	length := uint32(0)
-	//length
-	if err := binary.Read(reader, endian, &length); err != nil {
-		return nil, err
-	} else if length < uint32(lengthMin) || length >= uint32(this.config.PacketBytesLimit) {
-		return nil, &remoting.RemotingError{Op: remoting.ErrDecoder, Err: errors.New(fmt.Sprintf("head length %d", length))}
-	}
-	//id
-	if err := binary.Read(reader, endian, &command.id); err != nil {
-		return nil, err
-	}
-	//code
-	if err := binary.Read(reader, endian, &command.code); err != nil {
+
+	//length(4) + id(4) + code(2) + vf(4)
+	head := remoting.AcquireBuffer(lengthMin)
+	defer remoting.ReleaseBuffer(head)
+	if _, err := io.ReadFull(reader, head); err != nil {
 		return nil, err
 	}
-	vf := uint32(0)
-	if err := binary.Read(reader, endian, &vf); err != nil {
-		return nil, err
+	length := endian.Uint32(head)
+	if length < uint32(lengthMin) || length >= uint32(this.config.PacketBytesLimit) {
+		return nil, &remoting.RemotingError{Op: remoting.ErrDecoder, Err: errors.New(fmt.Sprintf("head length %d", length))}
 	}
+	command.id = endian.Uint32(head[4:])
+	command.code = endian.Uint16(head[8:])
+	vf := endian.Uint32(head[10:])
 
 	command.Version = uint8((vf >> 24) & 0xFF)
 	command.flag = int(vf & 3 /*0b11*/)
 	headerLength := int((vf >> 2) & 0x3FFFFF)
-	if headerLength > 0 {
-		command.header = make([]byte, headerLength, headerLength)
-		if i, err := reader.Read(command.header); err != nil {
-			return nil, &remoting.RemotingError{Op: remoting.ErrDecoder, Err: err}
-		} else if i != headerLength {
-			return nil, &remoting.RemotingError{Op: remoting.ErrDecoder,
-				Err: errors.New(fmt.Sprintf("head length export %d bug %d", headerLength, i))}
-		}
-	}
 	bodyLength := int(length) - lengthMin - headerLength
-	if bodyLength > 0 {
-		command.Body = make([]byte, bodyLength)
-		if i, err := reader.Read(command.Body); err != nil {
-			return nil, &remoting.RemotingError{Op: remoting.ErrDecoder, Err: err}
-		} else if i != bodyLength {
+	if bodyLength < 0 {
+		return nil, &remoting.RemotingError{Op: remoting.ErrDecoder,
+			Err: errors.New(fmt.Sprintf("head length %d, total length %d", headerLength, length))}
+	}
+	if headerLength+bodyLength > 0 {
+		//header和body会被处理器持有，不能使用缓存池，一次分配后切分
+		data := make([]byte, headerLength+bodyLength)
+		if i, err := io.ReadFull(reader, data); err != nil {
 			return nil, &remoting.RemotingError{Op: remoting.ErrDecoder,
-				Err: errors.New(fmt.Sprintf("body length export %d bus %d", bodyLength, i))}
+				Err: errors.New(fmt.Sprintf("packet length export %d but %d: %s", len(data), i, err))}
+		}
+		if headerLength > 0 {
+			command.header = data[:headerLength:headerLength]
+		}
+		if bodyLength > 0 {
+			command.Body = data[headerLength:]
 		}
 	}
 	return command, nil
@@ -67,13 +62,18 @@ func (this *tenuredCoder) Decode(channel remoting.RemotingChannel, reader io.Rea
 
 func (this *tenuredCoder) Encode(channel remoting.RemotingChannel, msg interface{}) ([]byte, error) {
 	if bs, ok := msg.(*TenuredCommand); ok {
-		return this.encodeCommand(bs)
+		return this.encodeCommand(channel, bs)
 	} else {
 		return nil, os.ErrInvalid
 	}
 }
 
-func (this *tenuredCoder) encodeCommand(msg *TenuredCommand) ([]byte, error) {
+//编码使用缓存池，写出之后由channel回收
+func (this *tenuredCoder) Pooled() bool {
+	return true
+}
+
+func (this *tenuredCoder) encodeCommand(channel remoting.RemotingChannel, msg *TenuredCommand) ([]byte, error) {
 	length := uint32(lengthMin)
 	headerLength := uint32(0)
 
@@ -90,12 +90,17 @@ func (this *tenuredCoder) encodeCommand(msg *TenuredCommand) ([]byte, error) {
 			Err: errors.New("the packet limit size " + strconv.Itoa(this.config.PacketBytesLimit))}
 	}
 
-	bs := make([]byte, length, length)
+	bs := remoting.AcquireBuffer(int(length))
 	endian.PutUint32(bs, length)       //4
 	endian.PutUint32(bs[4:], msg.id)   //4
 	endian.PutUint16(bs[8:], msg.code) //2
 
-	vf := (uint32(msg.Version&0xFF) << 24) | uint32((headerLength&0x3FFFFF)<<2) | uint32(msg.flag&3 /*0b11*/)
+	//没有指定版本的消息使用连接协商后的版本
+	version := msg.Version
+	if version == VERSION_DEFAULT && channel != nil {
+		version = GetVersion(channel)
+	}
+	vf := (uint32(version&0xFF) << 24) | uint32((headerLength&0x3FFFFF)<<2) | uint32(msg.flag&3 /*0b11*/)
 	endian.PutUint32(bs[10:], vf)
 
 	if headerLength > 0 {
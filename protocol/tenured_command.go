@@ -14,8 +14,12 @@ const FLAG_ONEWAY = 1 //0b01
 const RESPONSE_SUCCESS = 0
 const REQUEST_CODE_IDLE = uint16(0)
 const REQUEST_CODE_ATUH = uint16(1)
+const REQUEST_CODE_REDIRECT = uint16(10) //服务下线，通知客户端重新连接到其他节点
+const REQUEST_CODE_HEALTH = uint16(11)   //健康检查，节点间互相检查健康状态
+const REQUEST_CODE_STREAM = uint16(12)   //流式回复的流控，客户端归还发送额度或者取消流
 
 const ErrNoHeader = commons.Error("NoHeader")
+const ErrCircuitOpen = commons.Error("CircuitOpen")
 
 var atomicId atomic.AtomicUInt32
 
@@ -0,0 +1,48 @@
+package protocol
+
+import (
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+)
+
+//健康检查回复的头信息，Status为pass，warn或者fail
+type HealthHeader struct {
+	Status string `json:"status"`
+	Output string `json:"output,omitempty"`
+}
+
+//节点自身的健康检查
+type TenuredHealthChecker interface {
+	Health() (status string, output string)
+}
+
+func NewHealth() *TenuredCommand {
+	return NewRequest(REQUEST_CODE_HEALTH)
+}
+
+func (this *tenuredService) SetHealthChecker(checker TenuredHealthChecker) {
+	this.healthChecker = checker
+}
+
+func (this *tenuredService) onHealth(channel remoting.RemotingChannel, command *TenuredCommand) {
+	header := &HealthHeader{Status: "pass"}
+	if this.healthChecker != nil {
+		header.Status, header.Output = this.healthChecker.Health()
+	}
+	logger.Debugf("channel %s check health: %s", channel.RemoteAddr(), header.Status)
+	this.makeAck(channel, command, header, nil)
+}
+
+//检查对端节点的健康状态
+func (this *tenuredService) Health(address string, timeout time.Duration) (*HealthHeader, error) {
+	response, err := this.Invoke(address, NewHealth(), timeout)
+	if err != nil {
+		return nil, err
+	}
+	header := &HealthHeader{}
+	if err := response.GetHeader(header); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
@@ -0,0 +1,49 @@
+package protocol
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type healthCheckerFunc func() (string, string)
+
+func (this healthCheckerFunc) Health() (string, string) {
+	return this()
+}
+
+func TestTenured_Health(t *testing.T) {
+	server, _ := NewTenuredServer("127.0.0.1:6088", nil)
+	server.AuthHeader = &AuthHeader{Module: "test", Address: "127.0.0.1:6088"}
+	lock, status := sync.Mutex{}, "pass"
+	server.SetHealthChecker(healthCheckerFunc(func() (string, string) {
+		lock.Lock()
+		defer lock.Unlock()
+		if status == "pass" {
+			return status, ""
+		}
+		return status, "executors: 85% used"
+	}))
+	assert.Nil(t, server.Start())
+	defer server.Shutdown(true)
+
+	client, _ := NewTenuredClient(nil)
+	client.AuthHeader = &AuthHeader{Module: "test", Address: "127.0.0.1:8080"}
+	assert.Nil(t, client.Start())
+	defer client.Shutdown(true)
+
+	header, err := client.Health("127.0.0.1:6088", time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "pass", header.Status)
+	assert.Equal(t, "", header.Output)
+
+	lock.Lock()
+	status = "warn"
+	lock.Unlock()
+	header, err = client.Health("127.0.0.1:6088", time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "warn", header.Status)
+	assert.Equal(t, "executors: 85% used", header.Output)
+}
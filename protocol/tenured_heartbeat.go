@@ -0,0 +1,37 @@
+package protocol
+
+import (
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+)
+
+const heartbeat_attributes_name = "heartbeat"
+
+//认证时客户端提议的心跳间隔，SECONDS。服务端接受后由客户端负责发送心跳，服务端只检测超时
+type HeartbeatHeader struct {
+	Heartbeat int `json:"heartbeat,omitempty"`
+}
+
+//把客户端提议的心跳间隔限制在 [min, max] 范围内，返回0表示不使用客户端心跳
+func NegotiateHeartbeat(min, max, proposal int) int {
+	if max <= 0 || proposal <= 0 {
+		return 0
+	}
+	if proposal < min {
+		return min
+	} else if proposal > max {
+		return max
+	}
+	return proposal
+}
+
+//连接协商后的心跳间隔，0表示使用服务端默认配置
+func GetHeartbeat(channel remoting.RemotingChannel) int {
+	if heartbeat, has := channel.GetAttribute(heartbeat_attributes_name); has {
+		return heartbeat.(int)
+	}
+	return 0
+}
+
+func setHeartbeat(channel remoting.RemotingChannel, heartbeat int) {
+	channel.SetAttribute(heartbeat_attributes_name, heartbeat)
+}
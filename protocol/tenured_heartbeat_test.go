@@ -0,0 +1,50 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateHeartbeat(t *testing.T) {
+	assert.Equal(t, 0, NegotiateHeartbeat(5, 300, 0))
+	assert.Equal(t, 0, NegotiateHeartbeat(5, 0, 60))
+	assert.Equal(t, 5, NegotiateHeartbeat(5, 300, 1))
+	assert.Equal(t, 60, NegotiateHeartbeat(5, 300, 60))
+	assert.Equal(t, 300, NegotiateHeartbeat(5, 300, 3600))
+}
+
+func TestTenuredServer_Heartbeat(t *testing.T) {
+	address := "127.0.0.1:6086"
+	config := remoting.DefaultConfig()
+	config.MinIdleTime = 1
+	config.MaxIdleTime = 2
+	config.IdleTimeout = 1
+	heartbeatServer, _ := NewTenuredServer(address, config)
+	heartbeatServer.AuthHeader = &AuthHeader{Module: "test", Address: address}
+	heartbeatServer.SetSessionManager(NewMapSessionManager())
+	assert.Nil(t, heartbeatServer.Start())
+	defer heartbeatServer.Shutdown(true)
+
+	heartbeat := make(chan int, 1)
+	client, _ := NewTenuredClient(nil)
+	client.AuthHeader = &AuthHeader{Module: "test"}
+	client.Heartbeat = 60
+	client.AuthResponseHandler = func(client *TenuredClient, cmd *TenuredCommand) {
+		header := &AuthHeader{}
+		cmd.GetSafeHeader(header)
+		heartbeat <- header.Heartbeat
+	}
+	assert.Nil(t, client.Start())
+	defer client.Shutdown(true)
+
+	_, err := client.Invoke(address, NewIdle(), time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, <-heartbeat)
+
+	//客户端按照协商的间隔发送心跳，连接保持
+	time.Sleep(time.Second * 5)
+	assert.Equal(t, 1, heartbeatServer.GetSessionManager().Size())
+}
@@ -0,0 +1,44 @@
+package protocol
+
+import (
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+)
+
+//重定向通知的头信息，服务端下线前通知客户端重新连接到Address
+type RedirectHeader struct {
+	Address string `json:"address"`
+}
+
+func NewRedirect(address string) *TenuredCommand {
+	command := NewRequest(REQUEST_CODE_REDIRECT).MakeOneway()
+	command.SetSafeHeader(&RedirectHeader{Address: address})
+	return command
+}
+
+//收到重定向通知后，等待当前连接上的请求完成后关闭连接，下次调用时由负载均衡重新选择节点
+func (this *tenuredService) onRedirect(channel remoting.RemotingChannel, command *TenuredCommand) {
+	header := &RedirectHeader{}
+	command.GetSafeHeader(header)
+	logger.Infof("channel %s redirect to %s", channel.RemoteAddr(), header.Address)
+
+	go func() {
+		deadline := time.Now().Add(time.Second * 3)
+		for this.pendingRequests(channel.RemoteAddr()) > 0 && time.Now().Before(deadline) {
+			<-time.After(time.Millisecond * 10)
+		}
+		channel.Close()
+	}()
+}
+
+//连接上还没有收到回复的请求数
+func (this *tenuredService) pendingRequests(address string) int {
+	count := 0
+	this.responseTables.IterCb(func(key interface{}, v interface{}) {
+		if v.(*responseTableBlock).address == address {
+			count++
+		}
+	})
+	return count
+}
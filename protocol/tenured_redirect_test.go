@@ -0,0 +1,69 @@
+package protocol
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenured_Redirect(t *testing.T) {
+	redirectServer, _ := NewTenuredServer("127.0.0.1:6074", nil)
+	redirectServer.AuthHeader = &AuthHeader{Module: "test", Address: "127.0.0.1:6074"}
+	redirectServer.SetSessionManager(NewMapSessionManager())
+	assert.Nil(t, redirectServer.Start())
+	defer redirectServer.Shutdown(true)
+
+	redirectClient, _ := NewTenuredClient(nil)
+	redirectClient.AuthHeader = &AuthHeader{Module: "test", Address: "127.0.0.1:8080"}
+	assert.Nil(t, redirectClient.Start())
+	defer redirectClient.Shutdown(true)
+
+	_, err := redirectClient.Invoke("127.0.0.1:6074", NewIdle(), time.Second)
+	assert.Nil(t, err)
+
+	sessions := redirectServer.GetSessionManager()
+	assert.Equal(t, 1, sessions.Size())
+	for _, channel := range sessions.Filter(func(channel remoting.RemotingChannel) bool { return true }) {
+		assert.Nil(t, channel.Write(NewRedirect("127.0.0.1:6075"), time.Second))
+	}
+
+	for i := 0; i < 100 && sessions.Size() > 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.Equal(t, 0, sessions.Size())
+}
+
+func TestTenured_RedirectIgnoredByServer(t *testing.T) {
+	redirectServer, _ := NewTenuredServer("127.0.0.1:6091", nil)
+	redirectServer.AuthHeader = &AuthHeader{Module: "test", Address: "127.0.0.1:6091"}
+	redirectServer.SetSessionManager(NewMapSessionManager())
+	assert.Nil(t, redirectServer.Start())
+	defer redirectServer.Shutdown(true)
+
+	redirectClient, _ := NewTenuredClient(nil)
+	redirectClient.AuthHeader = &AuthHeader{Module: "test", Address: "127.0.0.1:8080"}
+	assert.Nil(t, redirectClient.Start())
+	defer redirectClient.Shutdown(true)
+
+	_, err := redirectClient.Invoke("127.0.0.1:6091", NewIdle(), time.Second)
+	assert.Nil(t, err)
+	sessions := redirectServer.GetSessionManager()
+	assert.Equal(t, 1, sessions.Size())
+
+	//未认证的连接不计入会话
+	conn, err := net.Dial("tcp", "127.0.0.1:6091")
+	assert.Nil(t, err)
+	defer conn.Close()
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, 1, sessions.Size())
+
+	//客户端发送的重定向不会让服务端关闭连接
+	assert.Nil(t, redirectClient.remoting.SendTo("127.0.0.1:6091", NewRedirect("127.0.0.1:6075"), time.Second))
+	time.Sleep(time.Millisecond * 200)
+	assert.Equal(t, 1, sessions.Size())
+	_, err = redirectClient.Invoke("127.0.0.1:6091", NewIdle(), time.Second)
+	assert.Nil(t, err)
+}
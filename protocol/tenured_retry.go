@@ -0,0 +1,92 @@
+package protocol
+
+import (
+	"net"
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/commons/future"
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+)
+
+//远程调用的重试策略，对应tcd中方法定义的 retry(n) 和 idempotent
+type RetryPolicy struct {
+	//失败后最多重试次数，不包含第一次调用
+	Retry int
+
+	//方法是否幂等。非幂等方法只有在请求确定没有发送出去（连接失败）的时候才会转移到下一个节点，
+	//幂等方法在超时或者连接中断的时候也会重试
+	Idempotent bool
+
+	//第一次重试前的等待时间，之后每次翻倍
+	Backoff time.Duration
+
+	//最大等待时间
+	MaxBackoff time.Duration
+}
+
+//不重试，仅调用第一个可用节点
+var NoRetry = &RetryPolicy{}
+
+func NewRetryPolicy(retry int, idempotent bool) *RetryPolicy {
+	return &RetryPolicy{
+		Retry: retry, Idempotent: idempotent,
+		Backoff: time.Millisecond * 50, MaxBackoff: time.Second,
+	}
+}
+
+//第attempt次重试(从1开始)前需要等待的时间
+func (this *RetryPolicy) backoff(attempt int) time.Duration {
+	if this.Backoff <= 0 || attempt <= 0 {
+		return 0
+	}
+	wait := this.Backoff
+	for i := 1; i < attempt; i++ {
+		wait = wait * 2
+		if this.MaxBackoff > 0 && wait >= this.MaxBackoff {
+			return this.MaxBackoff
+		}
+	}
+	return wait
+}
+
+//错误是否允许在下一个节点上重试
+func (this *RetryPolicy) retryable(err error) bool {
+	if IsConnectError(err) {
+		return true
+	}
+	return this.Idempotent && IsTimeoutError(err)
+}
+
+//请求还没有发送出去就失败了，例如：无法建立连接
+func IsConnectError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == ErrCircuitOpen || remoting.IsRemotingError(err, remoting.ErrNoChannel) {
+		return true
+	}
+	if opErr, ok := err.(*net.OpError); ok && opErr.Op == "dial" {
+		return true
+	}
+	return false
+}
+
+//请求已经发送（或者部分发送），但是没有收到回复，例如：超时、连接被关闭
+func IsTimeoutError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == future.ErrTimeout {
+		return true
+	}
+	if remoting.IsRemotingError(err, remoting.ErrSendTimeout, remoting.ErrClosed) {
+		return true
+	}
+	if opErr, ok := err.(*net.OpError); ok && opErr.Op != "dial" {
+		return true
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package protocol
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/commons/breaker"
+	"github.com/ihaiker/tenured-go-server/commons/future"
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/ihaiker/tenured-go-server/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := NewRetryPolicy(5, true)
+	assert.Equal(t, time.Duration(0), policy.backoff(0))
+	assert.Equal(t, time.Millisecond*50, policy.backoff(1))
+	assert.Equal(t, time.Millisecond*100, policy.backoff(2))
+	assert.Equal(t, time.Second, policy.backoff(10))
+}
+
+func TestRetryPolicy_Retryable(t *testing.T) {
+	_, dialErr := net.DialTimeout("tcp", "127.0.0.1:1", time.Second)
+	assert.NotNil(t, dialErr)
+	assert.True(t, IsConnectError(dialErr))
+	assert.True(t, IsTimeoutError(future.ErrTimeout))
+	assert.True(t, IsTimeoutError(&remoting.RemotingError{Op: remoting.ErrClosed, Err: net.ErrWriteToConnected}))
+
+	write := NewRetryPolicy(2, false)
+	assert.True(t, write.retryable(dialErr))
+	assert.False(t, write.retryable(future.ErrTimeout))
+	assert.False(t, write.retryable(ErrorNoAuth()))
+
+	read := NewRetryPolicy(2, true)
+	assert.True(t, read.retryable(future.ErrTimeout))
+	assert.False(t, read.retryable(ErrorNoAuth()))
+}
+
+//发送失败只回调一次，熔断器也只记录一次失败
+func TestTenuredClient_AsyncInvokeSendError(t *testing.T) {
+	address := "127.0.0.1:2"
+	calls := int32(0)
+	client.AsyncInvoke(address, NewRequest(HELLO), time.Millisecond*300, func(tenuredCommand *TenuredCommand, err error) {
+		assert.NotNil(t, err)
+		atomic.AddInt32(&calls, 1)
+	})
+	time.Sleep(time.Millisecond * 600)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Equal(t, uint64(1), breaker.Default().Get(address).Stat().Failure)
+}
+
+func TestTenuredClientInvoke_InvokeRetry(t *testing.T) {
+	invoke := &TenuredClientInvoke{client: client}
+	instances := []*registry.ServerInstance{
+		{Address: "127.0.0.1:1", Status: registry.StatusOK},
+		{Address: "127.0.0.1:6071", Status: registry.StatusOK},
+	}
+
+	_, err := invoke.InvokeRetry(instances, NoRetry, HELLO, nil, []byte("hello"), time.Second, nil)
+	assert.NotNil(t, err)
+
+	body, err := invoke.InvokeRetry(instances, NewRetryPolicy(1, false), HELLO, nil, []byte("hello"), time.Second, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello tenured", string(body))
+
+	_, err = invoke.InvokeRetry([]*registry.ServerInstance{{Address: "127.0.0.1:6071", Status: registry.StatusDown}},
+		NewRetryPolicy(1, true), HELLO, nil, []byte("hello"), time.Second, nil)
+	assert.Equal(t, ErrorRouter().Code(), err.Code())
+}
@@ -3,45 +3,142 @@ package protocol
 import (
 	"github.com/ihaiker/tenured-go-server/commons/c8tmap"
 	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"time"
 )
 
 type TenuredServer struct {
 	tenuredService
 	AuthChecker TenuredAuthChecker
 	AuthHeader  interface{}
+
+	server *remoting.RemotingServer
+	//客户端提议心跳间隔的范围，SECONDS
+	minIdleTime int
+	maxIdleTime int
+	//连接认证超时时间，小于等于0不检查
+	authTimeout time.Duration
+	authTimers  c8tmap.ConcurrentMap
 }
 
 func (this *TenuredServer) onCommandProcesser(channel remoting.RemotingChannel, command *TenuredCommand) {
 	if command.code == REQUEST_CODE_ATUH {
-		if err := this.AuthChecker.Auth(channel, command); err != nil {
-			logger.Infof("auth channel(%s) error: %s", channel.RemoteAddr(), err.Error())
+		versions := &VersionsHeader{}
+		command.GetSafeHeader(versions)
+		if version, match := NegotiateVersion(this.versions, versions.Versions); !match {
+			logger.Infof("channel(%s) versions %v not supported", channel.ClientAddr(), versions.Versions)
+			this.makeAck(channel, command, nil, ErrorVersion())
+		} else if err := this.AuthChecker.Auth(channel, command); err != nil {
+			logger.Infof("auth channel(%s) error: %s", channel.ClientAddr(), err.Error())
 			this.makeAck(channel, command, nil, err)
+			this.server.AuthFailure(channel)
 		} else {
-			logger.Debugf("channel(%s) auth success", channel.RemoteAddr())
-			this.makeAck(channel, command, this.AuthHeader, nil)
+			this.server.AuthSuccess(channel)
+			this.authed(channel)
+			//认证成功后才加入会话管理，未认证的连接不计入会话
+			this.OnConnect(channel)
+			logger.Debugf("channel(%s) auth success, version %d", channel.ClientAddr(), version)
+			setVersion(channel, version)
+			this.negotiateHeartbeat(channel, command)
+			//认证回复使用协商后的版本号告知客户端
+			command.Version = version
+			this.makeAck(channel, command, this.authResponse(channel), nil)
+			if listener, ok := this.AuthChecker.(TenuredAuthListener); ok {
+				listener.OnAuthed(channel)
+			}
 		}
 		return
 	} else if this.AuthChecker != nil && !this.AuthChecker.IsAuthed(channel) {
 		this.makeAck(channel, command, nil, ErrorNoAuth())
 		this.fastFailChannel(channel)
 		return
+	} else if pc, ok := this.AuthChecker.(TenuredPermissionChecker); ok && !isProtocolCode(command.code) && !pc.Permit(channel, command.code) {
+		logger.Infof("channel(%s) not permitted to call %d", channel.ClientAddr(), command.code)
+		this.makeAck(channel, command, nil, ErrorNoPermission())
+		return
 	}
 	this.tenuredService.onCommandProcesser(channel, command)
 }
 
+//客户端提议了心跳间隔时，由客户端发送心跳，服务端不再发送
+func (this *TenuredServer) negotiateHeartbeat(channel remoting.RemotingChannel, command *TenuredCommand) {
+	proposal := &HeartbeatHeader{}
+	command.GetSafeHeader(proposal)
+	if heartbeat := NegotiateHeartbeat(this.minIdleTime, this.maxIdleTime, proposal.Heartbeat); heartbeat > 0 {
+		logger.Debugf("channel(%s) heartbeat %ds", channel.ClientAddr(), heartbeat)
+		setHeartbeat(channel, heartbeat)
+		channel.SetHeartbeat(time.Duration(heartbeat)*time.Second, true)
+	}
+}
+
+func (this *TenuredServer) authResponse(channel remoting.RemotingChannel) interface{} {
+	if responder, ok := this.AuthChecker.(TenuredAuthResponder); ok {
+		if header := responder.AuthResponse(channel); header != nil {
+			return header
+		}
+	}
+	if header, ok := this.AuthHeader.(*AuthHeader); ok && GetHeartbeat(channel) > 0 {
+		withHeartbeat := *header
+		withHeartbeat.Heartbeat = GetHeartbeat(channel)
+		return &withHeartbeat
+	}
+	return this.AuthHeader
+}
+
 func (this *TenuredServer) OnMessage(channel remoting.RemotingChannel, msg interface{}) {
 	command := msg.(*TenuredCommand)
 	if command.IsACK() {
-		requestId := command.id
-		if f, has := this.responseTables.Pop(requestId); has {
-			f.(*responseTableBlock).future.Set(command)
-		}
+		this.onResponse(command)
 		return
 	} else {
 		this.onCommandProcesser(channel, command)
 	}
 }
 
+//连接建立后开始计时，超时未完成认证的连接直接关闭。
+//认证请求可能先于 OnChannel 处理，所以认证成功后在 authTimers 中留下标记
+func (this *TenuredServer) OnChannel(channel remoting.RemotingChannel) error {
+	if this.authTimeout > 0 && this.AuthChecker != nil {
+		var timer *time.Timer
+		timer = time.AfterFunc(this.authTimeout, func() {
+			if this.authTimers.RemoveCb(channel.RemoteAddr(), func(key interface{}, v interface{}, exists bool) bool {
+				return exists && v == timer
+			}) {
+				logger.Infof("channel(%s) auth timeout", channel.ClientAddr())
+				channel.Close()
+			}
+		})
+		this.authTimers.Upsert(channel.RemoteAddr(), timer, func(exist bool, valueInMap interface{}, newValue interface{}) interface{} {
+			if exist {
+				timer.Stop()
+				return valueInMap
+			}
+			return newValue
+		})
+	}
+	if this.AuthChecker == nil {
+		this.OnConnect(channel)
+	}
+	return nil
+}
+
+func (this *TenuredServer) authed(channel remoting.RemotingChannel) {
+	this.authTimers.Upsert(channel.RemoteAddr(), true, func(exist bool, valueInMap interface{}, newValue interface{}) interface{} {
+		if timer, ok := valueInMap.(*time.Timer); exist && ok {
+			timer.Stop()
+		}
+		return newValue
+	})
+}
+
+func (this *TenuredServer) OnClose(channel remoting.RemotingChannel) {
+	if timer, has := this.authTimers.Pop(channel.RemoteAddr()); has {
+		if timer, ok := timer.(*time.Timer); ok {
+			timer.Stop()
+		}
+	}
+	this.tenuredService.OnClose(channel)
+}
+
 func NewTenuredServer(address string, config *remoting.RemotingConfig) (*TenuredServer, error) {
 	if config == nil {
 		config = remoting.DefaultConfig()
@@ -55,8 +152,15 @@ func NewTenuredServer(address string, config *remoting.RemotingConfig) (*Tenured
 				remoting:         remotingServer,
 				responseTables:   c8tmap.New(), //map[uint32]*responseTableBlock{},
 				commandProcesser: map[uint16]*tenuredCommandRunner{},
+				versionProcesser: map[uint32]*tenuredCommandRunner{},
+				versions:         Versions(),
 			},
 			AuthChecker: &ModuleAuthChecker{},
+			server:      remotingServer,
+			authTimeout: time.Duration(config.AuthTimeout) * time.Second,
+			minIdleTime: config.MinIdleTime,
+			maxIdleTime: config.MaxIdleTime,
+			authTimers:  c8tmap.New(),
 		}
 		remotingServer.SetHandler(server)
 		return server, nil
@@ -4,17 +4,21 @@ import (
 	"errors"
 
 	"github.com/ihaiker/tenured-go-server/commons"
+	"github.com/ihaiker/tenured-go-server/commons/breaker"
 	"github.com/ihaiker/tenured-go-server/commons/c8tmap"
 	"github.com/ihaiker/tenured-go-server/commons/executors"
 	"github.com/ihaiker/tenured-go-server/commons/future"
 	"github.com/ihaiker/tenured-go-server/commons/remoting"
 	"reflect"
+	"sync"
 	"time"
 )
 
 type responseTableBlock struct {
 	address string
 	future  *future.SetFuture
+	//流式回复，不为nil时不使用future
+	stream *TenuredStream
 }
 
 type TenuredService interface {
@@ -28,8 +32,13 @@ type TenuredService interface {
 	AsyncInvoke(channel string, command *TenuredCommand, timeout time.Duration,
 		callback func(tenuredCommand *TenuredCommand, err error))
 
+	InvokeStream(channel string, command *TenuredCommand, timeout time.Duration) (*TenuredStream, error)
+
 	RegisterCommandProcesser(code uint16, processer TenuredCommandProcesser, executorService executors.ExecutorService)
 
+	//注册指定协议版本的处理器，优先于 RegisterCommandProcesser 注册的处理器
+	RegisterVersionCommandProcesser(code uint16, version uint8, processer TenuredCommandProcesser, executorService executors.ExecutorService)
+
 	IsActive() bool
 }
 
@@ -37,9 +46,19 @@ type tenuredService struct {
 	remoting         remoting.Remoting
 	responseTables   c8tmap.ConcurrentMap //map[uint32]*responseTableBlock，tome: golang map不能并发写入。
 	commandProcesser map[uint16]*tenuredCommandRunner
+	versionProcesser map[uint32]*tenuredCommandRunner
+
+	//支持的协议版本
+	versions []uint8
 
 	sessionManager SessionManager
 	*remoting.HandlerWrapper
+
+	//远程地址熔断器，为nil不启用
+	breakers *breaker.Breakers
+
+	//健康检查，为nil时总是返回健康
+	healthChecker TenuredHealthChecker
 }
 
 func (this *tenuredService) SetSessionManager(manager SessionManager) {
@@ -50,10 +69,24 @@ func (this *tenuredService) GetSessionManager() SessionManager {
 	return this.sessionManager
 }
 
+//设置支持的协议版本，连接认证时选择双方都支持的最高版本
+func (this *tenuredService) SetVersions(versions ...uint8) {
+	this.versions = versions
+}
+
 func (this *tenuredService) Invoke(channel string, command *TenuredCommand, timeout time.Duration) (*TenuredCommand, error) {
 	if !this.remoting.IsActive() {
 		return nil, &TenuredError{code: remoting.ErrClosed.String(), message: "closed"}
 	}
+	if !this.allow(channel) {
+		return nil, ErrCircuitOpen
+	}
+	response, err := this.invoke(channel, command, timeout)
+	this.feedback(channel, err)
+	return response, err
+}
+
+func (this *tenuredService) invoke(channel string, command *TenuredCommand, timeout time.Duration) (*TenuredCommand, error) {
 	requestId := command.id
 	responseFuture := future.Set()
 
@@ -80,6 +113,26 @@ func (this *tenuredService) Invoke(channel string, command *TenuredCommand, time
 	}
 }
 
+//熔断器是否允许调用
+func (this *tenuredService) allow(channel string) bool {
+	if this.breakers == nil {
+		return true
+	}
+	return this.breakers.Get(channel).Allow()
+}
+
+//调用结果反馈给熔断器，只有超时、连接关闭和无法连接才认为节点异常，业务错误说明节点是正常的
+func (this *tenuredService) feedback(channel string, err error) {
+	if this.breakers == nil {
+		return
+	}
+	if IsTimeoutError(err) || IsConnectError(err) {
+		this.breakers.Get(channel).Failure()
+	} else {
+		this.breakers.Get(channel).Success()
+	}
+}
+
 func (this *tenuredService) AsyncInvoke(channel string, command *TenuredCommand, timeout time.Duration,
 	callback func(tenuredCommand *TenuredCommand, err error)) {
 
@@ -87,6 +140,18 @@ func (this *tenuredService) AsyncInvoke(channel string, command *TenuredCommand,
 		callback(nil, &TenuredError{code: remoting.ErrClosed.String(), message: "closed"})
 		return
 	}
+	if !this.allow(channel) {
+		callback(nil, ErrCircuitOpen)
+		return
+	}
+	//发送失败后等待回复也会超时，只反馈和回调一次
+	userCallback, once := callback, new(sync.Once)
+	callback = func(tenuredCommand *TenuredCommand, err error) {
+		once.Do(func() {
+			this.feedback(channel, err)
+			userCallback(tenuredCommand, err)
+		})
+	}
 	requestId := command.id
 	responseFuture := future.Set()
 	//this.responseTables[requestId] = &responseTableBlock{address: channel, future: responseFuture}
@@ -124,8 +189,13 @@ func (this *tenuredService) RegisterCommandProcesser(code uint16, processer Tenu
 	this.commandProcesser[code] = &tenuredCommandRunner{process: processer, executorService: executorService}
 }
 
+func (this *tenuredService) RegisterVersionCommandProcesser(code uint16, version uint8, processer TenuredCommandProcesser, executorService executors.ExecutorService) {
+	this.versionProcesser[versionKey(code, version)] = &tenuredCommandRunner{process: processer, executorService: executorService}
+}
+
 func (this *tenuredService) makeAck(channel remoting.RemotingChannel, requestCommand *TenuredCommand, header interface{}, err *TenuredError) {
 	response := NewACK(requestCommand.id)
+	response.Version = requestCommand.Version
 	if err != nil {
 		response.RemotingError(err)
 	}
@@ -145,6 +215,14 @@ func (this *tenuredService) onCommandProcesser(channel remoting.RemotingChannel,
 		logger.Debug("receiver idle ", channel.RemoteAddr())
 		this.makeAck(channel, command, nil, nil)
 		return
+	} else if command.code == REQUEST_CODE_HEALTH {
+		this.onHealth(channel, command)
+		return
+	} else if command.code == REQUEST_CODE_STREAM {
+		onStreamControl(channel, command)
+		return
+	} else if processRunner, has := this.versionProcesser[versionKey(command.code, command.Version)]; has {
+		processRunner.onCommand(channel, command)
 	} else if processRunner, has := this.commandProcesser[command.code]; has {
 		processRunner.onCommand(channel, command)
 	} else {
@@ -155,10 +233,7 @@ func (this *tenuredService) onCommandProcesser(channel remoting.RemotingChannel,
 func (this *tenuredService) OnMessage(channel remoting.RemotingChannel, msg interface{}) {
 	command := msg.(*TenuredCommand)
 	if command.IsACK() {
-		requestId := command.id
-		if f, has := this.responseTables.Pop(requestId); has {
-			f.(*responseTableBlock).future.Set(command)
-		}
+		this.onResponse(command)
 		return
 	} else {
 		this.onCommandProcesser(channel, command)
@@ -175,6 +250,20 @@ func (this *tenuredService) OnIdle(channel remoting.RemotingChannel) {
 	}
 }
 
+func (this *tenuredService) OnHighWatermark(channel remoting.RemotingChannel, pending int) {
+	logger.Warnf("channel %s send queue high watermark: %d", channel.RemoteAddr(), pending)
+	if fc, ok := this.sessionManager.(SessionFlowControl); ok {
+		fc.OnHighWatermark(channel, pending)
+	}
+}
+
+func (this *tenuredService) OnLowWatermark(channel remoting.RemotingChannel, pending int) {
+	logger.Infof("channel %s send queue low watermark: %d", channel.RemoteAddr(), pending)
+	if fc, ok := this.sessionManager.(SessionFlowControl); ok {
+		fc.OnLowWatermark(channel, pending)
+	}
+}
+
 func (this *tenuredService) OnConnect(channel remoting.RemotingChannel) {
 	if this.sessionManager != nil {
 		this.sessionManager.OnConnect(channel)
@@ -183,6 +272,7 @@ func (this *tenuredService) OnConnect(channel remoting.RemotingChannel) {
 
 func (this *tenuredService) OnClose(channel remoting.RemotingChannel) {
 	this.fastFailChannel(channel)
+	closeStreams(channel)
 	if this.sessionManager != nil {
 		this.sessionManager.OnClose(channel)
 	}
@@ -194,7 +284,7 @@ func (this *tenuredService) fastFailChannel(channel remoting.RemotingChannel) {
 		if val, has := this.responseTables.Get(tu.Key); has {
 			block := val.(*responseTableBlock)
 			if block.address == channel.RemoteAddr() {
-				block.future.Exception(errors.New(remoting.ErrClosed.String()))
+				block.fail(&remoting.RemotingError{Op: remoting.ErrClosed, Err: errors.New("the channel is closed")})
 				this.responseTables.Remove(tu.Key)
 			}
 		}
@@ -214,7 +304,7 @@ func (this *tenuredService) waitRequest(interrupt bool) {
 		it := this.responseTables.IterBuffered()
 		for tu := <-it; tu.Key != nil; tu = <-it {
 			if block, has := this.responseTables.Pop(tu.Key); has {
-				block.(*responseTableBlock).future.Exception(errors.New(remoting.ErrClosed.String()))
+				block.(*responseTableBlock).fail(&remoting.RemotingError{Op: remoting.ErrClosed, Err: errors.New("the service is closed")})
 			}
 		}
 	} else {
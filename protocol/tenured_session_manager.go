@@ -15,6 +15,12 @@ type SessionManager interface {
 	Filter(func(remoting.RemotingChannel) bool) []remoting.RemotingChannel
 }
 
+//会话管理器可选实现，连接发送队列积压和恢复时通知，用于丢弃或者转移慢连接的负载
+type SessionFlowControl interface {
+	OnHighWatermark(channel remoting.RemotingChannel, pending int)
+	OnLowWatermark(channel remoting.RemotingChannel, pending int)
+}
+
 type MapSessionManager struct {
 	sessions c8tmap.ConcurrentMap
 }
@@ -0,0 +1,311 @@
+package protocol
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/commons"
+	"github.com/ihaiker/tenured-go-server/commons/c8tmap"
+	"github.com/ihaiker/tenured-go-server/commons/future"
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+)
+
+const ErrStreamCanceled = commons.Error("StreamCanceled")
+
+//服务端发送超过了客户端给出的额度
+const ErrStreamOverflow = commons.Error("StreamOverflow")
+
+//流控窗口，VERSION_STREAM 之后服务端最多发送 STREAM_WINDOW 个客户端还没有处理的数据帧
+const STREAM_WINDOW = 64
+
+//流控信息，客户端处理完数据帧后归还额度，或者通知服务端取消流
+type StreamHeader struct {
+	Id      uint32 `json:"id"`
+	Credits int    `json:"credits,omitempty"`
+	Cancel  bool   `json:"cancel,omitempty"`
+}
+
+func newStreamControl(header *StreamHeader) *TenuredCommand {
+	command := NewRequest(REQUEST_CODE_STREAM).MakeOneway()
+	command.SetSafeHeader(header)
+	return command
+}
+
+//流式返回的数据帧，和最终回复使用相同的请求ID。最终回复（不带 FLAG_ONEWAY 的ACK）作为结束标记
+func NewStreamItem(id uint32) *TenuredCommand {
+	return NewACK(id).MakeOneway()
+}
+
+func (this *TenuredCommand) IsStreamItem() bool {
+	return this.IsACK() && this.IsOneway()
+}
+
+//服务端每个流剩余的发送额度，key为 连接#请求ID
+var streamCredits = c8tmap.New()
+
+type streamCredit struct {
+	lock     *sync.Mutex
+	credits  int
+	canceled bool
+	//额度变化时通知等待的发送方
+	signal chan struct{}
+}
+
+func streamKey(channel remoting.RemotingChannel, id uint32) string {
+	return fmt.Sprintf("%p#%d", channel, id)
+}
+
+func getStreamCredit(channel remoting.RemotingChannel, id uint32) *streamCredit {
+	return streamCredits.Upsert(streamKey(channel, id), nil, func(exist bool, valueInMap interface{}, newValue interface{}) interface{} {
+		if exist {
+			return valueInMap
+		}
+		return &streamCredit{lock: new(sync.Mutex), credits: STREAM_WINDOW, signal: make(chan struct{}, 1)}
+	}).(*streamCredit)
+}
+
+func (this *streamCredit) notify() {
+	select {
+	case this.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (this *streamCredit) update(header *StreamHeader) {
+	this.lock.Lock()
+	this.credits += header.Credits
+	this.canceled = this.canceled || header.Cancel
+	this.lock.Unlock()
+	this.notify()
+}
+
+//获取一个发送额度，流被取消时返回 ErrStreamCanceled
+func (this *streamCredit) acquire(timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		this.lock.Lock()
+		if this.canceled {
+			this.lock.Unlock()
+			return ErrStreamCanceled
+		} else if this.credits > 0 {
+			this.credits--
+			this.lock.Unlock()
+			return nil
+		}
+		this.lock.Unlock()
+		select {
+		case <-this.signal:
+		case <-timer.C:
+			return future.ErrTimeout
+		}
+	}
+}
+
+//服务端写出一个流式数据帧。VERSION_STREAM 之后没有发送额度时等待客户端归还，客户端取消后返回 ErrStreamCanceled，调用方应停止继续发送。
+//等待额度时需要读取客户端的流控信息，流式处理器不能在连接的读取协程中执行（注册时需要指定 executorService）
+func WriteStreamItem(channel remoting.RemotingChannel, request *TenuredCommand, header interface{}, body []byte, timeout time.Duration) error {
+	if request.Version >= VERSION_STREAM {
+		if err := getStreamCredit(channel, request.id).acquire(timeout); err != nil {
+			return err
+		}
+	}
+	item := NewStreamItem(request.id)
+	item.Version = request.Version
+	if !commons.IsNil(header) {
+		if err := item.SetHeader(header); err != nil {
+			return err
+		}
+	}
+	item.Body = body
+	return channel.Write(item, timeout)
+}
+
+//服务端流处理完成，写出最终回复前后调用，释放流控信息
+func CloseStream(channel remoting.RemotingChannel, request *TenuredCommand) {
+	streamCredits.Remove(streamKey(channel, request.id))
+}
+
+//连接关闭，取消连接上全部的流
+func closeStreams(channel remoting.RemotingChannel) {
+	prefix := fmt.Sprintf("%p#", channel)
+	for _, key := range streamCredits.Keys() {
+		if strings.HasPrefix(key.(string), prefix) {
+			if credit, has := streamCredits.Pop(key); has {
+				credit.(*streamCredit).update(&StreamHeader{Cancel: true})
+			}
+		}
+	}
+}
+
+//服务端收到客户端的流控信息。归还额度只更新正在发送的流，已经结束的流忽略；
+//取消可能先于服务端第一次发送到达，总是记录，服务端开始发送时直接返回 ErrStreamCanceled
+func onStreamControl(channel remoting.RemotingChannel, command *TenuredCommand) {
+	header := &StreamHeader{}
+	if err := command.GetHeader(header); err != nil {
+		logger.Warnf("stream control from %s error: %s", channel.RemoteAddr(), err)
+		return
+	}
+	if header.Cancel {
+		getStreamCredit(channel, header.Id).update(header)
+	} else if credit, has := streamCredits.Get(streamKey(channel, header.Id)); has {
+		credit.(*streamCredit).update(header)
+	}
+}
+
+//客户端接收流式回复
+type TenuredStream struct {
+	id      uint32
+	address string
+	service *tenuredService
+
+	//每个数据帧的最长等待时间
+	timeout time.Duration
+
+	items chan *TenuredCommand
+	end   *TenuredCommand
+	//已经处理还没有归还给服务端的额度
+	consumed int
+
+	done      chan struct{}
+	doneOnce  *sync.Once
+	doneError error
+}
+
+func newTenuredStream(service *tenuredService, address string, id uint32, timeout time.Duration) *TenuredStream {
+	return &TenuredStream{
+		id: id, address: address, service: service, timeout: timeout,
+		items: make(chan *TenuredCommand, STREAM_WINDOW),
+		done:  make(chan struct{}), doneOnce: new(sync.Once),
+	}
+}
+
+//收到数据帧，在连接的读取协程中调用不能阻塞。服务端按照额度发送，缓冲区满说明服务端没有遵守流控
+func (this *TenuredStream) push(item *TenuredCommand) {
+	select {
+	case this.items <- item:
+	default:
+		logger.Warnf("stream %d from %s overflow", this.id, this.address)
+		this.cancel(ErrStreamOverflow)
+	}
+}
+
+//收到最终回复，和push在同一个读取协程中调用
+func (this *TenuredStream) finish(end *TenuredCommand) {
+	this.end = end
+	close(this.items)
+}
+
+func (this *TenuredStream) fail(err error) {
+	this.doneOnce.Do(func() {
+		this.doneError = err
+		close(this.done)
+	})
+}
+
+//获取下一个数据帧，全部接收完成返回 io.EOF
+func (this *TenuredStream) Next() (*TenuredCommand, error) {
+	timer := time.NewTimer(this.timeout)
+	defer timer.Stop()
+	select {
+	case item, has := <-this.items:
+		if has {
+			this.release(item)
+			return item, nil
+		}
+		if err := this.end.GetError(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	case <-this.done:
+		return nil, this.doneError
+	case <-timer.C:
+		this.Cancel()
+		return nil, future.ErrTimeout
+	}
+}
+
+//处理了一半的窗口后归还额度，旧版本的服务端不需要
+func (this *TenuredStream) release(item *TenuredCommand) {
+	if item.Version < VERSION_STREAM {
+		return
+	}
+	if this.consumed++; this.consumed < STREAM_WINDOW/2 {
+		return
+	}
+	credits := this.consumed
+	this.consumed = 0
+	if err := this.service.remoting.SendTo(this.address, newStreamControl(&StreamHeader{Id: this.id, Credits: credits}), this.timeout); err != nil {
+		logger.Debugf("send stream %d credits error: %v", this.id, err)
+	}
+}
+
+//不再接收后续的数据帧，并且通知服务端停止发送
+func (this *TenuredStream) Cancel() {
+	this.cancel(ErrStreamCanceled)
+}
+
+func (this *TenuredStream) cancel(err error) {
+	_, has := this.service.responseTables.Pop(this.id)
+	this.fail(err)
+	if !has {
+		//已经结束，不需要通知服务端
+		return
+	}
+	this.service.remoting.SyncSendTo(this.address, newStreamControl(&StreamHeader{Id: this.id, Cancel: true}), this.timeout, func(err error) {
+		if err != nil {
+			logger.Debugf("send stream %d cancel error: %v", this.id, err)
+		}
+	})
+}
+
+//发送请求，通过返回的 TenuredStream 读取流式回复
+func (this *tenuredService) InvokeStream(channel string, command *TenuredCommand, timeout time.Duration) (*TenuredStream, error) {
+	if !this.remoting.IsActive() {
+		return nil, &TenuredError{code: remoting.ErrClosed.String(), message: "closed"}
+	}
+	if !this.allow(channel) {
+		return nil, ErrCircuitOpen
+	}
+	requestId := command.id
+	stream := newTenuredStream(this, channel, requestId, timeout)
+	this.responseTables.Set(requestId, &responseTableBlock{address: channel, stream: stream})
+
+	err := this.remoting.SendTo(channel, command, timeout)
+	this.feedback(channel, err)
+	if err != nil {
+		logger.Debugf("send stream %d error: %v", requestId, err)
+		this.responseTables.Remove(requestId)
+		return nil, err
+	}
+	return stream, nil
+}
+
+//处理回复，流式数据帧交给对应的 TenuredStream
+func (this *tenuredService) onResponse(command *TenuredCommand) {
+	requestId := command.id
+	if command.IsStreamItem() {
+		if block, has := this.responseTables.Get(requestId); has && block.(*responseTableBlock).stream != nil {
+			block.(*responseTableBlock).stream.push(command)
+		}
+		return
+	}
+	if block, has := this.responseTables.Pop(requestId); has {
+		if stream := block.(*responseTableBlock).stream; stream != nil {
+			stream.finish(command)
+		} else {
+			block.(*responseTableBlock).future.Set(command)
+		}
+	}
+}
+
+func (this *responseTableBlock) fail(err error) {
+	if this.stream != nil {
+		this.stream.fail(err)
+	} else {
+		this.future.Exception(err)
+	}
+}
@@ -0,0 +1,145 @@
+package protocol
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/commons/executors"
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/ihaiker/tenured-go-server/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+const STREAM = uint16(20)
+
+func TestTenuredClientInvoke_InvokeStream(t *testing.T) {
+	streamServer, _ := NewTenuredServer("127.0.0.1:6077", nil)
+	streamServer.AuthHeader = &AuthHeader{Module: "test", Address: "127.0.0.1:6077"}
+	streamServer.RegisterCommandProcesser(STREAM, func(channel remoting.RemotingChannel, request *TenuredCommand) {
+		size, _ := strconv.Atoi(string(request.Body))
+		for i := 0; i < size; i++ {
+			_ = WriteStreamItem(channel, request, map[string]int{"index": i}, nil, time.Second)
+		}
+		response := NewACK(request.ID())
+		if size == 0 {
+			response.RemotingError(ErrorRouter())
+		}
+		_ = channel.Write(response, time.Second)
+	}, executors.NewFixedExecutorService(2, 10))
+	assert.Nil(t, streamServer.Start())
+	defer streamServer.Shutdown(true)
+
+	streamClient, _ := NewTenuredClient(nil)
+	streamClient.AuthHeader = &AuthHeader{Module: "test", Address: "127.0.0.1:8080"}
+	assert.Nil(t, streamClient.Start())
+	defer streamClient.Shutdown(true)
+
+	invoke := &TenuredClientInvoke{client: streamClient}
+	instances := []*registry.ServerInstance{{Address: "127.0.0.1:6077", Status: registry.StatusOK}}
+
+	indexes := make([]int, 0)
+	err := invoke.InvokeStream(instances, STREAM, nil, []byte("100"), time.Second, func(item *TenuredCommand) error {
+		header := map[string]int{}
+		item.GetSafeHeader(&header)
+		indexes = append(indexes, header["index"])
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(indexes))
+	assert.Equal(t, 99, indexes[99])
+
+	err = invoke.InvokeStream(instances, STREAM, nil, []byte("0"), time.Second, func(item *TenuredCommand) error {
+		return nil
+	})
+	assert.Equal(t, ErrorRouter().Code(), err.Code())
+
+	//回调返回错误，停止接收
+	received := 0
+	err = invoke.InvokeStream(instances, STREAM, nil, []byte("1000"), time.Second, func(item *TenuredCommand) error {
+		if received++; received == 10 {
+			return errors.New("stop")
+		}
+		return nil
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 10, received)
+	assert.Equal(t, 0, streamClient.pendingRequests("127.0.0.1:6077"))
+}
+
+//客户端不处理数据帧时服务端按照额度等待，不阻塞连接上的其他请求；取消后服务端停止发送
+//取消先于服务端第一次发送到达时不会丢失
+func TestTenuredStream_CancelBeforeWrite(t *testing.T) {
+	channel := &attributeChannel{attributes: map[string]interface{}{}}
+	request := NewRequest(STREAM)
+	request.Version = VERSION_STREAM
+	onStreamControl(channel, newStreamControl(&StreamHeader{Id: request.id, Cancel: true}))
+
+	start := time.Now()
+	assert.Equal(t, ErrStreamCanceled, WriteStreamItem(channel, request, nil, []byte("item"), time.Second))
+	assert.True(t, time.Since(start) < time.Millisecond*100)
+
+	CloseStream(channel, request)
+	assert.False(t, streamCredits.Has(streamKey(channel, request.id)))
+}
+
+func TestTenuredStream_FlowControl(t *testing.T) {
+	written, canceled := int32(0), make(chan error, 1)
+	streamServer, _ := NewTenuredServer("127.0.0.1:6093", nil)
+	streamServer.AuthHeader = &AuthHeader{Module: "test", Address: "127.0.0.1:6093"}
+	streamServer.RegisterCommandProcesser(STREAM, func(channel remoting.RemotingChannel, request *TenuredCommand) {
+		defer CloseStream(channel, request)
+		for i := 0; i < 1000; i++ {
+			if err := WriteStreamItem(channel, request, nil, []byte(strconv.Itoa(i)), time.Second*3); err != nil {
+				canceled <- err
+				break
+			}
+			atomic.AddInt32(&written, 1)
+		}
+		_ = channel.Write(NewACK(request.ID()), time.Second)
+	}, executors.NewFixedExecutorService(2, 10))
+	streamServer.RegisterCommandProcesser(HELLO, func(channel remoting.RemotingChannel, request *TenuredCommand) {
+		_ = channel.Write(NewACK(request.ID()), time.Second)
+	}, nil)
+	assert.Nil(t, streamServer.Start())
+	defer streamServer.Shutdown(true)
+
+	streamClient, _ := NewTenuredClient(nil)
+	streamClient.AuthHeader = &AuthHeader{Module: "test", Address: "127.0.0.1:8080"}
+	assert.Nil(t, streamClient.Start())
+	defer streamClient.Shutdown(true)
+
+	stream, err := streamClient.InvokeStream("127.0.0.1:6093", NewRequest(STREAM), time.Second)
+	assert.Nil(t, err)
+
+	//只发送一个窗口的数据
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&written) == STREAM_WINDOW
+	}, time.Second, time.Millisecond*10)
+	_, err = streamClient.Invoke("127.0.0.1:6093", NewRequest(HELLO), time.Second)
+	assert.Nil(t, err)
+
+	//处理半个窗口后归还额度
+	for i := 0; i < STREAM_WINDOW/2; i++ {
+		item, err := stream.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, strconv.Itoa(i), string(item.Body))
+	}
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&written) == STREAM_WINDOW+STREAM_WINDOW/2
+	}, time.Second, time.Millisecond*10)
+
+	stream.Cancel()
+	select {
+	case err := <-canceled:
+		assert.Equal(t, ErrStreamCanceled, err)
+	case <-time.After(time.Second):
+		assert.Fail(t, "server stream not canceled")
+	}
+	assert.Equal(t, int32(STREAM_WINDOW+STREAM_WINDOW/2), atomic.LoadInt32(&written))
+	assert.Eventually(t, func() bool {
+		return streamCredits.Count() == 0
+	}, time.Second, time.Millisecond*10)
+}
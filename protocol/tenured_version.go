@@ -0,0 +1,67 @@
+package protocol
+
+import (
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+)
+
+//协议版本，没有进行版本协商的连接使用 VERSION_DEFAULT
+const VERSION_DEFAULT = uint8(0)
+
+//支持流式回复，.tcd中stream方法只注册在这个版本上
+const VERSION_STREAM = uint8(1)
+
+//当前实现支持的全部协议版本，服务端和客户端默认使用
+func Versions() []uint8 {
+	return []uint8{VERSION_DEFAULT, VERSION_STREAM}
+}
+
+const version_attributes_name = "protocol_version"
+
+//认证时客户端声明支持的协议版本，可以附加在任何认证头信息中
+type VersionsHeader struct {
+	Versions []int `json:"versions,omitempty"`
+}
+
+//选择双方都支持的最高版本，没有声明版本的一方认为只支持 VERSION_DEFAULT
+func NegotiateVersion(local []uint8, remote []int) (uint8, bool) {
+	if len(local) == 0 {
+		local = []uint8{VERSION_DEFAULT}
+	}
+	if len(remote) == 0 {
+		remote = []int{int(VERSION_DEFAULT)}
+	}
+	found, version := false, VERSION_DEFAULT
+	for _, l := range local {
+		for _, r := range remote {
+			if int(l) == r && (!found || l > version) {
+				found, version = true, l
+			}
+		}
+	}
+	return version, found
+}
+
+func toVersionsHeader(versions []uint8) []int {
+	out := make([]int, len(versions))
+	for i, v := range versions {
+		out[i] = int(v)
+	}
+	return out
+}
+
+//连接协商后的协议版本
+func GetVersion(channel remoting.RemotingChannel) uint8 {
+	if version, has := channel.GetAttribute(version_attributes_name); has {
+		return version.(uint8)
+	}
+	return VERSION_DEFAULT
+}
+
+func setVersion(channel remoting.RemotingChannel, version uint8) {
+	channel.SetAttribute(version_attributes_name, version)
+}
+
+//按照（code, version）注册处理器时使用的key
+func versionKey(code uint16, version uint8) uint32 {
+	return uint32(code)<<8 | uint32(version)
+}
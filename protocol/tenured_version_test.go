@@ -0,0 +1,98 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateVersion(t *testing.T) {
+	version, match := NegotiateVersion(nil, nil)
+	assert.True(t, match)
+	assert.Equal(t, VERSION_DEFAULT, version)
+
+	version, match = NegotiateVersion([]uint8{0, 1, 2}, []int{0, 1})
+	assert.True(t, match)
+	assert.Equal(t, uint8(1), version)
+
+	version, match = NegotiateVersion([]uint8{0, 1, 2}, nil)
+	assert.True(t, match)
+	assert.Equal(t, VERSION_DEFAULT, version)
+
+	_, match = NegotiateVersion([]uint8{2}, []int{0, 1})
+	assert.False(t, match)
+}
+
+func TestTenured_VersionProcesser(t *testing.T) {
+	versionServer, _ := NewTenuredServer("127.0.0.1:6076", nil)
+	versionServer.AuthHeader = &AuthHeader{Module: "test", Address: "127.0.0.1:6076"}
+	versionServer.SetVersions(0, 1, 2)
+	versionServer.RegisterCommandProcesser(HELLO, func(channel remoting.RemotingChannel, command *TenuredCommand) {
+		ack := NewACK(command.ID())
+		ack.Body = []byte("v0")
+		_ = channel.Write(ack, time.Second)
+	}, nil)
+	versionServer.RegisterVersionCommandProcesser(HELLO, 1, func(channel remoting.RemotingChannel, command *TenuredCommand) {
+		ack := NewACK(command.ID())
+		ack.Body = []byte("v1")
+		_ = channel.Write(ack, time.Second)
+	}, nil)
+	assert.Nil(t, versionServer.Start())
+	defer versionServer.Shutdown(true)
+
+	newClient := func(versions ...uint8) *TenuredClient {
+		versionClient, _ := NewTenuredClient(nil)
+		versionClient.AuthHeader = &AuthHeader{Module: "test", Address: "127.0.0.1:8080"}
+		versionClient.SetVersions(versions...)
+		assert.Nil(t, versionClient.Start())
+		return versionClient
+	}
+
+	v1Client := newClient(0, 1)
+	defer v1Client.Shutdown(true)
+	response, err := v1Client.Invoke("127.0.0.1:6076", NewRequest(HELLO), time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", string(response.Body))
+	assert.Equal(t, uint8(1), response.Version)
+
+	legacyClient := newClient()
+	defer legacyClient.Shutdown(true)
+	response, err = legacyClient.Invoke("127.0.0.1:6076", NewRequest(HELLO), time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "v0", string(response.Body))
+
+	unknownClient := newClient(3)
+	defer unknownClient.Shutdown(true)
+	_, err = unknownClient.Invoke("127.0.0.1:6076", NewRequest(HELLO), time.Second)
+	assert.NotNil(t, err)
+}
+
+func TestTenured_DefaultVersions(t *testing.T) {
+	versionServer, _ := NewTenuredServer("127.0.0.1:6092", nil)
+	versionServer.AuthHeader = &AuthHeader{Module: "test", Address: "127.0.0.1:6092"}
+	versionServer.RegisterCommandProcesser(HELLO, func(channel remoting.RemotingChannel, command *TenuredCommand) {
+		ack := NewACK(command.ID())
+		ack.Body = []byte("legacy")
+		_ = channel.Write(ack, time.Second)
+	}, nil)
+	versionServer.RegisterVersionCommandProcesser(HELLO, VERSION_STREAM, func(channel remoting.RemotingChannel, command *TenuredCommand) {
+		ack := NewACK(command.ID())
+		ack.Body = []byte("stream")
+		_ = channel.Write(ack, time.Second)
+	}, nil)
+	assert.Nil(t, versionServer.Start())
+	defer versionServer.Shutdown(true)
+
+	versionClient, _ := NewTenuredClient(nil)
+	versionClient.AuthHeader = &AuthHeader{Module: "test", Address: "127.0.0.1:8080"}
+	assert.Nil(t, versionClient.Start())
+	defer versionClient.Shutdown(true)
+
+	//没有设置版本时双方都使用 Versions()，协商到 VERSION_STREAM
+	response, err := versionClient.Invoke("127.0.0.1:6092", NewRequest(HELLO), time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "stream", string(response.Body))
+	assert.Equal(t, VERSION_STREAM, response.Version)
+}
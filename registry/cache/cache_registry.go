@@ -1,70 +1,217 @@
 package cache
 
 import (
+	"sync"
+	"time"
+
 	"github.com/ihaiker/tenured-go-server/commons"
+	"github.com/ihaiker/tenured-go-server/commons/logs"
 	"github.com/ihaiker/tenured-go-server/registry"
 )
 
+var logger = logs.GetLogger("cache")
+
+//缓存的服务
+type cacheService struct {
+	instances []*registry.ServerInstance
+	//最后一次从注册中心获取服务列表的时间
+	updated time.Time
+	//已经有可以使用的服务列表（来自注册中心或者缓存文件）
+	ready bool
+	//注册中心不可用，当前使用的是过期的服务列表
+	stale bool
+	//已经订阅注册中心
+	subscribed bool
+	listeners  map[string]registry.RegistryNotifyListener
+}
+
+func (this *cacheService) notifyListeners() []registry.RegistryNotifyListener {
+	listeners := make([]registry.RegistryNotifyListener, 0, len(this.listeners))
+	for _, listener := range this.listeners {
+		listeners = append(listeners, listener)
+	}
+	return listeners
+}
+
+//缓存状态
+type CacheState struct {
+	//最后一次从注册中心获取服务列表的时间
+	Updated time.Time
+	//注册中心不可用，使用的是过期的服务列表
+	Stale bool
+}
+
+//注册中心缓存，所有服务列表缓存在内存中（可选保存到文件），注册中心不可用时使用最后一次获取的服务列表，
+//并定时重试，注册中心恢复后自动更新
 type CacheServiceRegistry struct {
-	reg registry.ServiceRegistry
+	reg  registry.ServiceRegistry
+	lock *sync.RWMutex
 
-	cache map[string]registry.RegistryNotifyListener
+	services map[string]*cacheService
 
-	serverCache map[string][]*registry.ServerInstance
+	//注册中心不可用时未完成的注册，恢复后重新注册
+	registrations map[string]*registry.ServerInstance
+
+	snapshot *snapshotFile
+	//刷新服务列表和重试的间隔
+	interval  time.Duration
+	closeChan chan struct{}
 }
 
+func (this *CacheServiceRegistry) service(serverName string) *cacheService {
+	service, has := this.services[serverName]
+	if !has {
+		service = &cacheService{listeners: map[string]registry.RegistryNotifyListener{}}
+		this.services[serverName] = service
+	}
+	return service
+}
+
+//注册失败时保留注册信息，注册中心恢复后重新注册，防止注册中心不可用时服务无法启动
 func (this *CacheServiceRegistry) Register(serverInstance *registry.ServerInstance) error {
-	return this.reg.Register(serverInstance)
+	err := this.reg.Register(serverInstance)
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if err != nil {
+		logger.Warnf("register %s error, retry after the registry recovers: %s", serverInstance.Id, err)
+		this.registrations[serverInstance.Id] = serverInstance
+	} else {
+		delete(this.registrations, serverInstance.Id)
+	}
+	return nil
 }
 
 func (this *CacheServiceRegistry) Unregister(serverId string) error {
+	this.lock.Lock()
+	delete(this.registrations, serverId)
+	this.lock.Unlock()
 	return this.reg.Unregister(serverId)
 }
 
+func (this *CacheServiceRegistry) UpdateHealth(serverInstance *registry.ServerInstance, health, output string) error {
+	return registry.ReportHealth(this.reg, serverInstance, health, output)
+}
+
 func (this *CacheServiceRegistry) Subscribe(serverName string, listener registry.RegistryNotifyListener) error {
-	pointer := registry.NotifyPointer(listener)
-	if _, has := this.cache[pointer]; has {
-		return nil
+	this.lock.Lock()
+	service := this.service(serverName)
+	service.listeners[registry.NotifyPointer(listener)] = listener
+	this.lock.Unlock()
+
+	this.subscribe(serverName)
+	return nil
+}
+
+//订阅注册中心，失败后定时重试
+func (this *CacheServiceRegistry) subscribe(serverName string) {
+	this.lock.Lock()
+	service := this.service(serverName)
+	if service.subscribed {
+		this.lock.Unlock()
+		return
 	}
-	newLis := registry.RegistryNotifyListener(func(serverInstances []*registry.ServerInstance) {
-		listener(serverInstances)
-	L1:
-		for _, serverInstance := range serverInstances {
-			if cacheServerInstances, has := this.serverCache[serverInstance.Name]; has {
-				for _, cacheServerInstance := range cacheServerInstances {
-					if cacheServerInstance.Id == serverInstance.Id {
-						cacheServerInstance.Status = serverInstance.Status
-						cacheServerInstance.Metadata = serverInstance.Metadata
-						continue L1
-					}
-				}
-			}
+	service.subscribed = true
+	this.lock.Unlock()
+
+	if err := this.reg.Subscribe(serverName, this.onNotify(serverName)); err != nil {
+		logger.Warnf("subscribe %s error, retry after the registry recovers: %s", serverName, err)
+		this.lock.Lock()
+		service.subscribed = false
+		this.lock.Unlock()
+	}
+}
+
+//注册中心的通知先更新缓存，然后通知监听器
+func (this *CacheServiceRegistry) onNotify(serverName string) registry.RegistryNotifyListener {
+	return func(serverInstances []*registry.ServerInstance) {
+		this.lock.Lock()
+		service := this.service(serverName)
+		service.instances = merge(service.instances, serverInstances)
+		listeners := service.notifyListeners()
+		this.lock.Unlock()
+
+		this.persist()
+		for _, listener := range listeners {
+			listener(serverInstances)
 		}
-	})
-	this.cache[pointer] = newLis
-	return this.reg.Subscribe(serverName, newLis)
+	}
 }
 
+//监听器仅删除，注册中心的订阅需要保留用于更新缓存
 func (this *CacheServiceRegistry) Unsubscribe(serverName string, listener registry.RegistryNotifyListener) error {
-	pointer := registry.NotifyPointer(listener)
-	if l, has := this.cache[pointer]; has {
-		return this.reg.Unsubscribe(serverName, l)
-	} else {
-		return nil
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if service, has := this.services[serverName]; has {
+		delete(service.listeners, registry.NotifyPointer(listener))
 	}
+	return nil
 }
 
 func (this *CacheServiceRegistry) Lookup(serverName string, tags []string) ([]*registry.ServerInstance, error) {
-	if ss, has := this.serverCache[serverName]; has {
-		return this.filterTags(ss, tags), nil
-	} else {
-		ss, err := this.reg.Lookup(serverName, nil) //不能带入tag不然也会丢失注册信息的问题
-		if err == nil {
-			this.serverCache[serverName] = ss
-			_ = this.Subscribe(serverName, func(serverInstances []*registry.ServerInstance) {})
+	this.lock.RLock()
+	service, has := this.services[serverName]
+	if has && service.ready {
+		instances := service.instances
+		this.lock.RUnlock()
+		return this.filterTags(instances, tags), nil
+	}
+	this.lock.RUnlock()
+
+	instances, err := this.refresh(serverName)
+	return this.filterTags(instances, tags), err
+}
+
+//从注册中心获取服务列表，失败时返回缓存的服务列表
+func (this *CacheServiceRegistry) refresh(serverName string) ([]*registry.ServerInstance, error) {
+	serverInstances, err := this.reg.Lookup(serverName, nil) //不能带入tag不然也会丢失注册信息的问题
+
+	this.lock.Lock()
+	service := this.service(serverName)
+	if err != nil {
+		if service.updated.IsZero() {
+			this.lock.Unlock()
+			return nil, err
+		}
+		if !service.stale {
+			logger.Warnf("lookup %s error, use the cache updated at %s: %s",
+				serverName, service.updated.Format("2006-01-02 15:04:05"), err)
 		}
-		return this.filterTags(ss, tags), err
+		service.ready, service.stale = true, true
+		instances := service.instances
+		this.lock.Unlock()
+		return instances, nil
 	}
+	if service.stale {
+		logger.Infof("registry recovered, refresh %s", serverName)
+	}
+	notifies := diff(service.instances, serverInstances)
+	service.instances = merge(nil, serverInstances)
+	service.updated = time.Now()
+	service.ready, service.stale = true, false
+	instances := service.instances
+	listeners := service.notifyListeners()
+	this.lock.Unlock()
+
+	this.persist()
+	this.subscribe(serverName)
+	if len(notifies) != 0 {
+		for _, listener := range listeners {
+			listener(notifies)
+		}
+	}
+	return instances, nil
+}
+
+//缓存状态，没有缓存的服务返回false
+func (this *CacheServiceRegistry) State(serverName string) (CacheState, bool) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	service, has := this.services[serverName]
+	if !has || service.updated.IsZero() {
+		return CacheState{}, false
+	}
+	return CacheState{Updated: service.updated, Stale: service.stale}, true
 }
 
 func (this *CacheServiceRegistry) filterTags(serverInstances []*registry.ServerInstance, tags []string) []*registry.ServerInstance {
@@ -81,18 +228,170 @@ func (this *CacheServiceRegistry) filterTags(serverInstances []*registry.ServerI
 	}
 }
 
+//刷新所有的服务列表，重试未完成的注册和订阅
+func (this *CacheServiceRegistry) refreshAll() {
+	this.lock.RLock()
+	serverNames := make([]string, 0, len(this.services))
+	for serverName, service := range this.services {
+		if service.ready || len(service.listeners) != 0 {
+			serverNames = append(serverNames, serverName)
+		}
+	}
+	registrations := make([]*registry.ServerInstance, 0, len(this.registrations))
+	for _, serverInstance := range this.registrations {
+		registrations = append(registrations, serverInstance)
+	}
+	this.lock.RUnlock()
+
+	for _, serverInstance := range registrations {
+		_ = this.Register(serverInstance)
+	}
+	for _, serverName := range serverNames {
+		_, _ = this.refresh(serverName)
+	}
+}
+
+func (this *CacheServiceRegistry) refreshLoop() {
+	ticker := time.NewTicker(this.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-this.closeChan:
+			return
+		case <-ticker.C:
+			this.refreshAll()
+		}
+	}
+}
+
+//读取缓存文件中的服务列表，只有注册中心不可用时才会使用
+func (this *CacheServiceRegistry) load() {
+	services, err := this.snapshot.read()
+	if err != nil {
+		logger.Warn("read registry cache error: ", err)
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for serverName, snapshot := range services {
+		service := this.service(serverName)
+		service.instances = make([]*registry.ServerInstance, 0, len(snapshot.Instances))
+		for _, instance := range snapshot.Instances {
+			service.instances = append(service.instances, fromSnapshot(instance))
+		}
+		service.updated = time.Unix(0, snapshot.Updated*int64(time.Millisecond))
+		service.stale = true
+	}
+}
+
+func (this *CacheServiceRegistry) persist() {
+	if this.snapshot == nil {
+		return
+	}
+	this.lock.RLock()
+	services := map[string]*snapshotService{}
+	for serverName, service := range this.services {
+		if service.updated.IsZero() {
+			continue
+		}
+		snapshot := &snapshotService{
+			Updated:   service.updated.UnixNano() / int64(time.Millisecond),
+			Instances: make([]*snapshotInstance, 0, len(service.instances)),
+		}
+		for _, serverInstance := range service.instances {
+			snapshot.Instances = append(snapshot.Instances, toSnapshot(serverInstance))
+		}
+		services[serverName] = snapshot
+	}
+	this.lock.RUnlock()
+
+	if err := this.snapshot.write(services); err != nil {
+		logger.Warn("write registry cache error: ", err)
+	}
+}
+
 func (this *CacheServiceRegistry) Start() error {
-	return commons.StartIfService(this.reg)
+	this.load()
+	if err := commons.StartIfService(this.reg); err != nil {
+		return err
+	}
+	go this.refreshLoop()
+	return nil
 }
 
 func (this *CacheServiceRegistry) Shutdown(interrupt bool) {
+	select {
+	case <-this.closeChan:
+	default:
+		close(this.closeChan)
+	}
 	commons.ShutdownIfService(this.reg, interrupt)
 }
 
+//更新服务列表，下线的实例删除，返回新的列表，已经返回给调用方的列表不会被修改
+func merge(serverInstances []*registry.ServerInstance, notifies []*registry.ServerInstance) []*registry.ServerInstance {
+	instances := make([]*registry.ServerInstance, 0, len(serverInstances)+len(notifies))
+	changed := map[string]*registry.ServerInstance{}
+	for _, notify := range notifies {
+		changed[notify.Id] = notify
+	}
+	for _, serverInstance := range serverInstances {
+		if notify, has := changed[serverInstance.Id]; has {
+			delete(changed, serverInstance.Id)
+			if notify.Status == registry.StatusDown {
+				continue
+			}
+			instance := *notify
+			instances = append(instances, &instance)
+		} else {
+			instances = append(instances, serverInstance)
+		}
+	}
+	for _, notify := range notifies {
+		if _, has := changed[notify.Id]; has && notify.Status != registry.StatusDown {
+			instance := *notify
+			instances = append(instances, &instance)
+		}
+	}
+	return instances
+}
+
+//新旧服务列表的差异，已经不存在的实例以下线状态通知
+func diff(olds []*registry.ServerInstance, news []*registry.ServerInstance) []*registry.ServerInstance {
+	notifies := make([]*registry.ServerInstance, 0)
+	current := map[string]*registry.ServerInstance{}
+	for _, serverInstance := range olds {
+		current[serverInstance.Id] = serverInstance
+	}
+	for _, serverInstance := range news {
+		if old, has := current[serverInstance.Id]; !has || old.Status != serverInstance.Status || old.Address != serverInstance.Address {
+			notifies = append(notifies, serverInstance)
+		}
+		delete(current, serverInstance.Id)
+	}
+	for _, serverInstance := range olds {
+		if _, has := current[serverInstance.Id]; has {
+			down := *serverInstance
+			down.Status = registry.StatusDown
+			notifies = append(notifies, &down)
+		}
+	}
+	return notifies
+}
+
 func NewCacheRegistry(reg registry.ServiceRegistry) registry.ServiceRegistry {
+	return NewPersistentCacheRegistry(reg, "")
+}
+
+//path不为空时服务列表保存到文件，注册中心在启动时不可用也可以使用最后一次获取的服务列表
+func NewPersistentCacheRegistry(reg registry.ServiceRegistry, path string) *CacheServiceRegistry {
 	return &CacheServiceRegistry{
-		reg:         reg,
-		cache:       map[string]registry.RegistryNotifyListener{},
-		serverCache: map[string][]*registry.ServerInstance{},
+		reg:           reg,
+		lock:          new(sync.RWMutex),
+		services:      map[string]*cacheService{},
+		registrations: map[string]*registry.ServerInstance{},
+		snapshot:      newSnapshotFile(path),
+		interval:      time.Second * 30,
+		closeChan:     make(chan struct{}),
 	}
 }
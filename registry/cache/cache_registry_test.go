@@ -1,21 +1,29 @@
 package cache
 
 import (
+	"errors"
 	"github.com/ihaiker/tenured-go-server/registry"
 	"github.com/ihaiker/tenured-go-server/registry/plugins"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestCacheServiceRegistry(t *testing.T) {
-	consulPlugin, err := plugins.GetRegistryPlugins("consul://127.0.0.1:8500")
+	memoryPlugin, err := plugins.GetRegistryPlugins("memory://cache")
 	assert.Nil(t, err)
 
-	reg, err := consulPlugin.Registry()
+	reg, err := memoryPlugin.Registry()
 	assert.Nil(t, err)
 
 	cache := NewCacheRegistry(reg)
+	err = reg.Register(&registry.ServerInstance{Id: "1", Name: "tenured_store", Address: "127.0.0.1:6072"})
+	assert.Nil(t, err)
 
 	w := sync.WaitGroup{}
 	w.Add(1)
@@ -37,3 +45,139 @@ func TestCacheServiceRegistry(t *testing.T) {
 		t.Log(k, v)
 	}
 }
+
+//可以模拟注册中心不可用的注册中心
+type failRegistry struct {
+	registry.ServiceRegistry
+	lock *sync.Mutex
+	fail bool
+}
+
+func (this *failRegistry) setFail(fail bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.fail = fail
+}
+
+func (this *failRegistry) err() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.fail {
+		return errors.New("registry unavailable")
+	}
+	return nil
+}
+
+func (this *failRegistry) Register(serverInstance *registry.ServerInstance) error {
+	if err := this.err(); err != nil {
+		return err
+	}
+	return this.ServiceRegistry.Register(serverInstance)
+}
+
+func (this *failRegistry) Lookup(serverName string, tags []string) ([]*registry.ServerInstance, error) {
+	if err := this.err(); err != nil {
+		return nil, err
+	}
+	return this.ServiceRegistry.Lookup(serverName, tags)
+}
+
+func (this *failRegistry) Subscribe(serverName string, listener registry.RegistryNotifyListener) error {
+	if err := this.err(); err != nil {
+		return err
+	}
+	return this.ServiceRegistry.Subscribe(serverName, listener)
+}
+
+func newFailRegistry(t *testing.T, name string) *failRegistry {
+	plugin, err := plugins.GetRegistryPlugins("memory://" + name)
+	assert.Nil(t, err)
+	reg, err := plugin.Registry()
+	assert.Nil(t, err)
+	return &failRegistry{ServiceRegistry: reg, lock: new(sync.Mutex)}
+}
+
+func TestCacheServiceRegistry_Concurrent(t *testing.T) {
+	reg := newFailRegistry(t, "concurrent")
+	cache := NewPersistentCacheRegistry(reg, "")
+	assert.Nil(t, cache.Start())
+	defer cache.Shutdown(true)
+
+	w := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		w.Add(1)
+		go func(i int) {
+			defer w.Done()
+			id := strconv.Itoa(i)
+			_ = cache.Subscribe("tenured_store", func(serverInstances []*registry.ServerInstance) {})
+			assert.Nil(t, cache.Register(&registry.ServerInstance{Id: id, Name: "tenured_store", Address: "127.0.0.1:" + id}))
+			for j := 0; j < 100; j++ {
+				_, err := cache.Lookup("tenured_store", nil)
+				assert.Nil(t, err)
+			}
+			assert.Nil(t, cache.Unregister(id))
+		}(i)
+	}
+	w.Wait()
+}
+
+//注册中心不可用时使用缓存文件中的服务列表，恢复后自动更新
+func TestCacheServiceRegistry_Persistent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "registry.cache")
+
+	reg := newFailRegistry(t, "persistent")
+	assert.Nil(t, reg.Register(&registry.ServerInstance{Id: "1", Name: "tenured_store", Address: "127.0.0.1:6072", Tags: []string{"account"}}))
+
+	cache := NewPersistentCacheRegistry(reg, path)
+	assert.Nil(t, cache.Start())
+	ss, err := cache.Lookup("tenured_store", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ss))
+	cache.Shutdown(true)
+
+	//重新启动时注册中心不可用
+	reg.setFail(true)
+	cache = NewPersistentCacheRegistry(reg, path)
+	cache.interval = time.Millisecond * 50
+	assert.Nil(t, cache.Start())
+	defer cache.Shutdown(true)
+
+	_, has := cache.State("tenured_linker")
+	assert.False(t, has)
+	_, err = cache.Lookup("tenured_linker", nil)
+	assert.NotNil(t, err)
+
+	ss, err = cache.Lookup("tenured_store", []string{"account"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ss))
+	assert.Equal(t, "127.0.0.1:6072", ss[0].Address)
+	state, has := cache.State("tenured_store")
+	assert.True(t, has)
+	assert.True(t, state.Stale)
+
+	//注册中心不可用时的注册和订阅在恢复后完成
+	assert.Nil(t, cache.Register(&registry.ServerInstance{Id: "2", Name: "tenured_store", Address: "127.0.0.1:6073"}))
+	lock, notifies := sync.Mutex{}, map[string]string{}
+	assert.Nil(t, cache.Subscribe("tenured_store", func(serverInstances []*registry.ServerInstance) {
+		lock.Lock()
+		defer lock.Unlock()
+		for _, serverInstance := range serverInstances {
+			notifies[serverInstance.Id] = serverInstance.Status
+		}
+	}))
+
+	reg.setFail(false)
+	time.Sleep(time.Millisecond * 300)
+
+	state, _ = cache.State("tenured_store")
+	assert.False(t, state.Stale)
+	ss, err = cache.Lookup("tenured_store", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(ss))
+	lock.Lock()
+	assert.Equal(t, registry.StatusOK, notifies["2"])
+	lock.Unlock()
+}
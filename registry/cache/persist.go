@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ihaiker/tenured-go-server/registry"
+)
+
+//缓存文件中保存的服务实例
+type snapshotInstance struct {
+	Id       string            `json:"id"`
+	Name     string            `json:"name"`
+	Address  string            `json:"address"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Tags     []string          `json:"tags,omitempty"`
+	Status   string            `json:"status"`
+}
+
+//缓存文件中保存的服务，Updated为最后一次从注册中心获取的时间，单位毫秒
+type snapshotService struct {
+	Updated   int64               `json:"updated"`
+	Instances []*snapshotInstance `json:"instances"`
+}
+
+//服务列表缓存文件，注册中心不可用时使用最后一次获取的服务列表
+type snapshotFile struct {
+	lock *sync.Mutex
+	path string
+}
+
+func (this *snapshotFile) read() (map[string]*snapshotService, error) {
+	services := map[string]*snapshotService{}
+	if this == nil {
+		return services, nil
+	}
+	if data, err := ioutil.ReadFile(this.path); err != nil {
+		if os.IsNotExist(err) {
+			return services, nil
+		}
+		return nil, err
+	} else if len(data) == 0 {
+		return services, nil
+	} else if err := json.Unmarshal(data, &services); err != nil {
+		return nil, err
+	}
+	return services, nil
+}
+
+//写入临时文件后改名，保证读取时文件完整
+func (this *snapshotFile) write(services map[string]*snapshotService) error {
+	if this == nil {
+		return nil
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	data, err := json.MarshalIndent(services, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(this.path), 0755); err != nil {
+		return err
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(this.path), filepath.Base(this.path)+".")
+	if err != nil {
+		return err
+	}
+	if _, err = temp.Write(data); err != nil {
+		_ = temp.Close()
+		_ = os.Remove(temp.Name())
+		return err
+	}
+	if err = temp.Close(); err != nil {
+		_ = os.Remove(temp.Name())
+		return err
+	}
+	if err = os.Rename(temp.Name(), this.path); err != nil {
+		_ = os.Remove(temp.Name())
+	}
+	return err
+}
+
+func newSnapshotFile(path string) *snapshotFile {
+	if path == "" {
+		return nil
+	}
+	return &snapshotFile{lock: new(sync.Mutex), path: path}
+}
+
+func toSnapshot(serverInstance *registry.ServerInstance) *snapshotInstance {
+	return &snapshotInstance{
+		Id: serverInstance.Id, Name: serverInstance.Name, Address: serverInstance.Address,
+		Metadata: serverInstance.Metadata, Tags: serverInstance.Tags, Status: serverInstance.Status,
+	}
+}
+
+func fromSnapshot(instance *snapshotInstance) *registry.ServerInstance {
+	return &registry.ServerInstance{
+		Id: instance.Id, Name: instance.Name, Address: instance.Address,
+		Metadata: instance.Metadata, Tags: instance.Tags, Status: instance.Status,
+	}
+}
@@ -10,8 +10,10 @@ import (
 type PluginConfig struct {
 	Plugin  string
 	Address []string
-	Params  url.Values
-	User    url.Userinfo
+	//地址中的路径部分，例如：file:///data/registry.json
+	Path   string
+	Params url.Values
+	User   url.Userinfo
 }
 
 func (this *PluginConfig) GetInt(key string, def int) int {
@@ -44,6 +46,7 @@ func ParseConfig(cfg string) (*PluginConfig, error) {
 		config := &PluginConfig{}
 		config.Plugin = u.Scheme
 		config.Address = strings.Split(u.Host, ";")
+		config.Path = u.Path
 		if u.User != nil {
 			config.User = *u.User
 		}
@@ -6,7 +6,7 @@ import (
 
 type ConsulServerAttrs struct {
 	//检查类型
-	CheckType string `json:"checkType" yaml:"type" attr:"checkType"` //http,tcp
+	CheckType string `json:"checkType" yaml:"type" attr:"checkType"` //http,tcp,ttl
 
 	Health string `json:"health" yaml:"health" attr:"health"` //http url
 
@@ -18,6 +18,9 @@ type ConsulServerAttrs struct {
 
 	//请求处理超时时间
 	RequestTimeout string `json:"requestTimeout" yaml:"requestTimeout" attr:"requestTime"`
+
+	//ttl检查方式下，超过此时间服务没有上报健康状态，consul将服务置为异常
+	TTL string `json:"ttl" yaml:"ttl" attr:"ttl"`
 }
 
 func (this *ConsulServerAttrs) Config(attrs map[string]string) {
@@ -31,5 +34,6 @@ func newInstance() *ConsulServerAttrs {
 		Interval:       "5s",
 		Deregister:     "15s",
 		RequestTimeout: "3s",
+		TTL:            "15s",
 	}
 }
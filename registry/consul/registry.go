@@ -67,6 +67,9 @@ func (this *ConsulServiceRegistry) Register(serverInstance *registry.ServerInsta
 			check.HTTP = "http://" + serverInstance.Address + attrs.Health
 		case "tcp":
 			check.TCP = serverInstance.Address
+		case "ttl":
+			check.CheckID = ttlCheckId(serverInstance.Id)
+			check.TTL = attrs.TTL
 		}
 
 		reg := &api.AgentServiceRegistration{
@@ -81,14 +84,27 @@ func (this *ConsulServiceRegistry) Register(serverInstance *registry.ServerInsta
 	}
 }
 
+func ttlCheckId(serverId string) string {
+	return "service:" + serverId
+}
+
+//ttl检查方式下由服务上报健康状态，其他检查方式由consul检查
+func (this *ConsulServiceRegistry) UpdateHealth(serverInstance *registry.ServerInstance, health, output string) error {
+	if attrs, match := serverInstance.PluginAttrs.(*ConsulServerAttrs); !match || attrs.CheckType != "ttl" {
+		return nil
+	}
+	return this.client.Agent().UpdateTTL(ttlCheckId(serverInstance.Id), output, health)
+}
+
 func (this *ConsulServiceRegistry) Unregister(serverId string) error {
 	logger.Info("unregister ", serverId)
 	return this.client.Agent().ServiceDeregister(serverId)
 }
 
 func (this *ConsulServiceRegistry) convertService(serverName string, service *api.ServiceEntry) *registry.ServerInstance {
+	//warning状态的服务仍然可以提供服务
 	status := service.Checks.AggregatedStatus()
-	if status == api.HealthPassing {
+	if status == api.HealthPassing || status == api.HealthWarning {
 		status = registry.StatusOK
 	} else {
 		status = registry.StatusCritical
@@ -0,0 +1,51 @@
+package dns
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/registry"
+)
+
+type DnsConfig struct {
+	config *registry.PluginConfig
+}
+
+//DNS服务器地址，dns://10.0.0.2:53;10.0.0.3 ，请求失败时依次尝试下一个。为空时使用系统配置
+func (this *DnsConfig) Servers() []string {
+	servers := make([]string, 0)
+	for _, address := range this.config.Address {
+		if address == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			address = net.JoinHostPort(address, "53")
+		}
+		servers = append(servers, address)
+	}
+	return servers
+}
+
+//服务所在的域，服务tenured_store查询 _tenured_store._tcp.<domain> 的SRV记录
+func (this *DnsConfig) Domain() string {
+	return strings.Trim(this.config.Get("domain", ""), ".")
+}
+
+func (this *DnsConfig) Proto() string {
+	return this.config.Get("proto", "tcp")
+}
+
+//没有SRV记录时查询 <serverName>.<domain> 的A记录，使用此端口。为0时不查询A记录
+func (this *DnsConfig) Port() int {
+	return this.config.GetInt("port", 0)
+}
+
+//订阅服务时轮询DNS的间隔
+func (this *DnsConfig) Interval() time.Duration {
+	return time.Second * time.Duration(this.config.GetInt("interval", 30))
+}
+
+func (this *DnsConfig) Timeout() time.Duration {
+	return time.Second * time.Duration(this.config.GetInt("timeout", 3))
+}
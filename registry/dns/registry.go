@@ -0,0 +1,369 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/registry"
+)
+
+const (
+	//TXT记录中的实例ID，例如：id=store-1
+	txtId = "id"
+	//TXT记录中的标签，使用逗号分隔，例如：tags=account,user
+	txtTags = "tags"
+)
+
+//DNS查询，*net.Resolver实现了此接口
+type resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+//服务注册监听者
+type subscriber struct {
+	listeners map[string]registry.RegistryNotifyListener
+	services  map[string]*registry.ServerInstance
+}
+
+//基于DNS记录的服务发现，服务由运维人员配置SRV/A记录，不支持注册。
+//服务的SRV记录为 _<serverName>._<proto>.<domain>，标签和附加属性配置在TXT记录中，
+//服务级别的TXT记录配置在SRV记录的名称上，实例级别的配置在SRV记录的目标主机上。
+type DnsServiceRegistry struct {
+	lock     *sync.Mutex
+	config   *DnsConfig
+	resolver resolver
+	//订阅信息，key为服务名称
+	subscribes map[string]*subscriber
+
+	//新增订阅时立即查询一次
+	refresh   chan struct{}
+	polling   bool
+	closeChan chan struct{}
+}
+
+func (this *DnsServiceRegistry) Start() error {
+	return nil
+}
+
+func (this *DnsServiceRegistry) Shutdown(interrupt bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	select {
+	case <-this.closeChan:
+	default:
+		close(this.closeChan)
+	}
+}
+
+//DNS的服务列表由外部维护，注册忽略
+func (this *DnsServiceRegistry) Register(serverInstance *registry.ServerInstance) error {
+	logger.Debugf("ignore register %s(%s) : %s", serverInstance.Name, serverInstance.Address, serverInstance.Id)
+	return nil
+}
+
+func (this *DnsServiceRegistry) Unregister(serverId string) error {
+	logger.Debug("ignore unregister ", serverId)
+	return nil
+}
+
+func (this *DnsServiceRegistry) name(name string) string {
+	if domain := this.config.Domain(); domain != "" {
+		return name + "." + domain
+	}
+	return name
+}
+
+//超时和临时错误之外的DNS错误认为记录不存在
+func isNotFound(err error) bool {
+	dnsErr, match := err.(*net.DNSError)
+	return match && !dnsErr.Timeout() && !dnsErr.Temporary()
+}
+
+//读取TXT记录中的 key=value 配置放入attrs，记录不存在时忽略
+func (this *DnsServiceRegistry) lookupTxt(ctx context.Context, name string, attrs map[string]string) error {
+	records, err := this.resolver.LookupTXT(ctx, name)
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	for _, record := range records {
+		if idx := strings.Index(record, "="); idx > 0 {
+			attrs[strings.TrimSpace(record[:idx])] = strings.TrimSpace(record[idx+1:])
+		}
+	}
+	return nil
+}
+
+func (this *DnsServiceRegistry) convertService(serverName, target, host string, port uint16, attrs map[string]string) *registry.ServerInstance {
+	address := net.JoinHostPort(host, strconv.Itoa(int(port)))
+	serverInstance := &registry.ServerInstance{
+		Id:       address,
+		Name:     serverName,
+		Address:  address,
+		Metadata: map[string]string{},
+		Status:   registry.StatusOK,
+	}
+	if hostname := strings.TrimSuffix(target, "."); hostname != host {
+		serverInstance.Metadata[registry.MetadataHostname] = hostname
+	}
+	for key, value := range attrs {
+		switch key {
+		case txtId:
+			serverInstance.Id = value
+		case txtTags:
+			for _, tag := range strings.Split(value, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					serverInstance.Tags = append(serverInstance.Tags, tag)
+				}
+			}
+		default:
+			serverInstance.Metadata[key] = value
+		}
+	}
+	return serverInstance
+}
+
+//查询服务的所有实例，没有SRV记录并且配置了端口时使用A记录
+func (this *DnsServiceRegistry) resolve(serverName string) ([]*registry.ServerInstance, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), this.config.Timeout())
+	defer cancel()
+
+	serviceName := this.name("_" + serverName + "._" + this.config.Proto())
+	_, records, err := this.resolver.LookupSRV(ctx, "", "", serviceName)
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+	serviceAttrs := map[string]string{}
+	if err := this.lookupTxt(ctx, serviceName, serviceAttrs); err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		if this.config.Port() == 0 {
+			return []*registry.ServerInstance{}, nil
+		}
+		records = []*net.SRV{{Target: this.name(serverName), Port: uint16(this.config.Port())}}
+	}
+
+	serverInstances := make([]*registry.ServerInstance, 0, len(records))
+	for _, record := range records {
+		hosts, err := this.resolver.LookupHost(ctx, record.Target)
+		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		attrs := map[string]string{}
+		for key, value := range serviceAttrs {
+			attrs[key] = value
+		}
+		if err := this.lookupTxt(ctx, record.Target, attrs); err != nil {
+			return nil, err
+		}
+		//一个主机名对应多个地址时，ID只能使用地址
+		if len(hosts) > 1 {
+			delete(attrs, txtId)
+		}
+		for _, host := range hosts {
+			serverInstances = append(serverInstances, this.convertService(serverName, record.Target, host, record.Port, attrs))
+		}
+	}
+	return serverInstances, nil
+}
+
+func (this *DnsServiceRegistry) notify(subscribe *subscriber, serverInstances []*registry.ServerInstance) {
+	this.lock.Lock()
+	listeners := make([]registry.RegistryNotifyListener, 0, len(subscribe.listeners))
+	for _, lis := range subscribe.listeners {
+		listeners = append(listeners, lis)
+	}
+	this.lock.Unlock()
+
+	for _, si := range serverInstances {
+		logger.Infof("notify registry name=%s, id=%s, status=%s", si.Name, si.Id, si.Status)
+	}
+	for _, lis := range listeners {
+		lis(serverInstances)
+	}
+}
+
+//查询订阅的服务，和订阅者已知的服务比较，通知变化。查询失败时保留已知的服务
+func (this *DnsServiceRegistry) notifySubscribes() {
+	this.lock.Lock()
+	subscribes := map[string]*subscriber{}
+	for name, subscribe := range this.subscribes {
+		subscribes[name] = subscribe
+	}
+	this.lock.Unlock()
+
+	for serverName, subscribe := range subscribes {
+		serverInstances, err := this.resolve(serverName)
+		if err != nil {
+			logger.Warnf("lookup %s error: %s", serverName, err)
+			continue
+		}
+		notifies := make([]*registry.ServerInstance, 0)
+		currentServices := map[string]*registry.ServerInstance{}
+		for _, serverInstance := range serverInstances {
+			currentServices[serverInstance.Id] = serverInstance
+			if old, has := subscribe.services[serverInstance.Id]; !has || old.Address != serverInstance.Address {
+				notifies = append(notifies, serverInstance)
+			}
+		}
+		for _, serverInstance := range subscribe.services {
+			if _, has := currentServices[serverInstance.Id]; !has {
+				down := *serverInstance
+				down.Status = registry.StatusDown
+				notifies = append(notifies, &down)
+			}
+		}
+		subscribe.services = currentServices
+		if len(notifies) != 0 {
+			this.notify(subscribe, notifies)
+		}
+	}
+}
+
+func (this *DnsServiceRegistry) poll() {
+	logger.Debug("start poll dns records")
+	ticker := time.NewTicker(this.config.Interval())
+	defer ticker.Stop()
+	for {
+		this.notifySubscribes()
+		select {
+		case <-this.closeChan:
+			return
+		case <-this.refresh:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (this *DnsServiceRegistry) Subscribe(serverName string, listener registry.RegistryNotifyListener) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	logger.Info("Subscribe ", serverName)
+	if this.addSubscribe(serverName, listener) {
+		if !this.polling {
+			this.polling = true
+			go this.poll()
+		} else {
+			select {
+			case this.refresh <- struct{}{}:
+			default:
+			}
+		}
+	}
+	return nil
+}
+
+func (this *DnsServiceRegistry) Unsubscribe(serverName string, listener registry.RegistryNotifyListener) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	logger.Info("Unsubscribe ", serverName, ",fn ", listener)
+	if this.removeSubscribe(serverName, listener) {
+		delete(this.subscribes, serverName)
+	}
+	return nil
+}
+
+func (this *DnsServiceRegistry) Lookup(serverName string, tags []string) ([]*registry.ServerInstance, error) {
+	instances, err := this.resolve(serverName)
+	if err != nil {
+		return nil, err
+	}
+	serverInstances := make([]*registry.ServerInstance, 0, len(instances))
+	for _, serverInstance := range instances {
+		if hasAllTags(serverInstance, tags) {
+			serverInstances = append(serverInstances, serverInstance)
+		}
+	}
+	return serverInstances, nil
+}
+
+func hasAllTags(serverInstance *registry.ServerInstance, tags []string) bool {
+	for _, tag := range tags {
+		if !serverInstance.HasTag(tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func (this *DnsServiceRegistry) getOrCreateSubscribe(name string) *subscriber {
+	if subInfo, has := this.subscribes[name]; !has {
+		subInfo = &subscriber{
+			listeners: map[string]registry.RegistryNotifyListener{},
+			services:  map[string]*registry.ServerInstance{},
+		}
+		this.subscribes[name] = subInfo
+	}
+	return this.subscribes[name]
+}
+
+//@return 返回是否是此服务的第一个监听器
+func (this *DnsServiceRegistry) addSubscribe(name string, listener registry.RegistryNotifyListener) bool {
+	sets := this.getOrCreateSubscribe(name)
+	from := len(sets.listeners)
+	pointer := registry.NotifyPointer(listener)
+	sets.listeners[pointer] = listener
+	return from == 0 && len(sets.listeners) == 1
+}
+
+//@return 是否是次服务的最后一个监听器
+func (this *DnsServiceRegistry) removeSubscribe(name string, listener registry.RegistryNotifyListener) bool {
+	if sets, has := this.subscribes[name]; has {
+		pointer := registry.NotifyPointer(listener)
+		from := len(sets.listeners)
+		delete(sets.listeners, pointer)
+		return from == 1 && len(sets.listeners) == 0
+	} else {
+		return false
+	}
+}
+
+//指定DNS服务器时使用go实现的解析器，依次连接配置的服务器
+func newResolver(config *DnsConfig) resolver {
+	servers := config.Servers()
+	if len(servers) == 0 {
+		return net.DefaultResolver
+	}
+	dialer := &net.Dialer{Timeout: config.Timeout()}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var lastErr error
+			for _, server := range servers {
+				if conn, err := dialer.DialContext(ctx, network, server); err == nil {
+					return conn, nil
+				} else {
+					lastErr = err
+				}
+			}
+			return nil, lastErr
+		},
+	}
+}
+
+func newRegistry(pluginConfig *registry.PluginConfig) (*DnsServiceRegistry, error) {
+	config := &DnsConfig{config: pluginConfig}
+	return &DnsServiceRegistry{
+		lock:       new(sync.Mutex),
+		config:     config,
+		resolver:   newResolver(config),
+		subscribes: map[string]*subscriber{},
+		refresh:    make(chan struct{}, 1),
+		closeChan:  make(chan struct{}),
+	}, nil
+}
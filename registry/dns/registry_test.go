@@ -0,0 +1,163 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+//模拟DNS记录
+type fakeResolver struct {
+	lock  sync.Mutex
+	srv   map[string][]*net.SRV
+	hosts map[string][]string
+	txt   map[string][]string
+	//模拟DNS服务器超时
+	fail bool
+}
+
+func (this *fakeResolver) notFound(name string) error {
+	if this.fail {
+		return &net.DNSError{Err: "i/o timeout", Name: name, IsTimeout: true}
+	}
+	return &net.DNSError{Err: "no such host", Name: name}
+}
+
+func (this *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if records, has := this.srv[name]; has {
+		return name, records, nil
+	}
+	return "", nil, this.notFound(name)
+}
+
+func (this *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if hosts, has := this.hosts[host]; has {
+		return hosts, nil
+	}
+	return nil, this.notFound(host)
+}
+
+func (this *fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if records, has := this.txt[name]; has {
+		return records, nil
+	}
+	return nil, this.notFound(name)
+}
+
+func newTestRegistry(t *testing.T, address string, fake *fakeResolver) *DnsServiceRegistry {
+	config, err := registry.ParseConfig(address)
+	assert.Nil(t, err)
+	reg, err := newRegistry(config)
+	assert.Nil(t, err)
+	reg.resolver = fake
+	return reg
+}
+
+func waitStatus(t *testing.T, notifies chan *registry.ServerInstance, id, status string) {
+	timeout := time.After(time.Second * 5)
+	for {
+		select {
+		case si := <-notifies:
+			if si.Id == id && si.Status == status {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("wait %s %s timeout", id, status)
+		}
+	}
+}
+
+func TestDnsConfig(t *testing.T) {
+	config, _ := registry.ParseConfig("dns://10.0.0.2;10.0.0.3:5353?domain=service.local.")
+	dnsConfig := &DnsConfig{config: config}
+	assert.Equal(t, []string{"10.0.0.2:53", "10.0.0.3:5353"}, dnsConfig.Servers())
+	assert.Equal(t, "service.local", dnsConfig.Domain())
+
+	config, _ = registry.ParseConfig("dns://")
+	assert.Equal(t, 0, len((&DnsConfig{config: config}).Servers()))
+}
+
+func TestDnsServiceRegistry(t *testing.T) {
+	fake := &fakeResolver{
+		srv: map[string][]*net.SRV{
+			"_tenured_store._tcp.service.local": {
+				{Target: "store1.service.local.", Port: 6072},
+				{Target: "store2.service.local.", Port: 6072},
+			},
+		},
+		hosts: map[string][]string{
+			"store1.service.local.":        {"10.0.0.1"},
+			"store2.service.local.":        {"10.0.0.2"},
+			"tenured_linker.service.local": {"10.0.1.1", "10.0.1.2"},
+		},
+		txt: map[string][]string{
+			"_tenured_store._tcp.service.local": {"tags=account", "external=store.example.com:6072"},
+			"store1.service.local.":             {"id=store-1", "tags=account,user"},
+		},
+	}
+	reg := newTestRegistry(t, "dns://?domain=service.local&interval=1&port=6073", fake)
+	defer reg.Shutdown(true)
+
+	assert.Nil(t, reg.Register(&registry.ServerInstance{Id: "1", Name: "tenured_store"}))
+	assert.Nil(t, reg.Unregister("1"))
+
+	instances, err := reg.Lookup("tenured_store", []string{"user"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(instances))
+	assert.Equal(t, "store-1", instances[0].Id)
+	assert.Equal(t, "10.0.0.1:6072", instances[0].Address)
+	assert.Equal(t, []string{"account", "user"}, instances[0].Tags)
+	assert.Equal(t, "store1.service.local", instances[0].Metadata[registry.MetadataHostname])
+	assert.Equal(t, "store.example.com:6072", instances[0].Metadata["external"])
+	assert.Equal(t, registry.StatusOK, instances[0].Status)
+
+	//服务级别的TXT记录
+	instances, err = reg.Lookup("tenured_store", []string{"account"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(instances))
+
+	//没有SRV记录使用A记录和配置的端口
+	instances, err = reg.Lookup("tenured_linker", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(instances))
+	assert.Equal(t, "10.0.1.1:6073", instances[0].Id)
+
+	instances, err = reg.Lookup("tenured_tenant", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(instances))
+
+	notifies := make(chan *registry.ServerInstance, 16)
+	assert.Nil(t, reg.Subscribe("tenured_store", func(serverInstances []*registry.ServerInstance) {
+		for _, si := range serverInstances {
+			notifies <- si
+		}
+	}))
+	waitStatus(t, notifies, "store-1", registry.StatusOK)
+
+	fake.lock.Lock()
+	fake.srv["_tenured_store._tcp.service.local"] = fake.srv["_tenured_store._tcp.service.local"][:1]
+	fake.lock.Unlock()
+	waitStatus(t, notifies, "10.0.0.2:6072", registry.StatusDown)
+
+	//查询失败时保留已知的服务
+	fake.lock.Lock()
+	fake.srv = nil
+	fake.fail = true
+	fake.lock.Unlock()
+	select {
+	case si := <-notifies:
+		t.Fatalf("unexpected notify %s %s", si.Id, si.Status)
+	case <-time.After(time.Millisecond * 1500):
+	}
+}
@@ -0,0 +1,40 @@
+package etcd
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/registry"
+)
+
+type EtcdConfig struct {
+	config *registry.PluginConfig
+}
+
+//etcd节点地址，etcd://host1:2379;host2:2379
+func (this *EtcdConfig) Endpoints() []string {
+	return this.config.Address
+}
+
+//服务注册的key前缀，服务实例保存在 {prefix}/{serverName}/{serverId}
+func (this *EtcdConfig) Prefix() string {
+	return strings.TrimRight(this.config.Get("prefix", "/tenured/services"), "/")
+}
+
+func (this *EtcdConfig) DialTimeout() time.Duration {
+	return time.Second * time.Duration(this.config.GetInt("dialTimeout", 5))
+}
+
+//请求超时时间
+func (this *EtcdConfig) RequestTimeout() time.Duration {
+	return time.Second * time.Duration(this.config.GetInt("requestTimeout", 3))
+}
+
+func (this *EtcdConfig) Username() string {
+	return this.config.User.Username()
+}
+
+func (this *EtcdConfig) Password() string {
+	password, _ := this.config.User.Password()
+	return password
+}
@@ -0,0 +1,31 @@
+package etcd
+
+import (
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/registry"
+)
+
+type EtcdServerAttrs struct {
+	//注册租约时间，节点异常超过此时间没有续约自动下线
+	TTL string `json:"ttl" yaml:"ttl" attr:"ttl"` //15s
+}
+
+func (this *EtcdServerAttrs) Config(attrs map[string]string) {
+	registry.LoadModel(this, attrs)
+}
+
+//租约时间，单位秒，最少1秒
+func (this *EtcdServerAttrs) LeaseTTL() int64 {
+	ttl, err := time.ParseDuration(this.TTL)
+	if err != nil || ttl < time.Second {
+		return 15
+	}
+	return int64(ttl / time.Second)
+}
+
+func newInstance() *EtcdServerAttrs {
+	return &EtcdServerAttrs{
+		TTL: "15s",
+	}
+}
@@ -0,0 +1,57 @@
+package etcd
+
+import (
+	"sync"
+
+	"github.com/ihaiker/tenured-go-server/commons/logs"
+	"github.com/ihaiker/tenured-go-server/registry"
+	"github.com/sirupsen/logrus"
+)
+
+type EtcdRegistryPlugins struct {
+	lock     *sync.Mutex
+	registry registry.ServiceRegistry
+	config   *registry.PluginConfig
+}
+
+func (this *EtcdRegistryPlugins) Instance(config map[string]string) (*registry.ServerInstance, error) {
+	sInstance := &registry.ServerInstance{}
+
+	etcdAttr := newInstance()
+	etcdAttr.Config(config)
+	sInstance.PluginAttrs = etcdAttr
+
+	return sInstance, nil
+}
+
+func (this *EtcdRegistryPlugins) Registry() (registry.ServiceRegistry, error) {
+	if this.registry != nil {
+		return this.registry, nil
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.registry != nil {
+		return this.registry, nil
+	}
+
+	if reg, err := newRegistry(this.config); err != nil {
+		return nil, err
+	} else {
+		this.registry = reg
+		return reg, nil
+	}
+}
+
+var logger *logrus.Logger
+
+func init() {
+	logger = logs.GetLogger("etcd")
+}
+
+func NewRegistryPlugins(config *registry.PluginConfig) (registry.Plugins, error) {
+	return &EtcdRegistryPlugins{
+		lock:   new(sync.Mutex),
+		config: config,
+	}, nil
+}
@@ -0,0 +1,445 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/registry"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+//保存在etcd中的服务实例
+type etcdInstance struct {
+	Id       string            `json:"id"`
+	Name     string            `json:"name"`
+	Address  string            `json:"address"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Tags     []string          `json:"tags,omitempty"`
+	Status   string            `json:"status"`
+}
+
+//服务注册监听者
+type subscriber struct {
+	listeners map[string]registry.RegistryNotifyListener
+	services  map[string]*registry.ServerInstance
+	closeChan chan struct{}
+}
+
+func (this *subscriber) close() {
+	close(this.closeChan)
+}
+
+//服务注册信息，租约丢失后使用新的租约重新注册
+type registration struct {
+	key     string
+	value   string
+	ttl     int64
+	leaseId clientv3.LeaseID
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type EtcdServiceRegistry struct {
+	lock   *sync.Mutex
+	client *clientv3.Client
+	config *EtcdConfig
+	//订阅信息
+	subscribes map[string]*subscriber
+	//本节点注册的服务
+	registrations map[string]*registration
+}
+
+func (this *EtcdServiceRegistry) servicePrefix(serverName string) string {
+	return this.config.Prefix() + "/" + serverName + "/"
+}
+
+func (this *EtcdServiceRegistry) Start() error {
+	return nil
+}
+
+func (this *EtcdServiceRegistry) Shutdown(interrupt bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	for name, ch := range this.subscribes {
+		ch.close()
+		delete(this.subscribes, name)
+	}
+	for id, reg := range this.registrations {
+		this.revoke(reg)
+		delete(this.registrations, id)
+	}
+	if err := this.client.Close(); err != nil {
+		logger.Warn("close etcd client error: ", err)
+	}
+}
+
+func (this *EtcdServiceRegistry) Register(serverInstance *registry.ServerInstance) error {
+	logger.Infof("register %s(%s) : %s", serverInstance.Name, serverInstance.Address, serverInstance.Id)
+	ttl := newInstance().LeaseTTL()
+	if attrs, match := serverInstance.PluginAttrs.(*EtcdServerAttrs); match {
+		ttl = attrs.LeaseTTL()
+	}
+	status := serverInstance.Status
+	if status == "" {
+		status = registry.StatusOK
+	}
+	value, _ := json.Marshal(&etcdInstance{
+		Id: serverInstance.Id, Name: serverInstance.Name, Address: serverInstance.Address,
+		Metadata: serverInstance.Metadata, Tags: serverInstance.Tags, Status: status,
+	})
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	//已经注册过，使用原来的租约更新注册信息
+	if reg, has := this.registrations[serverInstance.Id]; has {
+		reg.value = string(value)
+		ctx, cancel := context.WithTimeout(reg.ctx, this.config.RequestTimeout())
+		defer cancel()
+		_, err := this.client.Put(ctx, reg.key, reg.value, clientv3.WithLease(reg.leaseId))
+		return err
+	}
+
+	reg := &registration{
+		key: this.servicePrefix(serverInstance.Name) + serverInstance.Id, value: string(value), ttl: ttl,
+	}
+	reg.ctx, reg.cancel = context.WithCancel(context.Background())
+	alive, err := this.grant(reg)
+	if err != nil {
+		reg.cancel()
+		return err
+	}
+	this.registrations[serverInstance.Id] = reg
+	go this.keepAlive(reg, alive)
+	return nil
+}
+
+//申请租约并写入注册信息
+func (this *EtcdServiceRegistry) grant(reg *registration) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	ctx, cancel := context.WithTimeout(reg.ctx, this.config.RequestTimeout())
+	defer cancel()
+
+	lease, err := this.client.Grant(ctx, reg.ttl)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = this.client.Put(ctx, reg.key, reg.value, clientv3.WithLease(lease.ID)); err != nil {
+		return nil, err
+	}
+	reg.leaseId = lease.ID
+	return this.client.KeepAlive(reg.ctx, lease.ID)
+}
+
+//续约，续约通道关闭说明租约已经过期（etcd长时间不可用），重新注册
+func (this *EtcdServiceRegistry) keepAlive(reg *registration, alive <-chan *clientv3.LeaseKeepAliveResponse) {
+	for {
+		for range alive {
+		}
+		select {
+		case <-reg.ctx.Done():
+			return
+		default:
+		}
+		logger.Warnf("lease of %s lost, register again", reg.key)
+		for {
+			var err error
+			this.lock.Lock()
+			alive, err = this.grant(reg)
+			this.lock.Unlock()
+			if err == nil {
+				break
+			}
+			logger.Warnf("register %s error: %s", reg.key, err)
+			select {
+			case <-reg.ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}
+}
+
+//撤销租约，注册信息随租约一起删除
+func (this *EtcdServiceRegistry) revoke(reg *registration) {
+	reg.cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), this.config.RequestTimeout())
+	defer cancel()
+	if _, err := this.client.Revoke(ctx, reg.leaseId); err != nil {
+		logger.Warnf("revoke %s error: %s", reg.key, err)
+	}
+}
+
+func (this *EtcdServiceRegistry) Unregister(serverId string) error {
+	logger.Info("unregister ", serverId)
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if reg, has := this.registrations[serverId]; has {
+		delete(this.registrations, serverId)
+		this.revoke(reg)
+		return nil
+	}
+
+	//其他节点注册的服务，按照ID查找删除
+	ctx, cancel := context.WithTimeout(context.Background(), this.config.RequestTimeout())
+	defer cancel()
+	resp, err := this.client.Get(ctx, this.config.Prefix()+"/", clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return err
+	}
+	for _, kv := range resp.Kvs {
+		if strings.HasSuffix(string(kv.Key), "/"+serverId) {
+			if _, err := this.client.Delete(ctx, string(kv.Key)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (this *EtcdServiceRegistry) convertService(value []byte) (*registry.ServerInstance, error) {
+	instance := &etcdInstance{}
+	if err := json.Unmarshal(value, instance); err != nil {
+		return nil, err
+	}
+	status := instance.Status
+	if status != registry.StatusOK && status != registry.StatusDown {
+		status = registry.StatusCritical
+	}
+	return &registry.ServerInstance{
+		Id:       instance.Id,
+		Name:     instance.Name,
+		Metadata: instance.Metadata,
+		Address:  instance.Address,
+		Tags:     instance.Tags,
+		Status:   status,
+	}, nil
+}
+
+func (this *EtcdServiceRegistry) notify(subscribe *subscriber, serverInstances []*registry.ServerInstance) {
+	this.lock.Lock()
+	listeners := make([]registry.RegistryNotifyListener, 0, len(subscribe.listeners))
+	for _, lis := range subscribe.listeners {
+		listeners = append(listeners, lis)
+	}
+	this.lock.Unlock()
+
+	for _, si := range serverInstances {
+		logger.Infof("notify registry name=%s, id=%s, status=%s", si.Name, si.Id, si.Status)
+	}
+	for _, lis := range listeners {
+		lis(serverInstances)
+	}
+}
+
+//全量加载服务，和已知的服务比较后通知变化，返回加载时的版本号
+func (this *EtcdServiceRegistry) syncSubscribe(serverName string, subscribe *subscriber) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), this.config.RequestTimeout())
+	defer cancel()
+	resp, err := this.client.Get(ctx, this.servicePrefix(serverName), clientv3.WithPrefix())
+	if err != nil {
+		return 0, err
+	}
+
+	notifies := make([]*registry.ServerInstance, 0)
+	currentServices := map[string]*registry.ServerInstance{}
+	for _, kv := range resp.Kvs {
+		serverInstance, err := this.convertService(kv.Value)
+		if err != nil {
+			logger.Warnf("registry %s error: %s", string(kv.Key), err)
+			continue
+		}
+		currentServices[serverInstance.Id] = serverInstance
+		if old, has := subscribe.services[serverInstance.Id]; !has || old.Status != serverInstance.Status {
+			notifies = append(notifies, serverInstance)
+		}
+	}
+	for _, serverInstance := range subscribe.services {
+		if _, has := currentServices[serverInstance.Id]; !has {
+			serverInstance.Status = registry.StatusDown
+			notifies = append(notifies, serverInstance)
+		}
+	}
+	subscribe.services = currentServices
+	if len(notifies) != 0 {
+		this.notify(subscribe, notifies)
+	}
+	return resp.Header.Revision, nil
+}
+
+func (this *EtcdServiceRegistry) watchSubscribe(serverName string, subscribe *subscriber) {
+	logger.Debug("start subscribe server: ", serverName)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-subscribe.closeChan
+		cancel()
+	}()
+
+	for {
+		select {
+		case <-subscribe.closeChan:
+			return
+		default:
+		}
+		revision, err := this.syncSubscribe(serverName, subscribe)
+		if err != nil {
+			logger.Warn("load registry error: ", err)
+			select {
+			case <-subscribe.closeChan:
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		//监听中断（版本被压缩、leader切换等）后重新全量加载
+		watchChan := this.client.Watch(clientv3.WithRequireLeader(ctx), this.servicePrefix(serverName),
+			clientv3.WithPrefix(), clientv3.WithRev(revision+1))
+		for resp := range watchChan {
+			if err := resp.Err(); err != nil {
+				logger.Warnf("watch %s error: %s", serverName, err)
+				break
+			}
+			notifies := make([]*registry.ServerInstance, 0)
+			for _, event := range resp.Events {
+				switch event.Type {
+				case mvccpb.PUT:
+					serverInstance, err := this.convertService(event.Kv.Value)
+					if err != nil {
+						logger.Warnf("registry %s error: %s", string(event.Kv.Key), err)
+						continue
+					}
+					if old, has := subscribe.services[serverInstance.Id]; !has || old.Status != serverInstance.Status {
+						notifies = append(notifies, serverInstance)
+					}
+					subscribe.services[serverInstance.Id] = serverInstance
+				case mvccpb.DELETE:
+					key := string(event.Kv.Key)
+					serverId := key[strings.LastIndex(key, "/")+1:]
+					if serverInstance, has := subscribe.services[serverId]; has {
+						delete(subscribe.services, serverId)
+						serverInstance.Status = registry.StatusDown
+						notifies = append(notifies, serverInstance)
+					}
+				}
+			}
+			if len(notifies) != 0 {
+				this.notify(subscribe, notifies)
+			}
+		}
+	}
+}
+
+func (this *EtcdServiceRegistry) Subscribe(serverName string, listener registry.RegistryNotifyListener) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	logger.Info("Subscribe ", serverName)
+	if this.addSubscribe(serverName, listener) {
+		go this.watchSubscribe(serverName, this.subscribes[serverName])
+	}
+	return nil
+}
+
+func (this *EtcdServiceRegistry) Unsubscribe(serverName string, listener registry.RegistryNotifyListener) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	logger.Info("Unsubscribe ", serverName, ",fn ", listener)
+	if this.removeSubscribe(serverName, listener) {
+		if sub, has := this.subscribes[serverName]; has {
+			sub.close()
+			delete(this.subscribes, serverName)
+		}
+	}
+	return nil
+}
+
+func (this *EtcdServiceRegistry) Lookup(serverName string, tags []string) ([]*registry.ServerInstance, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), this.config.RequestTimeout())
+	defer cancel()
+	resp, err := this.client.Get(ctx, this.servicePrefix(serverName), clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	serverInstances := make([]*registry.ServerInstance, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		if serverInstance, err := this.convertService(kv.Value); err != nil {
+			logger.Warnf("registry %s error: %s", string(kv.Key), err)
+		} else if hasAllTags(serverInstance, tags) {
+			serverInstances = append(serverInstances, serverInstance)
+		}
+	}
+	return serverInstances, nil
+}
+
+func hasAllTags(serverInstance *registry.ServerInstance, tags []string) bool {
+	for _, tag := range tags {
+		if !serverInstance.HasTag(tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func (this *EtcdServiceRegistry) getOrCreateSubscribe(name string) *subscriber {
+	if subInfo, has := this.subscribes[name]; !has {
+		subInfo = &subscriber{
+			listeners: map[string]registry.RegistryNotifyListener{},
+			services:  map[string]*registry.ServerInstance{},
+			closeChan: make(chan struct{}),
+		}
+		this.subscribes[name] = subInfo
+	}
+	return this.subscribes[name]
+}
+
+//@return 返回是否是此服务的第一个监听器
+func (this *EtcdServiceRegistry) addSubscribe(name string, listener registry.RegistryNotifyListener) bool {
+	sets := this.getOrCreateSubscribe(name)
+	from := len(sets.listeners)
+	pointer := registry.NotifyPointer(listener)
+	sets.listeners[pointer] = listener
+	return from == 0 && len(sets.listeners) == 1
+}
+
+//@return 是否是次服务的最后一个监听器
+func (this *EtcdServiceRegistry) removeSubscribe(name string, listener registry.RegistryNotifyListener) bool {
+	if sets, has := this.subscribes[name]; has {
+		pointer := registry.NotifyPointer(listener)
+		from := len(sets.listeners)
+		delete(sets.listeners, pointer)
+		return from == 1 && len(sets.listeners) == 0
+	} else {
+		return false
+	}
+}
+
+func newRegistry(pluginConfig *registry.PluginConfig) (*EtcdServiceRegistry, error) {
+	config := &EtcdConfig{config: pluginConfig}
+	serviceRegistry := &EtcdServiceRegistry{
+		lock:          new(sync.Mutex),
+		config:        config,
+		subscribes:    map[string]*subscriber{},
+		registrations: map[string]*registration{},
+	}
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   config.Endpoints(),
+		DialTimeout: config.DialTimeout(),
+		Username:    config.Username(),
+		Password:    config.Password(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	serviceRegistry.client = client
+	return serviceRegistry, nil
+}
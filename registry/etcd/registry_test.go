@@ -0,0 +1,121 @@
+package etcd
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/registry"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+func startEtcd(t *testing.T) (*embed.Etcd, func()) {
+	dir, err := ioutil.TempDir("", "etcd")
+	assert.Nil(t, err)
+
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	cfg.LogLevel = "error"
+	clientUrl, _ := url.Parse("http://127.0.0.1:23790")
+	peerUrl, _ := url.Parse("http://127.0.0.1:23800")
+	cfg.ListenClientUrls, cfg.AdvertiseClientUrls = []url.URL{*clientUrl}, []url.URL{*clientUrl}
+	cfg.ListenPeerUrls, cfg.AdvertisePeerUrls = []url.URL{*peerUrl}, []url.URL{*peerUrl}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	server, err := embed.StartEtcd(cfg)
+	assert.Nil(t, err)
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(time.Second * 10):
+		t.Fatal("etcd start timeout")
+	}
+	return server, func() {
+		server.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func newTestRegistry(t *testing.T) registry.ServiceRegistry {
+	config, err := registry.ParseConfig("etcd://127.0.0.1:23790?prefix=/test/services")
+	assert.Nil(t, err)
+	plugin, err := NewRegistryPlugins(config)
+	assert.Nil(t, err)
+	reg, err := plugin.Registry()
+	assert.Nil(t, err)
+	return reg
+}
+
+func waitStatus(t *testing.T, notifies chan *registry.ServerInstance, id, status string) {
+	timeout := time.After(time.Second * 5)
+	for {
+		select {
+		case si := <-notifies:
+			if si.Id == id && si.Status == status {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("wait %s %s timeout", id, status)
+		}
+	}
+}
+
+func TestEtcdServiceRegistry(t *testing.T) {
+	_, closeEtcd := startEtcd(t)
+	defer closeEtcd()
+
+	reg := newTestRegistry(t)
+
+	watcher := newTestRegistry(t)
+	notifies := make(chan *registry.ServerInstance, 16)
+	err := watcher.Subscribe("tenured_store", func(serverInstances []*registry.ServerInstance) {
+		for _, si := range serverInstances {
+			notifies <- si
+		}
+	})
+	assert.Nil(t, err)
+
+	plugin, _ := NewRegistryPlugins(nil)
+	instance, _ := plugin.Instance(map[string]string{"ttl": "3s"})
+	assert.Equal(t, int64(3), instance.PluginAttrs.(*EtcdServerAttrs).LeaseTTL())
+	instance.Id = "1"
+	instance.Name = "tenured_store"
+	instance.Address = "127.0.0.1:6072"
+	instance.Tags = []string{"account", "user"}
+	instance.Metadata = map[string]string{"external": "127.0.0.1:6072"}
+	assert.Nil(t, reg.Register(instance))
+	waitStatus(t, notifies, "1", registry.StatusOK)
+
+	instances, err := watcher.Lookup("tenured_store", []string{"account"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(instances))
+	assert.Equal(t, "127.0.0.1:6072", instances[0].Address)
+	assert.Equal(t, "127.0.0.1:6072", instances[0].Metadata["external"])
+
+	instances, err = watcher.Lookup("tenured_store", []string{"account", "search"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(instances))
+
+	//使用原来的租约更新状态
+	instance.Status = registry.StatusCritical
+	assert.Nil(t, reg.Register(instance))
+	waitStatus(t, notifies, "1", registry.StatusCritical)
+
+	assert.Nil(t, reg.Unregister("1"))
+	waitStatus(t, notifies, "1", registry.StatusDown)
+
+	instances, err = watcher.Lookup("tenured_store", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(instances))
+
+	//注册中心关闭后撤销租约，其他节点收到下线通知
+	instance.Status = registry.StatusOK
+	assert.Nil(t, reg.Register(instance))
+	waitStatus(t, notifies, "1", registry.StatusOK)
+	reg.(*EtcdServiceRegistry).Shutdown(true)
+	waitStatus(t, notifies, "1", registry.StatusDown)
+
+	watcher.(*EtcdServiceRegistry).Shutdown(true)
+}
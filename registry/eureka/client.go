@@ -0,0 +1,172 @@
+package eureka
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+type eurekaPort struct {
+	Port    int    `json:"$"`
+	Enabled string `json:"@enabled"`
+}
+
+type eurekaDataCenter struct {
+	Class string `json:"@class"`
+	Name  string `json:"name"`
+}
+
+type eurekaLease struct {
+	RenewalIntervalInSecs int `json:"renewalIntervalInSecs"`
+	DurationInSecs        int `json:"durationInSecs"`
+}
+
+//eureka实例信息，只保留用到的字段
+type eurekaInstance struct {
+	InstanceId     string            `json:"instanceId"`
+	HostName       string            `json:"hostName"`
+	App            string            `json:"app"`
+	IpAddr         string            `json:"ipAddr"`
+	Status         string            `json:"status"`
+	Port           eurekaPort        `json:"port"`
+	SecurePort     eurekaPort        `json:"securePort"`
+	VipAddress     string            `json:"vipAddress,omitempty"`
+	DataCenterInfo eurekaDataCenter  `json:"dataCenterInfo"`
+	LeaseInfo      *eurekaLease      `json:"leaseInfo,omitempty"`
+	Metadata       map[string]string `json:"metadata,omitempty"`
+	//增量数据中的变更类型：ADDED，MODIFIED，DELETED
+	ActionType string `json:"actionType,omitempty"`
+}
+
+type eurekaApplication struct {
+	Name      string            `json:"name"`
+	Instances []*eurekaInstance `json:"instance"`
+}
+
+type eurekaApplications struct {
+	AppsHashcode string               `json:"apps__hashcode"`
+	Applications []*eurekaApplication `json:"application"`
+}
+
+//eureka REST API客户端，请求失败时依次尝试下一个服务地址
+type eurekaClient struct {
+	urls     []string
+	username string
+	password string
+	client   *http.Client
+}
+
+func newEurekaClient(config *EurekaConfig) *eurekaClient {
+	return &eurekaClient{
+		urls:     config.ServiceUrls(),
+		username: config.Username(),
+		password: config.Password(),
+		client:   &http.Client{Timeout: config.RequestTimeout()},
+	}
+}
+
+func (this *eurekaClient) do(method, path string, body interface{}, out interface{}) (int, error) {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	var lastErr error
+	for _, url := range this.urls {
+		request, err := http.NewRequest(method, url+path, bytes.NewReader(data))
+		if err != nil {
+			return 0, err
+		}
+		request.Header.Set("Accept", "application/json")
+		if body != nil {
+			request.Header.Set("Content-Type", "application/json")
+		}
+		if this.username != "" {
+			request.SetBasicAuth(this.username, this.password)
+		}
+		response, err := this.client.Do(request)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if response.StatusCode >= http.StatusInternalServerError {
+			_ = response.Body.Close()
+			lastErr = fmt.Errorf("%s %s%s: %s", method, url, path, response.Status)
+			continue
+		}
+		if out != nil && response.StatusCode == http.StatusOK {
+			err = json.NewDecoder(response.Body).Decode(out)
+		}
+		_ = response.Body.Close()
+		return response.StatusCode, err
+	}
+	return 0, lastErr
+}
+
+func (this *eurekaClient) register(instance *eurekaInstance) error {
+	code, err := this.do(http.MethodPost, "/apps/"+instance.App, map[string]interface{}{"instance": instance}, nil)
+	if err == nil && code != http.StatusNoContent && code != http.StatusOK {
+		err = fmt.Errorf("register %s/%s: %d", instance.App, instance.InstanceId, code)
+	}
+	return err
+}
+
+//心跳续约，实例不存在时返回false
+func (this *eurekaClient) renew(app, instanceId string) (bool, error) {
+	code, err := this.do(http.MethodPut, "/apps/"+app+"/"+instanceId, nil, nil)
+	if err != nil {
+		return false, err
+	} else if code == http.StatusNotFound {
+		return false, nil
+	} else if code != http.StatusOK {
+		return false, fmt.Errorf("renew %s/%s: %d", app, instanceId, code)
+	}
+	return true, nil
+}
+
+func (this *eurekaClient) cancel(app, instanceId string) error {
+	code, err := this.do(http.MethodDelete, "/apps/"+app+"/"+instanceId, nil, nil)
+	if err == nil && code != http.StatusOK && code != http.StatusNotFound {
+		err = fmt.Errorf("cancel %s/%s: %d", app, instanceId, code)
+	}
+	return err
+}
+
+//获取单个应用，不存在时返回nil
+func (this *eurekaClient) application(app string) (*eurekaApplication, error) {
+	out := &struct {
+		Application *eurekaApplication `json:"application"`
+	}{}
+	code, err := this.do(http.MethodGet, "/apps/"+app, nil, out)
+	if err != nil {
+		return nil, err
+	} else if code == http.StatusNotFound {
+		return nil, nil
+	} else if code != http.StatusOK {
+		return nil, fmt.Errorf("get application %s: %d", app, code)
+	}
+	return out.Application, nil
+}
+
+func (this *eurekaClient) applications(path string) (*eurekaApplications, error) {
+	out := &struct {
+		Applications *eurekaApplications `json:"applications"`
+	}{}
+	code, err := this.do(http.MethodGet, path, nil, out)
+	if err != nil {
+		return nil, err
+	} else if code != http.StatusOK || out.Applications == nil {
+		return nil, fmt.Errorf("get %s: %d", path, code)
+	}
+	return out.Applications, nil
+}
+
+//全量获取服务列表
+func (this *eurekaClient) full() (*eurekaApplications, error) {
+	return this.applications("/apps")
+}
+
+//获取最近变化的服务实例
+func (this *eurekaClient) delta() (*eurekaApplications, error) {
+	return this.applications("/apps/delta")
+}
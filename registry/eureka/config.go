@@ -0,0 +1,44 @@
+package eureka
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/registry"
+)
+
+type EurekaConfig struct {
+	config *registry.PluginConfig
+}
+
+func (this *EurekaConfig) Scheme() string {
+	return this.config.Get("scheme", "http")
+}
+
+//eureka服务地址，eureka://host1:8761;host2:8761 ，请求失败时依次尝试下一个
+func (this *EurekaConfig) ServiceUrls() []string {
+	context := "/" + strings.Trim(this.config.Get("context", "/eureka"), "/")
+	urls := make([]string, len(this.config.Address))
+	for i, address := range this.config.Address {
+		urls[i] = this.Scheme() + "://" + address + context
+	}
+	return urls
+}
+
+//增量拉取服务列表的间隔
+func (this *EurekaConfig) FetchInterval() time.Duration {
+	return time.Second * time.Duration(this.config.GetInt("fetchInterval", 30))
+}
+
+func (this *EurekaConfig) RequestTimeout() time.Duration {
+	return time.Second * time.Duration(this.config.GetInt("requestTimeout", 3))
+}
+
+func (this *EurekaConfig) Username() string {
+	return this.config.User.Username()
+}
+
+func (this *EurekaConfig) Password() string {
+	password, _ := this.config.User.Password()
+	return password
+}
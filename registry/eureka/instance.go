@@ -0,0 +1,46 @@
+package eureka
+
+import (
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/registry"
+)
+
+type EurekaServerAttrs struct {
+	//心跳续约间隔
+	RenewalInterval string `json:"renewalInterval" yaml:"renewalInterval" attr:"renewalInterval"` //30s
+
+	//超过此时间没有续约，eureka将实例下线
+	Duration string `json:"duration" yaml:"duration" attr:"duration"` //90s
+
+	//eureka数据中心名称，MyOwn或者Amazon
+	DataCenter string `json:"dataCenter" yaml:"dataCenter" attr:"dataCenter"`
+}
+
+func (this *EurekaServerAttrs) Config(attrs map[string]string) {
+	registry.LoadModel(this, attrs)
+}
+
+func seconds(value string, def int) int {
+	if duration, err := time.ParseDuration(value); err != nil || duration < time.Second {
+		return def
+	} else {
+		return int(duration / time.Second)
+	}
+}
+
+func (this *EurekaServerAttrs) RenewalSeconds() int {
+	return seconds(this.RenewalInterval, 30)
+}
+
+func (this *EurekaServerAttrs) DurationSeconds() int {
+	return seconds(this.Duration, 90)
+}
+
+func newInstance() *EurekaServerAttrs {
+	return &EurekaServerAttrs{
+		RenewalInterval: "30s",
+		Duration:        "90s",
+		DataCenter:      "MyOwn",
+	}
+}
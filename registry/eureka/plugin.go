@@ -0,0 +1,57 @@
+package eureka
+
+import (
+	"sync"
+
+	"github.com/ihaiker/tenured-go-server/commons/logs"
+	"github.com/ihaiker/tenured-go-server/registry"
+	"github.com/sirupsen/logrus"
+)
+
+type EurekaRegistryPlugins struct {
+	lock     *sync.Mutex
+	registry registry.ServiceRegistry
+	config   *registry.PluginConfig
+}
+
+func (this *EurekaRegistryPlugins) Instance(config map[string]string) (*registry.ServerInstance, error) {
+	sInstance := &registry.ServerInstance{}
+
+	eurekaAttr := newInstance()
+	eurekaAttr.Config(config)
+	sInstance.PluginAttrs = eurekaAttr
+
+	return sInstance, nil
+}
+
+func (this *EurekaRegistryPlugins) Registry() (registry.ServiceRegistry, error) {
+	if this.registry != nil {
+		return this.registry, nil
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.registry != nil {
+		return this.registry, nil
+	}
+
+	if reg, err := newRegistry(this.config); err != nil {
+		return nil, err
+	} else {
+		this.registry = reg
+		return reg, nil
+	}
+}
+
+var logger *logrus.Logger
+
+func init() {
+	logger = logs.GetLogger("eureka")
+}
+
+func NewRegistryPlugins(config *registry.PluginConfig) (registry.Plugins, error) {
+	return &EurekaRegistryPlugins{
+		lock:   new(sync.Mutex),
+		config: config,
+	}, nil
+}
@@ -0,0 +1,473 @@
+package eureka
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/registry"
+)
+
+const (
+	eurekaStatusUp           = "UP"
+	eurekaStatusDown         = "DOWN"
+	eurekaStatusOutOfService = "OUT_OF_SERVICE"
+
+	eurekaActionAdded    = "ADDED"
+	eurekaActionModified = "MODIFIED"
+	eurekaActionDeleted  = "DELETED"
+
+	//eureka没有标签，标签使用逗号分隔保存在metadata中
+	metadataTags = "tenuredTags"
+)
+
+//服务注册监听者
+type subscriber struct {
+	listeners map[string]registry.RegistryNotifyListener
+	services  map[string]*registry.ServerInstance
+}
+
+//本节点注册的服务，定时心跳续约
+type registration struct {
+	instance  *eurekaInstance
+	interval  time.Duration
+	closeChan chan struct{}
+}
+
+type EurekaServiceRegistry struct {
+	lock   *sync.Mutex
+	client *eurekaClient
+	config *EurekaConfig
+	//订阅信息，key为服务名称
+	subscribes map[string]*subscriber
+	//本节点注册的服务
+	registrations map[string]*registration
+
+	//本地服务列表，app -> instanceId -> instance，只在拉取协程中访问
+	apps map[string]map[string]*eurekaInstance
+	//新增订阅时立即拉取一次
+	refresh   chan struct{}
+	fetching  bool
+	closeChan chan struct{}
+}
+
+func appName(serverName string) string {
+	return strings.ToUpper(serverName)
+}
+
+func (this *EurekaServiceRegistry) Start() error {
+	return nil
+}
+
+func (this *EurekaServiceRegistry) Shutdown(interrupt bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	select {
+	case <-this.closeChan:
+		return
+	default:
+		close(this.closeChan)
+	}
+	for id, reg := range this.registrations {
+		close(reg.closeChan)
+		delete(this.registrations, id)
+		if err := this.client.cancel(reg.instance.App, reg.instance.InstanceId); err != nil {
+			logger.Warnf("cancel %s error: %s", id, err)
+		}
+	}
+}
+
+func (this *EurekaServiceRegistry) convertInstance(serverInstance *registry.ServerInstance) (*eurekaInstance, error) {
+	host, portStr, err := net.SplitHostPort(serverInstance.Address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+	attrs, match := serverInstance.PluginAttrs.(*EurekaServerAttrs)
+	if !match {
+		attrs = newInstance()
+	}
+
+	status := eurekaStatusUp
+	if serverInstance.Status == registry.StatusCritical {
+		status = eurekaStatusOutOfService
+	} else if serverInstance.Status == registry.StatusDown {
+		status = eurekaStatusDown
+	}
+	metadata := map[string]string{}
+	for key, value := range serverInstance.Metadata {
+		metadata[key] = value
+	}
+	if len(serverInstance.Tags) > 0 {
+		metadata[metadataTags] = strings.Join(serverInstance.Tags, ",")
+	}
+	hostName := host
+	if name, has := serverInstance.Metadata[registry.MetadataHostname]; has && name != "" {
+		hostName = name
+	}
+	dataCenter := eurekaDataCenter{Class: "com.netflix.appinfo.InstanceInfo$DefaultDataCenterInfo", Name: attrs.DataCenter}
+	if attrs.DataCenter == "Amazon" {
+		dataCenter.Class = "com.netflix.appinfo.AmazonInfo"
+	}
+	return &eurekaInstance{
+		InstanceId: serverInstance.Id,
+		HostName:   hostName,
+		App:        appName(serverInstance.Name),
+		IpAddr:     host,
+		Status:     status,
+		Port:       eurekaPort{Port: port, Enabled: "true"},
+		SecurePort: eurekaPort{Port: 443, Enabled: "false"},
+		VipAddress: serverInstance.Name,
+		LeaseInfo: &eurekaLease{
+			RenewalIntervalInSecs: attrs.RenewalSeconds(), DurationInSecs: attrs.DurationSeconds(),
+		},
+		DataCenterInfo: dataCenter,
+		Metadata:       metadata,
+	}, nil
+}
+
+func (this *EurekaServiceRegistry) convertService(serverName string, instance *eurekaInstance) *registry.ServerInstance {
+	status := registry.StatusCritical
+	if instance.Status == eurekaStatusUp {
+		status = registry.StatusOK
+	}
+	metadata := map[string]string{}
+	var tags []string
+	for key, value := range instance.Metadata {
+		if key == metadataTags {
+			tags = strings.Split(value, ",")
+		} else {
+			metadata[key] = value
+		}
+	}
+	return &registry.ServerInstance{
+		Id:       instance.InstanceId,
+		Name:     serverName,
+		Metadata: metadata,
+		Address:  net.JoinHostPort(instance.IpAddr, strconv.Itoa(instance.Port.Port)),
+		Tags:     tags,
+		Status:   status,
+	}
+}
+
+func (this *EurekaServiceRegistry) Register(serverInstance *registry.ServerInstance) error {
+	logger.Infof("register %s(%s) : %s", serverInstance.Name, serverInstance.Address, serverInstance.Id)
+	instance, err := this.convertInstance(serverInstance)
+	if err != nil {
+		return err
+	}
+	if err := this.client.register(instance); err != nil {
+		return err
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if reg, has := this.registrations[serverInstance.Id]; has {
+		reg.instance = instance
+		return nil
+	}
+	reg := &registration{
+		instance:  instance,
+		interval:  time.Second * time.Duration(instance.LeaseInfo.RenewalIntervalInSecs),
+		closeChan: make(chan struct{}),
+	}
+	this.registrations[serverInstance.Id] = reg
+	go this.renew(reg)
+	return nil
+}
+
+//心跳续约，eureka返回实例不存在时（eureka重启或者续约超时被剔除）重新注册
+func (this *EurekaServiceRegistry) renew(reg *registration) {
+	ticker := time.NewTicker(reg.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-reg.closeChan:
+			return
+		case <-ticker.C:
+			this.lock.Lock()
+			instance := reg.instance
+			this.lock.Unlock()
+
+			if found, err := this.client.renew(instance.App, instance.InstanceId); err != nil {
+				logger.Warnf("renew %s error: %s", instance.InstanceId, err)
+			} else if !found {
+				logger.Warnf("%s not found in eureka, register again", instance.InstanceId)
+				if err := this.client.register(instance); err != nil {
+					logger.Warnf("register %s error: %s", instance.InstanceId, err)
+				}
+			}
+		}
+	}
+}
+
+func (this *EurekaServiceRegistry) Unregister(serverId string) error {
+	logger.Info("unregister ", serverId)
+	this.lock.Lock()
+	reg, has := this.registrations[serverId]
+	if has {
+		close(reg.closeChan)
+		delete(this.registrations, serverId)
+	}
+	this.lock.Unlock()
+	if has {
+		return this.client.cancel(reg.instance.App, serverId)
+	}
+
+	//其他节点注册的服务，按照ID查找
+	apps, err := this.client.full()
+	if err != nil {
+		return err
+	}
+	for _, app := range apps.Applications {
+		for _, instance := range app.Instances {
+			if instance.InstanceId == serverId {
+				return this.client.cancel(app.Name, serverId)
+			}
+		}
+	}
+	return nil
+}
+
+func (this *EurekaServiceRegistry) notify(subscribe *subscriber, serverInstances []*registry.ServerInstance) {
+	this.lock.Lock()
+	listeners := make([]registry.RegistryNotifyListener, 0, len(subscribe.listeners))
+	for _, lis := range subscribe.listeners {
+		listeners = append(listeners, lis)
+	}
+	this.lock.Unlock()
+
+	for _, si := range serverInstances {
+		logger.Infof("notify registry name=%s, id=%s, status=%s", si.Name, si.Id, si.Status)
+	}
+	for _, lis := range listeners {
+		lis(serverInstances)
+	}
+}
+
+//按照eureka的规则计算服务列表的hashcode，例如：DOWN_1_UP_2_
+func appsHashcode(apps map[string]map[string]*eurekaInstance) string {
+	counts := map[string]int{}
+	for _, instances := range apps {
+		for _, instance := range instances {
+			counts[instance.Status]++
+		}
+	}
+	statuses := make([]string, 0, len(counts))
+	for status := range counts {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	hashcode := ""
+	for _, status := range statuses {
+		hashcode += fmt.Sprintf("%s_%d_", status, counts[status])
+	}
+	return hashcode
+}
+
+//拉取服务列表，第一次全量拉取，之后增量拉取。增量合并后hashcode不一致时重新全量拉取
+func (this *EurekaServiceRegistry) fetch() error {
+	if this.apps != nil {
+		if delta, err := this.client.delta(); err != nil {
+			return err
+		} else {
+			for _, app := range delta.Applications {
+				instances, has := this.apps[app.Name]
+				if !has {
+					instances = map[string]*eurekaInstance{}
+					this.apps[app.Name] = instances
+				}
+				for _, instance := range app.Instances {
+					switch instance.ActionType {
+					case eurekaActionDeleted:
+						delete(instances, instance.InstanceId)
+					case eurekaActionAdded, eurekaActionModified:
+						instances[instance.InstanceId] = instance
+					}
+				}
+				if len(instances) == 0 {
+					delete(this.apps, app.Name)
+				}
+			}
+			if appsHashcode(this.apps) == delta.AppsHashcode {
+				return nil
+			}
+			logger.Debugf("eureka delta hashcode %s mismatch %s, fetch full", delta.AppsHashcode, appsHashcode(this.apps))
+		}
+	}
+
+	full, err := this.client.full()
+	if err != nil {
+		return err
+	}
+	apps := map[string]map[string]*eurekaInstance{}
+	for _, app := range full.Applications {
+		instances := map[string]*eurekaInstance{}
+		for _, instance := range app.Instances {
+			instances[instance.InstanceId] = instance
+		}
+		apps[app.Name] = instances
+	}
+	this.apps = apps
+	return nil
+}
+
+//比较本地服务列表和订阅者已知的服务，通知变化
+func (this *EurekaServiceRegistry) notifySubscribes() {
+	this.lock.Lock()
+	subscribes := map[string]*subscriber{}
+	for name, subscribe := range this.subscribes {
+		subscribes[name] = subscribe
+	}
+	this.lock.Unlock()
+
+	for serverName, subscribe := range subscribes {
+		notifies := make([]*registry.ServerInstance, 0)
+		currentServices := map[string]*registry.ServerInstance{}
+		for _, instance := range this.apps[appName(serverName)] {
+			serverInstance := this.convertService(serverName, instance)
+			currentServices[serverInstance.Id] = serverInstance
+			if old, has := subscribe.services[serverInstance.Id]; !has || old.Status != serverInstance.Status {
+				notifies = append(notifies, serverInstance)
+			}
+		}
+		for _, serverInstance := range subscribe.services {
+			if _, has := currentServices[serverInstance.Id]; !has {
+				serverInstance.Status = registry.StatusDown
+				notifies = append(notifies, serverInstance)
+			}
+		}
+		subscribe.services = currentServices
+		if len(notifies) != 0 {
+			this.notify(subscribe, notifies)
+		}
+	}
+}
+
+func (this *EurekaServiceRegistry) fetchLoop() {
+	logger.Debug("start fetch eureka registry")
+	ticker := time.NewTicker(this.config.FetchInterval())
+	defer ticker.Stop()
+	for {
+		if err := this.fetch(); err != nil {
+			logger.Warn("load registry error: ", err)
+		} else {
+			this.notifySubscribes()
+		}
+		select {
+		case <-this.closeChan:
+			return
+		case <-this.refresh:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (this *EurekaServiceRegistry) Subscribe(serverName string, listener registry.RegistryNotifyListener) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	logger.Info("Subscribe ", serverName)
+	if this.addSubscribe(serverName, listener) {
+		if !this.fetching {
+			this.fetching = true
+			go this.fetchLoop()
+		} else {
+			select {
+			case this.refresh <- struct{}{}:
+			default:
+			}
+		}
+	}
+	return nil
+}
+
+func (this *EurekaServiceRegistry) Unsubscribe(serverName string, listener registry.RegistryNotifyListener) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	logger.Info("Unsubscribe ", serverName, ",fn ", listener)
+	if this.removeSubscribe(serverName, listener) {
+		delete(this.subscribes, serverName)
+	}
+	return nil
+}
+
+func (this *EurekaServiceRegistry) Lookup(serverName string, tags []string) ([]*registry.ServerInstance, error) {
+	app, err := this.client.application(appName(serverName))
+	if err != nil {
+		return nil, err
+	}
+	serverInstances := make([]*registry.ServerInstance, 0)
+	if app == nil {
+		return serverInstances, nil
+	}
+	for _, instance := range app.Instances {
+		if serverInstance := this.convertService(serverName, instance); hasAllTags(serverInstance, tags) {
+			serverInstances = append(serverInstances, serverInstance)
+		}
+	}
+	return serverInstances, nil
+}
+
+func hasAllTags(serverInstance *registry.ServerInstance, tags []string) bool {
+	for _, tag := range tags {
+		if !serverInstance.HasTag(tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func (this *EurekaServiceRegistry) getOrCreateSubscribe(name string) *subscriber {
+	if subInfo, has := this.subscribes[name]; !has {
+		subInfo = &subscriber{
+			listeners: map[string]registry.RegistryNotifyListener{},
+			services:  map[string]*registry.ServerInstance{},
+		}
+		this.subscribes[name] = subInfo
+	}
+	return this.subscribes[name]
+}
+
+//@return 返回是否是此服务的第一个监听器
+func (this *EurekaServiceRegistry) addSubscribe(name string, listener registry.RegistryNotifyListener) bool {
+	sets := this.getOrCreateSubscribe(name)
+	from := len(sets.listeners)
+	pointer := registry.NotifyPointer(listener)
+	sets.listeners[pointer] = listener
+	return from == 0 && len(sets.listeners) == 1
+}
+
+//@return 是否是次服务的最后一个监听器
+func (this *EurekaServiceRegistry) removeSubscribe(name string, listener registry.RegistryNotifyListener) bool {
+	if sets, has := this.subscribes[name]; has {
+		pointer := registry.NotifyPointer(listener)
+		from := len(sets.listeners)
+		delete(sets.listeners, pointer)
+		return from == 1 && len(sets.listeners) == 0
+	} else {
+		return false
+	}
+}
+
+func newRegistry(pluginConfig *registry.PluginConfig) (*EurekaServiceRegistry, error) {
+	config := &EurekaConfig{config: pluginConfig}
+	return &EurekaServiceRegistry{
+		lock:          new(sync.Mutex),
+		config:        config,
+		client:        newEurekaClient(config),
+		subscribes:    map[string]*subscriber{},
+		registrations: map[string]*registration{},
+		refresh:       make(chan struct{}, 1),
+		closeChan:     make(chan struct{}),
+	}, nil
+}
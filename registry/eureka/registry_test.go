@@ -0,0 +1,240 @@
+package eureka
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+//模拟eureka的REST接口
+type fakeEureka struct {
+	lock   sync.Mutex
+	apps   map[string]map[string]*eurekaInstance
+	recent []*eurekaInstance
+	renews map[string]int
+	deltas int
+}
+
+func newFakeEureka() *fakeEureka {
+	return &fakeEureka{
+		apps:   map[string]map[string]*eurekaInstance{},
+		renews: map[string]int{},
+	}
+}
+
+func (this *fakeEureka) applications(apps map[string]map[string]*eurekaInstance) []*eurekaApplication {
+	applications := make([]*eurekaApplication, 0)
+	for name, instances := range apps {
+		app := &eurekaApplication{Name: name}
+		for _, instance := range instances {
+			app.Instances = append(app.Instances, instance)
+		}
+		applications = append(applications, app)
+	}
+	return applications
+}
+
+func (this *fakeEureka) change(instance *eurekaInstance, action string) {
+	changed := *instance
+	changed.ActionType = action
+	this.recent = append(this.recent, &changed)
+}
+
+//直接删除实例，模拟实例续约超时被剔除
+func (this *fakeEureka) evict(app, id string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	delete(this.apps[app], id)
+}
+
+//直接添加实例，不产生增量数据，模拟增量丢失
+func (this *fakeEureka) put(instance *eurekaInstance) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.apps[instance.App][instance.InstanceId] = instance
+}
+
+func (this *fakeEureka) get(app, id string) *eurekaInstance {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.apps[app][id]
+}
+
+func (this *fakeEureka) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	paths := strings.Split(strings.TrimPrefix(r.URL.Path, "/eureka/apps"), "/")
+	write := func(out interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+
+	switch {
+	case r.Method == http.MethodGet && len(paths) == 1:
+		write(map[string]interface{}{"applications": &eurekaApplications{
+			AppsHashcode: appsHashcode(this.apps), Applications: this.applications(this.apps),
+		}})
+	case r.Method == http.MethodGet && paths[1] == "delta":
+		this.deltas++
+		delta := map[string]map[string]*eurekaInstance{}
+		for _, instance := range this.recent {
+			if _, has := delta[instance.App]; !has {
+				delta[instance.App] = map[string]*eurekaInstance{}
+			}
+			delta[instance.App][instance.InstanceId] = instance
+		}
+		this.recent = nil
+		write(map[string]interface{}{"applications": &eurekaApplications{
+			AppsHashcode: appsHashcode(this.apps), Applications: this.applications(delta),
+		}})
+	case r.Method == http.MethodGet && len(paths) == 2:
+		if instances, has := this.apps[paths[1]]; !has {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			write(map[string]interface{}{"application": this.applications(map[string]map[string]*eurekaInstance{paths[1]: instances})[0]})
+		}
+	case r.Method == http.MethodPost && len(paths) == 2:
+		body := &struct {
+			Instance *eurekaInstance `json:"instance"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(body); err != nil || body.Instance.App != paths[1] {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if _, has := this.apps[paths[1]]; !has {
+			this.apps[paths[1]] = map[string]*eurekaInstance{}
+		}
+		action := eurekaActionAdded
+		if _, has := this.apps[paths[1]][body.Instance.InstanceId]; has {
+			action = eurekaActionModified
+		}
+		this.apps[paths[1]][body.Instance.InstanceId] = body.Instance
+		this.change(body.Instance, action)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut && len(paths) == 3:
+		if _, has := this.apps[paths[1]][paths[2]]; !has {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		this.renews[paths[2]]++
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodDelete && len(paths) == 3:
+		if instance, has := this.apps[paths[1]][paths[2]]; !has {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			delete(this.apps[paths[1]], paths[2])
+			if len(this.apps[paths[1]]) == 0 {
+				delete(this.apps, paths[1])
+			}
+			this.change(instance, eurekaActionDeleted)
+			w.WriteHeader(http.StatusOK)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func waitStatus(t *testing.T, notifies chan *registry.ServerInstance, id, status string) {
+	timeout := time.After(time.Second * 5)
+	for {
+		select {
+		case si := <-notifies:
+			if si.Id == id && si.Status == status {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("wait %s %s timeout", id, status)
+		}
+	}
+}
+
+func TestEurekaServiceRegistry(t *testing.T) {
+	eureka := newFakeEureka()
+	server := httptest.NewServer(eureka)
+	defer server.Close()
+
+	config, err := registry.ParseConfig("eureka://" + strings.TrimPrefix(server.URL, "http://") + "?fetchInterval=1")
+	assert.Nil(t, err)
+	plugin, err := NewRegistryPlugins(config)
+	assert.Nil(t, err)
+	reg, err := plugin.Registry()
+	assert.Nil(t, err)
+	defer reg.(*EurekaServiceRegistry).Shutdown(true)
+
+	instance, _ := plugin.Instance(map[string]string{"renewalInterval": "1s"})
+	instance.Id = "store-1"
+	instance.Name = "tenured_store"
+	instance.Address = "127.0.0.1:6072"
+	instance.Tags = []string{"account", "user"}
+	instance.Metadata = map[string]string{"external": "10.0.0.1:6072", registry.MetadataHostname: "store1"}
+	assert.Nil(t, reg.Register(instance))
+
+	saved := eureka.get("TENURED_STORE", "store-1")
+	assert.NotNil(t, saved)
+	assert.Equal(t, "store1", saved.HostName)
+	assert.Equal(t, eurekaStatusUp, saved.Status)
+	assert.Equal(t, 1, saved.LeaseInfo.RenewalIntervalInSecs)
+
+	instances, err := reg.Lookup("tenured_store", []string{"user"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(instances))
+	assert.Equal(t, "127.0.0.1:6072", instances[0].Address)
+	assert.Equal(t, instance.Metadata, instances[0].Metadata)
+	assert.Equal(t, instance.Tags, instances[0].Tags)
+	assert.Equal(t, registry.StatusOK, instances[0].Status)
+
+	instances, err = reg.Lookup("tenured_store", []string{"search"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(instances))
+
+	notifies := make(chan *registry.ServerInstance, 16)
+	assert.Nil(t, reg.Subscribe("tenured_store", func(serverInstances []*registry.ServerInstance) {
+		for _, si := range serverInstances {
+			notifies <- si
+		}
+	}))
+	waitStatus(t, notifies, "store-1", registry.StatusOK)
+
+	//增量拉取新注册的实例
+	second, _ := plugin.Instance(nil)
+	second.Id = "store-2"
+	second.Name = "tenured_store"
+	second.Address = "127.0.0.2:6072"
+	assert.Nil(t, reg.Register(second))
+	waitStatus(t, notifies, "store-2", registry.StatusOK)
+
+	second.Status = registry.StatusCritical
+	assert.Nil(t, reg.Register(second))
+	waitStatus(t, notifies, "store-2", registry.StatusCritical)
+
+	assert.Nil(t, reg.Unregister("store-2"))
+	waitStatus(t, notifies, "store-2", registry.StatusDown)
+
+	eureka.lock.Lock()
+	assert.True(t, eureka.renews["store-1"] > 0)
+	assert.True(t, eureka.deltas > 0)
+	eureka.lock.Unlock()
+
+	//增量合并后hashcode不一致，全量拉取
+	eureka.put(&eurekaInstance{
+		InstanceId: "store-3", App: "TENURED_STORE", IpAddr: "127.0.0.3", Status: eurekaStatusUp,
+		Port: eurekaPort{Port: 6072, Enabled: "true"},
+	})
+	waitStatus(t, notifies, "store-3", registry.StatusOK)
+
+	//实例被剔除，续约时发现实例不存在重新注册
+	eureka.evict("TENURED_STORE", "store-1")
+	timeout := time.Now().Add(time.Second * 5)
+	for eureka.get("TENURED_STORE", "store-1") == nil && time.Now().Before(timeout) {
+		time.Sleep(time.Millisecond * 100)
+	}
+	assert.NotNil(t, eureka.get("TENURED_STORE", "store-1"))
+}
@@ -0,0 +1,48 @@
+package file
+
+import (
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/registry"
+)
+
+type FileConfig struct {
+	config *registry.PluginConfig
+}
+
+//注册文件路径，file:///data/registry.json 为绝对路径，file://registry.json 为相对路径
+func (this *FileConfig) Path() string {
+	path := this.config.Path
+	if len(this.config.Address) > 0 && this.config.Address[0] != "" {
+		path = this.config.Address[0] + path
+	}
+	if path == "" {
+		path = "registry.json"
+	}
+	return path
+}
+
+//心跳间隔，定时更新本节点注册服务的心跳时间
+func (this *FileConfig) Heartbeat() time.Duration {
+	return time.Second * time.Duration(this.config.GetInt("heartbeat", 5))
+}
+
+//超过此时间没有心跳，服务状态为CRITICAL
+func (this *FileConfig) TTL() time.Duration {
+	return time.Second * time.Duration(this.config.GetInt("ttl", 15))
+}
+
+//超过此时间没有心跳，从文件中删除服务
+func (this *FileConfig) Deregister() time.Duration {
+	return time.Second * time.Duration(this.config.GetInt("deregister", 60))
+}
+
+//检查文件变化的间隔
+func (this *FileConfig) WatchInterval() time.Duration {
+	return time.Millisecond * time.Duration(this.config.GetInt("watchInterval", 1000))
+}
+
+//文件锁超过此时间没有释放，认为持有锁的进程已经退出
+func (this *FileConfig) LockTimeout() time.Duration {
+	return time.Second * time.Duration(this.config.GetInt("lockTimeout", 10))
+}
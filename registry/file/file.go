@@ -0,0 +1,147 @@
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+//文件中保存的服务实例
+type fileInstance struct {
+	Id       string            `json:"id"`
+	Name     string            `json:"name"`
+	Address  string            `json:"address"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Tags     []string          `json:"tags,omitempty"`
+	Status   string            `json:"status"`
+	//最后心跳时间，单位毫秒
+	Heartbeat int64 `json:"heartbeat"`
+}
+
+//服务列表，name -> id -> instance
+type fileServices map[string]map[string]*fileInstance
+
+func (this fileServices) remove(serverId string) {
+	for name, instances := range this {
+		delete(instances, serverId)
+		if len(instances) == 0 {
+			delete(this, name)
+		}
+	}
+}
+
+func (this fileServices) put(instance *fileInstance) {
+	this.remove(instance.Id)
+	if _, has := this[instance.Name]; !has {
+		this[instance.Name] = map[string]*fileInstance{}
+	}
+	this[instance.Name][instance.Id] = instance
+}
+
+func now() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+//注册文件，多个进程通过锁文件互斥修改，写入临时文件后改名保证读取时文件完整
+type registryFile struct {
+	path        string
+	lockTimeout time.Duration
+}
+
+func (this *registryFile) read() (fileServices, error) {
+	services := fileServices{}
+	if data, err := ioutil.ReadFile(this.path); err != nil {
+		if os.IsNotExist(err) {
+			return services, nil
+		}
+		return nil, err
+	} else if len(data) == 0 {
+		return services, nil
+	} else if err := json.Unmarshal(data, &services); err != nil {
+		return nil, err
+	}
+	return services, nil
+}
+
+func (this *registryFile) write(services fileServices) error {
+	data, err := json.MarshalIndent(services, "", "  ")
+	if err != nil {
+		return err
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(this.path), filepath.Base(this.path)+".")
+	if err != nil {
+		return err
+	}
+	if _, err = temp.Write(data); err != nil {
+		_ = temp.Close()
+		_ = os.Remove(temp.Name())
+		return err
+	}
+	if err = temp.Close(); err != nil {
+		_ = os.Remove(temp.Name())
+		return err
+	}
+	if err = os.Rename(temp.Name(), this.path); err != nil {
+		_ = os.Remove(temp.Name())
+	}
+	return err
+}
+
+//获取文件锁，锁文件超时未释放时认为持有锁的进程已经退出，删除后重新获取
+func (this *registryFile) lock() (func(), error) {
+	lockFile := this.path + ".lock"
+	deadline := time.Now().Add(this.lockTimeout)
+	for {
+		if fd, err := os.OpenFile(lockFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644); err == nil {
+			_, _ = fd.WriteString(strconv.Itoa(os.Getpid()))
+			_ = fd.Close()
+			return func() {
+				_ = os.Remove(lockFile)
+			}, nil
+		} else if !os.IsExist(err) {
+			return nil, err
+		}
+		if stat, err := os.Stat(lockFile); err == nil && time.Since(stat.ModTime()) > this.lockTimeout {
+			logger.Warn("remove expired lock file: ", lockFile)
+			_ = os.Remove(lockFile)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("lock registry file timeout: " + lockFile)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+//加锁修改文件内容，同时删除超过deregister时间没有心跳的服务
+func (this *registryFile) update(deregister time.Duration, fn func(services fileServices)) error {
+	if err := os.MkdirAll(filepath.Dir(this.path), 0755); err != nil {
+		return err
+	}
+	unlock, err := this.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	services, err := this.read()
+	if err != nil {
+		return err
+	}
+	fn(services)
+
+	expired := now() - int64(deregister/time.Millisecond)
+	for _, instances := range services {
+		for id, instance := range instances {
+			if instance.Heartbeat < expired {
+				logger.Infof("remove expired service %s(%s) : %s", instance.Name, instance.Address, id)
+				services.remove(id)
+			}
+		}
+	}
+	return this.write(services)
+}
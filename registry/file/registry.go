@@ -0,0 +1,367 @@
+package file
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/registry"
+)
+
+//服务注册监听者
+type subscriber struct {
+	listeners map[string]registry.RegistryNotifyListener
+	services  map[string]*registry.ServerInstance
+}
+
+//基于本地文件的注册中心，本机的多个进程通过同一个文件共享服务列表，用于本地开发和测试。
+//本节点注册的服务定时更新心跳时间，订阅者定时检查文件内容的变化。
+type FileServiceRegistry struct {
+	lock   *sync.Mutex
+	config *FileConfig
+	file   *registryFile
+	//订阅信息，key为服务名称
+	subscribes map[string]*subscriber
+	//本节点注册的服务
+	registrations map[string]*fileInstance
+
+	//注册和订阅变化时立即检查一次
+	refresh      chan struct{}
+	watching     bool
+	heartbeating bool
+	closeChan    chan struct{}
+}
+
+func (this *FileServiceRegistry) Start() error {
+	return nil
+}
+
+//关闭时删除本节点注册的服务，其他订阅者收到下线通知
+func (this *FileServiceRegistry) Shutdown(interrupt bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	select {
+	case <-this.closeChan:
+		return
+	default:
+		close(this.closeChan)
+	}
+	registrations := this.registrations
+	this.registrations = map[string]*fileInstance{}
+	if len(registrations) == 0 {
+		return
+	}
+	if err := this.file.update(this.config.Deregister(), func(services fileServices) {
+		for serverId := range registrations {
+			services.remove(serverId)
+		}
+	}); err != nil {
+		logger.Warn("remove registrations error: ", err)
+	}
+}
+
+func (this *FileServiceRegistry) convertInstance(serverInstance *registry.ServerInstance) *fileInstance {
+	instance := &fileInstance{
+		Id:        serverInstance.Id,
+		Name:      serverInstance.Name,
+		Address:   serverInstance.Address,
+		Metadata:  map[string]string{},
+		Status:    serverInstance.Status,
+		Heartbeat: now(),
+	}
+	for key, value := range serverInstance.Metadata {
+		instance.Metadata[key] = value
+	}
+	if serverInstance.Tags != nil {
+		instance.Tags = append([]string{}, serverInstance.Tags...)
+	}
+	if instance.Status == "" {
+		instance.Status = registry.StatusOK
+	}
+	return instance
+}
+
+//超过ttl时间没有心跳的服务状态为CRITICAL
+func (this *FileServiceRegistry) convertService(instance *fileInstance, current int64) *registry.ServerInstance {
+	status := instance.Status
+	if status == registry.StatusOK && current-instance.Heartbeat > int64(this.config.TTL()/time.Millisecond) {
+		status = registry.StatusCritical
+	}
+	metadata := map[string]string{}
+	for key, value := range instance.Metadata {
+		metadata[key] = value
+	}
+	return &registry.ServerInstance{
+		Id:       instance.Id,
+		Name:     instance.Name,
+		Metadata: metadata,
+		Address:  instance.Address,
+		Tags:     instance.Tags,
+		Status:   status,
+	}
+}
+
+//读取服务的有效实例，超过deregister时间没有心跳的服务认为已经下线
+func (this *FileServiceRegistry) services(services fileServices, serverName string) []*registry.ServerInstance {
+	current := now()
+	expired := current - int64(this.config.Deregister()/time.Millisecond)
+	serverInstances := make([]*registry.ServerInstance, 0)
+	for _, instance := range services[serverName] {
+		if instance.Heartbeat >= expired {
+			serverInstances = append(serverInstances, this.convertService(instance, current))
+		}
+	}
+	return serverInstances
+}
+
+func (this *FileServiceRegistry) Register(serverInstance *registry.ServerInstance) error {
+	logger.Infof("register %s(%s) : %s", serverInstance.Name, serverInstance.Address, serverInstance.Id)
+	instance := this.convertInstance(serverInstance)
+	if err := this.file.update(this.config.Deregister(), func(services fileServices) {
+		services.put(instance)
+	}); err != nil {
+		return err
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.registrations[instance.Id] = instance
+	if !this.heartbeating {
+		this.heartbeating = true
+		go this.heartbeat()
+	}
+	this.notifyRefresh()
+	return nil
+}
+
+//定时更新本节点注册服务的心跳时间，服务被删除时（超时或者文件被删除）重新写入
+func (this *FileServiceRegistry) heartbeat() {
+	ticker := time.NewTicker(this.config.Heartbeat())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-this.closeChan:
+			return
+		case <-ticker.C:
+			this.lock.Lock()
+			registrations := make([]*fileInstance, 0, len(this.registrations))
+			for _, instance := range this.registrations {
+				registrations = append(registrations, instance)
+			}
+			this.lock.Unlock()
+			if len(registrations) == 0 {
+				continue
+			}
+
+			if err := this.file.update(this.config.Deregister(), func(services fileServices) {
+				current := now()
+				for _, registration := range registrations {
+					instance := *registration
+					instance.Heartbeat = current
+					if saved, has := services[instance.Name][instance.Id]; has {
+						instance.Status = saved.Status
+					} else {
+						logger.Warnf("%s not found in registry file, register again", instance.Id)
+					}
+					services.put(&instance)
+				}
+			}); err != nil {
+				logger.Warn("heartbeat error: ", err)
+			}
+		}
+	}
+}
+
+func (this *FileServiceRegistry) Unregister(serverId string) error {
+	logger.Info("unregister ", serverId)
+	this.lock.Lock()
+	delete(this.registrations, serverId)
+	this.lock.Unlock()
+
+	if err := this.file.update(this.config.Deregister(), func(services fileServices) {
+		services.remove(serverId)
+	}); err != nil {
+		return err
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.notifyRefresh()
+	return nil
+}
+
+func (this *FileServiceRegistry) notify(subscribe *subscriber, serverInstances []*registry.ServerInstance) {
+	this.lock.Lock()
+	listeners := make([]registry.RegistryNotifyListener, 0, len(subscribe.listeners))
+	for _, lis := range subscribe.listeners {
+		listeners = append(listeners, lis)
+	}
+	this.lock.Unlock()
+
+	for _, si := range serverInstances {
+		logger.Infof("notify registry name=%s, id=%s, status=%s", si.Name, si.Id, si.Status)
+	}
+	for _, lis := range listeners {
+		lis(serverInstances)
+	}
+}
+
+//比较文件中的服务列表和订阅者已知的服务，通知变化
+func (this *FileServiceRegistry) notifySubscribes(services fileServices) {
+	this.lock.Lock()
+	subscribes := map[string]*subscriber{}
+	for name, subscribe := range this.subscribes {
+		subscribes[name] = subscribe
+	}
+	this.lock.Unlock()
+
+	for serverName, subscribe := range subscribes {
+		notifies := make([]*registry.ServerInstance, 0)
+		currentServices := map[string]*registry.ServerInstance{}
+		for _, serverInstance := range this.services(services, serverName) {
+			currentServices[serverInstance.Id] = serverInstance
+			if old, has := subscribe.services[serverInstance.Id]; !has || old.Status != serverInstance.Status {
+				notifies = append(notifies, serverInstance)
+			}
+		}
+		for _, serverInstance := range subscribe.services {
+			if _, has := currentServices[serverInstance.Id]; !has {
+				//已经通知过的实例可能仍被监听器持有，使用副本通知下线
+				down := *serverInstance
+				down.Status = registry.StatusDown
+				notifies = append(notifies, &down)
+			}
+		}
+		subscribe.services = currentServices
+		if len(notifies) != 0 {
+			this.notify(subscribe, notifies)
+		}
+	}
+}
+
+//定时读取注册文件，心跳超时的状态变化也需要定时检查，所以此处没有监听文件事件
+func (this *FileServiceRegistry) watch() {
+	logger.Debug("start watch registry file: ", this.file.path)
+	ticker := time.NewTicker(this.config.WatchInterval())
+	defer ticker.Stop()
+	for {
+		if services, err := this.file.read(); err != nil {
+			logger.Warn("read registry file error: ", err)
+		} else {
+			this.notifySubscribes(services)
+		}
+		select {
+		case <-this.closeChan:
+			return
+		case <-this.refresh:
+		case <-ticker.C:
+		}
+	}
+}
+
+//通知监听协程立即检查，调用者需持有锁
+func (this *FileServiceRegistry) notifyRefresh() {
+	if this.watching {
+		select {
+		case this.refresh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (this *FileServiceRegistry) Subscribe(serverName string, listener registry.RegistryNotifyListener) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	logger.Info("Subscribe ", serverName)
+	if this.addSubscribe(serverName, listener) {
+		if !this.watching {
+			this.watching = true
+			go this.watch()
+		} else {
+			this.notifyRefresh()
+		}
+	}
+	return nil
+}
+
+func (this *FileServiceRegistry) Unsubscribe(serverName string, listener registry.RegistryNotifyListener) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	logger.Info("Unsubscribe ", serverName, ",fn ", listener)
+	if this.removeSubscribe(serverName, listener) {
+		delete(this.subscribes, serverName)
+	}
+	return nil
+}
+
+func (this *FileServiceRegistry) Lookup(serverName string, tags []string) ([]*registry.ServerInstance, error) {
+	services, err := this.file.read()
+	if err != nil {
+		return nil, err
+	}
+	serverInstances := make([]*registry.ServerInstance, 0)
+	for _, serverInstance := range this.services(services, serverName) {
+		if hasAllTags(serverInstance, tags) {
+			serverInstances = append(serverInstances, serverInstance)
+		}
+	}
+	return serverInstances, nil
+}
+
+func hasAllTags(serverInstance *registry.ServerInstance, tags []string) bool {
+	for _, tag := range tags {
+		if !serverInstance.HasTag(tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func (this *FileServiceRegistry) getOrCreateSubscribe(name string) *subscriber {
+	if subInfo, has := this.subscribes[name]; !has {
+		subInfo = &subscriber{
+			listeners: map[string]registry.RegistryNotifyListener{},
+			services:  map[string]*registry.ServerInstance{},
+		}
+		this.subscribes[name] = subInfo
+	}
+	return this.subscribes[name]
+}
+
+//@return 返回是否是此服务的第一个监听器
+func (this *FileServiceRegistry) addSubscribe(name string, listener registry.RegistryNotifyListener) bool {
+	sets := this.getOrCreateSubscribe(name)
+	from := len(sets.listeners)
+	pointer := registry.NotifyPointer(listener)
+	sets.listeners[pointer] = listener
+	return from == 0 && len(sets.listeners) == 1
+}
+
+//@return 是否是次服务的最后一个监听器
+func (this *FileServiceRegistry) removeSubscribe(name string, listener registry.RegistryNotifyListener) bool {
+	if sets, has := this.subscribes[name]; has {
+		pointer := registry.NotifyPointer(listener)
+		from := len(sets.listeners)
+		delete(sets.listeners, pointer)
+		return from == 1 && len(sets.listeners) == 0
+	} else {
+		return false
+	}
+}
+
+func newRegistry(pluginConfig *registry.PluginConfig) (*FileServiceRegistry, error) {
+	config := &FileConfig{config: pluginConfig}
+	return &FileServiceRegistry{
+		lock:   new(sync.Mutex),
+		config: config,
+		file: &registryFile{
+			path: config.Path(), lockTimeout: config.LockTimeout(),
+		},
+		subscribes:    map[string]*subscriber{},
+		registrations: map[string]*fileInstance{},
+		refresh:       make(chan struct{}, 1),
+		closeChan:     make(chan struct{}),
+	}, nil
+}
@@ -0,0 +1,141 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRegistry(t *testing.T, path, params string) *FileServiceRegistry {
+	config, err := registry.ParseConfig("file://" + path + "?" + params)
+	assert.Nil(t, err)
+	plugin, err := NewRegistryPlugins(config)
+	assert.Nil(t, err)
+	reg, err := plugin.Registry()
+	assert.Nil(t, err)
+	return reg.(*FileServiceRegistry)
+}
+
+func waitStatus(t *testing.T, notifies chan *registry.ServerInstance, id, status string) {
+	timeout := time.After(time.Second * 5)
+	for {
+		select {
+		case si := <-notifies:
+			if si.Id == id && si.Status == status {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("wait %s %s timeout", id, status)
+		}
+	}
+}
+
+func TestFileConfig_Path(t *testing.T) {
+	config, _ := registry.ParseConfig("file:///data/registry.json")
+	assert.Equal(t, "/data/registry.json", (&FileConfig{config: config}).Path())
+
+	config, _ = registry.ParseConfig("file://conf/registry.json")
+	assert.Equal(t, "conf/registry.json", (&FileConfig{config: config}).Path())
+
+	config, _ = registry.ParseConfig("file://")
+	assert.Equal(t, "registry.json", (&FileConfig{config: config}).Path())
+}
+
+func TestFileServiceRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	assert.Nil(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "registry.json")
+
+	reg := newTestRegistry(t, path, "heartbeat=1&ttl=2&deregister=3&watchInterval=100")
+	watcher := newTestRegistry(t, path, "heartbeat=1&ttl=2&deregister=3&watchInterval=100")
+	defer watcher.Shutdown(true)
+
+	notifies := make(chan *registry.ServerInstance, 16)
+	assert.Nil(t, watcher.Subscribe("tenured_store", func(serverInstances []*registry.ServerInstance) {
+		for _, si := range serverInstances {
+			notifies <- si
+		}
+	}))
+
+	instance := &registry.ServerInstance{
+		Id: "1", Name: "tenured_store", Address: "127.0.0.1:6072",
+		Tags: []string{"account", "user"}, Metadata: map[string]string{"external": "127.0.0.1:6072"},
+	}
+	assert.Nil(t, reg.Register(instance))
+	waitStatus(t, notifies, "1", registry.StatusOK)
+
+	instances, err := watcher.Lookup("tenured_store", []string{"account"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(instances))
+	assert.Equal(t, "127.0.0.1:6072", instances[0].Address)
+	assert.Equal(t, "127.0.0.1:6072", instances[0].Metadata["external"])
+
+	instances, err = watcher.Lookup("tenured_store", []string{"account", "search"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(instances))
+
+	instance.Status = registry.StatusCritical
+	assert.Nil(t, reg.Register(instance))
+	waitStatus(t, notifies, "1", registry.StatusCritical)
+
+	assert.Nil(t, reg.Unregister("1"))
+	waitStatus(t, notifies, "1", registry.StatusDown)
+
+	//注册中心关闭后删除注册的服务
+	instance.Status = registry.StatusOK
+	assert.Nil(t, reg.Register(instance))
+	waitStatus(t, notifies, "1", registry.StatusOK)
+	reg.Shutdown(true)
+	waitStatus(t, notifies, "1", registry.StatusDown)
+
+	_, err = os.Stat(path + ".lock")
+	assert.True(t, os.IsNotExist(err))
+}
+
+//进程异常退出没有心跳，超过ttl状态为CRITICAL，超过deregister下线
+func TestFileServiceRegistry_Heartbeat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	assert.Nil(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "registry.json")
+
+	watcher := newTestRegistry(t, path, "heartbeat=1&ttl=1&deregister=2&watchInterval=100")
+	defer watcher.Shutdown(true)
+	notifies := make(chan *registry.ServerInstance, 16)
+	assert.Nil(t, watcher.Subscribe("tenured_store", func(serverInstances []*registry.ServerInstance) {
+		for _, si := range serverInstances {
+			notifies <- si
+		}
+	}))
+
+	file := &registryFile{path: path, lockTimeout: time.Second}
+	assert.Nil(t, file.update(time.Minute, func(services fileServices) {
+		services.put(&fileInstance{
+			Id: "1", Name: "tenured_store", Address: "127.0.0.1:6072", Status: registry.StatusOK, Heartbeat: now(),
+		})
+	}))
+	waitStatus(t, notifies, "1", registry.StatusOK)
+	waitStatus(t, notifies, "1", registry.StatusCritical)
+	waitStatus(t, notifies, "1", registry.StatusDown)
+
+	//正常心跳的服务保持OK状态，文件被删除后重新写入
+	reg := newTestRegistry(t, path, "heartbeat=1&ttl=2&deregister=3&watchInterval=100")
+	defer reg.Shutdown(true)
+	assert.Nil(t, reg.Register(&registry.ServerInstance{Id: "2", Name: "tenured_store", Address: "127.0.0.2:6072"}))
+	waitStatus(t, notifies, "2", registry.StatusOK)
+	assert.Nil(t, os.Remove(path))
+	waitStatus(t, notifies, "2", registry.StatusDown)
+	waitStatus(t, notifies, "2", registry.StatusOK)
+
+	time.Sleep(time.Second * 3)
+	instances, err := reg.Lookup("tenured_store", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(instances))
+	assert.Equal(t, registry.StatusOK, instances[0].Status)
+}
@@ -0,0 +1,27 @@
+package registry
+
+//服务自身检查的健康状态
+const HealthPass = "pass" //正常
+const HealthWarn = "warn" //可以提供服务，但是需要关注，例如：执行队列快满了
+const HealthFail = "fail" //不能提供服务
+
+//注册中心支持服务主动上报健康状态（TTL检查）时实现此接口
+type HealthReporter interface {
+	UpdateHealth(serverInstance *ServerInstance, health, output string) error
+}
+
+//上报服务的健康状态，注册中心不支持上报时，状态变化后使用新的状态重新注册服务
+func ReportHealth(reg ServiceRegistry, serverInstance *ServerInstance, health, output string) error {
+	if reporter, match := reg.(HealthReporter); match {
+		return reporter.UpdateHealth(serverInstance, health, output)
+	}
+	status := StatusOK
+	if health == HealthFail {
+		status = StatusCritical
+	}
+	if serverInstance.Status == status || (serverInstance.Status == "" && status == StatusOK) {
+		return nil
+	}
+	serverInstance.Status = status
+	return reg.Register(serverInstance)
+}
@@ -0,0 +1,74 @@
+package health
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ihaiker/tenured-go-server/commons/breaker"
+	"github.com/ihaiker/tenured-go-server/commons/executors"
+	"github.com/ihaiker/tenured-go-server/registry"
+)
+
+//执行队列使用率检查，超过warn（百分比）为警告，超过fail为失败
+func Executors(manager executors.ExecutorManager, warn, fail int) Check {
+	return func() (string, string) {
+		status := registry.HealthPass
+		outputs := make([]string, 0)
+		for name, executor := range manager.All() {
+			queue, match := executor.(executors.ExecutorQueue)
+			if !match || queue.Capacity() == 0 {
+				continue
+			}
+			used := queue.Pending() * 100 / queue.Capacity()
+			if used >= fail {
+				status = registry.HealthFail
+			} else if used >= warn {
+				if status == registry.HealthPass {
+					status = registry.HealthWarn
+				}
+			} else {
+				continue
+			}
+			outputs = append(outputs, fmt.Sprintf("%s %d%% used", name, used))
+		}
+		sort.Strings(outputs)
+		return status, strings.Join(outputs, ", ")
+	}
+}
+
+//注册中心是否可以访问，访问失败时服务仍然可以使用，所以只是警告
+func Registry(reg registry.ServiceRegistry, serverName string) Check {
+	return func() (string, string) {
+		if _, err := reg.Lookup(serverName, nil); err != nil {
+			return registry.HealthWarn, err.Error()
+		}
+		return registry.HealthPass, ""
+	}
+}
+
+//错误检查，返回错误时为失败
+func Error(fn func() error) Check {
+	return func() (string, string) {
+		if err := fn(); err != nil {
+			return registry.HealthFail, err.Error()
+		}
+		return registry.HealthPass, ""
+	}
+}
+
+//调用其他节点的熔断器检查，有熔断的节点时为警告，说明中列出熔断的地址
+func Breakers(breakers *breaker.Breakers) Check {
+	return func() (string, string) {
+		opens := make([]string, 0)
+		for _, stat := range breakers.Stats() {
+			if stat.State != breaker.StateClosed.String() {
+				opens = append(opens, stat.Name+" "+stat.State)
+			}
+		}
+		if len(opens) == 0 {
+			return registry.HealthPass, ""
+		}
+		return registry.HealthWarn, strings.Join(opens, ", ")
+	}
+}
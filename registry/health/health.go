@@ -0,0 +1,143 @@
+package health
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/commons/logs"
+	"github.com/ihaiker/tenured-go-server/registry"
+)
+
+var logger = logs.GetLogger("health")
+
+//检查项，返回健康状态（registry.HealthPass，HealthWarn，HealthFail）和说明
+type Check func() (string, string)
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+//服务自身的健康检查，汇总所有检查项的结果，并定时上报注册中心
+type HealthChecker struct {
+	lock     *sync.Mutex
+	checks   []*namedCheck
+	interval time.Duration
+
+	reg      registry.ServiceRegistry
+	instance *registry.ServerInstance
+	//最后一次上报的状态
+	status    string
+	closeChan chan struct{}
+	doneChan  chan struct{}
+}
+
+func (this *HealthChecker) Add(name string, check Check) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.checks = append(this.checks, &namedCheck{name: name, check: check})
+}
+
+func rank(health string) int {
+	switch health {
+	case registry.HealthPass:
+		return 0
+	case registry.HealthWarn:
+		return 1
+	default:
+		return 2
+	}
+}
+
+//执行所有检查项，返回最差的状态，说明为所有非正常检查项的说明
+func (this *HealthChecker) Health() (string, string) {
+	this.lock.Lock()
+	checks := make([]*namedCheck, len(this.checks))
+	copy(checks, this.checks)
+	this.lock.Unlock()
+
+	status := registry.HealthPass
+	outputs := make([]string, 0)
+	for _, check := range checks {
+		health, output := check.check()
+		if rank(health) > rank(status) {
+			status = health
+		}
+		if health != registry.HealthPass {
+			outputs = append(outputs, check.name+": "+output)
+		}
+	}
+	return status, strings.Join(outputs, "; ")
+}
+
+func (this *HealthChecker) Start() error {
+	return nil
+}
+
+//服务注册后开始定时上报健康状态
+func (this *HealthChecker) Report(reg registry.ServiceRegistry, serverInstance *registry.ServerInstance) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.reg != nil {
+		return
+	}
+	select {
+	case <-this.closeChan:
+		return
+	default:
+	}
+	this.reg = reg
+	this.instance = serverInstance
+	go this.reportLoop()
+}
+
+func (this *HealthChecker) report() {
+	status, output := this.Health()
+	if status != this.status {
+		logger.Infof("service %s health %s: %s", this.instance.Id, status, output)
+		this.status = status
+	}
+	if err := registry.ReportHealth(this.reg, this.instance, status, output); err != nil {
+		logger.Warn("report health error: ", err)
+	}
+}
+
+func (this *HealthChecker) reportLoop() {
+	defer close(this.doneChan)
+	ticker := time.NewTicker(this.interval)
+	defer ticker.Stop()
+	for {
+		this.report()
+		select {
+		case <-this.closeChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//停止上报，等待正在进行的上报完成，防止服务下线后又被上报重新注册
+func (this *HealthChecker) Shutdown(interrupt bool) {
+	this.lock.Lock()
+	select {
+	case <-this.closeChan:
+	default:
+		close(this.closeChan)
+	}
+	reporting := this.reg != nil
+	this.lock.Unlock()
+	if reporting {
+		<-this.doneChan
+	}
+}
+
+func NewHealthChecker(interval time.Duration) *HealthChecker {
+	return &HealthChecker{
+		lock:      new(sync.Mutex),
+		checks:    make([]*namedCheck, 0),
+		interval:  interval,
+		closeChan: make(chan struct{}),
+		doneChan:  make(chan struct{}),
+	}
+}
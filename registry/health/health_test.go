@@ -0,0 +1,113 @@
+package health
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/commons/breaker"
+	"github.com/ihaiker/tenured-go-server/commons/executors"
+	"github.com/ihaiker/tenured-go-server/registry"
+	"github.com/ihaiker/tenured-go-server/registry/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthChecker_Health(t *testing.T) {
+	checker := NewHealthChecker(time.Second)
+	status, output := checker.Health()
+	assert.Equal(t, registry.HealthPass, status)
+	assert.Equal(t, "", output)
+
+	checker.Add("registry", func() (string, string) {
+		return registry.HealthWarn, "timeout"
+	})
+	status, output = checker.Health()
+	assert.Equal(t, registry.HealthWarn, status)
+	assert.Equal(t, "registry: timeout", output)
+
+	checker.Add("leveldb", Error(func() error {
+		return errors.New("leveldb: closed")
+	}))
+	status, output = checker.Health()
+	assert.Equal(t, registry.HealthFail, status)
+	assert.Equal(t, "registry: timeout; leveldb: leveldb: closed", output)
+}
+
+func TestExecutors(t *testing.T) {
+	manager := executors.NewExecutorManager(executors.NewFixedExecutorService(1, 10))
+	defer manager.Shutdown(true)
+	block := make(chan struct{})
+	defer close(block)
+	check := Executors(manager, 50, 100)
+
+	status, _ := check()
+	assert.Equal(t, registry.HealthPass, status)
+
+	//一个任务阻塞执行线程，其余任务在队列中等待
+	def := manager.Get("default")
+	for i := 0; i < 7; i++ {
+		assert.Nil(t, def.Execute(func() { <-block }))
+	}
+	time.Sleep(time.Millisecond * 100)
+	status, output := check()
+	assert.Equal(t, registry.HealthWarn, status)
+	assert.Equal(t, "default 60% used", output)
+
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, def.Execute(func() { <-block }))
+	}
+	status, output = check()
+	assert.Equal(t, registry.HealthFail, status)
+	assert.Equal(t, "default 100% used", output)
+}
+
+//注册中心不支持健康上报时，状态变化后重新注册
+func TestHealthChecker_Report(t *testing.T) {
+	plugin, err := memory.NewRegistryPlugins(&registry.PluginConfig{Address: []string{"health"}})
+	assert.Nil(t, err)
+	reg, err := plugin.Registry()
+	assert.Nil(t, err)
+
+	instance := &registry.ServerInstance{Id: "1", Name: "tenured_store", Address: "127.0.0.1:6072"}
+	assert.Nil(t, reg.Register(instance))
+
+	lock, health := sync.Mutex{}, registry.HealthFail
+	checker := NewHealthChecker(time.Millisecond * 50)
+	checker.Add("store", func() (string, string) {
+		lock.Lock()
+		defer lock.Unlock()
+		return health, ""
+	})
+	defer checker.Shutdown(true)
+
+	lookup := func() string {
+		instances, err := reg.Lookup("tenured_store", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(instances))
+		return instances[0].Status
+	}
+
+	checker.Report(reg, instance)
+	time.Sleep(time.Millisecond * 200)
+	assert.Equal(t, registry.StatusCritical, lookup())
+
+	lock.Lock()
+	health = registry.HealthWarn
+	lock.Unlock()
+	time.Sleep(time.Millisecond * 200)
+	assert.Equal(t, registry.StatusOK, lookup())
+}
+
+func TestBreakers(t *testing.T) {
+	breakers := breaker.NewBreakers(&breaker.Config{FailureThreshold: 1, OpenTimeout: 10, HalfOpenMaxCalls: 1})
+	check := Breakers(breakers)
+	breakers.Get("127.0.0.1:6072").Success()
+	status, output := check()
+	assert.Equal(t, registry.HealthPass, status)
+
+	breakers.Get("127.0.0.1:6073").Failure()
+	status, output = check()
+	assert.Equal(t, registry.HealthWarn, status)
+	assert.Equal(t, "127.0.0.1:6073 open", output)
+}
@@ -3,8 +3,12 @@ package registry
 import (
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"os"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 //注册中心服务实例附属属性，用于想不通的注册中心发送注册是附加参数的配置方式
@@ -112,3 +116,104 @@ func AllNotOK(instance ...*ServerInstance) bool {
 	}
 	return true
 }
+
+const (
+	//服务实例额外监听的unix domain socket地址，例如：unix:///var/run/tenured/store.sock
+	MetadataUnixSocket = "unixSocket"
+	//服务实例所在的主机名，和调用方相同时才使用unix socket
+	MetadataHostname = "hostname"
+	//服务实例的权重，没有设置时为DefaultWeight，为0时不分配请求
+	MetadataWeight = "weight"
+	//服务实例所在的可用区
+	MetadataZone = "zone"
+)
+
+const DefaultWeight = 100
+
+//服务实例的权重，没有设置或者设置错误时返回DefaultWeight
+func Weight(instance *ServerInstance) int {
+	if instance.Metadata == nil {
+		return DefaultWeight
+	}
+	if weight, err := strconv.Atoi(instance.Metadata[MetadataWeight]); err != nil || weight < 0 {
+		return DefaultWeight
+	} else {
+		return weight
+	}
+}
+
+func Zone(instance *ServerInstance) string {
+	if instance.Metadata == nil {
+		return ""
+	}
+	return instance.Metadata[MetadataZone]
+}
+
+var localHostname, _ = os.Hostname()
+
+//unix socket文件检查结果的缓存时间，每次选择节点都会调用，不能每次都访问文件系统
+var unixSocketCacheTime = time.Second * 10
+
+type unixSocketState struct {
+	exists  bool
+	checked time.Time
+}
+
+var unixSocketLock = new(sync.RWMutex)
+
+//key: 实例ID + socket地址
+var unixSockets = map[string]*unixSocketState{}
+
+func unixSocketExists(instance *ServerInstance, unixSocket string) bool {
+	key := instance.Id + "|" + unixSocket
+	now := time.Now()
+	unixSocketLock.RLock()
+	state, has := unixSockets[key]
+	unixSocketLock.RUnlock()
+	if has && now.Sub(state.checked) < unixSocketCacheTime {
+		return state.exists
+	}
+
+	_, err := os.Stat(strings.TrimPrefix(unixSocket, "unix://"))
+	unixSocketLock.Lock()
+	defer unixSocketLock.Unlock()
+	//顺便清理过期的检查结果，下线的实例不会再被访问
+	for k, s := range unixSockets {
+		if now.Sub(s.checked) >= unixSocketCacheTime {
+			delete(unixSockets, k)
+		}
+	}
+	unixSockets[key] = &unixSocketState{exists: err == nil, checked: now}
+	return err == nil
+}
+
+//调用方和服务实例在同一台主机并且实例提供了unix socket时，返回unix socket地址，否则返回注册地址。instance为nil时返回空字符串
+func LocalAddress(instance *ServerInstance) string {
+	if instance == nil {
+		return ""
+	}
+	if instance.Metadata == nil || localHostname == "" {
+		return instance.Address
+	}
+	unixSocket := instance.Metadata[MetadataUnixSocket]
+	if unixSocket == "" || instance.Metadata[MetadataHostname] != localHostname {
+		return instance.Address
+	}
+	if !unixSocketExists(instance, unixSocket) {
+		return instance.Address
+	}
+	return unixSocket
+}
+
+//负载均衡选择节点时使用，同一主机上的节点返回使用unix socket地址的副本，不修改注册中心缓存的实例
+func Local(instance *ServerInstance) *ServerInstance {
+	if instance == nil {
+		return nil
+	}
+	if address := LocalAddress(instance); address != instance.Address {
+		local := *instance
+		local.Address = address
+		return &local
+	}
+	return instance
+}
@@ -0,0 +1,46 @@
+package registry
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenured")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "store.sock")
+	assert.Nil(t, ioutil.WriteFile(socket, nil, 0600))
+
+	instance := &ServerInstance{
+		Id: "1", Address: "127.0.0.1:6072", Status: StatusOK,
+		Metadata: map[string]string{
+			MetadataUnixSocket: "unix://" + socket,
+			MetadataHostname:   localHostname,
+		},
+	}
+	local := Local(instance)
+	assert.Equal(t, "unix://"+socket, local.Address)
+	assert.Equal(t, "127.0.0.1:6072", instance.Address)
+
+	//检查结果在缓存时间内不再访问文件系统
+	assert.Nil(t, os.Remove(socket))
+	assert.Equal(t, "unix://"+socket, LocalAddress(instance))
+	assert.Nil(t, ioutil.WriteFile(socket, nil, 0600))
+
+	//其他主机上的实例
+	instance.Metadata[MetadataHostname] = "other-host"
+	assert.Equal(t, "127.0.0.1:6072", Local(instance).Address)
+
+	//socket文件不存在
+	instance.Metadata[MetadataHostname] = localHostname
+	instance.Metadata[MetadataUnixSocket] = "unix://" + filepath.Join(dir, "none.sock")
+	assert.Equal(t, "127.0.0.1:6072", Local(instance).Address)
+
+	assert.Nil(t, Local(nil))
+	assert.Equal(t, "", LocalAddress(nil))
+}
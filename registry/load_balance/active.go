@@ -0,0 +1,93 @@
+package load_balance
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ihaiker/tenured-go-server/commons/atomic"
+	"github.com/ihaiker/tenured-go-server/protocol"
+	"github.com/ihaiker/tenured-go-server/registry"
+)
+
+//最少活跃请求，Select时选中节点的请求数加一，Return时减一，请求数相同的节点轮询
+type leastActiveLoadBalance struct {
+	serverName string
+	serverTag  string
+	reg        registry.ServiceRegistry
+
+	rangeIndex *atomic.AtomicUInt32
+
+	lock *sync.Mutex
+	//每个节点正在处理的请求数，key为节点ID
+	actives map[string]int
+}
+
+func (this *leastActiveLoadBalance) Select(requestCode uint16, obj ...interface{}) ([]*registry.ServerInstance, string, error) {
+	return this.SelectFilter(nil, requestCode, obj...)
+}
+
+func (this *leastActiveLoadBalance) SelectFilter(filter InstanceFilter, requestCode uint16, obj ...interface{}) ([]*registry.ServerInstance, string, error) {
+	ss, err := this.reg.Lookup(this.serverName, []string{this.serverTag})
+	if err != nil {
+		return nil, "", err
+	}
+	candidates := make([]*registry.ServerInstance, 0, len(ss))
+	for _, instance := range ss {
+		if registry.IsOK(instance) && registry.Weight(instance) > 0 &&
+			(filter == nil || filter(instance)) && available(instance) {
+			candidates = append(candidates, instance)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, "", protocol.ErrorRouter()
+	}
+
+	//从轮询位置开始按照请求数排序，第一个为选中的节点，其余用于调用失败时转移
+	start := int(this.rangeIndex.GetAndIncrement() % uint32(len(candidates)))
+	rotated := make([]*registry.ServerInstance, 0, len(candidates))
+	candidates = append(append(rotated, candidates[start:]...), candidates[:start]...)
+
+	this.lock.Lock()
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return this.actives[candidates[i].Id] < this.actives[candidates[j].Id]
+	})
+	regKey := candidates[0].Id
+	this.actives[regKey]++
+	this.lock.Unlock()
+
+	selected := make([]*registry.ServerInstance, len(candidates))
+	for i, instance := range candidates {
+		selected[i] = registry.Local(instance)
+	}
+	return selected, regKey, nil
+}
+
+func (this *leastActiveLoadBalance) Return(requestCode uint16, regKey string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.actives[regKey] <= 1 {
+		delete(this.actives, regKey)
+	} else {
+		this.actives[regKey]--
+	}
+}
+
+//节点正在处理的请求数
+func (this *leastActiveLoadBalance) Active(id string) int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.actives[id]
+}
+
+func (this *leastActiveLoadBalance) String() string {
+	return fmt.Sprintf("leastActive(%s:%s)", this.serverName, this.serverTag)
+}
+
+func NewLeastActiveLoadBalance(serverName string, serverTag string, reg registry.ServiceRegistry) LoadBalance {
+	return &leastActiveLoadBalance{
+		serverName: serverName, serverTag: serverTag, reg: reg,
+		rangeIndex: atomic.NewUint32(0),
+		lock:       new(sync.Mutex), actives: map[string]int{},
+	}
+}
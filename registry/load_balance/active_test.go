@@ -0,0 +1,54 @@
+package load_balance
+
+import (
+	"testing"
+
+	"github.com/ihaiker/tenured-go-server/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeastActiveLoadBalance(t *testing.T) {
+	reg := &staticRegistry{instances: []*registry.ServerInstance{
+		storeInstance("1", registry.StatusOK), storeInstance("2", registry.StatusOK), storeInstance("3", registry.StatusOK),
+	}}
+	lb := NewLeastActiveLoadBalance("tenured_store", "search", reg).(*leastActiveLoadBalance)
+
+	//没有返回的请求，依次分配到请求数最少的节点
+	keys := make([]string, 0)
+	for i := 0; i < 6; i++ {
+		ss, regKey, err := lb.Select(0)
+		assert.Nil(t, err)
+		assert.Equal(t, ss[0].Id, regKey)
+		assert.Equal(t, 3, len(ss))
+		keys = append(keys, regKey)
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		assert.Equal(t, 2, lb.Active(id))
+	}
+
+	//节点2的请求全部返回后优先选择节点2
+	for _, regKey := range keys {
+		if regKey == "2" {
+			lb.Return(0, regKey)
+		}
+	}
+	assert.Equal(t, 0, lb.Active("2"))
+	ss, regKey, _ := lb.Select(0)
+	assert.Equal(t, "2", ss[0].Id)
+	lb.Return(0, regKey)
+
+	//请求数相同时轮询
+	for _, regKey := range keys {
+		if regKey != "2" {
+			lb.Return(0, regKey)
+		}
+	}
+	counts := map[string]int{}
+	for i := 0; i < 30; i++ {
+		ss, regKey, _ := lb.Select(0)
+		lb.Return(0, regKey)
+		counts[ss[0].Id]++
+	}
+	assert.Equal(t, map[string]int{"1": 10, "2": 10, "3": 10}, counts)
+	assert.Equal(t, 0, len(lb.actives))
+}
@@ -2,15 +2,16 @@ package load_balance
 
 import (
 	"fmt"
-	"github.com/emirpasic/gods/maps/treemap"
-	"github.com/emirpasic/gods/utils"
+	"github.com/ihaiker/tenured-go-server/protocol"
 	"github.com/ihaiker/tenured-go-server/registry"
 
+	"github.com/emirpasic/gods/maps/treemap"
 	"github.com/kataras/iris/core/errors"
-	"hash/crc64"
-	"strconv"
 )
 
+//从请求参数中获取hash使用的key
+type HashKey func(requestCode uint16, obj ...interface{}) string
+
 type HashLoadBalance struct {
 	//注册服务的名称
 	serverName string
@@ -20,25 +21,11 @@ type HashLoadBalance struct {
 	//注册中心管理器
 	registration registry.ServiceRegistry
 
-	table *crc64.Table
-
-	//虚拟节点数
-	virtualNum int
-
-	//保存hash表
-	tree *treemap.Map
+	//为空时使用第一个参数
+	hashKey HashKey
 
-	//保存注册服务
-	serverInstances map[string]*registry.ServerInstance
-}
-
-func (this *HashLoadBalance) addSerInstance(instance *registry.ServerInstance) {
-	id := instance.Id
-	for i := 0; i < this.virtualNum; i++ {
-		hashCode := crc64.Checksum([]byte(id+strconv.Itoa(i)), this.table)
-		this.tree.Put(hashCode, id)
-	}
-	this.serverInstances[id] = instance
+	//保存hash环和注册服务
+	ring *hashRing
 }
 
 func (this *HashLoadBalance) Start() error {
@@ -46,23 +33,12 @@ func (this *HashLoadBalance) Start() error {
 	if err != nil {
 		return err
 	}
-	for _, serverInstance := range ss {
-		this.addSerInstance(serverInstance)
-	}
-
+	this.ring.onNotify(this.serverTag, ss)
 	return this.registration.Subscribe(this.serverName, this.onNotify)
 }
 
 func (this *HashLoadBalance) onNotify(serverInstances []*registry.ServerInstance) {
-	for _, si := range serverInstances {
-		if si.HasTag(this.serverTag) {
-			if si.Status == registry.StatusOK {
-				this.addSerInstance(si)
-			} else if saveIs, has := this.serverInstances[si.Id]; has {
-				saveIs.Status = si.Status
-			}
-		}
-	}
+	this.ring.onNotify(this.serverTag, serverInstances)
 }
 
 func (this *HashLoadBalance) Shutdown(interrupt bool) {
@@ -70,32 +46,89 @@ func (this *HashLoadBalance) Shutdown(interrupt bool) {
 }
 
 func (this *HashLoadBalance) Select(requestCode uint16, obj ...interface{}) ([]*registry.ServerInstance, string, error) {
-	if len(obj) == 0 {
+	var key string
+	if this.hashKey != nil {
+		key = this.hashKey(requestCode, obj...)
+	} else if len(obj) == 0 {
 		return nil, "", errors.New("not support no param for hash load_balance")
+	} else {
+		key = fmt.Sprintf("%v", obj[0])
 	}
 
-	hashCode := crc64.Checksum([]byte(fmt.Sprintf("%v", obj[0])), this.table)
+	hashCode := this.ring.hash(key)
 
-	_, value := this.tree.Find(func(key interface{}, value interface{}) bool {
-		return hashCode <= key.(uint64)
+	//从hash位置顺时针查找没有熔断的节点，第一个为主节点，后续节点保存有副本数据，用于读取失败时转移
+	this.ring.lock.RLock()
+	selected := make([]*registry.ServerInstance, 0, len(this.ring.serverInstances))
+	ids := map[string]bool{}
+	walkRing(this.ring.tree, hashCode, func(value interface{}) bool {
+		id := value.(*element).Id
+		if ids[id] {
+			return false
+		}
+		ids[id] = true
+		if si, has := this.ring.serverInstances[id]; has {
+			if available(si) {
+				selected = append(selected, registry.Local(si))
+			}
+		}
+		return len(ids) == len(this.ring.serverInstances)
 	})
-	if value == nil {
-		_, value = this.tree.Min()
+	this.ring.lock.RUnlock()
+	if len(selected) == 0 {
+		return nil, "", protocol.ErrorRouter()
 	}
+	return selected, "", nil
+}
 
-	serverId := value.(string)
-	return []*registry.ServerInstance{this.serverInstances[serverId]}, "", nil
+//从hashCode位置开始顺时针遍历环上的节点，fn返回true时停止
+func walkRing(tree *treemap.Map, hashCode uint64, fn func(value interface{}) bool) {
+	it := tree.Iterator()
+	for it.Next() {
+		if it.Key().(uint64) >= hashCode && fn(it.Value()) {
+			return
+		}
+	}
+	for it.Begin(); it.Next(); {
+		if it.Key().(uint64) >= hashCode || fn(it.Value()) {
+			return
+		}
+	}
 }
 
 func (this *HashLoadBalance) Return(requestCode uint16, key string) {}
 
+func (this *HashLoadBalance) Ring() []RingNode {
+	return this.ring.ring()
+}
+
+func (this *HashLoadBalance) Members() []*registry.ServerInstance {
+	return this.ring.members()
+}
+
+func (this *HashLoadBalance) AddRingListener(listener RingListener) {
+	this.ring.addListener(listener)
+}
+
+func (this *HashLoadBalance) Successors(key string, n int) []*registry.ServerInstance {
+	return this.ring.successors(key, n)
+}
+
+func (this *HashLoadBalance) String() string {
+	return fmt.Sprintf("hash(%s:%s)%s", this.serverName, this.serverTag, this.ring)
+}
+
 func NewHashLoadBalance(serverName string, serverTag string, registration registry.ServiceRegistry, virtualNum int) LoadBalance {
 	hlb := &HashLoadBalance{
 		serverName: serverName, serverTag: serverTag, registration: registration,
-
-		table: crc64.MakeTable(crc64.ECMA), virtualNum: virtualNum, tree: treemap.NewWith(utils.UInt64Comparator),
-
-		serverInstances: map[string]*registry.ServerInstance{},
+		ring: newHashRing(virtualNum),
 	}
 	return hlb
 }
+
+//使用hashKey获取请求的key，保证同一条数据的不同请求路由到相同节点
+func NewHashKeyLoadBalance(serverName string, serverTag string, registration registry.ServiceRegistry, virtualNum int, hashKey HashKey) LoadBalance {
+	hlb := NewHashLoadBalance(serverName, serverTag, registration, virtualNum).(*HashLoadBalance)
+	hlb.hashKey = hashKey
+	return hlb
+}
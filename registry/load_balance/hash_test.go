@@ -0,0 +1,241 @@
+package load_balance
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ihaiker/tenured-go-server/commons/breaker"
+	"github.com/ihaiker/tenured-go-server/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func storeInstance(id, status string) *registry.ServerInstance {
+	return &registry.ServerInstance{
+		Id: id, Name: "tenured_store", Address: "127.0.0.1:" + id,
+		Tags: []string{"search"}, Status: status,
+	}
+}
+
+func ringIds(ring []RingNode) map[string]int {
+	ids := map[string]int{}
+	for _, node := range ring {
+		ids[node.Id]++
+	}
+	return ids
+}
+
+//记录每个key选中的节点
+func selectAll(t *testing.T, lb LoadBalance) map[string]string {
+	owners := map[string]string{}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		ss, _, err := lb.Select(0, key)
+		assert.Nil(t, err)
+		owners[key] = ss[0].Id
+	}
+	return owners
+}
+
+func TestHashLoadBalance_JoinLeave(t *testing.T) {
+	lb := NewHashLoadBalance("tenured_store", "search", nil, 10).(*HashLoadBalance)
+	events := make([]string, 0)
+	lb.AddRingListener(func(event RingEvent) {
+		events = append(events, event.Type+":"+event.Instance.Id)
+	})
+
+	_, _, err := lb.Select(0, "key")
+	assert.NotNil(t, err)
+
+	lb.onNotify([]*registry.ServerInstance{storeInstance("1", registry.StatusOK), storeInstance("2", registry.StatusOK)})
+	assert.Equal(t, map[string]int{"1": 10, "2": 10}, ringIds(lb.Ring()))
+	before := selectAll(t, lb)
+
+	//新节点加入只有部分key移动到新节点
+	lb.onNotify([]*registry.ServerInstance{storeInstance("3", registry.StatusOK)})
+	assert.Equal(t, 3, len(lb.Members()))
+	joined := selectAll(t, lb)
+	for key, owner := range joined {
+		if owner != before[key] {
+			assert.Equal(t, "3", owner)
+		}
+	}
+
+	//节点下线后不再出现在环上，原来的key回到之前的节点
+	lb.onNotify([]*registry.ServerInstance{storeInstance("3", registry.StatusDown)})
+	assert.Equal(t, map[string]int{"1": 10, "2": 10}, ringIds(lb.Ring()))
+	assert.Equal(t, before, selectAll(t, lb))
+
+	//不可用的节点同样离开
+	lb.onNotify([]*registry.ServerInstance{storeInstance("2", registry.StatusCritical)})
+	assert.Equal(t, map[string]int{"1": 10}, ringIds(lb.Ring()))
+	for _, owner := range selectAll(t, lb) {
+		assert.Equal(t, "1", owner)
+	}
+
+	assert.Equal(t, []string{"join:1", "join:2", "join:3", "leave:3", "leave:2"}, events)
+}
+
+//节点反复上下线，环的内容和分配保持一致，重复的通知不产生事件
+func TestHashLoadBalance_Flap(t *testing.T) {
+	lb := NewHashLoadBalance("tenured_store", "search", nil, 10).(*HashLoadBalance)
+	events := 0
+	lb.AddRingListener(func(event RingEvent) {
+		events++
+	})
+	lb.onNotify([]*registry.ServerInstance{storeInstance("1", registry.StatusOK), storeInstance("2", registry.StatusOK)})
+	ring, owners := lb.Ring(), selectAll(t, lb)
+
+	for i := 0; i < 5; i++ {
+		lb.onNotify([]*registry.ServerInstance{storeInstance("2", registry.StatusCritical)})
+		lb.onNotify([]*registry.ServerInstance{storeInstance("2", registry.StatusDown)})
+		lb.onNotify([]*registry.ServerInstance{storeInstance("2", registry.StatusOK)})
+		lb.onNotify([]*registry.ServerInstance{storeInstance("2", registry.StatusOK)})
+	}
+	assert.Equal(t, 2+5*2, events)
+	assert.Equal(t, ring, lb.Ring())
+	assert.Equal(t, owners, selectAll(t, lb))
+
+	//其他标签的服务不会加入
+	other := storeInstance("4", registry.StatusOK)
+	other.Tags = []string{"account"}
+	lb.onNotify([]*registry.ServerInstance{other})
+	assert.Equal(t, 2, len(lb.Members()))
+}
+
+func TestTimedHashLoadBalance_Leave(t *testing.T) {
+	lb := NewTimedHashLoadBalance("tenured_store", "search", nil, 10, func(requestCode uint16, parameters ...interface{}) uint64 {
+		return parameters[0].(uint64)
+	}).(*TimedHashLoadBalance)
+
+	lb.onNotify([]*registry.ServerInstance{storeInstance("1", registry.StatusOK), storeInstance("2", registry.StatusOK)})
+	lb.onNotify([]*registry.ServerInstance{storeInstance("2", registry.StatusDown)})
+	assert.Equal(t, map[string]int{"1": 10}, ringIds(lb.Ring()))
+	for i := uint64(0); i < 100; i++ {
+		ss, _, err := lb.Select(0, i<<22)
+		assert.Nil(t, err)
+		assert.Equal(t, "1", ss[0].Id)
+		assert.Equal(t, registry.StatusOK, ss[0].Status)
+	}
+
+	lb.onNotify([]*registry.ServerInstance{storeInstance("1", registry.StatusDown)})
+	_, _, err := lb.Select(0, uint64(1))
+	assert.NotNil(t, err)
+}
+
+//主节点之后是副本节点，ID生成之后启动的节点排在最后
+func TestTimedHashLoadBalance_Successors(t *testing.T) {
+	lb := NewTimedHashLoadBalance("tenured_store", "search", nil, 10, func(requestCode uint16, parameters ...interface{}) uint64 {
+		return parameters[0].(uint64)
+	}).(*TimedHashLoadBalance)
+	later := storeInstance("3", registry.StatusOK)
+	later.Metadata = map[string]string{"FirstStartTime": "1000"}
+	lb.onNotify([]*registry.ServerInstance{storeInstance("1", registry.StatusOK), storeInstance("2", registry.StatusOK), later})
+
+	for i := uint64(0); i < 100; i++ {
+		id := i << 22
+		selected, _, err := lb.Select(0, id)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(selected))
+		assert.Equal(t, "3", selected[2].Id)
+		successors := lb.Successors(fmt.Sprintf("%d", id), 2)
+		assert.Equal(t, 2, len(successors))
+		assert.Equal(t, successors[0].Id, selected[0].Id)
+		assert.Equal(t, successors[1].Id, selected[1].Id)
+	}
+}
+
+//熔断的主节点跳过，使用环上的下一个节点
+func TestTimedHashLoadBalance_Breaker(t *testing.T) {
+	lb := NewTimedHashLoadBalance("tenured_store", "search", nil, 10, func(requestCode uint16, parameters ...interface{}) uint64 {
+		return parameters[0].(uint64)
+	}).(*TimedHashLoadBalance)
+	lb.onNotify([]*registry.ServerInstance{storeInstance("7", registry.StatusOK), storeInstance("8", registry.StatusOK)})
+
+	opened := breaker.Default().Get("127.0.0.1:7")
+	for opened.Available() {
+		opened.Failure()
+	}
+	for i := uint64(0); i < 100; i++ {
+		selected, _, err := lb.Select(0, i<<22)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(selected))
+		assert.Equal(t, "8", selected[0].Id)
+	}
+}
+
+//副本节点和读取时的转移顺序一致
+func TestHashLoadBalance_Successors(t *testing.T) {
+	lb := NewHashLoadBalance("tenured_store", "search", nil, 10).(*HashLoadBalance)
+	lb.onNotify([]*registry.ServerInstance{
+		storeInstance("1", registry.StatusOK), storeInstance("2", registry.StatusOK), storeInstance("3", registry.StatusOK),
+	})
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		successors := lb.Successors(key, 2)
+		assert.Equal(t, 2, len(successors))
+		assert.NotEqual(t, successors[0].Id, successors[1].Id)
+
+		selected, _, err := lb.Select(0, key)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(selected))
+		assert.Equal(t, successors[0].Id, selected[0].Id)
+		assert.Equal(t, successors[1].Id, selected[1].Id)
+	}
+	assert.Equal(t, 3, len(lb.Successors("key", 5)))
+
+	//主节点离开后原来的第一个副本节点成为主节点
+	owner := lb.Successors("key", 2)
+	lb.onNotify([]*registry.ServerInstance{storeInstance(owner[0].Id, registry.StatusDown)})
+	assert.Equal(t, owner[1].Id, lb.Successors("key", 1)[0].Id)
+}
+
+//节点变化时移动的区间和选择节点的变化一致
+func TestMovedRanges(t *testing.T) {
+	lb := NewHashLoadBalance("tenured_store", "search", nil, 10).(*HashLoadBalance)
+	events := make([]RingEvent, 0)
+	lb.AddRingListener(func(event RingEvent) {
+		events = append(events, event)
+	})
+	lb.onNotify([]*registry.ServerInstance{storeInstance("1", registry.StatusOK), storeInstance("2", registry.StatusOK)})
+	before := selectAll(t, lb)
+
+	lb.onNotify([]*registry.ServerInstance{storeInstance("3", registry.StatusOK)})
+	joined := selectAll(t, lb)
+	event := events[len(events)-1]
+	assert.Equal(t, 2, len(event.Previous.Members()))
+	assert.Equal(t, 3, len(event.Current.Members()))
+
+	moved := NewMovedRanges(event.Previous, event.Current, 1)
+	gained := moved.Gained("3")
+	assert.Equal(t, len(moved), len(gained))
+	for key, owner := range joined {
+		hashCode := RingHash(key)
+		if owner != before[key] {
+			assert.NotNil(t, gained.Get(hashCode), key)
+			assert.True(t, gained.Ranges().Contains(hashCode))
+			assert.Equal(t, before[key], gained.Get(hashCode).From[0].Id)
+		} else {
+			assert.Nil(t, moved.Get(hashCode), key)
+		}
+	}
+
+	//节点离开时区间回到原来的节点
+	lb.onNotify([]*registry.ServerInstance{storeInstance("3", registry.StatusDown)})
+	event = events[len(events)-1]
+	left := NewMovedRanges(event.Previous, event.Current, 1)
+	assert.Equal(t, 0, len(left.Gained("3")))
+	assert.Equal(t, len(left), len(left.Lost("3")))
+	for key, owner := range joined {
+		if owner == "3" {
+			assert.Equal(t, before[key], left.Get(RingHash(key)).To[0].Id)
+		}
+	}
+
+	//跨过环起点的区间
+	assert.True(t, HashRange{Start: 100, End: 10}.Contains(5))
+	assert.True(t, HashRange{Start: 100, End: 10}.Contains(101))
+	assert.False(t, HashRange{Start: 100, End: 10}.Contains(50))
+	assert.True(t, HashRanges{{Start: 100, End: 10}, {Start: 20, End: 30}}.Contains(200))
+	assert.False(t, HashRanges{{Start: 100, End: 10}, {Start: 20, End: 30}}.Contains(15))
+}
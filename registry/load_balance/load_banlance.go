@@ -1,6 +1,10 @@
 package load_balance
 
 import (
+	"fmt"
+	"sync"
+
+	"github.com/ihaiker/tenured-go-server/commons/breaker"
 	"github.com/ihaiker/tenured-go-server/registry"
 )
 
@@ -10,3 +14,48 @@ type LoadBalance interface {
 	//返回
 	Return(requestCode uint16, regKey string)
 }
+
+type InstanceFilter func(instance *registry.ServerInstance) bool
+
+//可以限定候选节点的负载均衡，可用区优先时使用
+type FilterLoadBalance interface {
+	LoadBalance
+
+	//只在filter返回true的节点中选择
+	SelectFilter(filter InstanceFilter, requestCode uint16, obj ...interface{}) (serverInstances []*registry.ServerInstance, regKey string, err error)
+}
+
+//节点是否没有熔断。调用方按照实际连接的地址（同一主机时为unix socket）记录熔断器，所有负载均衡使用同一个key
+func available(instance *registry.ServerInstance) bool {
+	return breaker.Default().Available(registry.LocalAddress(instance))
+}
+
+type LoadBalanceFunc func(serverName string, serverTag string, reg registry.ServiceRegistry) LoadBalance
+
+var loadBalancesLock = new(sync.RWMutex)
+
+//按照名称注册的负载均衡，用于配置和.tcd文件中指定负载均衡
+var loadBalances = map[string]LoadBalanceFunc{
+	"none":   NewNoneLoadBalance,
+	"round":  NewRoundLoadBalance,
+	"weight": NewWeightLoadBalance,
+	"zone":   NewZoneWeightLoadBalance,
+
+	"leastActive": NewLeastActiveLoadBalance,
+}
+
+func RegisterLoadBalance(name string, fn LoadBalanceFunc) {
+	loadBalancesLock.Lock()
+	defer loadBalancesLock.Unlock()
+	loadBalances[name] = fn
+}
+
+func NewNamedLoadBalance(name string, serverName string, serverTag string, reg registry.ServiceRegistry) (LoadBalance, error) {
+	loadBalancesLock.RLock()
+	fn, has := loadBalances[name]
+	loadBalancesLock.RUnlock()
+	if !has {
+		return nil, fmt.Errorf("load balance %s not found", name)
+	}
+	return fn(serverName, serverTag, reg), nil
+}
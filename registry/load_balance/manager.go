@@ -8,12 +8,29 @@ import (
 type LoadBalanceManager struct {
 	def   LoadBalance
 	store map[uint16]LoadBalance
+	//按照名称创建的负载均衡，key为 名称/服务名称/服务标签
+	named map[string]LoadBalance
 }
 
 func (this *LoadBalanceManager) AddLoadBalance(requestCode uint16, lb LoadBalance) {
 	this.store[requestCode] = lb
 }
 
+//按照名称为请求码指定负载均衡，相同名称、服务和标签的请求码共用一个负载均衡
+func (this *LoadBalanceManager) UseLoadBalance(requestCode uint16, name, serverName, serverTag string, reg registry.ServiceRegistry) error {
+	key := name + "/" + serverName + "/" + serverTag
+	lb, has := this.named[key]
+	if !has {
+		var err error
+		if lb, err = NewNamedLoadBalance(name, serverName, serverTag, reg); err != nil {
+			return err
+		}
+		this.named[key] = lb
+	}
+	this.AddLoadBalance(requestCode, lb)
+	return nil
+}
+
 func (this *LoadBalanceManager) Select(requestCode uint16, obj ...interface{}) (serverInstances []*registry.ServerInstance, regKey string, err error) {
 	if lb, has := this.store[requestCode]; has {
 		serverInstances, regKey, err = lb.Select(requestCode, obj...)
@@ -62,6 +79,7 @@ func (this *LoadBalanceManager) Shutdown(interrupt bool) {
 func NewLoadBalanceManager(def LoadBalance) *LoadBalanceManager {
 	lbm := &LoadBalanceManager{
 		store: map[uint16]LoadBalance{},
+		named: map[string]LoadBalance{},
 	}
 	lbm.def = def
 	return lbm
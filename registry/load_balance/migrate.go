@@ -0,0 +1,163 @@
+package load_balance
+
+import (
+	"hash/crc64"
+	"sort"
+
+	"github.com/ihaiker/tenured-go-server/registry"
+)
+
+//key在hash环上的位置，和负载均衡使用的hash一致
+func RingHash(key string) uint64 {
+	return crc64.Checksum([]byte(key), ringTable)
+}
+
+//节点变化时hash环的快照
+type RingSnapshot struct {
+	ring *hashRing
+}
+
+func (this *RingSnapshot) Successors(key string, n int) []*registry.ServerInstance {
+	return this.ring.successors(key, n)
+}
+
+func (this *RingSnapshot) Members() []*registry.ServerInstance {
+	return this.ring.members()
+}
+
+func (this *RingSnapshot) Has(id string) bool {
+	_, has := this.ring.serverInstances[id]
+	return has
+}
+
+//hash环上的区间(Start, End]，Start大于等于End时跨过环的起点
+type HashRange struct {
+	Start uint64 `json:"start"`
+	End   uint64 `json:"end"`
+}
+
+func (this HashRange) Contains(hashCode uint64) bool {
+	if this.Start < this.End {
+		return this.Start < hashCode && hashCode <= this.End
+	}
+	return hashCode > this.Start || hashCode <= this.End
+}
+
+//按照End排序并且互不重叠的区间
+type HashRanges []HashRange
+
+func (this HashRanges) Contains(hashCode uint64) bool {
+	return searchRange(len(this), func(i int) HashRange { return this[i] }, hashCode) >= 0
+}
+
+//移动的区间，From为变化前的节点（第一个为主节点），To为变化后的节点
+type MovedRange struct {
+	HashRange
+	From []*registry.ServerInstance
+	To   []*registry.ServerInstance
+}
+
+//按照End排序的移动区间
+type MovedRanges []*MovedRange
+
+func (this MovedRanges) Get(hashCode uint64) *MovedRange {
+	if idx := searchRange(len(this), func(i int) HashRange { return this[i].HashRange }, hashCode); idx >= 0 {
+		return this[idx]
+	}
+	return nil
+}
+
+//节点id在变化后需要保存，变化前没有保存的区间
+func (this MovedRanges) Gained(id string) MovedRanges {
+	gained := MovedRanges{}
+	for _, moved := range this {
+		if contains(moved.To, id) && !contains(moved.From, id) {
+			gained = append(gained, moved)
+		}
+	}
+	return gained
+}
+
+//节点id在变化前保存，变化后不再保存的区间
+func (this MovedRanges) Lost(id string) MovedRanges {
+	lost := MovedRanges{}
+	for _, moved := range this {
+		if contains(moved.From, id) && !contains(moved.To, id) {
+			lost = append(lost, moved)
+		}
+	}
+	return lost
+}
+
+func (this MovedRanges) Ranges() HashRanges {
+	ranges := make(HashRanges, len(this))
+	for i, moved := range this {
+		ranges[i] = moved.HashRange
+	}
+	return ranges
+}
+
+//计算两个环之间保存节点（n个副本）变化的区间。
+//两个环上所有虚拟节点把环分割成多个区间，每个区间内的key在同一个环上的保存节点相同
+func NewMovedRanges(previous, current *RingSnapshot, n int) MovedRanges {
+	hashCodes := make([]uint64, 0, previous.ring.tree.Size()+current.ring.tree.Size())
+	for _, ring := range []*hashRing{previous.ring, current.ring} {
+		for _, node := range ring.ring() {
+			hashCodes = append(hashCodes, node.Hash)
+		}
+	}
+	sort.Slice(hashCodes, func(i, j int) bool {
+		return hashCodes[i] < hashCodes[j]
+	})
+
+	moved := MovedRanges{}
+	for i, end := range hashCodes {
+		if i > 0 && hashCodes[i-1] == end {
+			continue
+		}
+		start := hashCodes[len(hashCodes)-1]
+		if i > 0 {
+			start = hashCodes[i-1]
+		}
+		from, to := previous.ring.successorsOf(end, n), current.ring.successorsOf(end, n)
+		if !sameIds(from, to) {
+			moved = append(moved, &MovedRange{HashRange: HashRange{Start: start, End: end}, From: from, To: to})
+		}
+	}
+	return moved
+}
+
+//查找包含hashCode的区间，跨过起点的区间只可能是第一个
+func searchRange(n int, get func(i int) HashRange, hashCode uint64) int {
+	idx := sort.Search(n, func(i int) bool {
+		return get(i).End >= hashCode
+	})
+	if idx < n && get(idx).Contains(hashCode) {
+		return idx
+	}
+	if n > 0 && get(0).Contains(hashCode) {
+		return 0
+	}
+	return -1
+}
+
+func contains(instances []*registry.ServerInstance, id string) bool {
+	for _, instance := range instances {
+		if instance.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
+func sameIds(a, b []*registry.ServerInstance) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, instance := range a {
+		if !contains(b, instance.Id) {
+			return false
+		}
+	}
+	return true
+}
@@ -41,7 +41,7 @@ func (this *noneLoadBalance) Select(requestCode uint16, obj ...interface{}) ([]*
 		defer func() { gl.CurrentNode += 1 }()
 		gl.NodeSize = len(ss)
 		gl.Server = ss[gl.CurrentNode]
-		return []*registry.ServerInstance{ss[gl.CurrentNode]}, "", err
+		return []*registry.ServerInstance{registry.Local(ss[gl.CurrentNode])}, "", err
 	}
 }
 
@@ -0,0 +1,233 @@
+package load_balance
+
+import (
+	"fmt"
+	"hash/crc64"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/emirpasic/gods/maps/treemap"
+	"github.com/emirpasic/gods/utils"
+	"github.com/ihaiker/tenured-go-server/commons/logs"
+	"github.com/ihaiker/tenured-go-server/registry"
+)
+
+var logger = logs.GetLogger("load_balance")
+
+var ringTable = crc64.MakeTable(crc64.ECMA)
+
+const RingJoin = "join"   //节点加入hash环
+const RingLeave = "leave" //节点离开hash环
+
+//hash环节点变化事件
+type RingEvent struct {
+	Type     string
+	Instance *registry.ServerInstance
+
+	//变化前后的环，用于计算需要迁移的数据
+	Previous *RingSnapshot
+	Current  *RingSnapshot
+}
+
+type RingListener func(event RingEvent)
+
+//hash环上的虚拟节点
+type RingNode struct {
+	Hash uint64
+	Id   string
+}
+
+//使用一致性hash的负载均衡，可以查看环的内容和监听节点变化
+type RingLoadBalance interface {
+	LoadBalance
+
+	//环上所有的虚拟节点，按照hash排序
+	Ring() []RingNode
+
+	//环上所有的服务实例
+	Members() []*registry.ServerInstance
+
+	AddRingListener(listener RingListener)
+
+	//从key的位置开始顺时针的n个不同节点，第一个为主节点，其余为副本节点
+	Successors(key string, n int) []*registry.ServerInstance
+}
+
+//虚拟节点保存的内容
+type element struct {
+	Id        string
+	StartTime uint64
+}
+
+//一致性hash环，服务正常时加入，下线或者不可用时删除，恢复后重新加入
+type hashRing struct {
+	lock  *sync.RWMutex
+	table *crc64.Table
+	//虚拟节点数
+	virtualNum int
+	//保存hash表
+	tree *treemap.Map
+	//保存注册服务
+	serverInstances map[string]*registry.ServerInstance
+
+	listeners []RingListener
+}
+
+func (this *hashRing) hash(key string) uint64 {
+	return crc64.Checksum([]byte(key), this.table)
+}
+
+func (this *hashRing) join(instance *registry.ServerInstance) {
+	id := instance.Id
+	this.lock.Lock()
+	if _, has := this.serverInstances[id]; has {
+		this.serverInstances[id] = instance
+		this.lock.Unlock()
+		return
+	}
+	previous := this.snapshot()
+	firstStartTime, _ := strconv.ParseUint(instance.Metadata["FirstStartTime"], 10, 64)
+	for i := 0; i < this.virtualNum; i++ {
+		this.tree.Put(this.hash(id+strconv.Itoa(i)), &element{Id: id, StartTime: firstStartTime})
+	}
+	this.serverInstances[id] = instance
+	listeners, current := this.listeners, this.snapshot()
+	this.lock.Unlock()
+
+	logger.Infof("ring join %s", instance)
+	this.fire(listeners, RingEvent{Type: RingJoin, Instance: instance, Previous: previous, Current: current})
+}
+
+func (this *hashRing) leave(instance *registry.ServerInstance) {
+	id := instance.Id
+	this.lock.Lock()
+	if _, has := this.serverInstances[id]; !has {
+		this.lock.Unlock()
+		return
+	}
+	previous := this.snapshot()
+	for i := 0; i < this.virtualNum; i++ {
+		hashCode := this.hash(id + strconv.Itoa(i))
+		//hash冲突时虚拟节点可能已经属于其他节点
+		if value, found := this.tree.Get(hashCode); found && value.(*element).Id == id {
+			this.tree.Remove(hashCode)
+		}
+	}
+	delete(this.serverInstances, id)
+	listeners, current := this.listeners, this.snapshot()
+	this.lock.Unlock()
+
+	logger.Infof("ring leave %s", instance)
+	this.fire(listeners, RingEvent{Type: RingLeave, Instance: instance, Previous: previous, Current: current})
+}
+
+func (this *hashRing) fire(listeners []RingListener, event RingEvent) {
+	for _, listener := range listeners {
+		listener(event)
+	}
+}
+
+//根据注册中心通知维护hash环，只有正常状态的节点在环上
+func (this *hashRing) onNotify(serverTag string, serverInstances []*registry.ServerInstance) {
+	for _, si := range serverInstances {
+		if !si.HasTag(serverTag) {
+			continue
+		}
+		if si.Status == registry.StatusOK {
+			this.join(si)
+		} else {
+			this.leave(si)
+		}
+	}
+}
+
+func (this *hashRing) successors(key string, n int) []*registry.ServerInstance {
+	return this.successorsOf(this.hash(key), n)
+}
+
+func (this *hashRing) successorsOf(hashCode uint64, n int) []*registry.ServerInstance {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	instances := make([]*registry.ServerInstance, 0, n)
+	ids := map[string]bool{}
+	walkRing(this.tree, hashCode, func(value interface{}) bool {
+		id := value.(*element).Id
+		if !ids[id] {
+			ids[id] = true
+			instances = append(instances, this.serverInstances[id])
+		}
+		return len(instances) >= n || len(ids) == len(this.serverInstances)
+	})
+	return instances
+}
+
+func (this *hashRing) addListener(listener RingListener) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	listeners := make([]RingListener, len(this.listeners), len(this.listeners)+1)
+	copy(listeners, this.listeners)
+	this.listeners = append(listeners, listener)
+}
+
+func (this *hashRing) ring() []RingNode {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	nodes := make([]RingNode, 0, this.tree.Size())
+	it := this.tree.Iterator()
+	for it.Next() {
+		nodes = append(nodes, RingNode{Hash: it.Key().(uint64), Id: it.Value().(*element).Id})
+	}
+	return nodes
+}
+
+func (this *hashRing) members() []*registry.ServerInstance {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	instances := make([]*registry.ServerInstance, 0, len(this.serverInstances))
+	for _, instance := range this.serverInstances {
+		instances = append(instances, instance)
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].Id < instances[j].Id
+	})
+	return instances
+}
+
+//复制当前的环，调用时需要持有锁。没有监听时不需要快照
+func (this *hashRing) snapshot() *RingSnapshot {
+	if len(this.listeners) == 0 {
+		return nil
+	}
+	ring := newHashRing(this.virtualNum)
+	it := this.tree.Iterator()
+	for it.Next() {
+		ring.tree.Put(it.Key(), it.Value())
+	}
+	for id, instance := range this.serverInstances {
+		ring.serverInstances[id] = instance
+	}
+	return &RingSnapshot{ring: ring}
+}
+
+func (this *hashRing) String() string {
+	counts := map[string]int{}
+	for _, node := range this.ring() {
+		counts[node.Id]++
+	}
+	out := make([]string, 0, len(counts))
+	for _, instance := range this.members() {
+		out = append(out, fmt.Sprintf("%s(%s)=%d", instance.Id, instance.Address, counts[instance.Id]))
+	}
+	return "[" + strings.Join(out, ", ") + "]"
+}
+
+func newHashRing(virtualNum int) *hashRing {
+	return &hashRing{
+		lock: new(sync.RWMutex), table: ringTable,
+		virtualNum: virtualNum, tree: treemap.NewWith(utils.UInt64Comparator),
+		serverInstances: map[string]*registry.ServerInstance{},
+		listeners:       make([]RingListener, 0),
+	}
+}
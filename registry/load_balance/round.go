@@ -14,19 +14,28 @@ type roundLoadBalance struct {
 }
 
 func (this *roundLoadBalance) Select(requestCode uint16, obj ...interface{}) ([]*registry.ServerInstance, string, error) {
+	return this.SelectFilter(nil, requestCode, obj...)
+}
+
+func (this *roundLoadBalance) SelectFilter(filter InstanceFilter, requestCode uint16, obj ...interface{}) ([]*registry.ServerInstance, string, error) {
 	if ss, err := this.reg.Lookup(this.serverName, []string{this.serverTag}); err != nil {
 		return nil, "", err
 	} else if len(ss) == 0 {
 		return ss, "", err
 	} else {
+		//第一个为本次轮询选中的节点，其余可用节点依次排在后面，用于调用失败时转移
+		start := int(this.rangeIndex.GetAndIncrement() % uint32(len(ss)))
+		selected := make([]*registry.ServerInstance, 0, len(ss))
 		for i := 0; i < len(ss); i++ {
-			currentRangeIndex := this.rangeIndex.GetAndIncrement()
-			idx := int(currentRangeIndex % uint32(len(ss)))
-			if registry.IsOK(ss[idx]) {
-				return []*registry.ServerInstance{ss[idx]}, "", nil
+			instance := registry.Local(ss[(start+i)%len(ss)])
+			if (filter == nil || filter(instance)) && registry.IsOK(instance) && available(instance) {
+				selected = append(selected, instance)
 			}
 		}
-		return nil, "", protocol.ErrorRouter()
+		if len(selected) == 0 {
+			return nil, "", protocol.ErrorRouter()
+		}
+		return selected, "", nil
 	}
 }
 
@@ -2,23 +2,16 @@ package load_balance
 
 import (
 	"fmt"
-	"github.com/emirpasic/gods/maps/treemap"
-	"github.com/emirpasic/gods/utils"
+	"strconv"
+
 	"github.com/ihaiker/tenured-go-server/protocol"
 	"github.com/ihaiker/tenured-go-server/registry"
 
 	"github.com/ihaiker/tenured-go-server/commons/snowflake"
-	"hash/crc64"
-	"strconv"
 )
 
 type SnowflakeExport func(requestCode uint16, parameters ...interface{}) uint64
 
-type element struct {
-	Id        string
-	StartTime uint64
-}
-
 type TimedHashLoadBalance struct {
 	//注册服务的名称
 	serverName string
@@ -30,26 +23,8 @@ type TimedHashLoadBalance struct {
 
 	snowflakeExport SnowflakeExport
 
-	table *crc64.Table
-	//虚拟节点数
-	virtualNum int
-	//保存hash表
-	tree *treemap.Map
-
-	//保存注册服务
-	serverInstances map[string]*registry.ServerInstance
-}
-
-func (this *TimedHashLoadBalance) addSerInstance(instance *registry.ServerInstance) {
-	id := instance.Id
-	firstStartTime, _ := strconv.ParseUint(instance.Metadata["FirstStartTime"], 10, 64)
-
-	for i := 0; i < this.virtualNum; i++ {
-		hashCode := crc64.Checksum([]byte(id+strconv.Itoa(i)), this.table)
-		this.tree.Put(hashCode, &element{Id: id, StartTime: firstStartTime})
-	}
-
-	this.serverInstances[id] = instance
+	//保存hash环和注册服务
+	ring *hashRing
 }
 
 func (this *TimedHashLoadBalance) Start() error {
@@ -57,67 +32,102 @@ func (this *TimedHashLoadBalance) Start() error {
 	if err != nil {
 		return err
 	}
-	for _, serverInstance := range ss {
-		this.addSerInstance(serverInstance)
-	}
-
+	this.ring.onNotify(this.serverTag, ss)
 	return this.registration.Subscribe(this.serverName, this.onNotify)
 }
 
 func (this *TimedHashLoadBalance) onNotify(serverInstances []*registry.ServerInstance) {
-	for _, si := range serverInstances {
-		if si.HasTag(this.serverTag) {
-			if si.Status == registry.StatusOK {
-				this.addSerInstance(si)
-			} else if saveIs, has := this.serverInstances[si.Id]; has {
-				saveIs.Status = saveIs.String()
-			}
-		}
-	}
+	this.ring.onNotify(this.serverTag, serverInstances)
 }
 
 func (this *TimedHashLoadBalance) Shutdown(interrupt bool) {
 	_ = this.registration.Unsubscribe(this.serverName, this.onNotify)
 }
 
+//第一个为主节点，后续节点保存有副本数据，用于读取失败时转移。
+//跳过不正常和熔断的节点，主节点不可用时使用环上的下一个节点
 func (this *TimedHashLoadBalance) Select(requestCode uint16, obj ...interface{}) ([]*registry.ServerInstance, string, error) {
-	if this.tree.Size() == 0 {
+	//从请求参数参数中获取分区的snowflake生成的ID
+	snowflakeId := this.snowflakeExport(requestCode, obj...)
+	successors := this.successors(snowflakeId, -1)
+	selected := make([]*registry.ServerInstance, 0, len(successors))
+	for _, si := range successors {
+		if registry.IsOK(si) && available(si) {
+			selected = append(selected, registry.Local(si))
+		}
+	}
+	if len(selected) == 0 {
 		return nil, "", protocol.ErrorRouter()
 	}
+	return selected, "", nil
+}
 
-	//从请求参数参数中获取分区的snowflake生成的ID
-	snowflakeId := this.snowflakeExport(requestCode, obj...)
+//从ID的hash位置顺时针查找n个不同节点（n小于0时全部节点），优先选择ID生成之前已经启动的节点，
+//第一个为主节点，后续为副本节点。之后启动的节点排在最后，只在节点数不足时使用
+func (this *TimedHashLoadBalance) successors(snowflakeId uint64, n int) []*registry.ServerInstance {
 	//分解此项ID值
 	petal := snowflake.Decompose(snowflakeId)
+	hashCode := this.ring.hash(fmt.Sprintf("%d", snowflakeId))
 
-	hashCode := crc64.Checksum([]byte(fmt.Sprintf("%d", snowflakeId)), this.table)
-
-	_, value := this.tree.Find(func(key interface{}, value interface{}) bool {
-		return hashCode <= key.(uint64) && (value.(*element).StartTime <= petal.Time)
-	})
-	if value == nil {
-		_, value = this.tree.Find(func(key interface{}, value interface{}) bool {
-			return value.(*element).StartTime <= petal.Time
-		})
+	this.ring.lock.RLock()
+	defer this.ring.lock.RUnlock()
+	if n < 0 || n > len(this.ring.serverInstances) {
+		n = len(this.ring.serverInstances)
 	}
-	if value == nil {
-		_, value = this.tree.Min()
+	selected := make([]*registry.ServerInstance, 0, n)
+	later := make([]*registry.ServerInstance, 0)
+	ids := map[string]bool{}
+	walkRing(this.ring.tree, hashCode, func(value interface{}) bool {
+		e := value.(*element)
+		if ids[e.Id] {
+			return false
+		}
+		ids[e.Id] = true
+		if e.StartTime <= petal.Time {
+			selected = append(selected, this.ring.serverInstances[e.Id])
+		} else {
+			later = append(later, this.ring.serverInstances[e.Id])
+		}
+		return len(selected) >= n || len(ids) == len(this.ring.serverInstances)
+	})
+	for i := 0; len(selected) < n && i < len(later); i++ {
+		selected = append(selected, later[i])
 	}
-
-	serverId := value.(*element).Id
-	return []*registry.ServerInstance{this.serverInstances[serverId]}, "", nil
+	return selected
 }
 
 func (this *TimedHashLoadBalance) Return(requestCode uint16, key string) {}
 
+func (this *TimedHashLoadBalance) Ring() []RingNode {
+	return this.ring.ring()
+}
+
+func (this *TimedHashLoadBalance) Members() []*registry.ServerInstance {
+	return this.ring.members()
+}
+
+func (this *TimedHashLoadBalance) AddRingListener(listener RingListener) {
+	this.ring.addListener(listener)
+}
+
+//key为snowflake生成的ID，和Select使用相同的节点顺序，副本节点和读取转移的节点一致
+func (this *TimedHashLoadBalance) Successors(key string, n int) []*registry.ServerInstance {
+	snowflakeId, err := strconv.ParseUint(key, 10, 64)
+	if err != nil {
+		return this.ring.successors(key, n)
+	}
+	return this.successors(snowflakeId, n)
+}
+
+func (this *TimedHashLoadBalance) String() string {
+	return fmt.Sprintf("timedHash(%s:%s)%s", this.serverName, this.serverTag, this.ring)
+}
+
 func NewTimedHashLoadBalance(serverName string, serverTag string, registration registry.ServiceRegistry, virtualNum int, snowflakeExport SnowflakeExport) LoadBalance {
 	hlb := &TimedHashLoadBalance{
 		serverName: serverName, serverTag: serverTag, registration: registration,
 
-		snowflakeExport: snowflakeExport, table: crc64.MakeTable(crc64.ECMA),
-		virtualNum: virtualNum, tree: treemap.NewWith(utils.UInt64Comparator),
-
-		serverInstances: map[string]*registry.ServerInstance{},
+		snowflakeExport: snowflakeExport, ring: newHashRing(virtualNum),
 	}
 	return hlb
 }
@@ -0,0 +1,94 @@
+package load_balance
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ihaiker/tenured-go-server/protocol"
+	"github.com/ihaiker/tenured-go-server/registry"
+)
+
+//平滑加权轮询，权重从服务实例的Metadata中读取，权重为0的节点不分配请求
+type weightLoadBalance struct {
+	serverName string
+	serverTag  string
+	reg        registry.ServiceRegistry
+
+	lock *sync.Mutex
+	//每个节点当前的权重，key为节点ID
+	current map[string]int
+}
+
+func (this *weightLoadBalance) Select(requestCode uint16, obj ...interface{}) ([]*registry.ServerInstance, string, error) {
+	return this.SelectFilter(nil, requestCode, obj...)
+}
+
+func (this *weightLoadBalance) SelectFilter(filter InstanceFilter, requestCode uint16, obj ...interface{}) ([]*registry.ServerInstance, string, error) {
+	ss, err := this.reg.Lookup(this.serverName, []string{this.serverTag})
+	if err != nil {
+		return nil, "", err
+	}
+	candidates := make([]*registry.ServerInstance, 0, len(ss))
+	for _, instance := range ss {
+		if registry.IsOK(instance) && registry.Weight(instance) > 0 &&
+			(filter == nil || filter(instance)) && available(instance) {
+			candidates = append(candidates, instance)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, "", protocol.ErrorRouter()
+	}
+
+	//第一个为本次选中的节点，其余可用节点依次排在后面，用于调用失败时转移
+	picked := this.pick(candidates, ss)
+	selected := make([]*registry.ServerInstance, 0, len(candidates))
+	selected = append(selected, registry.Local(candidates[picked]))
+	for i, instance := range candidates {
+		if i != picked {
+			selected = append(selected, registry.Local(instance))
+		}
+	}
+	return selected, "", nil
+}
+
+//每次所有节点增加自身权重，选中当前权重最大的节点并减去总权重。
+//已经不在注册中心的节点删除当前权重，重新注册后从0开始
+func (this *weightLoadBalance) pick(candidates, instances []*registry.ServerInstance) int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	ids := make(map[string]bool, len(instances))
+	for _, instance := range instances {
+		ids[instance.Id] = true
+	}
+	for id := range this.current {
+		if !ids[id] {
+			delete(this.current, id)
+		}
+	}
+	total, picked := 0, -1
+	for i, instance := range candidates {
+		weight := registry.Weight(instance)
+		total += weight
+		this.current[instance.Id] += weight
+		if picked == -1 || this.current[instance.Id] > this.current[candidates[picked].Id] {
+			picked = i
+		}
+	}
+	this.current[candidates[picked].Id] -= total
+	return picked
+}
+
+func (this *weightLoadBalance) Return(requestCode uint16, key string) {
+
+}
+
+func (this *weightLoadBalance) String() string {
+	return fmt.Sprintf("weight(%s:%s)", this.serverName, this.serverTag)
+}
+
+func NewWeightLoadBalance(serverName string, serverTag string, reg registry.ServiceRegistry) LoadBalance {
+	return &weightLoadBalance{
+		serverName: serverName, serverTag: serverTag, reg: reg,
+		lock: new(sync.Mutex), current: map[string]int{},
+	}
+}
@@ -0,0 +1,132 @@
+package load_balance
+
+import (
+	"testing"
+
+	"github.com/ihaiker/tenured-go-server/commons/breaker"
+	"github.com/ihaiker/tenured-go-server/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+//固定服务列表的注册中心
+type staticRegistry struct {
+	instances []*registry.ServerInstance
+}
+
+func (this *staticRegistry) Register(serverInstance *registry.ServerInstance) error {
+	return nil
+}
+
+func (this *staticRegistry) Unregister(serverId string) error {
+	return nil
+}
+
+func (this *staticRegistry) Subscribe(serverName string, listener registry.RegistryNotifyListener) error {
+	return nil
+}
+
+func (this *staticRegistry) Unsubscribe(serverName string, listener registry.RegistryNotifyListener) error {
+	return nil
+}
+
+func (this *staticRegistry) Lookup(serverName string, tags []string) ([]*registry.ServerInstance, error) {
+	return this.instances, nil
+}
+
+func zoneInstance(id, zone, weight, status string) *registry.ServerInstance {
+	instance := storeInstance(id, status)
+	instance.Metadata = map[string]string{registry.MetadataZone: zone, registry.MetadataWeight: weight}
+	return instance
+}
+
+//统计第一个节点的选中次数
+func selectCount(t *testing.T, lb LoadBalance, times int) map[string]int {
+	counts := map[string]int{}
+	for i := 0; i < times; i++ {
+		ss, _, err := lb.Select(0)
+		assert.Nil(t, err)
+		counts[ss[0].Id]++
+	}
+	return counts
+}
+
+func TestWeightLoadBalance(t *testing.T) {
+	reg := &staticRegistry{instances: []*registry.ServerInstance{
+		zoneInstance("1", "", "300", registry.StatusOK),
+		zoneInstance("2", "", "100", registry.StatusOK),
+		zoneInstance("3", "", "0", registry.StatusOK),
+		zoneInstance("4", "", "100", registry.StatusDown),
+		storeInstance("5", registry.StatusOK),
+	}}
+	lb := NewWeightLoadBalance("tenured_store", "search", reg)
+	assert.Equal(t, map[string]int{"1": 300, "2": 100, "5": 100}, selectCount(t, lb, 500))
+
+	//其余可用节点排在后面用于失败转移
+	ss, _, _ := lb.Select(0)
+	assert.Equal(t, 3, len(ss))
+
+	//离开的节点删除当前权重
+	reg.instances = reg.instances[:2]
+	_, _, _ = lb.Select(0)
+	assert.NotContains(t, lb.(*weightLoadBalance).current, "5")
+
+	reg.instances = []*registry.ServerInstance{zoneInstance("3", "", "0", registry.StatusOK)}
+	_, _, err := lb.Select(0)
+	assert.NotNil(t, err)
+}
+
+func TestZoneLoadBalance(t *testing.T) {
+	reg := &staticRegistry{instances: []*registry.ServerInstance{
+		zoneInstance("1", "a", "200", registry.StatusOK),
+		zoneInstance("2", "a", "100", registry.StatusOK),
+		zoneInstance("3", "b", "100", registry.StatusOK),
+		zoneInstance("4", "b", "100", registry.StatusOK),
+	}}
+	lb := NewZoneLoadBalance("tenured_store", "search", reg, "a", 0.5, NewWeightLoadBalance("tenured_store", "search", reg))
+	assert.Equal(t, map[string]int{"1": 200, "2": 100}, selectCount(t, lb, 300))
+
+	//其他可用区的节点排在后面用于失败转移
+	ss, _, _ := lb.Select(0)
+	assert.Equal(t, 4, len(ss))
+	assert.Equal(t, "b", registry.Zone(ss[2]))
+	assert.Equal(t, "b", registry.Zone(ss[3]))
+
+	//可用区内健康容量低于阈值时使用全部节点
+	reg.instances[0].Status = registry.StatusCritical
+	assert.Equal(t, map[string]int{"2": 100, "3": 100, "4": 100}, selectCount(t, lb, 300))
+
+	//不支持过滤的负载均衡只调整顺序
+	reg.instances[0].Status = registry.StatusOK
+	round := NewZoneLoadBalance("tenured_store", "search", reg, "b", 0.5, NewNoneLoadBalance("tenured_store", "search", reg))
+	ss, _, err := round.Select(0, &GlobalLoading{})
+	assert.Nil(t, err)
+	assert.Equal(t, "1", ss[0].Id)
+
+	//熔断的其他可用区节点不用于失败转移
+	opened := zoneInstance("9", "b", "100", registry.StatusOK)
+	reg.instances = append(reg.instances, opened)
+	for breaker.Default().Get(registry.LocalAddress(opened)).Available() {
+		breaker.Default().Get(registry.LocalAddress(opened)).Failure()
+	}
+	ss, _, err = lb.Select(0)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(ss))
+	for _, instance := range ss {
+		assert.NotEqual(t, "9", instance.Id)
+	}
+}
+
+func TestLoadBalanceManager_UseLoadBalance(t *testing.T) {
+	reg := &staticRegistry{instances: []*registry.ServerInstance{zoneInstance("1", "a", "100", registry.StatusOK)}}
+	lbm := NewLoadBalanceManager(nil)
+	assert.Nil(t, lbm.UseLoadBalance(1, "weight", "tenured_store", "search", reg))
+	assert.Nil(t, lbm.UseLoadBalance(2, "weight", "tenured_store", "search", reg))
+	assert.Nil(t, lbm.UseLoadBalance(3, "zone", "tenured_store", "search", reg))
+	assert.NotNil(t, lbm.UseLoadBalance(4, "unknown", "tenured_store", "search", reg))
+	assert.True(t, lbm.store[1] == lbm.store[2])
+	assert.False(t, lbm.store[1] == lbm.store[3])
+
+	ss, _, err := lbm.Select(3)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", ss[0].Id)
+}
@@ -0,0 +1,117 @@
+package load_balance
+
+import (
+	"fmt"
+
+	"github.com/ihaiker/tenured-go-server/commons"
+	"github.com/ihaiker/tenured-go-server/commons/mixins"
+	"github.com/ihaiker/tenured-go-server/registry"
+)
+
+//同可用区健康节点的权重占比低于此值时不再优先
+const DefaultZoneThreshold = 0.5
+
+//优先选择调用方所在可用区的节点，其他可用区的节点排在后面用于失败转移。
+//可用区内健康节点的权重占可用区全部节点权重的比例低于threshold时，不区分可用区
+type zoneLoadBalance struct {
+	serverName string
+	serverTag  string
+	reg        registry.ServiceRegistry
+
+	zone      string
+	threshold float64
+	lb        LoadBalance
+}
+
+func (this *zoneLoadBalance) inZone(instance *registry.ServerInstance) bool {
+	return registry.Zone(instance) == this.zone
+}
+
+//可用区内健康节点的容量是否达到阈值
+func (this *zoneLoadBalance) healthy() bool {
+	ss, err := this.reg.Lookup(this.serverName, []string{this.serverTag})
+	if err != nil {
+		return false
+	}
+	total, healthy := 0, 0
+	for _, instance := range ss {
+		if !this.inZone(instance) {
+			continue
+		}
+		weight := registry.Weight(instance)
+		total += weight
+		if registry.IsOK(instance) && available(instance) {
+			healthy += weight
+		}
+	}
+	return healthy > 0 && float64(healthy) >= float64(total)*this.threshold
+}
+
+func (this *zoneLoadBalance) Select(requestCode uint16, obj ...interface{}) ([]*registry.ServerInstance, string, error) {
+	if this.zone == "" || !this.healthy() {
+		return this.lb.Select(requestCode, obj...)
+	}
+	if filterLb, match := this.lb.(FilterLoadBalance); match {
+		selected, regKey, err := filterLb.SelectFilter(this.inZone, requestCode, obj...)
+		if err != nil {
+			return this.lb.Select(requestCode, obj...)
+		}
+		//其他可用区的节点用于失败转移，不参与选择
+		if ss, err := this.reg.Lookup(this.serverName, []string{this.serverTag}); err == nil {
+			for _, instance := range ss {
+				if !this.inZone(instance) && registry.IsOK(instance) && registry.Weight(instance) > 0 && available(instance) {
+					selected = append(selected, registry.Local(instance))
+				}
+			}
+		}
+		return selected, regKey, nil
+	}
+
+	//不支持过滤的负载均衡，保持原来的顺序把同可用区的节点移到前面
+	selected, regKey, err := this.lb.Select(requestCode, obj...)
+	if err != nil {
+		return selected, regKey, err
+	}
+	sorted := make([]*registry.ServerInstance, 0, len(selected))
+	for _, instance := range selected {
+		if this.inZone(instance) {
+			sorted = append(sorted, instance)
+		}
+	}
+	for _, instance := range selected {
+		if !this.inZone(instance) {
+			sorted = append(sorted, instance)
+		}
+	}
+	return sorted, regKey, nil
+}
+
+func (this *zoneLoadBalance) Return(requestCode uint16, key string) {
+	this.lb.Return(requestCode, key)
+}
+
+func (this *zoneLoadBalance) Start() error {
+	return commons.StartIfService(this.lb)
+}
+
+func (this *zoneLoadBalance) Shutdown(interrupt bool) {
+	commons.ShutdownIfService(this.lb, interrupt)
+}
+
+func (this *zoneLoadBalance) String() string {
+	return fmt.Sprintf("zone(%s,%v)%v", this.zone, this.threshold, this.lb)
+}
+
+//zone为调用方所在的可用区，为空时直接使用lb
+func NewZoneLoadBalance(serverName string, serverTag string, reg registry.ServiceRegistry, zone string, threshold float64, lb LoadBalance) LoadBalance {
+	return &zoneLoadBalance{
+		serverName: serverName, serverTag: serverTag, reg: reg,
+		zone: zone, threshold: threshold, lb: lb,
+	}
+}
+
+//使用加权轮询，调用方的可用区从环境变量 TENURED_ZONE 读取
+func NewZoneWeightLoadBalance(serverName string, serverTag string, reg registry.ServiceRegistry) LoadBalance {
+	return NewZoneLoadBalance(serverName, serverTag, reg, mixins.Get(mixins.KeyZone, ""), DefaultZoneThreshold,
+		NewWeightLoadBalance(serverName, serverTag, reg))
+}
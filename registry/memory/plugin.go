@@ -0,0 +1,52 @@
+package memory
+
+import (
+	"sync"
+
+	"github.com/ihaiker/tenured-go-server/commons/logs"
+	"github.com/ihaiker/tenured-go-server/registry"
+	"github.com/sirupsen/logrus"
+)
+
+type MemoryRegistryPlugins struct {
+	lock     *sync.Mutex
+	registry registry.ServiceRegistry
+	config   *registry.PluginConfig
+}
+
+func (this *MemoryRegistryPlugins) Instance(config map[string]string) (*registry.ServerInstance, error) {
+	//内存注册中心没有附加属性
+	return &registry.ServerInstance{}, nil
+}
+
+func (this *MemoryRegistryPlugins) Registry() (registry.ServiceRegistry, error) {
+	if this.registry != nil {
+		return this.registry, nil
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.registry != nil {
+		return this.registry, nil
+	}
+
+	if reg, err := newRegistry(this.config); err != nil {
+		return nil, err
+	} else {
+		this.registry = reg
+		return reg, nil
+	}
+}
+
+var logger *logrus.Logger
+
+func init() {
+	logger = logs.GetLogger("memory")
+}
+
+func NewRegistryPlugins(config *registry.PluginConfig) (registry.Plugins, error) {
+	return &MemoryRegistryPlugins{
+		lock:   new(sync.Mutex),
+		config: config,
+	}, nil
+}
@@ -0,0 +1,196 @@
+package memory
+
+import (
+	"sync"
+
+	"github.com/ihaiker/tenured-go-server/registry"
+)
+
+//服务注册监听者
+type subscriber struct {
+	listeners map[string]registry.RegistryNotifyListener
+	services  map[string]*registry.ServerInstance
+}
+
+//进程内注册中心，用于本地开发和测试
+type MemoryServiceRegistry struct {
+	lock  *sync.Mutex
+	store *memoryStore
+	//订阅信息，key为服务名称
+	subscribes map[string]*subscriber
+	//本注册中心注册的服务ID
+	registrations map[string]struct{}
+}
+
+func (this *MemoryServiceRegistry) Start() error {
+	return nil
+}
+
+//关闭时删除本注册中心注册的服务，其他订阅者收到下线通知
+func (this *MemoryServiceRegistry) Shutdown(interrupt bool) {
+	this.lock.Lock()
+	registrations := this.registrations
+	this.registrations = map[string]struct{}{}
+	this.subscribes = map[string]*subscriber{}
+	this.lock.Unlock()
+
+	this.store.detach(this)
+	for serverId := range registrations {
+		this.store.remove(serverId)
+	}
+}
+
+func (this *MemoryServiceRegistry) Register(serverInstance *registry.ServerInstance) error {
+	logger.Infof("register %s(%s) : %s", serverInstance.Name, serverInstance.Address, serverInstance.Id)
+	this.lock.Lock()
+	this.registrations[serverInstance.Id] = struct{}{}
+	this.lock.Unlock()
+	this.store.put(serverInstance)
+	return nil
+}
+
+func (this *MemoryServiceRegistry) Unregister(serverId string) error {
+	logger.Info("unregister ", serverId)
+	this.lock.Lock()
+	delete(this.registrations, serverId)
+	this.lock.Unlock()
+	this.store.remove(serverId)
+	return nil
+}
+
+func (this *MemoryServiceRegistry) notify(subscribe *subscriber, serverInstances []*registry.ServerInstance) {
+	this.lock.Lock()
+	listeners := make([]registry.RegistryNotifyListener, 0, len(subscribe.listeners))
+	for _, lis := range subscribe.listeners {
+		listeners = append(listeners, lis)
+	}
+	this.lock.Unlock()
+
+	for _, si := range serverInstances {
+		logger.Infof("notify registry name=%s, id=%s, status=%s", si.Name, si.Id, si.Status)
+	}
+	for _, lis := range listeners {
+		lis(serverInstances)
+	}
+}
+
+//比较服务列表和订阅者已知的服务，通知变化。只在通知协程中调用
+func (this *MemoryServiceRegistry) notifySubscribe(serverName string, serverInstances []*registry.ServerInstance) {
+	this.lock.Lock()
+	subscribe, has := this.subscribes[serverName]
+	this.lock.Unlock()
+	if !has {
+		return
+	}
+
+	notifies := make([]*registry.ServerInstance, 0)
+	currentServices := map[string]*registry.ServerInstance{}
+	for _, instance := range serverInstances {
+		serverInstance := copyInstance(instance)
+		currentServices[serverInstance.Id] = serverInstance
+		if old, has := subscribe.services[serverInstance.Id]; !has || old.Status != serverInstance.Status {
+			notifies = append(notifies, serverInstance)
+		}
+	}
+	for _, serverInstance := range subscribe.services {
+		if _, has := currentServices[serverInstance.Id]; !has {
+			//已经通知过的实例可能仍被监听器持有，使用副本通知下线
+			down := *serverInstance
+			down.Status = registry.StatusDown
+			notifies = append(notifies, &down)
+		}
+	}
+	subscribe.services = currentServices
+	if len(notifies) != 0 {
+		this.notify(subscribe, notifies)
+	}
+}
+
+func (this *MemoryServiceRegistry) Subscribe(serverName string, listener registry.RegistryNotifyListener) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	logger.Info("Subscribe ", serverName)
+	if this.addSubscribe(serverName, listener) {
+		this.store.refresh(serverName)
+	}
+	return nil
+}
+
+func (this *MemoryServiceRegistry) Unsubscribe(serverName string, listener registry.RegistryNotifyListener) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	logger.Info("Unsubscribe ", serverName, ",fn ", listener)
+	if this.removeSubscribe(serverName, listener) {
+		delete(this.subscribes, serverName)
+	}
+	return nil
+}
+
+func (this *MemoryServiceRegistry) Lookup(serverName string, tags []string) ([]*registry.ServerInstance, error) {
+	serverInstances := make([]*registry.ServerInstance, 0)
+	for _, serverInstance := range this.store.lookup(serverName) {
+		if hasAllTags(serverInstance, tags) {
+			serverInstances = append(serverInstances, serverInstance)
+		}
+	}
+	return serverInstances, nil
+}
+
+func hasAllTags(serverInstance *registry.ServerInstance, tags []string) bool {
+	for _, tag := range tags {
+		if !serverInstance.HasTag(tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func (this *MemoryServiceRegistry) getOrCreateSubscribe(name string) *subscriber {
+	if subInfo, has := this.subscribes[name]; !has {
+		subInfo = &subscriber{
+			listeners: map[string]registry.RegistryNotifyListener{},
+			services:  map[string]*registry.ServerInstance{},
+		}
+		this.subscribes[name] = subInfo
+	}
+	return this.subscribes[name]
+}
+
+//@return 返回是否是此服务的第一个监听器
+func (this *MemoryServiceRegistry) addSubscribe(name string, listener registry.RegistryNotifyListener) bool {
+	sets := this.getOrCreateSubscribe(name)
+	from := len(sets.listeners)
+	pointer := registry.NotifyPointer(listener)
+	sets.listeners[pointer] = listener
+	return from == 0 && len(sets.listeners) == 1
+}
+
+//@return 是否是次服务的最后一个监听器
+func (this *MemoryServiceRegistry) removeSubscribe(name string, listener registry.RegistryNotifyListener) bool {
+	if sets, has := this.subscribes[name]; has {
+		pointer := registry.NotifyPointer(listener)
+		from := len(sets.listeners)
+		delete(sets.listeners, pointer)
+		return from == 1 && len(sets.listeners) == 0
+	} else {
+		return false
+	}
+}
+
+//memory://name，名称相同的注册中心共享服务列表，默认为空
+func newRegistry(pluginConfig *registry.PluginConfig) (*MemoryServiceRegistry, error) {
+	name := ""
+	if pluginConfig != nil && len(pluginConfig.Address) > 0 {
+		name = pluginConfig.Address[0]
+	}
+	reg := &MemoryServiceRegistry{
+		lock:          new(sync.Mutex),
+		store:         getStore(name),
+		subscribes:    map[string]*subscriber{},
+		registrations: map[string]struct{}{},
+	}
+	reg.store.attach(reg)
+	return reg, nil
+}
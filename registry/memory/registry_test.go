@@ -0,0 +1,112 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRegistry(t *testing.T, address string) *MemoryServiceRegistry {
+	config, err := registry.ParseConfig(address)
+	assert.Nil(t, err)
+	plugin, err := NewRegistryPlugins(config)
+	assert.Nil(t, err)
+	reg, err := plugin.Registry()
+	assert.Nil(t, err)
+	return reg.(*MemoryServiceRegistry)
+}
+
+func waitStatus(t *testing.T, notifies chan *registry.ServerInstance, id, status string) {
+	timeout := time.After(time.Second * 5)
+	for {
+		select {
+		case si := <-notifies:
+			if si.Id == id && si.Status == status {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("wait %s %s timeout", id, status)
+		}
+	}
+}
+
+func TestMemoryServiceRegistry(t *testing.T) {
+	reg := newTestRegistry(t, "memory://test")
+	watcher := newTestRegistry(t, "memory://test")
+	other := newTestRegistry(t, "memory://other")
+	defer watcher.Shutdown(true)
+	defer other.Shutdown(true)
+
+	notifies := make(chan *registry.ServerInstance, 16)
+	assert.Nil(t, watcher.Subscribe("tenured_store", func(serverInstances []*registry.ServerInstance) {
+		for _, si := range serverInstances {
+			notifies <- si
+		}
+	}))
+
+	instance := &registry.ServerInstance{
+		Id: "1", Name: "tenured_store", Address: "127.0.0.1:6072",
+		Tags: []string{"account", "user"}, Metadata: map[string]string{"external": "127.0.0.1:6072"},
+	}
+	assert.Nil(t, reg.Register(instance))
+	waitStatus(t, notifies, "1", registry.StatusOK)
+
+	instances, err := watcher.Lookup("tenured_store", []string{"account"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(instances))
+	assert.Equal(t, "127.0.0.1:6072", instances[0].Address)
+	assert.Equal(t, "127.0.0.1:6072", instances[0].Metadata["external"])
+
+	instances, err = watcher.Lookup("tenured_store", []string{"account", "search"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(instances))
+
+	//不同名称的注册中心数据隔离
+	instances, err = other.Lookup("tenured_store", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(instances))
+
+	instance.Status = registry.StatusCritical
+	assert.Nil(t, reg.Register(instance))
+	waitStatus(t, notifies, "1", registry.StatusCritical)
+
+	assert.Nil(t, reg.Unregister("1"))
+	waitStatus(t, notifies, "1", registry.StatusDown)
+
+	//注册中心关闭后删除注册的服务，其他订阅者收到下线通知
+	instance.Status = registry.StatusOK
+	assert.Nil(t, reg.Register(instance))
+	waitStatus(t, notifies, "1", registry.StatusOK)
+	reg.Shutdown(true)
+	waitStatus(t, notifies, "1", registry.StatusDown)
+
+	instances, err = watcher.Lookup("tenured_store", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(instances))
+}
+
+//监听器中调用注册中心不会死锁
+func TestMemoryServiceRegistry_ReentrantListener(t *testing.T) {
+	reg := newTestRegistry(t, "memory://reentrant")
+	defer reg.Shutdown(true)
+
+	done := make(chan struct{})
+	assert.Nil(t, reg.Subscribe("tenured_store", func(serverInstances []*registry.ServerInstance) {
+		for _, si := range serverInstances {
+			if si.Id == "1" && si.Status == registry.StatusOK {
+				assert.Nil(t, reg.Register(&registry.ServerInstance{Id: "2", Name: "tenured_store", Address: "127.0.0.2:6072"}))
+			} else if si.Id == "2" {
+				close(done)
+			}
+		}
+	}))
+	assert.Nil(t, reg.Register(&registry.ServerInstance{Id: "1", Name: "tenured_store", Address: "127.0.0.1:6072"}))
+
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait notify timeout")
+	}
+}
@@ -0,0 +1,152 @@
+package memory
+
+import (
+	"sync"
+
+	"github.com/ihaiker/tenured-go-server/registry"
+)
+
+//进程内的服务列表，相同名称的内存注册中心共享同一份数据，例如：memory://test
+type memoryStore struct {
+	lock *sync.Mutex
+	//服务列表，name -> id -> instance
+	services map[string]map[string]*registry.ServerInstance
+	//使用此数据的注册中心
+	registries map[*MemoryServiceRegistry]struct{}
+
+	//发生变化的服务，由通知协程合并后通知订阅者
+	changes    map[string]struct{}
+	changeChan chan struct{}
+}
+
+var storesLock = new(sync.Mutex)
+var stores = map[string]*memoryStore{}
+
+func getStore(name string) *memoryStore {
+	storesLock.Lock()
+	defer storesLock.Unlock()
+	if store, has := stores[name]; has {
+		return store
+	}
+	store := &memoryStore{
+		lock:       new(sync.Mutex),
+		services:   map[string]map[string]*registry.ServerInstance{},
+		registries: map[*MemoryServiceRegistry]struct{}{},
+		changes:    map[string]struct{}{},
+		changeChan: make(chan struct{}, 1),
+	}
+	stores[name] = store
+	go store.dispatch()
+	return store
+}
+
+func copyInstance(serverInstance *registry.ServerInstance) *registry.ServerInstance {
+	instance := &registry.ServerInstance{
+		Id:       serverInstance.Id,
+		Name:     serverInstance.Name,
+		Address:  serverInstance.Address,
+		Status:   serverInstance.Status,
+		Metadata: map[string]string{},
+	}
+	for key, value := range serverInstance.Metadata {
+		instance.Metadata[key] = value
+	}
+	if serverInstance.Tags != nil {
+		instance.Tags = append([]string{}, serverInstance.Tags...)
+	}
+	return instance
+}
+
+func (this *memoryStore) attach(reg *MemoryServiceRegistry) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.registries[reg] = struct{}{}
+}
+
+func (this *memoryStore) detach(reg *MemoryServiceRegistry) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	delete(this.registries, reg)
+}
+
+func (this *memoryStore) put(serverInstance *registry.ServerInstance) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	instance := copyInstance(serverInstance)
+	if instance.Status == "" {
+		instance.Status = registry.StatusOK
+	}
+	//相同ID更换了服务名称，从原服务中删除
+	for name, instances := range this.services {
+		if old, has := instances[instance.Id]; has && name != instance.Name {
+			delete(instances, old.Id)
+			this.changed(name)
+		}
+	}
+	if _, has := this.services[instance.Name]; !has {
+		this.services[instance.Name] = map[string]*registry.ServerInstance{}
+	}
+	this.services[instance.Name][instance.Id] = instance
+	this.changed(instance.Name)
+}
+
+func (this *memoryStore) remove(serverId string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for name, instances := range this.services {
+		if _, has := instances[serverId]; has {
+			delete(instances, serverId)
+			if len(instances) == 0 {
+				delete(this.services, name)
+			}
+			this.changed(name)
+		}
+	}
+}
+
+func (this *memoryStore) lookup(serverName string) []*registry.ServerInstance {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	serverInstances := make([]*registry.ServerInstance, 0, len(this.services[serverName]))
+	for _, instance := range this.services[serverName] {
+		serverInstances = append(serverInstances, copyInstance(instance))
+	}
+	return serverInstances
+}
+
+//标记服务发生变化，调用者需持有锁
+func (this *memoryStore) changed(serverName string) {
+	this.changes[serverName] = struct{}{}
+	select {
+	case this.changeChan <- struct{}{}:
+	default:
+	}
+}
+
+func (this *memoryStore) refresh(serverName string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.changed(serverName)
+}
+
+//异步通知订阅者，避免监听器中调用注册中心时死锁，同时保证通知的顺序
+func (this *memoryStore) dispatch() {
+	for range this.changeChan {
+		this.lock.Lock()
+		changes := this.changes
+		this.changes = map[string]struct{}{}
+		registries := make([]*MemoryServiceRegistry, 0, len(this.registries))
+		for reg := range this.registries {
+			registries = append(registries, reg)
+		}
+		this.lock.Unlock()
+
+		for serverName := range changes {
+			serverInstances := this.lookup(serverName)
+			for _, reg := range registries {
+				reg.notifySubscribe(serverName, serverInstances)
+			}
+		}
+	}
+}
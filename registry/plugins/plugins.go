@@ -7,6 +7,11 @@ import (
 	"github.com/ihaiker/tenured-go-server/commons/runtime"
 	"github.com/ihaiker/tenured-go-server/registry"
 	"github.com/ihaiker/tenured-go-server/registry/consul"
+	"github.com/ihaiker/tenured-go-server/registry/dns"
+	"github.com/ihaiker/tenured-go-server/registry/etcd"
+	"github.com/ihaiker/tenured-go-server/registry/eureka"
+	"github.com/ihaiker/tenured-go-server/registry/file"
+	"github.com/ihaiker/tenured-go-server/registry/memory"
 	"path/filepath"
 	"plugin"
 )
@@ -18,6 +23,16 @@ func GetRegistryPlugins(registryConfig string) (registry.Plugins, error) {
 		return nil, err
 	} else if config.Plugin == "consul" {
 		return consul.NewRegistryPlugins(config)
+	} else if config.Plugin == "etcd" {
+		return etcd.NewRegistryPlugins(config)
+	} else if config.Plugin == "eureka" {
+		return eureka.NewRegistryPlugins(config)
+	} else if config.Plugin == "memory" {
+		return memory.NewRegistryPlugins(config)
+	} else if config.Plugin == "file" {
+		return file.NewRegistryPlugins(config)
+	} else if config.Plugin == "dns" {
+		return dns.NewRegistryPlugins(config)
 	} else {
 		return loadPluginRegistry(config)
 	}
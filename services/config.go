@@ -5,19 +5,43 @@ import (
 	"errors"
 	"github.com/go-yaml/yaml"
 	"github.com/ihaiker/tenured-go-server/commons"
+	"github.com/ihaiker/tenured-go-server/commons/breaker"
+	"github.com/ihaiker/tenured-go-server/commons/executors"
 	_ "github.com/ihaiker/tenured-go-server/commons/logs"
+	"github.com/ihaiker/tenured-go-server/commons/mixins"
 	"github.com/ihaiker/tenured-go-server/commons/nets"
 	"github.com/ihaiker/tenured-go-server/commons/remoting"
 	"github.com/ihaiker/tenured-go-server/commons/runtime"
+	"github.com/ihaiker/tenured-go-server/protocol"
+	"github.com/ihaiker/tenured-go-server/registry"
+	"github.com/ihaiker/tenured-go-server/registry/health"
 	"github.com/sirupsen/logrus"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 type Registry struct {
 	Address string `json:"address" yaml:"address"`
 	//注册服务与注册中心的参数配置
 	Attributes map[string]string `json:"attributes,omitempty" yaml:"attributes,omitempty"`
+	//注册服务实例的附加属性，例如：weight、zone
+	Metadata map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+}
+
+//合并配置的附加属性，没有配置可用区时使用环境变量 TENURED_ZONE
+func (this *Registry) InstanceMetadata(metadata map[string]string) map[string]string {
+	for key, value := range this.Metadata {
+		if _, has := metadata[key]; !has {
+			metadata[key] = value
+		}
+	}
+	if _, has := metadata[registry.MetadataZone]; !has {
+		if zone := mixins.Get(mixins.KeyZone, ""); zone != "" {
+			metadata[registry.MetadataZone] = zone
+		}
+	}
+	return metadata
 }
 
 type Tcp struct {
@@ -27,6 +51,93 @@ type Tcp struct {
 	Attributes map[string]string `json:"attributes,omitempty" yaml:"attributes,omitempty"`
 }
 
+//模块间认证配置
+type Auth struct {
+	//共享密钥，所有模块保持一致。配置为空时使用环境变量 TENURED_SECRET（tenured.secret），
+	//都为空时不校验模块身份，仅用于开发环境
+	Secret string `json:"secret" yaml:"secret"`
+
+	//签名时间允许的偏差，SECONDS
+	Skew int `json:"skew" yaml:"skew"`
+
+	//允许连接的模块和可以调用的请求码范围，为空时不限制。例如 "tenured_linker": ["3000-3999"]。
+	//配置了允许列表但是没有共享密钥时启动失败，不会静默关闭认证
+	Allow map[string][]string `json:"allow,omitempty" yaml:"allow,omitempty"`
+}
+
+func NewAuth() *Auth {
+	return &Auth{
+		Secret: mixins.Get(mixins.KeyAuthSecret, ""),
+		Skew:   300,
+	}
+}
+
+//配置的共享密钥，为空时使用环境变量
+func (this *Auth) secret() string {
+	if this != nil && this.Secret != "" {
+		return this.Secret
+	}
+	return mixins.Get(mixins.KeyAuthSecret, "")
+}
+
+//服务端使用的认证检查器
+func (this *Auth) Checker() (protocol.TenuredAuthChecker, error) {
+	if this == nil {
+		this = &Auth{}
+	}
+	secret := this.secret()
+	if secret == "" {
+		if len(this.Allow) > 0 {
+			return nil, errors.New("auth allow list is configured but secret is empty, set auth.secret or TENURED_SECRET")
+		}
+		logrus.Warn("auth secret is empty, any module can access this server")
+		return &protocol.ModuleAuthChecker{}, nil
+	}
+	return protocol.NewHmacAuthChecker(secret, time.Duration(this.Skew)*time.Second, this.Allow)
+}
+
+//设置当前模块调用其他模块时使用的凭证
+func (this *Auth) Credential(module string) {
+	protocol.SetModuleCredential(module, this.secret())
+}
+
+//服务健康检查配置，检查结果定时上报注册中心（consul需要使用ttl检查方式）
+type Health struct {
+	//检查并上报的间隔，SECONDS
+	Interval int `json:"interval" yaml:"interval"`
+
+	//执行队列使用率超过此百分比时为warn
+	QueueWarn int `json:"queueWarn" yaml:"queueWarn"`
+
+	//执行队列使用率超过此百分比时为fail
+	QueueFail int `json:"queueFail" yaml:"queueFail"`
+}
+
+func NewHealth() *Health {
+	return &Health{
+		Interval:  5,
+		QueueWarn: 80,
+		QueueFail: 100,
+	}
+}
+
+//创建健康检查，默认检查执行队列和注册中心是否可以访问
+func (this *Health) Checker(manager executors.ExecutorManager, reg registry.ServiceRegistry, serverName string) *health.HealthChecker {
+	config := NewHealth()
+	if this != nil {
+		config = this
+	}
+	interval := time.Duration(config.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	checker := health.NewHealthChecker(interval)
+	checker.Add("executors", health.Executors(manager, config.QueueWarn, config.QueueFail))
+	checker.Add("registry", health.Registry(reg, serverName))
+	checker.Add("breakers", health.Breakers(breaker.Default()))
+	return checker
+}
+
 type ExecutorParam struct {
 	Type  string
 	Param []int
@@ -1,6 +1,7 @@
 package console
 
 import (
+	"github.com/ihaiker/tenured-go-server/commons/breaker"
 	"github.com/ihaiker/tenured-go-server/commons/mixins"
 	"github.com/ihaiker/tenured-go-server/commons/nets"
 	"github.com/ihaiker/tenured-go-server/engine"
@@ -19,6 +20,10 @@ type ConsoleConfig struct {
 	Registry *services.Registry `json:"registry" yaml:"registry"` //注册中心
 
 	StoreClient *engine.StoreEngineConfig `json:"storeClient" yaml:"storeClient"`
+
+	Auth *services.Auth `json:"auth" yaml:"auth"` //调用store时使用的模块凭证
+
+	Breaker *breaker.Config `json:"breaker" yaml:"breaker"` //调用store的熔断器
 }
 
 func NewConsoleConfig() *ConsoleConfig {
@@ -44,5 +49,7 @@ func NewConsoleConfig() *ConsoleConfig {
 		StoreClient: &engine.StoreEngineConfig{
 			Type: "leveldb",
 		},
+		Auth:    services.NewAuth(),
+		Breaker: breaker.DefaultConfig(),
 	}
 }
@@ -1,9 +1,12 @@
 package ctl
 
 import (
+	"encoding/json"
+
 	"github.com/ihaiker/tenured-go-server/api"
 	"github.com/ihaiker/tenured-go-server/commons"
 	"github.com/ihaiker/tenured-go-server/protocol"
+	"github.com/ihaiker/tenured-go-server/registry/load_balance"
 	"github.com/kataras/iris/context"
 )
 
@@ -78,11 +81,29 @@ func applyApp(ctx context.Context) {
 	}
 }
 
+//导出账户，每行一个JSON，逐个节点流式读取，不受分页大小限制
+func exportAccount(ctx context.Context) {
+	search := &api.Search{Status: api.AccountStatus(ctx.URLParam("status"))}
+	ctx.ContentType("application/x-ndjson")
+	encoder := json.NewEncoder(ctx)
+	gl := &load_balance.GlobalLoading{}
+	for gl.NextNode() {
+		if err := accountService.ScanAccounts(gl, search, func(account *api.Account) error {
+			account.Password = ""
+			return encoder.Encode(account)
+		}); err != nil {
+			logger.Error("export account error: ", err)
+			return
+		}
+	}
+}
+
 func init() {
 	accountServer := app.Party("/account")
 	{
 		accountServer.Post("/apply", applyAccount)
 		accountServer.Get("/mobile/{mobile}", mobileAccount)
+		accountServer.Get("/export", exportAccount)
 	}
 	appServer := app.Party("/app")
 	{
@@ -3,11 +3,13 @@ package ctl
 import (
 	"context"
 	"github.com/ihaiker/tenured-go-server/protocol"
+	"github.com/ihaiker/tenured-go-server/registry"
 	"github.com/ihaiker/tenured-go-server/registry/load_balance"
 
 	"github.com/ihaiker/tenured-go-server/api"
 	"github.com/ihaiker/tenured-go-server/api/client"
 	"github.com/ihaiker/tenured-go-server/commons"
+	"github.com/ihaiker/tenured-go-server/commons/breaker"
 	"github.com/ihaiker/tenured-go-server/commons/logs"
 	"github.com/kataras/iris"
 	ctx "github.com/kataras/iris/context"
@@ -20,6 +22,7 @@ var logger = logs.GetLogger("iris")
 var accountService api.AccountService
 var clusterIdService api.ClusterIdService
 var userService api.UserService
+var storeMigration *migrationStatus
 
 type HttpServer struct {
 	http           string
@@ -27,7 +30,7 @@ type HttpServer struct {
 }
 
 func allService() []interface{} {
-	return []interface{}{accountService, clusterIdService, userService}
+	return []interface{}{accountService, clusterIdService, userService, storeMigration}
 }
 
 func (this *HttpServer) startService() (err error) {
@@ -58,6 +61,10 @@ func (this *HttpServer) Start() (err error) {
 	app.Get("/health", func(ctx ctx.Context) {
 		ctx.JSON(map[string]interface{}{"status": "UP"})
 	})
+	//远程调用各节点熔断器状态
+	app.Get("/breakers", func(ctx ctx.Context) {
+		writeJson(ctx, breaker.Default().Stats())
+	})
 
 	startErr := make(chan error, 0)
 	go func() {
@@ -92,10 +99,11 @@ func (this *HttpServer) Shutdown(interrupt bool) {
 	}
 }
 
-func NewHttpServer(http string, storeClientLoadBalance load_balance.LoadBalance) *HttpServer {
+func NewHttpServer(http string, storeClientLoadBalance load_balance.LoadBalance, reg registry.ServiceRegistry, storeName string) *HttpServer {
 	accountService = client.NewAccountServiceClient(storeClientLoadBalance)
 	clusterIdService = client.NewClusterIdServiceClient(storeClientLoadBalance)
 	userService = client.NewUserServiceClient(storeClientLoadBalance)
+	storeMigration = newMigrationStatus(reg, storeName)
 
 	return &HttpServer{http: http}
 }
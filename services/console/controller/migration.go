@@ -0,0 +1,72 @@
+package ctl
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/engine/replica"
+	"github.com/ihaiker/tenured-go-server/protocol"
+	"github.com/ihaiker/tenured-go-server/registry"
+	"github.com/kataras/iris/context"
+)
+
+//存储节点的迁移进度
+type storeMigrateStatus struct {
+	Id         string                   `json:"id"`
+	Address    string                   `json:"address"`
+	Migrations []*replica.MigrateStatus `json:"migrations"`
+	Error      string                   `json:"error,omitempty"`
+}
+
+//逐个存储节点查询数据迁移进度
+type migrationStatus struct {
+	reg       registry.ServiceRegistry
+	storeName string
+	client    *protocol.TenuredClientInvoke
+}
+
+func (this *migrationStatus) query() ([]*storeMigrateStatus, error) {
+	instances, err := this.reg.Lookup(this.storeName, nil)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]*storeMigrateStatus, 0, len(instances))
+	for _, instance := range instances {
+		if !registry.IsOK(instance) {
+			continue
+		}
+		status := &storeMigrateStatus{Id: instance.Id, Address: instance.Address}
+		if body, err := this.client.Invoke(registry.Local(instance), replica.REQUEST_CODE_MIGRATE_STATUS,
+			nil, nil, time.Second*3, nil); err != nil {
+			status.Error = err.Error()
+		} else if err := json.Unmarshal(body, &status.Migrations); err != nil {
+			status.Error = err.Error()
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func (this *migrationStatus) Start() error {
+	return this.client.Start()
+}
+
+func (this *migrationStatus) Shutdown(interrupt bool) {
+	this.client.Shutdown(interrupt)
+}
+
+func newMigrationStatus(reg registry.ServiceRegistry, storeName string) *migrationStatus {
+	return &migrationStatus{reg: reg, storeName: storeName, client: protocol.NewClientInvoke()}
+}
+
+func migrations(ctx context.Context) {
+	if statuses, err := storeMigration.query(); err != nil {
+		writeJson(ctx, err)
+	} else {
+		writeJson(ctx, statuses)
+	}
+}
+
+func init() {
+	app.Get("/migrations", migrations)
+}
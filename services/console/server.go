@@ -3,6 +3,7 @@ package console
 import (
 	"fmt"
 	"github.com/ihaiker/tenured-go-server/commons"
+	"github.com/ihaiker/tenured-go-server/commons/breaker"
 	"github.com/ihaiker/tenured-go-server/commons/mixins"
 	"github.com/ihaiker/tenured-go-server/engine"
 	"github.com/ihaiker/tenured-go-server/registry"
@@ -55,6 +56,8 @@ func (this *ConsoleServer) startRegistry() error {
 }
 
 func (this *ConsoleServer) initClientPlugin() error {
+	this.config.Auth.Credential(mixins.Console(this.config.Prefix))
+	breaker.Default().SetConfig(this.config.Breaker)
 	storeName := this.config.Prefix + "_store"
 	if clientPlugin, err := engine.GetStoreClientPlugin(storeName, this.config.StoreClient, this.reg); err != nil {
 		return err
@@ -70,7 +73,7 @@ func (this *ConsoleServer) initHttpServer() error {
 	if err != nil {
 		return err
 	}
-	this.httpServer = ctl.NewHttpServer(httpAddress, this.storeClientLoadBalance)
+	this.httpServer = ctl.NewHttpServer(httpAddress, this.storeClientLoadBalance, this.reg, mixins.Store(this.config.Prefix))
 	this.serviceManager.Add(this.httpServer)
 	return nil
 }
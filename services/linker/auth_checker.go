@@ -22,17 +22,28 @@ type Auth struct {
 
 	//云用户ID
 	CloudId uint64 `json:"cloudId"`
+
+	//会话恢复票据，断线重连时使用，票据有效时不再校验Token
+	Ticket string `json:"ticket,omitempty"`
+
+	//客户端最后收到的推送序号，恢复会话时补发之后的推送
+	Sequence uint64 `json:"seq,omitempty"`
+
+	//客户端提议的心跳间隔，SECONDS。见 protocol.HeartbeatHeader
+	Heartbeat int `json:"heartbeat,omitempty"`
 }
 
 type LinkerAuthChecker struct {
 	serverAddress string
 	userServer    api.UserService
+	sessions      *SessionManager
 }
 
-func NewLinkerAuthChecker(serverAddress string, loadBalance load_balance.LoadBalance) (*LinkerAuthChecker, error) {
+func NewLinkerAuthChecker(serverAddress string, loadBalance load_balance.LoadBalance, sessions *SessionManager) (*LinkerAuthChecker, error) {
 	s := &LinkerAuthChecker{
 		serverAddress: serverAddress,
 		userServer:    client.NewUserServiceClient(loadBalance),
+		sessions:      sessions,
 	}
 	return s, nil
 }
@@ -42,6 +53,17 @@ func (this *LinkerAuthChecker) Auth(channel remoting.RemotingChannel, command *p
 	if err := command.GetHeader(auth); err != nil {
 		return ErrAuth
 	}
+	//使用连接的真实地址，linker部署在负载均衡后面时为 PROXY 头信息中的地址
+	auth.Address = channel.ClientAddr()
+	if auth.Ticket != "" {
+		if this.sessions.resume(channel, auth) {
+			logger.Debug("用户恢复会话：", auth)
+			auth.Ticket = ""
+			channel.SetAttribute("auth", auth)
+			return nil
+		}
+		logger.Debug("会话票据无效：", auth)
+	}
 	logger.Info("用户认证：", auth)
 
 	if token, err := this.userServer.GetToken(auth.AccountId, auth.AppId, auth.CloudId); err != nil {
@@ -50,12 +72,24 @@ func (this *LinkerAuthChecker) Auth(channel remoting.RemotingChannel, command *p
 		logger.Info("用户非法连接：", auth)
 		return ErrAuth
 	}
-	channel.Attributes()["auth"] = auth
+	auth.Ticket = ""
+	channel.SetAttribute("auth", auth)
+	this.sessions.create(channel, auth)
+	return nil
+}
+
+func (this *LinkerAuthChecker) AuthResponse(channel remoting.RemotingChannel) interface{} {
+	if ticket := this.sessions.ticket(channel); ticket != nil {
+		return ticket
+	}
 	return nil
 }
 
+func (this *LinkerAuthChecker) OnAuthed(channel remoting.RemotingChannel) {
+	this.sessions.attach(channel)
+}
+
 func (this *LinkerAuthChecker) IsAuthed(channel remoting.RemotingChannel) bool {
-	attr := channel.Attributes()
-	_, has := attr["auth"]
+	_, has := channel.GetAttribute("auth")
 	return has
 }
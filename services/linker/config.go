@@ -1,6 +1,7 @@
 package linker
 
 import (
+	"github.com/ihaiker/tenured-go-server/commons/breaker"
 	"github.com/ihaiker/tenured-go-server/commons/mixins"
 	"github.com/ihaiker/tenured-go-server/commons/nets"
 	"github.com/ihaiker/tenured-go-server/commons/remoting"
@@ -24,6 +25,33 @@ type linkerConfig struct {
 	Executors map[string]string `json:"executors" yaml:"executors"`
 
 	Engine *engine.StoreEngineConfig `json:"engine" yaml:"engine"`
+
+	//优雅下线时等待客户端转移到其他linker的时间，SECONDS。小于等于0直接关闭
+	DrainTimeout int `json:"drainTimeout" yaml:"drainTimeout"`
+
+	//连接断开后会话恢复票据的有效时间，SECONDS。小于等于0不支持会话恢复
+	ResumeTicketTTL int `json:"resumeTicketTTL" yaml:"resumeTicketTTL"`
+
+	//每个会话在内存中缓存的推送条数（等待确认和等待发送），超过后直接进入离线存储
+	ResumeBuffer int `json:"resumeBuffer" yaml:"resumeBuffer"`
+
+	//推送发送窗口，最多允许多少条推送等待客户端确认
+	PushWindow int `json:"pushWindow" yaml:"pushWindow"`
+
+	//推送确认超时时间，超时后使用相同的序号重发，SECONDS
+	PushRetransmit int `json:"pushRetransmit" yaml:"pushRetransmit"`
+
+	//推送最大重发次数，超过后认为客户端失去响应，关闭连接
+	PushRetries int `json:"pushRetries" yaml:"pushRetries"`
+
+	//调用store时使用的模块凭证
+	Auth *services.Auth `json:"auth" yaml:"auth"`
+
+	//健康检查
+	Health *services.Health `json:"health" yaml:"health"`
+
+	//调用store的熔断器
+	Breaker *breaker.Config `json:"breaker" yaml:"breaker"`
 }
 
 func NewLinkerConfig() *linkerConfig {
@@ -42,11 +70,27 @@ func NewLinkerConfig() *linkerConfig {
 			IpAndPort: &nets.IpAndPort{
 				Port: mixins.PortLinker,
 			},
-			RemotingConfig: remoting.DefaultConfig(),
+			RemotingConfig: linkerRemotingConfig(),
 		},
 		Engine: &engine.StoreEngineConfig{
 			Type: "leveldb",
 		},
-		Executors: map[string]string{},
+		Executors:       map[string]string{},
+		DrainTimeout:    30,
+		ResumeTicketTTL: 60,
+		ResumeBuffer:    64,
+		PushWindow:      16,
+		PushRetransmit:  5,
+		PushRetries:     3,
+		Auth:            services.NewAuth(),
+		Health:          services.NewHealth(),
+		Breaker:         breaker.DefaultConfig(),
 	}
 }
+
+//linker面向大量终端连接，慢连接直接断开，避免阻塞投递消息的线程
+func linkerRemotingConfig() *remoting.RemotingConfig {
+	config := remoting.DefaultConfig()
+	config.OverflowPolicy = remoting.OverflowDisconnect
+	return config
+}
@@ -0,0 +1,72 @@
+package linker
+
+import (
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/ihaiker/tenured-go-server/protocol"
+	"github.com/ihaiker/tenured-go-server/registry"
+)
+
+//优雅下线：先从注册中心删除当前节点，然后通知所有客户端重新连接到其他的linker，
+//等待客户端断开或者超过DrainTimeout后再关闭剩余的连接
+func (this *LinkerServer) drain() {
+	if this.serverInstance == nil || this.server == nil || this.config.DrainTimeout <= 0 {
+		return
+	}
+	logger.Info("drain linker server: ", this.address)
+	//先停止健康上报，防止注册中心不支持上报时又重新注册
+	this.healthChecker.Shutdown(true)
+	if err := this.reg.Unregister(this.serverInstance.Id); err != nil {
+		logger.Warn("unregister linker error: ", err)
+	}
+
+	sessionManager := this.server.GetSessionManager()
+	channels := sessionManager.Filter(func(channel remoting.RemotingChannel) bool {
+		return true
+	})
+	//没有其他linker时不发送重定向，依然等待客户端自己断开
+	peers := this.healthyPeers()
+	if len(peers) == 0 {
+		logger.Warn("not found healthy linker to redirect clients")
+		channels = nil
+	}
+	for idx, channel := range channels {
+		peer := peers[idx%len(peers)]
+		if err := channel.Write(protocol.NewRedirect(peer), time.Second); err != nil {
+			logger.Debugf("send redirect to %s error: %s", channel.RemoteAddr(), err)
+		}
+	}
+
+	timeout := time.After(time.Duration(this.config.DrainTimeout) * time.Second)
+	for sessionManager.Size() > 0 {
+		select {
+		case <-timeout:
+			logger.Warnf("drain timeout, %d clients are still connected", sessionManager.Size())
+			return
+		case <-time.After(time.Millisecond * 500):
+		}
+	}
+	logger.Info("all clients have left")
+}
+
+//其他正常的linker对外地址
+func (this *LinkerServer) healthyPeers() []string {
+	instances, err := this.reg.Lookup(this.serverInstance.Name, nil)
+	if err != nil {
+		logger.Warn("lookup linker error: ", err)
+		return nil
+	}
+	peers := make([]string, 0, len(instances))
+	for _, instance := range instances {
+		if instance.Id == this.serverInstance.Id || instance.Status != registry.StatusOK {
+			continue
+		}
+		if external, has := instance.Metadata["external"]; has && external != "" {
+			peers = append(peers, external)
+		} else {
+			peers = append(peers, instance.Address)
+		}
+	}
+	return peers
+}
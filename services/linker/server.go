@@ -2,17 +2,21 @@ package linker
 
 import (
 	"fmt"
+	"github.com/ihaiker/tenured-go-server/api/client"
 	"github.com/ihaiker/tenured-go-server/api/invoke"
 	"github.com/ihaiker/tenured-go-server/commons"
+	"github.com/ihaiker/tenured-go-server/commons/breaker"
 	"github.com/ihaiker/tenured-go-server/commons/executors"
 	"github.com/ihaiker/tenured-go-server/commons/mixins"
 	"github.com/ihaiker/tenured-go-server/engine"
 	"github.com/ihaiker/tenured-go-server/protocol"
 	"github.com/ihaiker/tenured-go-server/registry"
 	"github.com/ihaiker/tenured-go-server/registry/cache"
+	"github.com/ihaiker/tenured-go-server/registry/health"
 	"github.com/ihaiker/tenured-go-server/registry/load_balance"
 	"github.com/ihaiker/tenured-go-server/registry/plugins"
 	"hash/crc64"
+	"time"
 )
 
 type LinkerServer struct {
@@ -24,9 +28,11 @@ type LinkerServer struct {
 	serviceManager  commons.ServiceManager
 	executorManager executors.ExecutorManager
 
+	serverInstance    *registry.ServerInstance
 	registryPlugin    registry.Plugins
 	storeClientPlugin engine.StoreClientPlugin
 	clientLoadBalance load_balance.LoadBalance
+	healthChecker     *health.HealthChecker
 }
 
 func NewLinkerServer(config *linkerConfig) *LinkerServer {
@@ -34,6 +40,8 @@ func NewLinkerServer(config *linkerConfig) *LinkerServer {
 }
 
 func (this *LinkerServer) initStoreClientPlugin() (err error) {
+	this.config.Auth.Credential(mixins.Linker(this.config.Prefix))
+	breaker.Default().SetConfig(this.config.Breaker)
 	storeServerName := this.config.Prefix + "_store"
 	if this.storeClientPlugin, err = engine.GetStoreClientPlugin(storeServerName, this.config.Engine, this.reg); err != nil {
 		return err
@@ -56,11 +64,16 @@ func (this *LinkerServer) initTenuredServer() (err error) {
 		Module:  mixins.Linker(this.config.Prefix),
 		Address: this.address,
 	}
-	if this.server.AuthChecker, err = NewLinkerAuthChecker(this.address, this.clientLoadBalance); err != nil {
+	sessionManager := NewSessionManager(time.Duration(this.config.ResumeTicketTTL)*time.Second, this.config.ResumeBuffer)
+	sessionManager.SetPushWindow(this.config.PushWindow, time.Duration(this.config.PushRetransmit)*time.Second, this.config.PushRetries)
+	sessionManager.SetMessageService(client.NewMessageServiceClient(this.clientLoadBalance))
+	if this.server.AuthChecker, err = NewLinkerAuthChecker(this.address, this.clientLoadBalance, sessionManager); err != nil {
 		return err
 	}
-	this.server.SetSessionManager(protocol.NewMapSessionManager())
-	this.serviceManager.Add(this.server)
+	this.server.SetSessionManager(sessionManager)
+	this.server.RegisterCommandProcesser(REQUEST_CODE_PUSH_ACK, sessionManager.onAck, this.executorManager.Get("PushAck"))
+	this.server.SetHealthChecker(this.healthChecker)
+	this.serviceManager.Add(sessionManager, this.server)
 	return nil
 }
 
@@ -81,8 +94,10 @@ func (this *LinkerServer) initRegistry() error {
 	if reg, err := registryPlugins.Registry(); err != nil {
 		return err
 	} else {
-		this.reg = cache.NewCacheRegistry(reg)
+		this.reg = cache.NewPersistentCacheRegistry(reg, this.config.Data+"/registry_linker.cache")
 		this.serviceManager.Add(this.reg)
+		//注册中心是否可用需要直接访问，不能使用缓存
+		this.healthChecker = this.config.Health.Checker(this.executorManager, reg, this.config.Prefix+"_linker")
 	}
 	this.registryPlugin = registryPlugins
 	this.serviceManager.Add(this.registryPlugin)
@@ -101,12 +116,14 @@ func (this *LinkerServer) registryServer() error {
 		serverInstance.Name = serverName
 		serverInstance.Id = fmt.Sprintf("%v", crc64.Checksum([]byte(this.address), crc64.MakeTable(crc64.ECMA)))
 		serverInstance.Address = this.address
-		serverInstance.Metadata = map[string]string{
+		serverInstance.Metadata = this.config.Registry.InstanceMetadata(map[string]string{
 			"external": external,
-		}
+		})
 		if err := this.reg.Register(serverInstance); err != nil {
 			return err
 		}
+		this.healthChecker.Report(this.reg, serverInstance)
+		this.serverInstance = serverInstance
 		return nil
 	}
 }
@@ -126,15 +143,16 @@ func (this *LinkerServer) Start() (err error) {
 	if err = this.initRegistry(); err != nil {
 		return
 	}
-	if err = this.initTenuredServer(); err != nil {
+	if err = this.initStoreClientPlugin(); err != nil {
 		return
 	}
-	if err = this.initStoreClientPlugin(); err != nil {
+	if err = this.initTenuredServer(); err != nil {
 		return
 	}
 	if err = this.registryCommandHandler(); err != nil {
 		return
 	}
+	this.serviceManager.Add(this.healthChecker)
 	if err = this.serviceManager.Start(); err != nil {
 		return
 	}
@@ -146,5 +164,8 @@ func (this *LinkerServer) Start() (err error) {
 
 func (this *LinkerServer) Shutdown(interrupt bool) {
 	logger.Info("shutdown linker server")
+	if !interrupt {
+		this.drain()
+	}
 	this.serviceManager.Shutdown(interrupt)
 }
@@ -0,0 +1,586 @@
+package linker
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ihaiker/tenured-go-server/api"
+	"github.com/ihaiker/tenured-go-server/commons"
+	"github.com/ihaiker/tenured-go-server/commons/c8tmap"
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/ihaiker/tenured-go-server/protocol"
+)
+
+//下行推送的请求码，头信息为 PushHeader，推送内容在body中
+const REQUEST_CODE_PUSH = uint16(4100)
+
+//客户端确认推送的请求码（oneway），头信息为 PushHeader，序号为客户端已经连续处理的最大序号（累计确认）
+const REQUEST_CODE_PUSH_ACK = uint16(4101)
+
+//推送序号，同一个会话内递增。超时重发的推送使用相同的序号，客户端丢弃小于等于已处理序号的推送
+type PushHeader struct {
+	Sequence uint64 `json:"seq"`
+}
+
+//认证成功后返回给客户端的会话恢复票据
+type ResumeTicket struct {
+	Ticket string `json:"ticket"`
+	//连接断开后票据的有效时间，SECONDS
+	TTL int `json:"ttl"`
+	//当前会话最后的推送序号
+	Sequence uint64 `json:"seq"`
+	//协商后的心跳间隔，SECONDS。为0时使用服务端心跳
+	Heartbeat int `json:"heartbeat,omitempty"`
+}
+
+type pushed struct {
+	sequence uint64
+	body     []byte
+	//离线消息ID，已经保存到离线存储时不为0，客户端确认后删除
+	offline uint64
+	//最后一次写出的时间和重发次数
+	sentAt  time.Time
+	retries int
+}
+
+//用户会话。推送按照滑动窗口发送，客户端累计确认，超时未确认的推送重发。
+//连接断开时未确认的推送保存到离线存储，会话保留 TTL 时间，客户端使用票据重连后补发，否则在下次认证后从离线存储补发
+type session struct {
+	manager *SessionManager
+
+	mutex  sync.Mutex
+	ticket string
+	auth   *Auth
+
+	sequence uint64
+	//客户端确认的最大序号
+	acked uint64
+	//已经写出等待确认的推送，序号递增
+	unacked []*pushed
+	//窗口已满或者连接断开时等待写出的推送
+	pending []*pushed
+	//离线存储中还有没有加载的消息
+	backlog bool
+
+	channel remoting.RemotingChannel
+	//会话恢复时客户端已经处理的序号，认证回复写出后作为确认处理
+	replayFrom uint64
+	retransmit *time.Timer
+	expire     *time.Timer
+
+	//连接发送队列超过高水位，暂停写出，新的推送转入离线存储，降到低水位后恢复
+	congested int32
+
+	//等待写出的推送命令。写出时不持有会话锁，同一时间只有一个协程写出，保证按照序号顺序写出
+	outbox  []*protocol.TenuredCommand
+	writing bool
+}
+
+func (this *session) isCongested() bool {
+	return atomic.LoadInt32(&this.congested) == 1
+}
+
+func (this *session) push(body []byte) error {
+	this.mutex.Lock()
+	attached := this.channel != nil
+	full := this.manager.bufferSize > 0 && len(this.unacked)+len(this.pending) >= this.manager.bufferSize
+	this.mutex.Unlock()
+
+	item := &pushed{body: body}
+	congested := this.isCongested()
+	if full || !attached || congested {
+		//连接断开时先保存到离线存储，linker宕机也不会丢失
+		id, err := this.manager.saveOffline(this.auth.CloudId, body)
+		if err != nil {
+			return err
+		}
+		if (full || congested) && id != 0 {
+			//缓存已满或者连接拥塞，等待客户端确认或者恢复后从离线存储加载
+			this.mutex.Lock()
+			this.backlog = true
+			this.mutex.Unlock()
+			return nil
+		} else if full {
+			return &remoting.RemotingError{Op: remoting.ErrOverflow, Err: errors.New("the session push buffer is full")}
+		}
+		item.offline = id
+	}
+
+	this.mutex.Lock()
+	this.sequence++
+	item.sequence = this.sequence
+	this.pending = append(this.pending, item)
+	this.unlockAndWrite(this.flush())
+	return nil
+}
+
+//在窗口允许的范围内取出等待中的推送，调用时持有锁，返回的推送使用 unlockAndWrite 写出。
+//写出失败的推送留在等待确认的队列中，超时后重发，连接断开时保存到离线存储
+func (this *session) flush() []*protocol.TenuredCommand {
+	commands := make([]*protocol.TenuredCommand, 0)
+	for this.channel != nil && !this.isCongested() && len(this.pending) > 0 && len(this.unacked) < this.manager.window {
+		item := this.pending[0]
+		this.pending = this.pending[1:]
+		this.unacked = append(this.unacked, item)
+		commands = append(commands, this.command(item))
+	}
+	if len(this.unacked) > 0 && this.retransmit == nil {
+		this.retransmit = time.AfterFunc(this.manager.retransmit, this.onRetransmit)
+	}
+	return commands
+}
+
+//推送命令，调用时持有锁
+func (this *session) command(item *pushed) *protocol.TenuredCommand {
+	command := protocol.NewRequest(REQUEST_CODE_PUSH).MakeOneway()
+	command.SetSafeHeader(&PushHeader{Sequence: item.sequence})
+	command.Body = item.body
+	item.sentAt = time.Now()
+	return command
+}
+
+//加入待写出队列并释放会话锁。发送队列满时写出会等待，不能持有会话锁阻塞确认和新的推送；
+//已经有协程在写出时由它继续写出，保证推送按照加入的顺序写出
+func (this *session) unlockAndWrite(commands []*protocol.TenuredCommand) {
+	this.outbox = append(this.outbox, commands...)
+	if this.writing || len(this.outbox) == 0 {
+		this.mutex.Unlock()
+		return
+	}
+	this.writing = true
+	for len(this.outbox) > 0 {
+		channel, batch := this.channel, this.outbox
+		this.outbox = nil
+		this.mutex.Unlock()
+		for _, command := range batch {
+			if channel == nil {
+				break
+			}
+			if err := channel.Write(command, this.manager.writeTimeout); err != nil {
+				logger.Debugf("push to %s error: %s", channel.ClientAddr(), err)
+				break
+			}
+		}
+		this.mutex.Lock()
+	}
+	this.writing = false
+	this.mutex.Unlock()
+}
+
+//客户端累计确认，返回已经确认的离线消息ID。重复和超出范围的确认直接忽略，调用时持有锁
+func (this *session) acknowledge(sequence uint64) []uint64 {
+	if sequence <= this.acked || sequence > this.sequence {
+		return nil
+	}
+	this.acked = sequence
+	offline := make([]uint64, 0)
+	idx := 0
+	for ; idx < len(this.unacked) && this.unacked[idx].sequence <= sequence; idx++ {
+		if this.unacked[idx].offline != 0 {
+			offline = append(offline, this.unacked[idx].offline)
+		}
+	}
+	this.unacked = this.unacked[idx:]
+	//会话恢复时客户端可能已经处理了等待中的推送
+	idx = 0
+	for ; idx < len(this.pending) && this.pending[idx].sequence <= sequence; idx++ {
+		if this.pending[idx].offline != 0 {
+			offline = append(offline, this.pending[idx].offline)
+		}
+	}
+	this.pending = this.pending[idx:]
+	return offline
+}
+
+func (this *session) ack(sequence uint64) {
+	this.mutex.Lock()
+	offline := this.acknowledge(sequence)
+	commands := this.flush()
+	load := this.backlog && this.channel != nil && !this.isCongested() && len(this.unacked) == 0 && len(this.pending) == 0
+	this.unlockAndWrite(commands)
+
+	this.manager.removeOffline(this.auth.CloudId, offline)
+	if load {
+		this.load()
+	}
+}
+
+//连接发送队列降到低水位，继续写出等待中的推送和离线存储中的消息
+func (this *session) uncongest(channel remoting.RemotingChannel) {
+	this.mutex.Lock()
+	if this.channel != channel || !atomic.CompareAndSwapInt32(&this.congested, 1, 0) {
+		this.mutex.Unlock()
+		return
+	}
+	commands := this.flush()
+	load := this.backlog && len(this.unacked) == 0 && len(this.pending) == 0
+	this.unlockAndWrite(commands)
+
+	if load {
+		this.load()
+	}
+}
+
+//超时未确认的推送使用相同的序号重发，超过重试次数认为客户端已经失去响应，关闭连接
+func (this *session) onRetransmit() {
+	this.mutex.Lock()
+	this.retransmit = nil
+	if this.channel == nil || len(this.unacked) == 0 {
+		this.mutex.Unlock()
+		return
+	}
+	//拥塞时推送还在发送队列中，不计入重发次数
+	if this.isCongested() {
+		this.retransmit = time.AfterFunc(this.manager.retransmit, this.onRetransmit)
+		this.mutex.Unlock()
+		return
+	}
+	now := time.Now()
+	commands := make([]*protocol.TenuredCommand, 0)
+	for _, item := range this.unacked {
+		if now.Sub(item.sentAt) < this.manager.retransmit {
+			continue
+		}
+		if item.retries >= this.manager.retries {
+			logger.Infof("push %d to %s not acked after %d retries, close it", item.sequence, this.channel.ClientAddr(), item.retries)
+			channel := this.channel
+			this.mutex.Unlock()
+			channel.Close()
+			return
+		}
+		item.retries++
+		commands = append(commands, this.command(item))
+	}
+	this.retransmit = time.AfterFunc(this.manager.retransmit, this.onRetransmit)
+	this.unlockAndWrite(commands)
+}
+
+//绑定连接，未确认的推送全部重新写出
+func (this *session) attach(channel remoting.RemotingChannel) {
+	this.mutex.Lock()
+	this.channel = channel
+	//之前连接上没有写出的推送都在未确认的队列中，下面全部重新写出
+	this.outbox = nil
+	atomic.StoreInt32(&this.congested, 0)
+	offline := this.acknowledge(this.replayFrom)
+	if this.replayFrom > this.acked {
+		logger.Infof("session %s acked %d greater than sequence %d", channel.ClientAddr(), this.replayFrom, this.sequence)
+	}
+	this.pending = append(this.unacked, this.pending...)
+	this.unacked = make([]*pushed, 0, this.manager.window)
+	for _, item := range this.pending {
+		item.retries = 0
+	}
+	commands := this.flush()
+	load := this.backlog && len(this.unacked) == 0 && len(this.pending) == 0
+	this.unlockAndWrite(commands)
+
+	this.manager.removeOffline(this.auth.CloudId, offline)
+	if load {
+		this.load()
+	}
+}
+
+//连接断开，还没有保存的推送写入离线存储，恢复会话时依然从内存补发
+func (this *session) detach(channel remoting.RemotingChannel) bool {
+	this.mutex.Lock()
+	if this.channel != nil && this.channel != channel {
+		this.mutex.Unlock()
+		return false
+	}
+	this.channel = nil
+	this.outbox = nil
+	if this.retransmit != nil {
+		this.retransmit.Stop()
+		this.retransmit = nil
+	}
+	items := make([]*pushed, 0, len(this.unacked)+len(this.pending))
+	for _, item := range append(this.unacked, this.pending...) {
+		if item.offline == 0 {
+			items = append(items, item)
+		}
+	}
+	this.mutex.Unlock()
+
+	if len(items) > 0 {
+		go this.store(items)
+	}
+	return true
+}
+
+//保存时会话可能已经恢复，客户端在保存完成前确认的推送保存后立即删除，避免再次从离线存储补发
+func (this *session) store(items []*pushed) {
+	for _, item := range items {
+		id, err := this.manager.saveOffline(this.auth.CloudId, item.body)
+		if err != nil {
+			logger.Warnf("save offline message of %d error: %s", this.auth.CloudId, err)
+			return
+		}
+		this.mutex.Lock()
+		item.offline = id
+		acked := item.sequence <= this.acked
+		this.mutex.Unlock()
+		if acked && id != 0 {
+			this.manager.removeOffline(this.auth.CloudId, []uint64{id})
+		}
+	}
+}
+
+//从离线存储加载消息，使用新的序号推送
+func (this *session) load() {
+	if this.manager.messages == nil {
+		return
+	}
+	messages, err := this.manager.messages.Fetch(this.auth.CloudId, this.manager.bufferSize)
+	if err != nil {
+		logger.Warnf("fetch offline message of %d error: %s", this.auth.CloudId, err)
+		return
+	}
+
+	this.mutex.Lock()
+	this.backlog = this.manager.bufferSize > 0 && len(messages.Messages) >= this.manager.bufferSize
+	for _, message := range messages.Messages {
+		this.sequence++
+		this.pending = append(this.pending, &pushed{sequence: this.sequence, body: message.Body, offline: message.Id})
+	}
+	this.unlockAndWrite(this.flush())
+}
+
+type SessionManager struct {
+	protocol.SessionManager
+
+	//票据有效时间，小于等于0不支持会话恢复
+	ttl time.Duration
+	//每个会话在内存中缓存的推送条数，包括等待确认和等待发送的
+	bufferSize int
+
+	//发送窗口，最多允许多少条推送等待确认
+	window int
+	//推送确认超时时间，超时后重发
+	retransmit time.Duration
+	//最大重发次数，超过后关闭连接
+	retries      int
+	writeTimeout time.Duration
+
+	//离线消息存储，为nil时不使用离线存储
+	messages api.MessageService
+
+	tickets  c8tmap.ConcurrentMap //ticket -> *session
+	channels c8tmap.ConcurrentMap //channel address -> *session
+	users    c8tmap.ConcurrentMap //userKey -> *session
+}
+
+func NewSessionManager(ttl time.Duration, bufferSize int) *SessionManager {
+	return &SessionManager{
+		SessionManager: protocol.NewMapSessionManager(),
+		ttl:            ttl,
+		bufferSize:     bufferSize,
+		window:         16,
+		retransmit:     time.Second * 5,
+		retries:        3,
+		writeTimeout:   time.Second * 3,
+		tickets:        c8tmap.New(),
+		channels:       c8tmap.New(),
+		users:          c8tmap.New(),
+	}
+}
+
+//设置推送窗口、确认超时时间和最大重发次数
+func (this *SessionManager) SetPushWindow(window int, retransmit time.Duration, retries int) {
+	if window > 0 {
+		this.window = window
+	}
+	if retransmit > 0 {
+		this.retransmit = retransmit
+	}
+	if retries >= 0 {
+		this.retries = retries
+	}
+}
+
+func (this *SessionManager) SetMessageService(messages api.MessageService) {
+	this.messages = messages
+}
+
+func (this *SessionManager) saveOffline(cloudId uint64, body []byte) (uint64, error) {
+	if this.messages == nil {
+		return 0, nil
+	}
+	if id, err := this.messages.Save(cloudId, body); err != nil {
+		return 0, err
+	} else {
+		return id.Id, nil
+	}
+}
+
+func (this *SessionManager) removeOffline(cloudId uint64, ids []uint64) {
+	if this.messages == nil {
+		return
+	}
+	for _, id := range ids {
+		if err := this.messages.Remove(cloudId, id); err != nil {
+			logger.Warnf("remove offline message %d of %d error: %s", id, cloudId, err)
+		}
+	}
+}
+
+func userKey(accountId, appId, cloudId uint64) string {
+	return fmt.Sprintf("%d/%d/%d", accountId, appId, cloudId)
+}
+
+func newTicket() string {
+	return strings.ReplaceAll(uuid.New().String(), "-", "")
+}
+
+//认证成功后创建会话，连接在认证回复写出后绑定，同时加载离线消息
+func (this *SessionManager) create(channel remoting.RemotingChannel, auth *Auth) {
+	s := &session{manager: this, auth: auth, backlog: this.messages != nil}
+	if this.ttl > 0 {
+		s.ticket = newTicket()
+		this.tickets.Set(s.ticket, s)
+	}
+	this.channels.Set(channel.RemoteAddr(), s)
+	this.users.Set(userKey(auth.AccountId, auth.AppId, auth.CloudId), s)
+}
+
+//使用票据恢复会话，票据只能使用一次，恢复后重新签发
+func (this *SessionManager) resume(channel remoting.RemotingChannel, auth *Auth) bool {
+	if this.ttl <= 0 {
+		return false
+	}
+	value, has := this.tickets.Pop(auth.Ticket)
+	if !has {
+		return false
+	}
+	s := value.(*session)
+	s.mutex.Lock()
+	if s.auth.AccountId != auth.AccountId || s.auth.AppId != auth.AppId || s.auth.CloudId != auth.CloudId {
+		s.mutex.Unlock()
+		this.tickets.Set(auth.Ticket, s)
+		return false
+	}
+	if s.expire != nil {
+		s.expire.Stop()
+		s.expire = nil
+	}
+	//旧连接还没有检测到断开，直接关闭
+	old := s.channel
+	s.replayFrom = auth.Sequence
+	s.ticket = newTicket()
+	s.mutex.Unlock()
+
+	if old != nil {
+		this.channels.Remove(old.RemoteAddr())
+		s.detach(old)
+		old.Close()
+	}
+	this.tickets.Set(s.ticket, s)
+	this.channels.Set(channel.RemoteAddr(), s)
+	this.users.Set(userKey(auth.AccountId, auth.AppId, auth.CloudId), s)
+	return true
+}
+
+func (this *SessionManager) ticket(channel remoting.RemotingChannel) *ResumeTicket {
+	if value, has := this.channels.Get(channel.RemoteAddr()); has && this.ttl > 0 {
+		s := value.(*session)
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return &ResumeTicket{
+			Ticket: s.ticket, TTL: int(this.ttl / time.Second), Sequence: s.sequence,
+			Heartbeat: protocol.GetHeartbeat(channel),
+		}
+	}
+	return nil
+}
+
+func (this *SessionManager) attach(channel remoting.RemotingChannel) {
+	if value, has := this.channels.Get(channel.RemoteAddr()); has {
+		value.(*session).attach(channel)
+	}
+}
+
+//处理客户端的推送确认
+func (this *SessionManager) onAck(channel remoting.RemotingChannel, request *protocol.TenuredCommand) {
+	header := &PushHeader{}
+	if err := request.GetHeader(header); err != nil {
+		logger.Debugf("push ack header of %s error: %s", channel.ClientAddr(), err)
+		return
+	}
+	if value, has := this.channels.Get(channel.RemoteAddr()); has {
+		value.(*session).ack(header.Sequence)
+	}
+}
+
+//连接发送队列超过高水位，暂停写出，新的推送转入离线存储。在写入连接的协程中调用，不能等待会话锁
+func (this *SessionManager) OnHighWatermark(channel remoting.RemotingChannel, pending int) {
+	if value, has := this.channels.Get(channel.RemoteAddr()); has {
+		if atomic.CompareAndSwapInt32(&value.(*session).congested, 0, 1) {
+			logger.Infof("channel %s is congested, pending %d, divert pushes to offline store", channel.ClientAddr(), pending)
+		}
+	}
+}
+
+//连接发送队列降到低水位，恢复推送
+func (this *SessionManager) OnLowWatermark(channel remoting.RemotingChannel, pending int) {
+	if value, has := this.channels.Get(channel.RemoteAddr()); has {
+		logger.Infof("channel %s resumes pushing, pending %d", channel.ClientAddr(), pending)
+		go value.(*session).uncongest(channel)
+	}
+}
+
+func (this *SessionManager) OnClose(channel remoting.RemotingChannel) {
+	this.SessionManager.OnClose(channel)
+	value, has := this.channels.Pop(channel.RemoteAddr())
+	if !has {
+		return
+	}
+	//写出失败时连接可能在持有会话锁的情况下关闭，会话在单独的协程中处理
+	go this.detach(value.(*session), channel)
+}
+
+func (this *SessionManager) detach(s *session, channel remoting.RemotingChannel) {
+	if !s.detach(channel) {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if this.ttl <= 0 {
+		this.users.RemoveCb(userKey(s.auth.AccountId, s.auth.AppId, s.auth.CloudId), func(key interface{}, v interface{}, exists bool) bool {
+			return exists && v == s
+		})
+		return
+	}
+	ticket, user := s.ticket, userKey(s.auth.AccountId, s.auth.AppId, s.auth.CloudId)
+	s.expire = time.AfterFunc(this.ttl, func() {
+		removeSession := func(key interface{}, v interface{}, exists bool) bool {
+			return exists && v == s
+		}
+		this.tickets.RemoveCb(ticket, removeSession)
+		this.users.RemoveCb(user, removeSession)
+	})
+}
+
+//推送消息给用户，客户端确认前保存在会话中，连接断开时进入离线存储，客户端恢复会话或者重新上线后补发
+func (this *SessionManager) Push(accountId, appId, cloudId uint64, body []byte) error {
+	if value, has := this.users.Get(userKey(accountId, appId, cloudId)); has {
+		return value.(*session).push(body)
+	}
+	if this.messages != nil {
+		_, err := this.saveOffline(cloudId, body)
+		return err
+	}
+	return &remoting.RemotingError{Op: remoting.ErrNoChannel, Err: errors.New("the user session not found")}
+}
+
+func (this *SessionManager) Start() error {
+	return commons.StartIfService(this.messages)
+}
+
+func (this *SessionManager) Shutdown(interrupt bool) {
+	commons.ShutdownIfService(this.messages, interrupt)
+}
@@ -0,0 +1,232 @@
+package linker
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/api"
+	"github.com/ihaiker/tenured-go-server/commons/logs"
+	"github.com/ihaiker/tenured-go-server/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger = logs.GetLogger("linker")
+}
+
+//发送队列超过high时通知高水位，drain清空队列后通知低水位
+type flowChannel struct {
+	manager *SessionManager
+	high    int
+
+	lock      sync.Mutex
+	queue     int
+	written   []*protocol.TenuredCommand
+	congested bool
+}
+
+func (this *flowChannel) RemoteAddr() string                      { return "127.0.0.1:40001" }
+func (this *flowChannel) ClientAddr() string                      { return this.RemoteAddr() }
+func (this *flowChannel) GetAttribute(string) (interface{}, bool) { return nil, false }
+func (this *flowChannel) SetAttribute(string, interface{})        {}
+func (this *flowChannel) SetHeartbeat(time.Duration, bool)        {}
+func (this *flowChannel) Close()                                  {}
+
+func (this *flowChannel) Pending() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.queue
+}
+
+func (this *flowChannel) Write(msg interface{}, timeout time.Duration) error {
+	this.lock.Lock()
+	this.queue++
+	this.written = append(this.written, msg.(*protocol.TenuredCommand))
+	high := !this.congested && this.queue >= this.high
+	if high {
+		this.congested = true
+	}
+	pending := this.queue
+	this.lock.Unlock()
+	if high {
+		this.manager.OnHighWatermark(this, pending)
+	}
+	return nil
+}
+
+func (this *flowChannel) AsyncWrite(msg interface{}, timeout time.Duration, callback func(error)) {
+	callback(this.Write(msg, timeout))
+}
+
+func (this *flowChannel) drain() {
+	this.lock.Lock()
+	this.queue = 0
+	low := this.congested
+	this.congested = false
+	this.lock.Unlock()
+	if low {
+		this.manager.OnLowWatermark(this, 0)
+	}
+}
+
+func (this *flowChannel) sequences() []uint64 {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	sequences := make([]uint64, 0, len(this.written))
+	for _, command := range this.written {
+		header := &PushHeader{}
+		_ = command.GetHeader(header)
+		sequences = append(sequences, header.Sequence)
+	}
+	return sequences
+}
+
+type memoryMessages struct {
+	lock     sync.Mutex
+	id       uint64
+	messages []*api.OfflineMessage
+}
+
+func (this *memoryMessages) Save(cloudId uint64, body []byte) (*api.MessageId, *protocol.TenuredError) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.id++
+	this.messages = append(this.messages, &api.OfflineMessage{Id: this.id, CloudId: cloudId, Body: body})
+	return &api.MessageId{Id: this.id}, nil
+}
+
+func (this *memoryMessages) Fetch(cloudId uint64, limit int) (*api.OfflineMessages, *protocol.TenuredError) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	messages := make([]*api.OfflineMessage, 0, len(this.messages))
+	for _, message := range this.messages {
+		if message.CloudId == cloudId && (limit <= 0 || len(messages) < limit) {
+			messages = append(messages, message)
+		}
+	}
+	return &api.OfflineMessages{Messages: messages}, nil
+}
+
+func (this *memoryMessages) Remove(cloudId uint64, id uint64) *protocol.TenuredError {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for i, message := range this.messages {
+		if message.CloudId == cloudId && message.Id == id {
+			this.messages = append(this.messages[:i], this.messages[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (this *memoryMessages) size() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return len(this.messages)
+}
+
+func TestSessionManager_Watermark(t *testing.T) {
+	messages := &memoryMessages{}
+	manager := NewSessionManager(0, 64)
+	manager.SetPushWindow(16, time.Minute, 3)
+	manager.SetMessageService(messages)
+
+	channel := &flowChannel{manager: manager, high: 2}
+	manager.create(channel, &Auth{AccountId: 1, AppId: 2, CloudId: 3})
+	manager.attach(channel)
+
+	//超过高水位后新的推送转入离线存储，不再写入连接
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, manager.Push(1, 2, 3, []byte("hello")))
+	}
+	assert.Equal(t, []uint64{1, 2}, channel.sequences())
+	assert.Equal(t, 1, messages.size())
+
+	//降到低水位并且客户端确认后从离线存储加载，继续推送
+	channel.drain()
+	manager.onAck(channel, ack(t, 2))
+	assert.Eventually(t, func() bool {
+		return len(channel.sequences()) == 3
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(t, []uint64{1, 2, 3}, channel.sequences())
+
+	channel.drain()
+	assert.Nil(t, manager.Push(1, 2, 3, []byte("world")))
+	assert.Equal(t, []uint64{1, 2, 3, 4}, channel.sequences())
+
+	//确认后删除离线存储中的消息
+	manager.onAck(channel, ack(t, 4))
+	assert.Equal(t, 0, messages.size())
+}
+
+func ack(t *testing.T, sequence uint64) *protocol.TenuredCommand {
+	command := protocol.NewRequest(REQUEST_CODE_PUSH_ACK)
+	assert.Nil(t, command.SetHeader(&PushHeader{Sequence: sequence}))
+	return command
+}
+
+//写出时发送队列已满会等待，等待期间不持有会话锁
+type blockingChannel struct {
+	flowChannel
+	release chan struct{}
+}
+
+func (this *blockingChannel) Write(msg interface{}, timeout time.Duration) error {
+	<-this.release
+	return this.flowChannel.Write(msg, timeout)
+}
+
+func TestSession_WriteWithoutLock(t *testing.T) {
+	manager := NewSessionManager(0, 64)
+	channel := &blockingChannel{flowChannel: flowChannel{manager: manager, high: 100}, release: make(chan struct{})}
+	manager.create(channel, &Auth{AccountId: 1, AppId: 2, CloudId: 3})
+	manager.attach(channel)
+
+	go func() {
+		_ = manager.Push(1, 2, 3, []byte("hello"))
+	}()
+	time.Sleep(time.Millisecond * 50)
+
+	//第一条推送阻塞在写出时，依然可以确认，新的推送等待前面的推送写出后按顺序写出
+	go func() {
+		_ = manager.Push(1, 2, 3, []byte("world"))
+	}()
+	done := make(chan struct{})
+	go func() {
+		manager.onAck(channel, ack(t, 1))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		assert.Fail(t, "session is locked while writing")
+	}
+	close(channel.release)
+	assert.Eventually(t, func() bool {
+		return len(channel.sequences()) == 2
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(t, []uint64{1, 2}, channel.sequences())
+}
+
+//断开连接后保存离线消息，保存完成前会话恢复并且客户端已经确认，保存后删除
+func TestSession_AckBeforeStored(t *testing.T) {
+	messages := &memoryMessages{}
+	manager := NewSessionManager(time.Minute, 64)
+	manager.SetMessageService(messages)
+
+	channel := &flowChannel{manager: manager, high: 100}
+	manager.create(channel, &Auth{AccountId: 1, AppId: 2, CloudId: 3})
+	manager.attach(channel)
+	assert.Nil(t, manager.Push(1, 2, 3, []byte("hello")))
+
+	value, _ := manager.channels.Get(channel.RemoteAddr())
+	s := value.(*session)
+	s.mutex.Lock()
+	items := append([]*pushed{}, s.unacked...)
+	s.mutex.Unlock()
+	s.ack(1)
+
+	s.store(items)
+	assert.Equal(t, 0, messages.size())
+}
@@ -2,6 +2,7 @@ package store
 
 import (
 	"github.com/ihaiker/tenured-go-server/api"
+	"github.com/ihaiker/tenured-go-server/commons/breaker"
 	"github.com/ihaiker/tenured-go-server/commons/mixins"
 	"github.com/ihaiker/tenured-go-server/commons/nets"
 	"github.com/ihaiker/tenured-go-server/commons/remoting"
@@ -25,6 +26,16 @@ type storeConfig struct {
 	Executors map[string]string `json:"executors" yaml:"executors"`
 
 	Engine *engine.StoreEngineConfig `json:"engine" yaml:"engine"`
+
+	Auth *services.Auth `json:"auth" yaml:"auth"` //模块间认证
+
+	Health *services.Health `json:"health" yaml:"health"` //健康检查
+
+	Breaker *breaker.Config `json:"breaker" yaml:"breaker"` //调用其他节点的熔断器
+
+	Replication *Replication `json:"replication" yaml:"replication"` //副本复制
+
+	Migration *Migration `json:"migration" yaml:"migration"` //节点变化时的数据迁移
 }
 
 func (this *storeConfig) HasStore(name string) bool {
@@ -62,5 +73,11 @@ func NewStoreConfig() *storeConfig {
 			},
 		},
 		Executors: map[string]string{},
+		Auth:      services.NewAuth(),
+		Health:    services.NewHealth(),
+		Breaker:   breaker.DefaultConfig(),
+
+		Replication: NewReplication(),
+		Migration:   NewMigration(),
 	}
 }
@@ -1,14 +1,22 @@
 package store
 
 import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
 	"github.com/ihaiker/tenured-go-server/api"
 	"github.com/ihaiker/tenured-go-server/api/invoke"
 	"github.com/ihaiker/tenured-go-server/commons"
 	"github.com/ihaiker/tenured-go-server/commons/executors"
 	"github.com/ihaiker/tenured-go-server/commons/mixins"
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
 	"github.com/ihaiker/tenured-go-server/engine"
+	"github.com/ihaiker/tenured-go-server/engine/replica"
 	"github.com/ihaiker/tenured-go-server/protocol"
 	"github.com/ihaiker/tenured-go-server/registry"
+	"github.com/ihaiker/tenured-go-server/registry/load_balance"
 )
 
 type ServicesInvokeManager struct {
@@ -24,15 +32,29 @@ type ServicesInvokeManager struct {
 	storePlugins engine.StorePlugin
 
 	serverManager *commons.ServiceManager
+
+	//存储服务的健康检查，key为存储名称
+	indicators map[string]engine.HealthIndicator
+
+	//当前节点的注册地址，用于排除副本节点中的自己
+	address string
+	//存储服务的副本复制，key为存储名称
+	replicators map[string]*replicator
+	//存储服务的数据迁移，key为存储名称
+	migrators map[string]*migrator
 }
 
-func NewServicesInvokeManager(config *storeConfig, reg registry.ServiceRegistry, server *protocol.TenuredServer, executorManager executors.ExecutorManager) *ServicesInvokeManager {
+func NewServicesInvokeManager(config *storeConfig, address string, reg registry.ServiceRegistry, server *protocol.TenuredServer, executorManager executors.ExecutorManager) *ServicesInvokeManager {
 	return &ServicesInvokeManager{
 		reg:             reg,
 		config:          config,
 		server:          server,
 		executorManager: executorManager,
 		serverManager:   commons.NewServiceManager(),
+		indicators:      map[string]engine.HealthIndicator{},
+		address:         address,
+		replicators:     map[string]*replicator{},
+		migrators:       map[string]*migrator{},
 	}
 }
 
@@ -48,6 +70,153 @@ func (this *ServicesInvokeManager) aware(service interface{}) {
 	}
 }
 
+func (this *ServicesInvokeManager) indicator(name string, service interface{}) {
+	if indicator, match := service.(engine.HealthIndicator); match {
+		this.indicators[name] = indicator
+	}
+}
+
+//配置了多副本或者数据迁移并且存储服务支持复制时，在服务启动后开始复制和迁移。
+//migrate为false时不迁移，account使用时间hash，已有数据的路由不会变化
+func (this *ServicesInvokeManager) replicate(name string, service interface{}, migrate bool) {
+	replication, migration := this.config.Replication, this.config.Migration
+	if replication == nil {
+		replication = NewReplication()
+	}
+	migrate = migrate && migration != nil && migration.Enable
+	if replication.Factor <= 1 && !migrate {
+		return
+	}
+	store, match := service.(engine.ReplicaStore)
+	if !match {
+		logger.Warnf("store %s not support replication", name)
+		return
+	}
+	replicator := newReplicator(mixins.Store(this.config.Prefix), name, this.address, replication, store, this.reg)
+	this.replicators[name] = replicator
+	this.serverManager.Add(replicator)
+	if !migrate {
+		store.SetReplicaWriter(replicator.write)
+		return
+	}
+	migrator := newMigrator(replicator, migration)
+	if replication.Factor > 1 {
+		store.SetReplicaWriter(replicator.write)
+	}
+	store.SetReplicaMarker(migrator.mark)
+	store.SetReplicaReader(migrator.read)
+	replicator.marker = migrator.mark
+	this.migrators[name] = migrator
+	this.serverManager.Add(migrator)
+}
+
+func (this *ServicesInvokeManager) onReplica(channel remoting.RemotingChannel, request *protocol.TenuredCommand) {
+	response := protocol.NewACK(request.ID())
+	header := &replicaHeader{}
+	if err := request.GetHeader(header); err != nil {
+		response.RemotingError(protocol.ErrorHandler(err))
+	} else if replicator, has := this.replicators[header.Store]; !has {
+		response.RemotingError(protocol.ErrorRouter())
+	} else if err := replicator.apply(request.Body); err != nil {
+		response.RemotingError(err)
+	}
+	if err := channel.Write(response, time.Millisecond*3000); err != nil {
+		logger.Error("replica write error: ", err)
+	}
+}
+
+func (this *ServicesInvokeManager) onMigrate(channel remoting.RemotingChannel, request *protocol.TenuredCommand) {
+	defer protocol.CloseStream(channel, request)
+	response := protocol.NewACK(request.ID())
+	header := &replicaHeader{}
+	ranges := load_balance.HashRanges{}
+	if err := request.GetHeader(header); err != nil {
+		response.RemotingError(protocol.ErrorHandler(err))
+	} else if migrator, has := this.migrators[header.Store]; !has {
+		response.RemotingError(protocol.ErrorRouter())
+	} else if err := json.Unmarshal(request.Body, &ranges); err != nil {
+		response.RemotingError(protocol.ErrorHandler(err))
+	} else if err := migrator.scan(channel, request, ranges); err != nil {
+		response.RemotingError(protocol.ErrorDB(err))
+	}
+	if err := channel.Write(response, time.Millisecond*3000); err != nil {
+		logger.Error("migrate write error: ", err)
+	}
+}
+
+func (this *ServicesInvokeManager) onMigrateGet(channel remoting.RemotingChannel, request *protocol.TenuredCommand) {
+	response := protocol.NewACK(request.ID())
+	header := &replicaHeader{}
+	if err := request.GetHeader(header); err != nil {
+		response.RemotingError(protocol.ErrorHandler(err))
+	} else if migrator, has := this.migrators[header.Store]; !has {
+		response.RemotingError(protocol.ErrorRouter())
+	} else if value, found, err := migrator.get(request.Body); err != nil {
+		response.RemotingError(protocol.ErrorDB(err))
+	} else if found {
+		_ = response.SetHeader(&migrateGetHeader{Found: true})
+		response.Body = value
+	}
+	if err := channel.Write(response, time.Millisecond*3000); err != nil {
+		logger.Error("migrate get write error: ", err)
+	}
+}
+
+//所有存储服务的迁移进度
+func (this *ServicesInvokeManager) onMigrateDone(channel remoting.RemotingChannel, request *protocol.TenuredCommand) {
+	response := protocol.NewACK(request.ID())
+	header := &migrateDoneHeader{}
+	ranges := load_balance.HashRanges{}
+	if err := request.GetHeader(header); err != nil {
+		response.RemotingError(protocol.ErrorHandler(err))
+	} else if migrator, has := this.migrators[header.Store]; !has {
+		response.RemotingError(protocol.ErrorRouter())
+	} else if err := json.Unmarshal(request.Body, &ranges); err != nil {
+		response.RemotingError(protocol.ErrorHandler(err))
+	} else {
+		go migrator.done(header.Id, header.Event, ranges)
+	}
+	if err := channel.Write(response, time.Millisecond*3000); err != nil {
+		logger.Error("migrate done write error: ", err)
+	}
+}
+
+func (this *ServicesInvokeManager) onMigrateStatus(channel remoting.RemotingChannel, request *protocol.TenuredCommand) {
+	response := protocol.NewACK(request.ID())
+	names := make([]string, 0, len(this.migrators))
+	for name := range this.migrators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	statuses := make([]*replica.MigrateStatus, 0)
+	for _, name := range names {
+		statuses = append(statuses, this.migrators[name].status()...)
+	}
+	if body, err := json.Marshal(statuses); err != nil {
+		response.RemotingError(protocol.ErrorHandler(err))
+	} else {
+		response.Body = body
+	}
+	if err := channel.Write(response, time.Millisecond*3000); err != nil {
+		logger.Error("migrate status write error: ", err)
+	}
+}
+
+//检查所有存储服务，返回第一个不可用服务的错误
+func (this *ServicesInvokeManager) Health() error {
+	names := make([]string, 0, len(this.indicators))
+	for name := range this.indicators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := this.indicators[name].Health(); err != nil {
+			return fmt.Errorf("%s %s", name, err)
+		}
+	}
+	return nil
+}
+
 func (this *ServicesInvokeManager) Start() (err error) {
 	storeServerName := mixins.Store(this.config.Prefix)
 
@@ -64,7 +233,9 @@ func (this *ServicesInvokeManager) Start() (err error) {
 			return err
 		} else {
 			this.aware(service)
+			this.indicator(api.StoreAccount, service)
 			this.serverManager.Add(service)
+			this.replicate(api.StoreAccount, service, false)
 		}
 	}
 
@@ -75,7 +246,9 @@ func (this *ServicesInvokeManager) Start() (err error) {
 			return err
 		} else {
 			this.aware(service)
+			this.indicator(api.StoreSearch, service)
 			this.serverManager.Add(service)
+			this.replicate(api.StoreSearch, service, true)
 		}
 	}
 
@@ -86,10 +259,34 @@ func (this *ServicesInvokeManager) Start() (err error) {
 			return err
 		} else {
 			this.aware(service)
+			this.indicator(api.StoreUser, service)
 			this.serverManager.Add(service)
+			this.replicate(api.StoreUser, service, true)
 		}
 	}
 
+	if this.config.HasStore(api.StoreMessage) {
+		if service, err := this.storePlugins.Message(); err != nil {
+			return err
+		} else if err := invoke.NewMessageServiceInvoke(this.server, service, this.executorManager); err != nil {
+			return err
+		} else {
+			this.aware(service)
+			this.indicator(api.StoreMessage, service)
+			this.serverManager.Add(service)
+			this.replicate(api.StoreMessage, service, true)
+		}
+	}
+
+	if len(this.replicators) != 0 {
+		this.server.RegisterCommandProcesser(REQUEST_CODE_REPLICA, this.onReplica, this.executorManager.Get("Replica"))
+	}
+	if len(this.migrators) != 0 {
+		this.server.RegisterCommandProcesser(REQUEST_CODE_MIGRATE, this.onMigrate, this.executorManager.Get("Migrate"))
+		this.server.RegisterCommandProcesser(REQUEST_CODE_MIGRATE_GET, this.onMigrateGet, this.executorManager.Get("MigrateGet"))
+		this.server.RegisterCommandProcesser(REQUEST_CODE_MIGRATE_DONE, this.onMigrateDone, this.executorManager.Get("MigrateDone"))
+	}
+	this.server.RegisterCommandProcesser(replica.REQUEST_CODE_MIGRATE_STATUS, this.onMigrateStatus, this.executorManager.Get("MigrateStatus"))
 	return this.serverManager.Start()
 }
 
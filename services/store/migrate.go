@@ -0,0 +1,556 @@
+package store
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/commons"
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/ihaiker/tenured-go-server/engine/replica"
+	"github.com/ihaiker/tenured-go-server/protocol"
+	"github.com/ihaiker/tenured-go-server/registry"
+	"github.com/ihaiker/tenured-go-server/registry/load_balance"
+)
+
+//迁移hash区间内的数据，流式返回
+const REQUEST_CODE_MIGRATE = uint16(4201)
+
+//迁移完成前读取原来节点的数据
+const REQUEST_CODE_MIGRATE_GET = uint16(4202)
+
+//迁入节点完成迁移后通知区间原来的节点，删除不再保存的数据
+const REQUEST_CODE_MIGRATE_DONE = uint16(4203)
+
+//迁移时每个数据帧的条数
+const migrateBatch = 256
+
+//保留的已经结束的迁移记录数
+const migrateHistory = 10
+
+const ErrMigrateClosed = commons.Error("MigrateClosed")
+
+//数据迁移配置
+type Migration struct {
+	//节点加入或者离开时迁移数据
+	Enable bool `json:"enable" yaml:"enable"`
+
+	//等待每个数据帧的超时时间，SECONDS
+	Timeout int `json:"timeout" yaml:"timeout"`
+}
+
+func NewMigration() *Migration {
+	return &Migration{
+		Enable:  true,
+		Timeout: 30,
+	}
+}
+
+type migrateGetHeader struct {
+	Found bool `json:"found"`
+}
+
+type migrateDoneHeader struct {
+	Store string `json:"store"`
+	//完成迁移的节点
+	Id string `json:"id"`
+	//引起迁移的节点变化
+	Event string `json:"event"`
+}
+
+//节点变化的名称，迁入和迁出的节点使用相同的名称
+func ringEventName(event load_balance.RingEvent) string {
+	return event.Type + ":" + event.Instance.Id
+}
+
+//一次节点变化引起的迁入
+type migration struct {
+	status *replica.MigrateStatus
+	//当前节点的id
+	self string
+	//当前节点新增的区间
+	ranges load_balance.MovedRanges
+	//迁移期间本地写入（包括删除）的key，迁入的旧数据不能覆盖，删除的数据也不从原来的节点读取
+	written map[string]bool
+}
+
+//一次节点变化引起的迁出
+type release struct {
+	event string
+	//当前节点不再保存的区间
+	ranges load_balance.MovedRanges
+	//区间End -> 还没有完成迁移的迁入节点
+	pending map[uint64]map[string]bool
+}
+
+func (this *migration) running() bool {
+	return this.status.State == replica.MigrateRunning
+}
+
+//节点变化时根据变化前后的hash环计算当前节点新增的区间，从区间原来的节点流式拉取数据。
+//迁移完成前本地不存在的数据从原来的节点读取。
+//迁入节点全部完成迁移后，原来的节点删除区间内不再保存的数据
+type migrator struct {
+	replicator *replicator
+	config     *Migration
+
+	lock *sync.RWMutex
+	//最新的在前
+	migrations []*migration
+	//最新的在前
+	releases []*release
+
+	closeChan chan struct{}
+}
+
+func (this *migrator) timeout() time.Duration {
+	if this.config.Timeout <= 0 {
+		return time.Second * 30
+	}
+	return time.Duration(this.config.Timeout) * time.Second
+}
+
+func (this *migrator) factor() int {
+	if this.replicator.config.Factor <= 1 {
+		return 1
+	}
+	return this.replicator.config.Factor
+}
+
+func (this *migrator) self(ring *load_balance.RingSnapshot) *registry.ServerInstance {
+	for _, instance := range ring.Members() {
+		if instance.Address == this.replicator.address {
+			return instance
+		}
+	}
+	return nil
+}
+
+func (this *migrator) onRingChanged(event load_balance.RingEvent) {
+	if event.Previous == nil || event.Current == nil {
+		return
+	}
+	self := this.self(event.Current)
+	if self == nil {
+		return
+	}
+	moved := load_balance.NewMovedRanges(event.Previous, event.Current, this.factor())
+	this.addRelease(ringEventName(event), moved.Lost(self.Id))
+	gained := moved.Gained(self.Id)
+	if len(gained) == 0 {
+		return
+	}
+
+	//每个区间从原来保存的第一个仍然在环上的节点迁移
+	instances := map[string]*registry.ServerInstance{}
+	groups := map[string]load_balance.HashRanges{}
+	for _, moved := range gained {
+		for _, from := range moved.From {
+			if from.Id != self.Id && event.Current.Has(from.Id) {
+				instances[from.Id] = from
+				groups[from.Id] = append(groups[from.Id], moved.HashRange)
+				break
+			}
+		}
+	}
+	if len(groups) == 0 {
+		logger.Warnf("%s %s:%s, %d ranges have no source", this.replicator.store, event.Type, event.Instance.Id, len(gained))
+		return
+	}
+
+	m := &migration{
+		status: &replica.MigrateStatus{
+			Store: this.replicator.store, Event: ringEventName(event),
+			State: replica.MigrateRunning, Sources: make([]*replica.MigrateSource, 0, len(groups)),
+			StartTime: time.Now().UnixNano() / int64(time.Millisecond),
+		},
+		self: self.Id, ranges: gained, written: map[string]bool{},
+	}
+	for id, ranges := range groups {
+		m.status.Sources = append(m.status.Sources, &replica.MigrateSource{
+			Id: id, Address: instances[id].Address, Ranges: len(ranges),
+		})
+	}
+	sort.Slice(m.status.Sources, func(i, j int) bool {
+		return m.status.Sources[i].Id < m.status.Sources[j].Id
+	})
+	this.add(m)
+
+	logger.Infof("migrate %s %s, %d ranges from %d nodes", m.status.Store, m.status.Event, len(gained), len(groups))
+	go this.run(m, instances, groups)
+}
+
+func (this *migrator) add(m *migration) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	migrations := make([]*migration, 0, len(this.migrations)+1)
+	migrations = append(migrations, m)
+	finished := 0
+	for _, old := range this.migrations {
+		if !old.running() {
+			if finished++; finished > migrateHistory {
+				continue
+			}
+		}
+		migrations = append(migrations, old)
+	}
+	this.migrations = migrations
+}
+
+//记录当前节点不再保存的区间，没有迁入节点的区间直接删除
+func (this *migrator) addRelease(event string, lost load_balance.MovedRanges) {
+	if len(lost) == 0 {
+		return
+	}
+	r := &release{event: event, ranges: lost, pending: map[uint64]map[string]bool{}}
+	released := load_balance.HashRanges{}
+	for _, moved := range lost {
+		gainers := map[string]bool{}
+		for _, to := range moved.To {
+			gainers[to.Id] = true
+		}
+		for _, from := range moved.From {
+			delete(gainers, from.Id)
+		}
+		if len(gainers) == 0 {
+			released = append(released, moved.HashRange)
+		} else {
+			r.pending[moved.End] = gainers
+		}
+	}
+
+	this.lock.Lock()
+	releases := make([]*release, 0, len(this.releases)+1)
+	releases = append(releases, r)
+	for i, old := range this.releases {
+		if i < migrateHistory {
+			releases = append(releases, old)
+		}
+	}
+	this.releases = releases
+	this.lock.Unlock()
+
+	if len(released) > 0 {
+		go this.clean(released)
+	}
+}
+
+//迁入节点完成迁移，全部迁入节点都完成的区间删除本地不再保存的数据
+func (this *migrator) done(id, event string, ranges load_balance.HashRanges) {
+	released := load_balance.HashRanges{}
+	this.lock.Lock()
+	for _, r := range this.releases {
+		if r.event != event {
+			continue
+		}
+		for _, hashRange := range ranges {
+			moved := r.ranges.Get(hashRange.End)
+			if moved == nil || moved.HashRange != hashRange {
+				continue
+			}
+			if pending := r.pending[moved.End]; pending[id] {
+				delete(pending, id)
+				if len(pending) == 0 {
+					released = append(released, moved.HashRange)
+				}
+			}
+		}
+		break
+	}
+	this.lock.Unlock()
+
+	if len(released) > 0 {
+		sort.Slice(released, func(i, j int) bool {
+			return released[i].End < released[j].End
+		})
+		this.clean(released)
+	}
+}
+
+//当前hash环上当前节点是否保存ringKey的数据
+func (this *migrator) owns(ringKey string) bool {
+	for _, instance := range this.replicator.ring.Successors(ringKey, this.factor()) {
+		if instance.Address == this.replicator.address {
+			return true
+		}
+	}
+	return false
+}
+
+//删除区间内当前节点不再保存的数据，只删除本地，不复制
+func (this *migrator) clean(ranges load_balance.HashRanges) {
+	local := this.replicator.local
+	total := 0
+	entries := make([]*replica.Entry, 0, migrateBatch)
+	flush := func() bool {
+		if len(entries) == 0 {
+			return true
+		}
+		if err := local.ApplyReplica(entries); err != nil {
+			logger.Warnf("release %s data error: %s", this.replicator.store, err)
+			return false
+		}
+		total += len(entries)
+		entries = entries[:0]
+		return true
+	}
+	err := local.ScanReplica(func(entry *replica.Entry) bool {
+		select {
+		case <-this.closeChan:
+			return false
+		default:
+		}
+		ringKey := local.RingKey(entry.Key)
+		if ranges.Contains(load_balance.RingHash(ringKey)) && !this.owns(ringKey) {
+			entries = append(entries, &replica.Entry{Key: entry.Key})
+		}
+		return len(entries) < migrateBatch || flush()
+	})
+	if err != nil {
+		logger.Warnf("scan %s release data error: %s", this.replicator.store, err)
+		return
+	}
+	if flush() {
+		logger.Infof("release %s %d ranges, %d entries", this.replicator.store, len(ranges), total)
+	}
+}
+
+func (this *migrator) run(m *migration, instances map[string]*registry.ServerInstance, groups map[string]load_balance.HashRanges) {
+	wg := new(sync.WaitGroup)
+	for _, source := range m.status.Sources {
+		wg.Add(1)
+		go func(source *replica.MigrateSource) {
+			defer wg.Done()
+			err := this.pull(m, source, instances[source.Id], groups[source.Id])
+
+			this.lock.Lock()
+			defer this.lock.Unlock()
+			if err != nil {
+				logger.Warnf("migrate %s from %s error: %s", m.status.Store, source.Address, err)
+				source.Error = err.Error()
+			} else {
+				source.Done = true
+			}
+		}(source)
+	}
+	wg.Wait()
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	m.status.State = replica.MigrateDone
+	for _, source := range m.status.Sources {
+		if !source.Done {
+			m.status.State = replica.MigrateFailed
+		}
+	}
+	m.status.EndTime = time.Now().UnixNano() / int64(time.Millisecond)
+	m.written = nil
+	logger.Infof("migrate %s %s %s", m.status.Store, m.status.Event, m.status.State)
+	if m.status.State == replica.MigrateDone {
+		go this.notify(m)
+	}
+}
+
+//迁移完成后通知区间原来的节点
+func (this *migrator) notify(m *migration) {
+	instances := map[string]*registry.ServerInstance{}
+	groups := map[string]load_balance.HashRanges{}
+	for _, moved := range m.ranges {
+		for _, from := range moved.From {
+			if from.Id != m.self {
+				instances[from.Id] = from
+				groups[from.Id] = append(groups[from.Id], moved.HashRange)
+			}
+		}
+	}
+	header := &migrateDoneHeader{Store: this.replicator.store, Id: m.self, Event: m.status.Event}
+	for id, ranges := range groups {
+		body, err := json.Marshal(ranges)
+		if err == nil {
+			_, err = this.replicator.client.Invoke(registry.Local(instances[id]), REQUEST_CODE_MIGRATE_DONE,
+				header, body, this.replicator.timeout(), nil)
+		}
+		if err != nil {
+			logger.Warnf("notify %s migrate done to %s error: %s", m.status.Store, instances[id].Address, err)
+		}
+	}
+}
+
+//从来源节点流式拉取区间内的数据
+func (this *migrator) pull(m *migration, source *replica.MigrateSource, instance *registry.ServerInstance, ranges load_balance.HashRanges) error {
+	body, err := json.Marshal(ranges)
+	if err != nil {
+		return err
+	}
+	if err := this.replicator.client.InvokeStream([]*registry.ServerInstance{registry.Local(instance)},
+		REQUEST_CODE_MIGRATE, &replicaHeader{Store: this.replicator.store}, body, this.timeout(),
+		func(item *protocol.TenuredCommand) error {
+			select {
+			case <-this.closeChan:
+				return ErrMigrateClosed
+			default:
+			}
+			entries := make([]*replica.Entry, 0)
+			if err := json.Unmarshal(item.Body, &entries); err != nil {
+				return err
+			}
+			return this.apply(m, source, entries)
+		}); err != nil {
+		return err
+	}
+	return nil
+}
+
+//写入迁入的数据，本地已经存在或者迁移期间写入过的数据不覆盖。
+//检查和写入期间持有锁，本地写入之前的标记需要等待写入完成，不会被迁入的旧数据覆盖
+func (this *migrator) apply(m *migration, source *replica.MigrateSource, entries []*replica.Entry) error {
+	local := this.replicator.local
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	applies := make([]*replica.Entry, 0, len(entries))
+	for _, entry := range entries {
+		if m.written[string(entry.Key)] {
+			continue
+		}
+		if value, err := local.GetReplica(entry.Key); err != nil {
+			return err
+		} else if value == nil {
+			applies = append(applies, entry)
+		}
+	}
+	if err := local.ApplyReplica(applies); err != nil {
+		return err
+	}
+	source.Received += len(entries)
+	return nil
+}
+
+//本地写入（包括复制过来的数据）之前调用，记录迁移区间内写入的key
+func (this *migrator) mark(entries []*replica.Entry) {
+	this.lock.Lock()
+	for _, m := range this.migrations {
+		if !m.running() {
+			continue
+		}
+		for _, entry := range entries {
+			if m.ranges.Get(load_balance.RingHash(this.replicator.local.RingKey(entry.Key))) != nil {
+				m.written[string(entry.Key)] = true
+			}
+		}
+	}
+	this.lock.Unlock()
+}
+
+//迁移完成前本地不存在的数据，从区间原来的节点读取，迁移期间本地删除的数据不读取
+func (this *migrator) read(key []byte) ([]byte, error) {
+	hashCode := load_balance.RingHash(this.replicator.local.RingKey(key))
+	froms := make([][]*registry.ServerInstance, 0)
+	this.lock.RLock()
+	for _, m := range this.migrations {
+		if !m.running() {
+			continue
+		}
+		if m.written[string(key)] {
+			this.lock.RUnlock()
+			return nil, nil
+		}
+		if moved := m.ranges.Get(hashCode); moved != nil {
+			froms = append(froms, moved.From)
+		}
+	}
+	this.lock.RUnlock()
+
+	var lastErr error
+	for _, from := range froms {
+		for _, instance := range from {
+			if instance.Address == this.replicator.address {
+				continue
+			}
+			header := &migrateGetHeader{}
+			if value, err := this.replicator.client.Invoke(registry.Local(instance), REQUEST_CODE_MIGRATE_GET,
+				&replicaHeader{Store: this.replicator.store}, key, this.replicator.timeout(), header); err != nil {
+				logger.Debugf("read %s migrating data from %s error: %s", this.replicator.store, instance.Address, err)
+				lastErr = err
+			} else if header.Found {
+				return value, nil
+			} else {
+				lastErr = nil
+			}
+		}
+	}
+	return nil, lastErr
+}
+
+//流式返回本地hash区间内的数据
+func (this *migrator) scan(channel remoting.RemotingChannel, request *protocol.TenuredCommand, ranges load_balance.HashRanges) error {
+	local := this.replicator.local
+	entries := make([]*replica.Entry, 0, migrateBatch)
+	var writeErr error
+	flush := func() bool {
+		if len(entries) == 0 {
+			return true
+		}
+		body, err := json.Marshal(entries)
+		if err == nil {
+			err = protocol.WriteStreamItem(channel, request, nil, body, this.timeout())
+		}
+		if writeErr = err; err != nil {
+			return false
+		}
+		entries = entries[:0]
+		return true
+	}
+	if err := local.ScanReplica(func(entry *replica.Entry) bool {
+		if ranges.Contains(load_balance.RingHash(local.RingKey(entry.Key))) {
+			entries = append(entries, entry)
+		}
+		return len(entries) < migrateBatch || flush()
+	}); err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+	flush()
+	return writeErr
+}
+
+func (this *migrator) get(key []byte) ([]byte, bool, error) {
+	value, err := this.replicator.local.GetReplica(key)
+	return value, value != nil, err
+}
+
+//全部迁移记录，最新的在前
+func (this *migrator) status() []*replica.MigrateStatus {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	statuses := make([]*replica.MigrateStatus, 0, len(this.migrations))
+	for _, m := range this.migrations {
+		status := *m.status
+		status.Sources = make([]*replica.MigrateSource, len(m.status.Sources))
+		for i, source := range m.status.Sources {
+			copied := *source
+			status.Sources[i] = &copied
+		}
+		statuses = append(statuses, &status)
+	}
+	return statuses
+}
+
+func (this *migrator) Start() error {
+	this.replicator.ring.AddRingListener(this.onRingChanged)
+	return nil
+}
+
+func (this *migrator) Shutdown(interrupt bool) {
+	close(this.closeChan)
+}
+
+func newMigrator(replicator *replicator, config *Migration) *migrator {
+	return &migrator{
+		replicator: replicator, config: config,
+		lock: new(sync.RWMutex), migrations: make([]*migration, 0),
+		closeChan: make(chan struct{}),
+	}
+}
@@ -0,0 +1,256 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ihaiker/tenured-go-server/commons"
+	"github.com/ihaiker/tenured-go-server/engine"
+	"github.com/ihaiker/tenured-go-server/engine/replica"
+	"github.com/ihaiker/tenured-go-server/protocol"
+	"github.com/ihaiker/tenured-go-server/registry"
+	"github.com/ihaiker/tenured-go-server/registry/load_balance"
+)
+
+//写入副本数据
+const REQUEST_CODE_REPLICA = uint16(4200)
+
+//和客户端负载均衡使用的虚拟节点数一致，保证副本节点和客户端路由的节点相同
+const replicaVirtualNum = 100
+
+//修复副本时每次发送的数据条数
+const replicaRepairBatch = 256
+
+//副本复制配置
+type Replication struct {
+	//副本数，包括主节点，小于等于1时不复制
+	Factor int `json:"factor" yaml:"factor"`
+
+	//写入成功需要的副本数，包括主节点，小于等于1时异步复制。
+	//先写入本地再复制，没有达到副本数时本地写入不会回滚，返回 protocol.ErrorPartialWrite，
+	//数据由副本修复（节点加入或者 RepairInterval）复制到其他节点
+	WriteQuorum int `json:"writeQuorum" yaml:"writeQuorum"`
+
+	//复制超时时间，SECONDS
+	Timeout int `json:"timeout" yaml:"timeout"`
+
+	//定时修复副本的间隔，SECONDS，为0时只在节点加入时修复
+	RepairInterval int `json:"repairInterval" yaml:"repairInterval"`
+}
+
+func NewReplication() *Replication {
+	return &Replication{
+		Factor:      1,
+		WriteQuorum: 1,
+		Timeout:     3,
+	}
+}
+
+type replicaHeader struct {
+	//存储名称，例如：account
+	Store string `json:"store"`
+}
+
+//存储服务的副本复制，本地写入的数据复制到hash环上的后续节点，节点加入时推送其应该保存的副本数据。
+//只配置数据迁移时只使用hash环和客户端
+type replicator struct {
+	store   string
+	address string
+	config  *Replication
+
+	local  engine.ReplicaStore
+	ring   load_balance.RingLoadBalance
+	client *protocol.TenuredClientInvoke
+	//数据迁移时写入复制过来的数据之前标记
+	marker replica.Marker
+
+	closeChan chan struct{}
+}
+
+func (this *replicator) timeout() time.Duration {
+	if this.config.Timeout <= 0 {
+		return time.Second * 3
+	}
+	return time.Duration(this.config.Timeout) * time.Second
+}
+
+//数据的副本节点，不包括当前节点
+func (this *replicator) replicas(ringKey string) []*registry.ServerInstance {
+	replicas := make([]*registry.ServerInstance, 0, this.config.Factor)
+	for _, instance := range this.ring.Successors(ringKey, this.config.Factor) {
+		if instance.Address != this.address {
+			replicas = append(replicas, instance)
+		}
+	}
+	if len(replicas) >= this.config.Factor {
+		replicas = replicas[:this.config.Factor-1]
+	}
+	return replicas
+}
+
+func (this *replicator) invoke(instance *registry.ServerInstance, entries []*replica.Entry) error {
+	body, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if _, err := this.client.Invoke(registry.Local(instance), REQUEST_CODE_REPLICA,
+		&replicaHeader{Store: this.store}, body, this.timeout(), nil); err != nil {
+		return err
+	}
+	return nil
+}
+
+//本地写入后调用，按照路由key分组复制到各自的副本节点
+func (this *replicator) write(entries []*replica.Entry) error {
+	for ringKey, group := range replica.Group(entries, this.local.RingKey) {
+		if err := this.send(this.replicas(ringKey), group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//发送到副本节点，成功的节点数（包括本地）没有达到WriteQuorum时返回错误，此时本地已经写入
+func (this *replicator) send(replicas []*registry.ServerInstance, entries []*replica.Entry) error {
+	results := make(chan error, len(replicas))
+	for _, instance := range replicas {
+		go func(instance *registry.ServerInstance) {
+			err := this.invoke(instance, entries)
+			if err != nil {
+				logger.Warnf("replicate %s to %s error: %s", this.store, instance.Address, err)
+			}
+			results <- err
+		}(instance)
+	}
+
+	quorum := this.config.WriteQuorum
+	if quorum <= 1 {
+		return nil
+	}
+	success := 1
+	for i := 0; i < len(replicas) && success < quorum; i++ {
+		if err := <-results; err == nil {
+			success++
+		}
+	}
+	if success < quorum {
+		return fmt.Errorf("%s replica quorum not reached: %d/%d", this.store, success, quorum)
+	}
+	return nil
+}
+
+//推送instance应该保存的副本数据
+func (this *replicator) repair(instance *registry.ServerInstance) {
+	logger.Infof("repair %s replica to %s", this.store, instance.Address)
+	total := 0
+	entries := make([]*replica.Entry, 0, replicaRepairBatch)
+	flush := func() bool {
+		if len(entries) == 0 {
+			return true
+		}
+		if err := this.invoke(instance, entries); err != nil {
+			logger.Warnf("repair %s replica to %s error: %s", this.store, instance.Address, err)
+			return false
+		}
+		total += len(entries)
+		entries = entries[:0]
+		return true
+	}
+	err := this.local.ScanReplica(func(entry *replica.Entry) bool {
+		select {
+		case <-this.closeChan:
+			return false
+		default:
+		}
+		for _, successor := range this.ring.Successors(this.local.RingKey(entry.Key), this.config.Factor) {
+			if successor.Id == instance.Id {
+				entries = append(entries, entry)
+				break
+			}
+		}
+		return len(entries) < replicaRepairBatch || flush()
+	})
+	if err != nil {
+		logger.Warnf("scan %s replica error: %s", this.store, err)
+		return
+	}
+	if flush() {
+		logger.Infof("repair %s replica to %s, %d entries", this.store, instance.Address, total)
+	}
+}
+
+func (this *replicator) onRingChanged(event load_balance.RingEvent) {
+	if this.config.Factor > 1 && event.Type == load_balance.RingJoin && event.Instance.Address != this.address {
+		go this.repair(event.Instance)
+	}
+}
+
+//定时修复所有其他节点的副本数据
+func (this *replicator) repairLoop() {
+	ticker := time.NewTicker(time.Duration(this.config.RepairInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-this.closeChan:
+			return
+		case <-ticker.C:
+			for _, instance := range this.ring.Members() {
+				if instance.Address != this.address {
+					this.repair(instance)
+				}
+			}
+		}
+	}
+}
+
+//写入其他节点复制过来的数据
+func (this *replicator) apply(body []byte) *protocol.TenuredError {
+	entries := make([]*replica.Entry, 0)
+	if err := json.Unmarshal(body, &entries); err != nil {
+		return protocol.ErrorHandler(err)
+	}
+	if this.marker != nil {
+		this.marker(entries)
+	}
+	if err := this.local.ApplyReplica(entries); err != nil {
+		return protocol.ErrorDB(err)
+	}
+	return nil
+}
+
+func (this *replicator) Start() error {
+	if err := this.client.Start(); err != nil {
+		return err
+	}
+	if err := commons.StartIfService(this.ring); err != nil {
+		return err
+	}
+	//启动时已经存在的节点不需要修复，只处理之后加入（包括恢复）的节点
+	this.ring.AddRingListener(this.onRingChanged)
+	if this.config.Factor > 1 && this.config.RepairInterval > 0 {
+		go this.repairLoop()
+	}
+	return nil
+}
+
+func (this *replicator) Shutdown(interrupt bool) {
+	close(this.closeChan)
+	commons.ShutdownIfService(this.ring, interrupt)
+	this.client.Shutdown(interrupt)
+}
+
+func newReplicator(serverName, store, address string, config *Replication, local engine.ReplicaStore, reg registry.ServiceRegistry) *replicator {
+	var ring load_balance.RingLoadBalance
+	if replicaRing, match := local.(engine.ReplicaRing); match {
+		ring = replicaRing.ReplicaRing(serverName, store, reg)
+	} else {
+		ring = load_balance.NewHashLoadBalance(serverName, store, reg, replicaVirtualNum).(load_balance.RingLoadBalance)
+	}
+	return &replicator{
+		store: store, address: address, config: config, local: local,
+		ring:      ring,
+		client:    protocol.NewClientInvoke(),
+		closeChan: make(chan struct{}),
+	}
+}
@@ -5,14 +5,17 @@ import (
 	"github.com/ihaiker/tenured-go-server/commons/mixins"
 	"github.com/ihaiker/tenured-go-server/protocol"
 	"github.com/ihaiker/tenured-go-server/registry/cache"
+	"github.com/ihaiker/tenured-go-server/registry/health"
 	"github.com/ihaiker/tenured-go-server/registry/plugins"
 
 	"github.com/ihaiker/tenured-go-server/api"
 	"github.com/ihaiker/tenured-go-server/commons"
+	"github.com/ihaiker/tenured-go-server/commons/breaker"
 	"github.com/ihaiker/tenured-go-server/commons/executors"
 	"github.com/ihaiker/tenured-go-server/commons/remoting"
 	"github.com/ihaiker/tenured-go-server/commons/snowflake"
 	"github.com/ihaiker/tenured-go-server/registry"
+	"os"
 	"strconv"
 	"time"
 )
@@ -26,6 +29,7 @@ type storeServer struct {
 	registryPlugins registry.Plugins
 
 	serviceInvokeManager *ServicesInvokeManager
+	healthChecker        *health.HealthChecker
 
 	executorManager executors.ExecutorManager
 	snowflakeId     *snowflake.Snowflake
@@ -46,6 +50,9 @@ func (this *storeServer) init() (err error) {
 	if err = this.initServicesInvoke(); err != nil {
 		return
 	}
+	if err = this.initHealthChecker(); err != nil {
+		return
+	}
 	return nil
 }
 
@@ -69,12 +76,17 @@ func (this *storeServer) initTenuredServer() (err error) {
 		Module:  mixins.Store(this.config.Prefix),
 		Address: this.address,
 	}
+	if this.server.AuthChecker, err = this.config.Auth.Checker(); err != nil {
+		return err
+	}
+	this.config.Auth.Credential(mixins.Store(this.config.Prefix))
+	breaker.Default().SetConfig(this.config.Breaker)
 	this.serviceManager.Add(this.server)
 	return nil
 }
 
 func (this *storeServer) initServicesInvoke() (err error) {
-	this.serviceInvokeManager = NewServicesInvokeManager(this.config, this.registry, this.server, this.executorManager)
+	this.serviceInvokeManager = NewServicesInvokeManager(this.config, this.address, this.registry, this.server, this.executorManager)
 	this.serviceManager.Add(this.serviceInvokeManager)
 	if this.config.HasStore(api.StoreClusterId) {
 		this.server.RegisterCommandProcesser(api.ClusterIdServiceGet, func(channel remoting.RemotingChannel, request *protocol.TenuredCommand) {
@@ -90,6 +102,13 @@ func (this *storeServer) initServicesInvoke() (err error) {
 	return nil
 }
 
+func (this *storeServer) initHealthChecker() error {
+	this.healthChecker.Add("store", health.Error(this.serviceInvokeManager.Health))
+	this.server.SetHealthChecker(this.healthChecker)
+	this.serviceManager.Add(this.healthChecker)
+	return nil
+}
+
 func (this *storeServer) maxMachineId(serverName string) (uint16, error) {
 	if ss, err := this.registry.Lookup(serverName, nil); err != nil {
 		return 0, err
@@ -159,8 +178,10 @@ func (this *storeServer) initRegistry() error {
 	if reg, err := registryPlugins.Registry(); err != nil {
 		return err
 	} else {
-		this.registry = cache.NewCacheRegistry(reg)
+		this.registry = cache.NewPersistentCacheRegistry(reg, this.config.Data+"/registry_store.cache")
 		this.serviceManager.Add(this.registry)
+		//注册中心是否可用需要直接访问，不能使用缓存
+		this.healthChecker = this.config.Health.Checker(this.executorManager, reg, mixins.Store(this.config.Prefix))
 	}
 	this.registryPlugins = registryPlugins
 	this.serviceManager.Add(this.registryPlugins)
@@ -181,13 +202,19 @@ func (this *storeServer) registryService() error {
 		serverInstance.Name = serverName
 		serverInstance.Id = fmt.Sprintf("%d", clusterId)
 		serverInstance.Address = this.address
-		serverInstance.Metadata = map[string]string{
+		serverInstance.Metadata = this.config.Registry.InstanceMetadata(map[string]string{
 			"FirstStartTime": fmt.Sprintf("%d", firstStartTime),
+		})
+		if unixSocket := this.config.Tcp.UnixSocket; unixSocket != "" {
+			hostname, _ := os.Hostname()
+			serverInstance.Metadata[registry.MetadataUnixSocket] = remoting.UnixPrefix + unixSocket
+			serverInstance.Metadata[registry.MetadataHostname] = hostname
 		}
 		serverInstance.Tags = this.config.Stores
 		if err := this.registry.Register(serverInstance); err != nil {
 			return err
 		}
+		this.healthChecker.Report(this.registry, serverInstance)
 	}
 	return err
 }
@@ -19,6 +19,8 @@ type TenantConfig struct {
 	Registry *services.Registry `json:"registry" yaml:"registry"` //注册中心
 
 	StoreClient *engine.StoreEngineConfig `json:"storeClient" yaml:"storeClient"`
+
+	Auth *services.Auth `json:"auth" yaml:"auth"` //调用store时使用的模块凭证
 }
 
 func NewTenantConfig() *TenantConfig {
@@ -44,5 +46,6 @@ func NewTenantConfig() *TenantConfig {
 		StoreClient: &engine.StoreEngineConfig{
 			Type: "leveldb",
 		},
+		Auth: services.NewAuth(),
 	}
 }
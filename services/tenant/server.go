@@ -56,6 +56,7 @@ func (this *TenantServer) startRegistry() error {
 }
 
 func (this *TenantServer) initClientPlugin() error {
+	this.config.Auth.Credential(mixins.Tenant(this.config.Prefix))
 	storeName := mixins.Store(this.config.Prefix)
 	if clientPlugin, err := engine.GetStoreClientPlugin(storeName, this.config.StoreClient, this.reg); err != nil {
 		return err